@@ -0,0 +1,648 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// /api/v2 exposes the same services as v1 with one convention throughout:
+//
+//   - every JSON member is snake_case, the lower-cased form of its v1 name
+//     (Customer_ID becomes customer_id);
+//   - success bodies are {"data": ...}, plus "meta" for lists;
+//   - errors are {"error": {"code", "message", "details", "request_id"}};
+//   - every route lives under /api/v2 once; public routes under /api/v2/public;
+//   - unknown request members are rejected instead of ignored;
+//   - DELETE answers 204 No Content.
+//
+// v1 keeps working unchanged but every v1 response carries Deprecation and
+// Link headers pointing at the v2 successor, and Sunset once a date is set.
+
+// apiVersionHeader is sent on every v2 response. respondWithAPIError uses it
+// to choose the error format, so shared helpers such as requireIfMatch and
+// authMiddleware answer v2 requests in v2 form.
+const apiVersionHeader = "API-Version"
+
+// v1DeprecatedAt is when v2 became available and v1 was deprecated.
+var v1DeprecatedAt = time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)
+
+// v1Sunset is when v1 will be removed, from API_V1_SUNSET (YYYY-MM-DD); zero
+// until a date has been announced.
+var v1Sunset time.Time
+
+func loadAPIVersionConfig() error {
+	if v := os.Getenv("API_V1_SUNSET"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return fmt.Errorf("API_V1_SUNSET must be YYYY-MM-DD")
+		}
+		v1Sunset = t
+	}
+	return nil
+}
+
+// --- Representations ---
+
+// The v2 types have the same fields as the v1 models, so they convert with a
+// plain type conversion; only the JSON names differ.
+
+type CustomerV2 struct {
+	CustomerID       int    `json:"customer_id"`
+	Name             string `json:"name"`
+	Address          string `json:"address"`
+	Email            string `json:"email"`
+	PhoneNumber      string `json:"phone_number"`
+	RegistrationDate string `json:"registration_date"`
+	Version          int    `json:"version,omitempty"`
+}
+
+type MeterV2 struct {
+	MeterID          int    `json:"meter_id"`
+	CustomerID       int    `json:"customer_id"`
+	MeterNumber      string `json:"meter_number"`
+	InstallationDate string `json:"installation_date"`
+	ActiveStatus     bool   `json:"active_status"`
+	Version          int    `json:"version,omitempty"`
+}
+
+type PaymentV2 struct {
+	PaymentID     int    `json:"payment_id"`
+	BillID        int    `json:"bill_id"`
+	ProcessedBy   int    `json:"processed_by"`
+	PaymentDate   string `json:"payment_date"`
+	AmountPaid    Money  `json:"amount_paid"`
+	Currency      string `json:"currency"`
+	PaymentMethod string `json:"payment_method"`
+	PaymentStatus string `json:"payment_status"`
+	Version       int    `json:"version,omitempty"`
+}
+
+type BillLineItemV2 struct {
+	LineItemID  int     `json:"line_item_id"`
+	BillID      int     `json:"bill_id"`
+	ItemType    string  `json:"item_type"`
+	Description string  `json:"description"`
+	Quantity    Decimal `json:"quantity"`
+	UnitPrice   Decimal `json:"unit_price"`
+	Amount      Money   `json:"amount"`
+}
+
+// BillV2 is converted field by field because its line items have their own
+// v2 type.
+type BillV2 struct {
+	BillID          int              `json:"bill_id"`
+	CustomerID      int              `json:"customer_id"`
+	CustomerName    string           `json:"customer_name"`
+	MeterID         int              `json:"meter_id"`
+	BillingDate     string           `json:"billing_date"`
+	DueDate         string           `json:"due_date"`
+	ReadingPrevious Decimal          `json:"previous_reading"`
+	ReadingCurrent  Decimal          `json:"current_reading"`
+	RateApplied     Decimal          `json:"rate_applied"`
+	TotalUnit       Decimal          `json:"total_unit"`
+	AmountDue       Money            `json:"amount_due"`
+	Currency        string           `json:"currency"`
+	PaidStatus      bool             `json:"paid_status"`
+	Status          string           `json:"bill_status"`
+	ReplacesBillID  *int             `json:"replaces_bill_id,omitempty"`
+	InvoiceNumber   *string          `json:"invoice_number"`
+	IssuedDate      *string          `json:"issued_date"`
+	Version         int              `json:"version,omitempty"`
+	LineItems       []BillLineItemV2 `json:"line_items,omitempty"`
+}
+
+func billToV2(b Billing) BillV2 {
+	v := BillV2{BillID: b.BillID, CustomerID: b.CustomerID, CustomerName: b.Customer_Name, MeterID: b.MeterID,
+		BillingDate: b.BillingDate, DueDate: b.DueDate, ReadingPrevious: b.ReadingPrevious, ReadingCurrent: b.ReadingCurrent,
+		RateApplied: b.RateApplied, TotalUnit: b.TotalUnit, AmountDue: b.AmountDue, Currency: b.Currency,
+		PaidStatus: b.PaidStatus, Status: b.Status, ReplacesBillID: b.ReplacesBillID, InvoiceNumber: b.InvoiceNumber,
+		IssuedDate: b.IssuedDate, Version: b.Version}
+	for _, li := range b.LineItems {
+		v.LineItems = append(v.LineItems, BillLineItemV2(li))
+	}
+	return v
+}
+
+func billFromV2(v BillV2) Billing {
+	b := Billing{BillID: v.BillID, CustomerID: v.CustomerID, Customer_Name: v.CustomerName, MeterID: v.MeterID,
+		BillingDate: v.BillingDate, DueDate: v.DueDate, ReadingPrevious: v.ReadingPrevious, ReadingCurrent: v.ReadingCurrent,
+		RateApplied: v.RateApplied, TotalUnit: v.TotalUnit, AmountDue: v.AmountDue, Currency: v.Currency,
+		PaidStatus: v.PaidStatus, Status: v.Status, ReplacesBillID: v.ReplacesBillID, InvoiceNumber: v.InvoiceNumber,
+		IssuedDate: v.IssuedDate, Version: v.Version}
+	for _, li := range v.LineItems {
+		b.LineItems = append(b.LineItems, BillLineItem(li))
+	}
+	return b
+}
+
+// SessionV2 is the result of a v2 login.
+type SessionV2 struct {
+	Token      string    `json:"token"`
+	Username   string    `json:"username"`
+	Permission string    `json:"permission"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// BillPaymentV2 is the result of paying a bill through the portal.
+type BillPaymentV2 struct {
+	PaymentID int `json:"payment_id"`
+	BillID    int `json:"bill_id"`
+}
+
+// snakeFields converts v1 member names to their v2 form.
+func snakeFields(names []string) []string {
+	out := make([]string, len(names))
+	for i, n := range names {
+		out[i] = strings.ToLower(n)
+	}
+	return out
+}
+
+// Fields that v2 PATCH requests may not change.
+var (
+	customerV2ReadOnlyFields = snakeFields(customerReadOnlyFields)
+	meterV2ReadOnlyFields    = snakeFields(meterReadOnlyFields)
+	billV2ReadOnlyFields     = snakeFields(billingReadOnlyFields)
+	paymentV2ReadOnlyFields  = snakeFields(paymentReadOnlyFields)
+)
+
+// --- Envelope ---
+
+// DataResponse is the envelope of every v2 success body that is not a list.
+type DataResponse struct {
+	Data interface{} `json:"data"`
+}
+
+// ErrorResponseV2 is the body of every v2 error response.
+type ErrorResponseV2 struct {
+	Error ErrorBodyV2 `json:"error"`
+}
+
+type ErrorBodyV2 struct {
+	Code      string       `json:"code"`
+	Message   string       `json:"message"`
+	Details   []FieldError `json:"details,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+}
+
+// v1FieldName matches a v1 member name with an underscore, such as Due_Date.
+var v1FieldName = regexp.MustCompile(`\b[A-Z][A-Za-z0-9]*(?:_[A-Za-z0-9]+)+\b`)
+
+// errorBodyV2 renames the v1 member names that services and validation use in
+// messages and details to their v2 form.
+func errorBodyV2(e *APIError, requestID string) ErrorBodyV2 {
+	msg := v1FieldName.ReplaceAllStringFunc(e.Message, strings.ToLower)
+	var details []FieldError
+	for _, d := range e.Details {
+		field := strings.ToLower(d.Field)
+		dm := v1FieldName.ReplaceAllStringFunc(d.Message, strings.ToLower)
+		if d.Field != "" {
+			dm = strings.ReplaceAll(dm, d.Field, field)
+			msg = regexp.MustCompile(`\b`+regexp.QuoteMeta(d.Field)+`\b`).ReplaceAllString(msg, field)
+		}
+		details = append(details, FieldError{Field: field, Code: d.Code, Message: dm})
+	}
+	return ErrorBodyV2{Code: e.Code, Message: msg, Details: details, RequestID: requestID}
+}
+
+func isV2Response(w http.ResponseWriter) bool {
+	return w.Header().Get(apiVersionHeader) == "2"
+}
+
+// respondV2 sends data in the v2 envelope.
+func respondV2(w http.ResponseWriter, code int, data interface{}) {
+	respondWithJSON(w, code, DataResponse{Data: data})
+}
+
+// withAPIV2 marks the responses of the v2 subrouter.
+func withAPIV2(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(apiVersionHeader, "2")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// deprecateV1 adds the deprecation headers (RFC 9745, RFC 8594) and a
+// successor-version link to responses of v1 routes that router also serves
+// under /api/v2. Routes v2 has no counterpart for yet are not deprecated.
+func deprecateV1(router *mux.Router) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			path := r.URL.Path
+			if !strings.HasPrefix(path, "/api/v2/") {
+				if successor := v2Successor(router, r); successor != "" {
+					w.Header().Set("Deprecation", "@"+strconv.FormatInt(v1DeprecatedAt.Unix(), 10))
+					if !v1Sunset.IsZero() {
+						w.Header().Set("Sunset", v1Sunset.Format(http.TimeFormat))
+					}
+					w.Header().Set("Link", `</docs>; rel="deprecation"; type="text/html", <`+successor+`>; rel="successor-version"`)
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+var v2PathRewriter = strings.NewReplacer("/billing/", "/bills/")
+
+// v2Path returns the v2 counterpart of the v1 path or path template path.
+func v2Path(path string) string {
+	switch {
+	case strings.HasPrefix(path, "/api/public/"):
+		path = "/api/v2/public/" + strings.TrimPrefix(path, "/api/public/")
+	case strings.HasPrefix(path, "/public/"):
+		path = "/api/v2" + path
+	case strings.HasPrefix(path, "/api/"):
+		path = "/api/v2/" + strings.TrimPrefix(path, "/api/")
+	default:
+		path = "/api/v2" + path
+	}
+	path = v2PathRewriter.Replace(path + "/")
+	path = strings.TrimSuffix(path, "/")
+	if path == "/api/v2/billing" {
+		path = "/api/v2/bills"
+	}
+	return path
+}
+
+// v2Successor returns the v2 path serving the same request as the v1 request
+// r, or "" when v2 has no such route.
+func v2Successor(router *mux.Router, r *http.Request) string {
+	path := v2Path(r.URL.Path)
+	probe := r.Clone(r.Context())
+	probe.URL = &url.URL{Path: path}
+	var match mux.RouteMatch
+	if !router.Match(probe, &match) || match.MatchErr != nil {
+		return ""
+	}
+	return path
+}
+
+// --- Handlers ---
+
+// decodeV2 decodes a v2 request body, rejecting members v2 does not define.
+func decodeV2(w http.ResponseWriter, r *http.Request, dst interface{}) bool {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(dst); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// v2ID parses the {id} path variable, answering 400 when it is not a number.
+func v2ID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "id must be an integer")
+		return 0, false
+	}
+	return id, true
+}
+
+// recordService is implemented by the services of the resources v2 exposes
+// with list, read, create, replace, patch and delete.
+type recordService[M any] interface {
+	List(q url.Values) ([]M, ListMeta, error)
+	Get(id int) (M, error)
+	Create(a auditContext, m *M) error
+	Update(a auditContext, id int, m *M, version int) error
+	Patch(a auditContext, id, version int, apply func(*M) error) (M, error)
+	Delete(a auditContext, id, version int) error
+}
+
+// v2Resource builds the CRUD handlers of one resource from its service and
+// the conversions between the model M and its v2 representation V.
+type v2Resource[M, V any] struct {
+	service  recordService[M]
+	noun     string // For messages, e.g. "customer"
+	plural   string
+	toV2     func(M) V
+	fromV2   func(V) M
+	version  func(M) int
+	readOnly []string
+}
+
+func (res v2Resource[M, V]) list(w http.ResponseWriter, r *http.Request) {
+	items, meta, err := res.service.List(r.URL.Query())
+	if err != nil {
+		respondWithListError(w, res.plural, err)
+		return
+	}
+	data := make([]V, len(items))
+	for i, m := range items {
+		data[i] = res.toV2(m)
+	}
+	respondWithJSON(w, http.StatusOK, ListResponse{Data: data, Meta: meta})
+}
+
+func (res v2Resource[M, V]) get(w http.ResponseWriter, r *http.Request) {
+	id, ok := v2ID(w, r)
+	if !ok {
+		return
+	}
+	if r.URL.Query().Has("as_of") {
+		res.getAsOf(w, r, id)
+		return
+	}
+	m, err := res.service.Get(id)
+	if err != nil {
+		respondWithServerError(w, "Failed to retrieve "+res.noun, err)
+		return
+	}
+	if notModified(w, r, res.version(m)) {
+		return
+	}
+	respondV2(w, http.StatusOK, res.toV2(m))
+}
+
+// getAsOf answers a get with ?as_of=: the record as it was at that moment,
+// from its history. Past versions carry no ETag, as they cannot be written.
+func (res v2Resource[M, V]) getAsOf(w http.ResponseWriter, r *http.Request, id int) {
+	hs, ok := any(res.service).(historyService[M])
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "as_of is not supported for "+res.plural)
+		return
+	}
+	asOf, err := parseAsOf(r.URL.Query().Get("as_of"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid parameters: "+err.Error())
+		return
+	}
+	m, err := hs.GetAsOf(id, asOf)
+	if err != nil {
+		respondWithServerError(w, "Failed to retrieve "+res.noun, err)
+		return
+	}
+	respondV2(w, http.StatusOK, res.toV2(m))
+}
+
+// history lists the stored versions of the record, oldest first.
+func (res v2Resource[M, V]) history(w http.ResponseWriter, r *http.Request) {
+	id, ok := v2ID(w, r)
+	if !ok {
+		return
+	}
+	versions, meta, err := any(res.service).(historyService[M]).History(id, r.URL.Query())
+	if err != nil {
+		respondWithListError(w, res.noun+" history", err)
+		return
+	}
+	data := make([]RecordVersion[V], len(versions))
+	for i, v := range versions {
+		data[i] = RecordVersion[V]{v.HistoryID, v.ValidFrom, v.ValidTo, v.ChangedBy, res.toV2(v.Record)}
+	}
+	respondWithJSON(w, http.StatusOK, ListResponse{Data: data, Meta: meta})
+}
+
+func (res v2Resource[M, V]) create(w http.ResponseWriter, r *http.Request) {
+	var v V
+	if !decodeV2(w, r, &v) {
+		return
+	}
+	m := res.fromV2(v)
+	if err := res.service.Create(auditFor(r), &m); err != nil {
+		respondWithServerError(w, "Failed to create "+res.noun, err)
+		return
+	}
+	setETag(w, res.version(m))
+	respondV2(w, http.StatusCreated, res.toV2(m))
+}
+
+func (res v2Resource[M, V]) replace(w http.ResponseWriter, r *http.Request) {
+	id, ok := v2ID(w, r)
+	if !ok {
+		return
+	}
+	version, ok := requireIfMatch(w, r)
+	if !ok {
+		return
+	}
+	var v V
+	if !decodeV2(w, r, &v) {
+		return
+	}
+	m := res.fromV2(v)
+	if err := res.service.Update(auditFor(r), id, &m, version); err != nil {
+		respondWithServerError(w, "Failed to update "+res.noun, err)
+		return
+	}
+	setETag(w, res.version(m))
+	respondV2(w, http.StatusOK, res.toV2(m))
+}
+
+func (res v2Resource[M, V]) patch(w http.ResponseWriter, r *http.Request) {
+	id, ok := v2ID(w, r)
+	if !ok {
+		return
+	}
+	version, ok := requireIfMatch(w, r)
+	if !ok {
+		return
+	}
+	m, err := res.service.Patch(auditFor(r), id, version, func(m *M) error {
+		v := res.toV2(*m)
+		if err := applyMergePatch(r, &v, res.readOnly); err != nil {
+			return err
+		}
+		*m = res.fromV2(v)
+		return nil
+	})
+	if err != nil {
+		respondWithPatchError(w, "Failed to update "+res.noun, err)
+		return
+	}
+	setETag(w, res.version(m))
+	respondV2(w, http.StatusOK, res.toV2(m))
+}
+
+func (res v2Resource[M, V]) delete(w http.ResponseWriter, r *http.Request) {
+	id, ok := v2ID(w, r)
+	if !ok {
+		return
+	}
+	version, ok := requireIfMatch(w, r)
+	if !ok {
+		return
+	}
+	if err := res.service.Delete(auditFor(r), id, version); err != nil {
+		respondWithServerError(w, "Failed to delete "+res.noun, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// register adds the CRUD routes of the resource under path, and the history
+// route when its service keeps one.
+func (res v2Resource[M, V]) register(router *mux.Router, path string) {
+	router.HandleFunc(path, res.list).Methods("GET", "OPTIONS")
+	router.HandleFunc(path, res.create).Methods("POST", "OPTIONS")
+	router.HandleFunc(path+"/{id}", res.get).Methods("GET", "OPTIONS")
+	router.HandleFunc(path+"/{id}", res.replace).Methods("PUT", "OPTIONS")
+	router.HandleFunc(path+"/{id}", res.patch).Methods("PATCH", "OPTIONS")
+	router.HandleFunc(path+"/{id}", res.delete).Methods("DELETE", "OPTIONS")
+	if _, ok := any(res.service).(historyService[M]); ok {
+		router.HandleFunc(path+"/{id}/history", res.history).Methods("GET", "OPTIONS")
+	}
+}
+
+var (
+	customersV2 = v2Resource[Customer, CustomerV2]{customerSvc, "customer", "customers",
+		func(c Customer) CustomerV2 { return CustomerV2(c) }, func(v CustomerV2) Customer { return Customer(v) },
+		func(c Customer) int { return c.Version }, customerV2ReadOnlyFields}
+	metersV2 = v2Resource[Meter, MeterV2]{meterSvc, "meter", "meters",
+		func(m Meter) MeterV2 { return MeterV2(m) }, func(v MeterV2) Meter { return Meter(v) },
+		func(m Meter) int { return m.Version }, meterV2ReadOnlyFields}
+	billsV2 = v2Resource[Billing, BillV2]{billSvc, "bill", "bills", billToV2, billFromV2,
+		func(b Billing) int { return b.Version }, billV2ReadOnlyFields}
+	paymentsV2 = v2Resource[Payment, PaymentV2]{paymentSvc, "payment", "payments",
+		func(p Payment) PaymentV2 { return PaymentV2(p) }, func(v PaymentV2) Payment { return Payment(v) },
+		func(p Payment) int { return p.Version }, paymentV2ReadOnlyFields}
+)
+
+func loginV2(w http.ResponseWriter, r *http.Request) {
+	var creds Credentials
+	if !decodeV2(w, r, &creds) {
+		return
+	}
+	token, user, expires, err := authenticate(creds)
+	if err != nil {
+		respondWithServerError(w, "Failed to log in", err)
+		return
+	}
+	respondV2(w, http.StatusOK, SessionV2{Token: token, Username: user.Username, Permission: user.Permission, ExpiresAt: expires})
+}
+
+func getUsersV2(w http.ResponseWriter, r *http.Request) {
+	users, meta, err := listUsers(r.URL.Query())
+	if err != nil {
+		respondWithListError(w, "users", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, ListResponse{Data: users, Meta: meta})
+}
+
+// changeBillStatusV2 returns a handler that moves the bill in the path to the
+// given state and responds with the updated bill.
+func changeBillStatusV2(to string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := v2ID(w, r)
+		if !ok {
+			return
+		}
+		var req BillStatusRequest
+		if r.ContentLength != 0 && !decodeV2(w, r, &req) {
+			return
+		}
+		log.Printf("API v2: changing Bill ID %d to %s", id, to)
+		if _, _, err := billSvc.ChangeStatus(auditFor(r), id, to, req.Reason); err != nil {
+			respondWithServerError(w, "Failed to update bill status", err)
+			return
+		}
+		b, err := billSvc.Get(id)
+		if err != nil {
+			respondWithServerError(w, "Failed to retrieve bill", err)
+			return
+		}
+		setETag(w, b.Version)
+		respondV2(w, http.StatusOK, billToV2(b))
+	}
+}
+
+func reissueBillV2(w http.ResponseWriter, r *http.Request) {
+	id, ok := v2ID(w, r)
+	if !ok {
+		return
+	}
+	var req BillStatusRequest
+	if !decodeV2(w, r, &req) {
+		return
+	}
+	b, err := billSvc.Reissue(auditFor(r), id, req.Reason)
+	if err != nil {
+		respondWithServerError(w, "Failed to reissue bill", err)
+		return
+	}
+	if b, err = billSvc.Get(b.BillID); err != nil {
+		respondWithServerError(w, "Failed to retrieve bill", err)
+		return
+	}
+	setETag(w, b.Version)
+	respondV2(w, http.StatusCreated, billToV2(b))
+}
+
+func getCustomerBillsV2(w http.ResponseWriter, r *http.Request) {
+	bills, err := billSvc.OpenForCustomer(r.URL.Query().Get("identifier"))
+	if err != nil {
+		respondWithServerError(w, "Failed to retrieve bills", err)
+		return
+	}
+	data := make([]BillV2, len(bills))
+	for i, b := range bills {
+		data[i] = billToV2(b)
+	}
+	respondV2(w, http.StatusOK, data)
+}
+
+// payBillV2 pays a bill's outstanding balance. Unlike v1, paying a bill that
+// is already settled is a 409 rather than a success message.
+func payBillV2(w http.ResponseWriter, r *http.Request) {
+	id, ok := v2ID(w, r)
+	if !ok {
+		return
+	}
+	var req PublicPaymentRequest
+	if !decodeV2(w, r, &req) {
+		return
+	}
+	paymentID, err := paymentSvc.PayOutstanding(auditFor(r), id, req.PaymentMethod)
+	if err != nil {
+		respondWithServerError(w, "Failed to record payment", err)
+		return
+	}
+	respondV2(w, http.StatusCreated, BillPaymentV2{PaymentID: paymentID, BillID: id})
+}
+
+// registerV2 adds the /api/v2 routes to router.
+func registerV2(router *mux.Router) {
+	v2 := router.PathPrefix("/api/v2").Subrouter()
+	v2.Use(withAPIV2)
+	v2.HandleFunc("/login", loginV2).Methods("POST", "OPTIONS")
+	v2.HandleFunc("/public/customer-bills", getCustomerBillsV2).Methods("GET", "OPTIONS")
+	v2.HandleFunc("/public/bills/{id}/pay", payBillV2).Methods("POST", "OPTIONS")
+	v2.HandleFunc("/public/consumption", getPortalConsumptionV2).Methods("GET", "OPTIONS")
+
+	authed := v2.NewRoute().Subrouter()
+	authed.Use(authMiddleware)
+	registerExports(authed)
+	authed.HandleFunc("/users", getUsersV2).Methods("GET", "OPTIONS")
+	customersV2.register(authed, "/customers")
+	metersV2.register(authed, "/meters")
+	billsV2.register(authed, "/bills")
+	authed.HandleFunc("/bills/{id}/issue", changeBillStatusV2(BillStatusIssued)).Methods("POST", "OPTIONS")
+	authed.HandleFunc("/bills/{id}/void", changeBillStatusV2(BillStatusVoid)).Methods("POST", "OPTIONS")
+	authed.HandleFunc("/bills/{id}/write-off", changeBillStatusV2(BillStatusWrittenOff)).Methods("POST", "OPTIONS")
+	authed.HandleFunc("/bills/{id}/reissue", reissueBillV2).Methods("POST", "OPTIONS")
+	paymentsV2.register(authed, "/payments")
+	authed.HandleFunc("/imports", getImportsV2).Methods("GET", "OPTIONS")
+	authed.HandleFunc("/imports", createImportV2).Methods("POST", "OPTIONS")
+	authed.HandleFunc("/imports/{id}", getImportV2).Methods("GET", "OPTIONS")
+	authed.HandleFunc("/imports/{id}/rows", getImportRowsV2).Methods("GET", "OPTIONS")
+	authed.HandleFunc("/imports/{id}/resume", resumeImportV2).Methods("POST", "OPTIONS")
+	authed.HandleFunc("/customers/{id}/consumption", getCustomerConsumptionV2).Methods("GET", "OPTIONS")
+	authed.HandleFunc("/meters/{id}/consumption", getMeterConsumptionV2).Methods("GET", "OPTIONS")
+	registerReports(authed)
+	registerShifts(authed)
+	authed.HandleFunc("/audit", getAudit).Methods("GET", "OPTIONS")
+}
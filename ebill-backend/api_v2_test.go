@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDeprecateV1(t *testing.T) {
+	router := newRouter()
+	tests := []struct {
+		method, path string
+		successor    string // "" when the response must not be deprecated
+	}{
+		{"GET", "/api/customers/1", "/api/v2/customers/1"},
+		{"GET", "/api/billing", "/api/v2/bills"},
+		{"POST", "/api/billing/7/issue", "/api/v2/bills/7/issue"},
+		{"GET", "/api/public/customer-bills", "/api/v2/public/customer-bills"},
+		{"GET", "/api/billing/7/pdf", ""},
+		{"GET", "/api/billing/7/status-history", ""},
+		{"GET", "/api/reports/tax", ""},
+		{"GET", "/public/bills/7/qr", ""},
+		{"GET", "/api/v2/customers/1", ""},
+		{"GET", "/openapi.json", ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+		deprecation, link := w.Header().Get("Deprecation"), w.Header().Get("Link")
+		if tt.successor == "" {
+			if deprecation != "" || link != "" {
+				t.Errorf("%s %s: Deprecation %q, Link %q; want neither", tt.method, tt.path, deprecation, link)
+			}
+			continue
+		}
+		if deprecation == "" || !strings.Contains(link, "<"+tt.successor+`>; rel="successor-version"`) {
+			t.Errorf("%s %s: Deprecation %q, Link %q; want a successor link to %s", tt.method, tt.path, deprecation, link, tt.successor)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// Every insert, update and delete of customers, meters, bills, payments and
+// users is recorded in audit_log by database triggers, so writes made by
+// other triggers, imports and reconciliation are covered too. Services tell
+// the triggers who is acting by running their writes in a transaction begun
+// with an auditContext, which sets the ebill.* settings for that transaction
+// only. Changes made without one are logged without an actor. A row changed
+// several times in one transaction, such as a bill whose line items are
+// rewritten, gets a single entry spanning all of those changes.
+
+// auditContext says who makes a change and from where.
+type auditContext struct {
+	ActorID       *int
+	ActorUsername string
+	ClientIP      string
+	RequestID     string
+}
+
+// auditFor returns the audit context of an API request: the caller from the
+// JWT claims (none for public routes), their address and the request ID.
+func auditFor(r *http.Request) auditContext {
+	a := auditContext{ActorID: currentUserID(r), RequestID: requestID(r)}
+	if claims, ok := r.Context().Value(UserClaimsKey).(*CustomClaims); ok {
+		a.ActorUsername = claims.Username
+	}
+	a.ClientIP = r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		a.ClientIP = host
+	}
+	if net.ParseIP(a.ClientIP) == nil {
+		a.ClientIP = "" // The column is INET
+	}
+	return a
+}
+
+// apply attributes the changes tx makes from now on to a.
+func (a auditContext) apply(tx *sql.Tx) error {
+	actor := ""
+	if a.ActorID != nil {
+		actor = strconv.Itoa(*a.ActorID)
+	}
+	_, err := tx.Exec(`SELECT set_config('ebill.actor_id', $1, true), set_config('ebill.actor_username', $2, true),
+	                          set_config('ebill.client_ip', $3, true), set_config('ebill.request_id', $4, true)`,
+		actor, a.ActorUsername, a.ClientIP, a.RequestID)
+	return err
+}
+
+// begin starts a transaction whose changes are attributed to a.
+func (a auditContext) begin() (*sql.Tx, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	if err := a.apply(tx); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return tx, nil
+}
+
+// write runs fn in a transaction begun with a and commits it when fn succeeds.
+func (a auditContext) write(fn func(tx *sql.Tx) error) error {
+	tx, err := a.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AuditEntry is one recorded change.
+type AuditEntry struct {
+	AuditID       int             `json:"audit_id"`
+	ChangedAt     string          `json:"changed_at"`
+	ActorID       *int            `json:"actor_id"`       // Null for changes made outside a signed-in request
+	ActorUsername *string         `json:"actor_username"` // As it was at the time
+	Action        string          `json:"action"`         // create, update or delete
+	EntityType    string          `json:"entity_type"`    // customer, meter, bill, payment or user
+	EntityID      int             `json:"entity_id"`
+	Before        json.RawMessage `json:"before"` // The row before the change, by column; null for creates
+	After         json.RawMessage `json:"after"`  // The row after the change, by column; null for deletes
+	ClientIP      *string         `json:"client_ip"`
+	RequestID     *string         `json:"request_id"`
+}
+
+const auditColumns = `"audit_id", "changed_at", "actor_id", "actor_username", "action", "entity_type", "entity_id",
+	"before_data", "after_data", host("client_ip"), "request_id"`
+
+func scanAuditEntry(row rowScanner, e *AuditEntry) error {
+	var before, after []byte
+	err := row.Scan(&e.AuditID, &e.ChangedAt, &e.ActorID, &e.ActorUsername, &e.Action, &e.EntityType, &e.EntityID,
+		&before, &after, &e.ClientIP, &e.RequestID)
+	if before != nil {
+		e.Before = before
+	}
+	if after != nil {
+		e.After = after
+	}
+	return err
+}
+
+var auditList = listResource{
+	columns: auditColumns,
+	from:    `"audit_log"`,
+	key:     `"audit_id"`,
+	filters: map[string]listFilter{
+		"actor_id":        {`"actor_id"`, filterInt},
+		"actor_username":  {`"actor_username"`, filterText},
+		"action":          {`"action"`, filterText},
+		"entity_type":     {`"entity_type"`, filterText},
+		"entity_id":       {`"entity_id"`, filterInt},
+		"client_ip":       {`host("client_ip")`, filterText},
+		"request_id":      {`"request_id"`, filterText},
+		"changed_at_from": {`"changed_at"::date`, filterDateFrom},
+		"changed_at_to":   {`"changed_at"::date`, filterDateTo},
+	},
+	sorts: map[string]string{
+		"audit_id":   `"audit_id"`,
+		"changed_at": `"changed_at"`,
+	},
+	defaultSort: "-audit_id",
+}
+
+// getAudit lists recorded changes, newest first, for administrators. It is
+// served both under /api and under /api/v2.
+func getAudit(w http.ResponseWriter, r *http.Request) {
+	log.Println("API: getAudit called")
+	if currentPermission(r) != "administrator" {
+		respondWithError(w, http.StatusForbidden, "Only administrators can read the audit log")
+		return
+	}
+	entries := []AuditEntry{}
+	meta, err := auditList.query(r.URL.Query(), func(row rowScanner) error {
+		var e AuditEntry
+		if err := scanAuditEntry(row, &e); err != nil {
+			return err
+		}
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		respondWithListError(w, "audit entries", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, ListResponse{Data: entries, Meta: meta})
+}
@@ -0,0 +1,652 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// Bank statement formats accepted by the importer.
+const (
+	StatementFormatCSV   = "csv"
+	StatementFormatFixed = "fixed"
+)
+
+// maxStatementSize limits uploaded statement files.
+const maxStatementSize = 10 << 20
+
+// StatementLine is one line of a bank statement. Amount is positive for money
+// received; withdrawals and reversals are negative and are not imported.
+type StatementLine struct {
+	LineNo         int
+	TransactionRef string // the bank's ID, or a stable hash of the line when it has none
+	Date           time.Time
+	Reference      string
+	Description    string
+	PayerName      string
+	Amount         Money
+	Currency       string
+}
+
+// StatementLineError reports a line that could not be parsed.
+type StatementLineError struct {
+	LineNo  int    `json:"line"`
+	Message string `json:"message"`
+}
+
+func (e StatementLineError) Error() string { return fmt.Sprintf("line %d: %s", e.LineNo, e.Message) }
+
+// statementParseError collects every bad line so the whole file can be fixed at once.
+type statementParseError []StatementLineError
+
+func (e statementParseError) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, le := range e {
+		msgs = append(msgs, le.Error())
+	}
+	return "invalid bank statement: " + strings.Join(msgs, "; ")
+}
+
+// detectStatementFormat guesses the format from the file name, then the content.
+func detectStatementFormat(fileName string, data []byte) string {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".csv":
+		return StatementFormatCSV
+	case ".txt", ".dat":
+		return StatementFormatFixed
+	}
+	firstLine, _, _ := bytes.Cut(data, []byte("\n"))
+	if bytes.HasPrefix(firstLine, []byte("H")) && !bytes.Contains(firstLine, []byte(",")) {
+		return StatementFormatFixed
+	}
+	return StatementFormatCSV
+}
+
+// parseStatement parses a statement file in the given format.
+func parseStatement(data []byte, format, currency string) ([]StatementLine, error) {
+	switch format {
+	case StatementFormatCSV:
+		return parseCSVStatement(data, currency)
+	case StatementFormatFixed:
+		return parseFixedWidthStatement(data, currency)
+	}
+	return nil, fmt.Errorf("unknown statement format %q (use csv or fixed)", format)
+}
+
+// lineHashes gives lines without a bank transaction ID a reference derived from
+// their content, so importing the same file twice does not record anything twice.
+// Identical lines within one file are told apart by their occurrence.
+type lineHashes map[string]int
+
+func (h lineHashes) ref(raw string) string {
+	sum := sha1.Sum([]byte(raw))
+	key := hex.EncodeToString(sum[:])
+	h[key]++
+	return fmt.Sprintf("line:%s:%d", key[:40], h[key])
+}
+
+// statementDateLayouts are the date formats found in bank exports, tried in order.
+var statementDateLayouts = []string{
+	"2006-01-02", "2006-01-02 15:04:05", "2006-01-02T15:04:05Z07:00",
+	"02/01/2006", "02/01/2006 15:04", "02/01/2006 15:04:05", "2/1/2006",
+	"02-01-2006", "02012006",
+}
+
+// buddhistEraYear finds a Buddhist Era year leading a date, or following its
+// day and month.
+var buddhistEraYear = regexp.MustCompile(`^(\d{1,2}[/-]\d{1,2}[/-]|\d{4})?(2[45]\d\d)(\D|$)`)
+
+// gregorianDate rewrites a Buddhist Era year in s (e.g. 2567) as the Gregorian
+// one. This is done before parsing, since the years differ in which are leap
+// years: 29/02/2567 is a valid date.
+func gregorianDate(s string) string {
+	m := buddhistEraYear.FindStringSubmatchIndex(s)
+	if m == nil {
+		return s
+	}
+	year, _ := strconv.Atoi(s[m[4]:m[5]])
+	return s[:m[4]] + strconv.Itoa(year-543) + s[m[5]:]
+}
+
+// parseStatementDate parses a statement date. Thai banks often print Buddhist
+// Era years, which are converted to the Gregorian calendar.
+func parseStatementDate(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range statementDateLayouts {
+		if t, err := time.ParseInLocation(layout, gregorianDate(s), time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized date %q", s)
+}
+
+// parseStatementAmount parses amounts such as "1,234.50", "-20.00" or "(20.00)".
+func parseStatementAmount(s string) (Money, error) {
+	s = strings.NewReplacer(",", "", " ", "").Replace(strings.TrimSpace(s))
+	if s == "" {
+		return Money{}, nil
+	}
+	neg := strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")")
+	if neg {
+		s = s[1 : len(s)-1]
+	}
+	m, err := ParseMoney(s)
+	if err != nil {
+		return Money{}, fmt.Errorf("invalid amount %q", s)
+	}
+	if neg {
+		m = m.Neg()
+	}
+	return m, nil
+}
+
+// csvStatementColumns maps normalized header names to fields.
+var csvStatementColumns = map[string]string{
+	"date": "date", "transactiondate": "date", "valuedate": "date", "postingdate": "date",
+	"description": "description", "details": "description", "narrative": "description", "memo": "description",
+	"reference": "reference", "ref": "reference", "ref1": "reference", "paymentreference": "reference",
+	"amount": "amount", "credit": "credit", "deposit": "credit", "debit": "debit", "withdrawal": "debit", "currency": "currency",
+	"transactionid": "id", "transactionref": "id", "txnid": "id",
+	"payer": "payer", "payername": "payer", "name": "payer", "counterparty": "payer",
+}
+
+// parseCSVStatement reads a CSV export with a header row. Columns are found by
+// name (see csvStatementColumns); a Date and either an Amount or a Credit column
+// are required.
+func parseCSVStatement(data []byte, currency string) ([]StatementLine, error) {
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	header, err := r.Read()
+	if err != nil {
+		return nil, statementParseError{{LineNo: 1, Message: "missing header row: " + err.Error()}}
+	}
+	cols := map[string]int{}
+	for i, name := range header {
+		key := strings.ToLower(nonAlphanumeric.ReplaceAllString(strings.ToUpper(name), ""))
+		if field, ok := csvStatementColumns[key]; ok {
+			if _, dup := cols[field]; !dup {
+				cols[field] = i
+			}
+		}
+	}
+	_, hasAmount := cols["amount"]
+	_, hasCredit := cols["credit"]
+	if _, ok := cols["date"]; !ok || !(hasAmount || hasCredit) {
+		return nil, statementParseError{{LineNo: 1, Message: "header must name a Date column and an Amount or Credit column"}}
+	}
+
+	var lines []StatementLine
+	var errs statementParseError
+	hashes := lineHashes{}
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		lineNo, _ := r.FieldPos(0)
+		if err != nil {
+			errs = append(errs, StatementLineError{LineNo: lineNo, Message: err.Error()})
+			continue
+		}
+		get := func(field string) string {
+			if i, ok := cols[field]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		if strings.Join(record, "") == "" {
+			continue
+		}
+		l := StatementLine{LineNo: lineNo, Reference: get("reference"), Description: get("description"),
+			PayerName: get("payer"), Currency: strings.ToUpper(get("currency")), TransactionRef: get("id")}
+		if l.Currency == "" {
+			l.Currency = currency
+		}
+		if l.Date, err = parseStatementDate(get("date")); err != nil {
+			errs = append(errs, StatementLineError{LineNo: lineNo, Message: err.Error()})
+			continue
+		}
+		if hasAmount {
+			l.Amount, err = parseStatementAmount(get("amount"))
+		} else {
+			var credit, debit Money
+			if credit, err = parseStatementAmount(get("credit")); err == nil {
+				debit, err = parseStatementAmount(get("debit"))
+			}
+			l.Amount = credit.Sub(debit)
+		}
+		if err != nil {
+			errs = append(errs, StatementLineError{LineNo: lineNo, Message: err.Error()})
+			continue
+		}
+		if l.TransactionRef == "" {
+			l.TransactionRef = hashes.ref(strings.Join(record, "\x1f"))
+		}
+		lines = append(lines, l)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return lines, nil
+}
+
+// fixedWidthField is a field of the fixed-width format, by 1-based column and width.
+type fixedWidthField struct{ start, width int }
+
+// Detail record ("D") layout of the bill payment text file that Thai banks send
+// to billers: 256-character records, with "H" header and "T" trailer records.
+var (
+	fwSequence    = fixedWidthField{2, 6}
+	fwBankCode    = fixedWidthField{8, 3}
+	fwPaymentDate = fixedWidthField{21, 8} // DDMMYYYY
+	fwPaymentTime = fixedWidthField{29, 6} // HHMMSS
+	fwPayerName   = fixedWidthField{35, 50}
+	fwRef1        = fixedWidthField{85, 20}
+	fwRef2        = fixedWidthField{105, 20}
+	fwRef3        = fixedWidthField{125, 20}
+	fwKind        = fixedWidthField{153, 1}  // C = credit, D = debit (reversal)
+	fwAmount      = fixedWidthField{164, 13} // two implied decimal places
+)
+
+func (f fixedWidthField) get(line []rune) string {
+	end := min(f.start-1+f.width, len(line))
+	if f.start-1 >= end {
+		return ""
+	}
+	return strings.TrimSpace(string(line[f.start-1 : end]))
+}
+
+// decodeStatementLine returns the characters of a fixed-width line. Files are
+// usually TIS-620 (one byte per character, Thai at 0xA1–0xFB); UTF-8 files are
+// accepted too, since column positions are counted in characters either way.
+func decodeStatementLine(raw []byte) []rune {
+	if utf8.Valid(raw) {
+		return []rune(string(raw))
+	}
+	out := make([]rune, len(raw))
+	for i, b := range raw {
+		if b >= 0xA1 && b <= 0xFB {
+			out[i] = rune(b) + 0x0E01 - 0xA1
+		} else {
+			out[i] = rune(b)
+		}
+	}
+	return out
+}
+
+// parseFixedWidthStatement reads a bill payment text file. Ref1 carries the
+// payment reference; Ref2 and Ref3 are kept as the description.
+func parseFixedWidthStatement(data []byte, currency string) ([]StatementLine, error) {
+	var lines []StatementLine
+	var errs statementParseError
+	hashes := lineHashes{}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 4096), 1<<20)
+	lineNo := 0
+	for sc.Scan() {
+		lineNo++
+		raw := bytes.TrimRight(sc.Bytes(), "\r")
+		if len(bytes.TrimSpace(raw)) == 0 || raw[0] != 'D' {
+			continue // header, trailer and blank records
+		}
+		rec := decodeStatementLine(raw)
+		if len(rec) < fwAmount.start+fwAmount.width-1 {
+			errs = append(errs, StatementLineError{LineNo: lineNo, Message: fmt.Sprintf("detail record is %d characters, expected at least %d", len(rec), fwAmount.start+fwAmount.width-1)})
+			continue
+		}
+		date, err := time.ParseInLocation("02012006150405", gregorianDate(fwPaymentDate.get(rec))+fwPaymentTime.get(rec), time.Local)
+		if err != nil {
+			errs = append(errs, StatementLineError{LineNo: lineNo, Message: fmt.Sprintf("invalid payment date/time %q", fwPaymentDate.get(rec)+fwPaymentTime.get(rec))})
+			continue
+		}
+		cents, err := strconv.ParseInt(fwAmount.get(rec), 10, 64)
+		if err != nil {
+			errs = append(errs, StatementLineError{LineNo: lineNo, Message: fmt.Sprintf("invalid amount %q", fwAmount.get(rec))})
+			continue
+		}
+		amount := MoneyFromSatang(cents)
+		if fwKind.get(rec) == "D" {
+			amount = amount.Neg()
+		}
+		lines = append(lines, StatementLine{
+			LineNo: lineNo,
+			// Sequence numbers restart in every file, so the line content identifies it.
+			TransactionRef: hashes.ref(string(raw)),
+			Date:           date,
+			Reference:      fwRef1.get(rec),
+			Description:    strings.TrimSpace(fwRef2.get(rec) + " " + fwRef3.get(rec)),
+			PayerName:      fwPayerName.get(rec),
+			Amount:         amount,
+			Currency:       currency,
+		})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return lines, nil
+}
+
+// BankStatementImport is one imported statement file.
+type BankStatementImport struct {
+	ImportID       int    `json:"Import_ID"`
+	FileName       string `json:"File_Name"`
+	Format         string `json:"Format"`
+	ImportedBy     *int   `json:"Imported_By"`
+	ImportedAt     string `json:"Imported_At"`
+	TotalLines     int    `json:"Total_Lines"`
+	SkippedLines   int    `json:"Skipped_Lines"`   // withdrawals and reversals
+	DuplicateLines int    `json:"Duplicate_Lines"` // already imported from an earlier statement
+}
+
+const bankStatementImportColumns = `"import_id", "file_name", "format", "imported_by", "imported_at", "total_lines", "skipped_lines", "duplicate_lines"`
+
+func scanBankStatementImport(row rowScanner, s *BankStatementImport) error {
+	return row.Scan(&s.ImportID, &s.FileName, &s.Format, &s.ImportedBy, &s.ImportedAt, &s.TotalLines, &s.SkippedLines, &s.DuplicateLines)
+}
+
+// matchStatementLine finds the bill a statement credit is for. A line whose text
+// contains exactly one open bill's payment reference, for exactly its outstanding
+// balance, is applied at once. Lines naming several bills, or none but with an
+// amount that equals some open balances, are queued as ambiguous with those
+// bills as candidates; anything else is queued as unmatched or mismatched.
+func matchStatementLine(tx *sql.Tx, t *InboundTransfer, text string, actor *int) error {
+	t.MatchStatus = TransferUnmatched
+	rows, err := tx.Query(`SELECT "bill_id", "bill_status", "payment_reference" FROM "billing"
+	                       WHERE "bill_status" IN ($1, $2) AND "payment_reference" <> '' AND strpos($3, "payment_reference") > 0
+	                       ORDER BY "bill_id" FOR UPDATE`, BillStatusIssued, BillStatusPartiallyPaid, promptPayReference(text))
+	if err != nil {
+		return err
+	}
+	var ids []int64
+	var status, reference string
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id, &status, &reference); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	switch len(ids) {
+	case 1:
+		t.PaymentReference = reference
+		return applyTransfer(tx, t, int(ids[0]), status, true, actor)
+	case 0:
+		// No reference: suggest the open bills that this exact amount would settle.
+		err = tx.QueryRow(`SELECT COALESCE(ARRAY_AGG("bill_id" ORDER BY "bill_id"), '{}') FROM (
+		                   SELECT "bill_id" FROM "bill_balance" WHERE "bill_status" IN ($1, $2) AND "currency" = $3 AND "outstanding" = $4
+		                   ORDER BY "bill_id" LIMIT 10) candidates`,
+			BillStatusIssued, BillStatusPartiallyPaid, t.Currency, t.Amount).Scan(pq.Array(&ids))
+		if err != nil {
+			return err
+		}
+	}
+	if len(ids) > 0 {
+		t.MatchStatus, t.CandidateBillIDs = TransferAmbiguous, ids
+	}
+	return nil
+}
+
+// importBankStatement parses a statement and reconciles every credit line in one
+// transaction, so a failed import leaves nothing behind. The payments it books
+// are attributed to a's actor.
+func importBankStatement(fileName, format string, data []byte, currency string, a auditContext) (*ReconciliationReport, error) {
+	actor := a.ActorID
+	if format == "" || format == "auto" {
+		format = detectStatementFormat(fileName, data)
+	}
+	lines, err := parseStatement(data, format, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := a.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	imp := BankStatementImport{FileName: fileName, Format: format, ImportedBy: actor, TotalLines: len(lines)}
+	err = tx.QueryRow(`INSERT INTO "bank_statement_import" ("file_name", "format", "imported_by", "total_lines")
+	                   VALUES ($1, $2, $3, $4) RETURNING "import_id"`, fileName, format, actor, len(lines)).Scan(&imp.ImportID)
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range lines {
+		if l.Amount.Sign() <= 0 {
+			imp.SkippedLines++
+			continue
+		}
+		t := InboundTransfer{
+			Source:         TransferSourceBankStatement,
+			ImportID:       &imp.ImportID,
+			TransactionRef: l.TransactionRef,
+			Amount:         l.Amount,
+			Currency:       l.Currency,
+			TransferredAt:  l.Date.Format(time.RFC3339),
+		}
+		if seen, err := findInboundTransfer(tx, &t); err != nil {
+			return nil, err
+		} else if seen {
+			imp.DuplicateLines++
+			continue
+		}
+		t.PaymentReference = promptPayReference(l.Reference)
+		if len(t.PaymentReference) > 30 {
+			t.PaymentReference = t.PaymentReference[:30]
+		}
+		if l.Description != "" {
+			t.Description = &l.Description
+		}
+		if l.PayerName != "" {
+			t.PayerName = &l.PayerName
+		}
+		if err := matchStatementLine(tx, &t, l.Reference+" "+l.Description, actor); err != nil {
+			return nil, fmt.Errorf("line %d: %w", l.LineNo, err)
+		}
+		if err := insertInboundTransfer(tx, &t); err != nil {
+			return nil, fmt.Errorf("line %d: %w", l.LineNo, err)
+		}
+	}
+	_, err = tx.Exec(`UPDATE "bank_statement_import" SET "skipped_lines" = $1, "duplicate_lines" = $2 WHERE "import_id" = $3`,
+		imp.SkippedLines, imp.DuplicateLines, imp.ImportID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return loadReconciliationReport(imp.ImportID)
+}
+
+// ReconciliationBucket totals the lines of an import with one match status.
+type ReconciliationBucket struct {
+	MatchStatus string `json:"Match_Status"`
+	Count       int    `json:"Count"`
+	Amount      Money  `json:"Amount"`
+}
+
+// ReconciliationReport summarizes an import: what was paid automatically and
+// what is waiting for review.
+type ReconciliationReport struct {
+	Import  BankStatementImport    `json:"Import"`
+	Summary []ReconciliationBucket `json:"Summary"`
+	Lines   []InboundTransfer      `json:"Lines"`
+}
+
+// loadReconciliationReport builds the report of an import from its current
+// state, so it also reflects reviews done since the import.
+func loadReconciliationReport(importID int) (*ReconciliationReport, error) {
+	rep := &ReconciliationReport{}
+	err := scanBankStatementImport(db.QueryRow(`SELECT `+bankStatementImportColumns+` FROM "bank_statement_import" WHERE "import_id" = $1`, importID), &rep.Import)
+	if err != nil {
+		return nil, err
+	}
+	rep.Lines, err = queryInboundTransfers(`SELECT `+inboundTransferColumns+` FROM "inbound_transfer" WHERE "import_id" = $1 ORDER BY "transfer_id"`, importID)
+	if err != nil {
+		return nil, err
+	}
+	buckets := map[string]*ReconciliationBucket{}
+	for _, t := range rep.Lines {
+		b, ok := buckets[t.MatchStatus]
+		if !ok {
+			rep.Summary = append(rep.Summary, ReconciliationBucket{MatchStatus: t.MatchStatus})
+			b = &rep.Summary[len(rep.Summary)-1]
+			buckets[t.MatchStatus] = b
+		}
+		b.Count++
+		b.Amount = b.Amount.Add(t.Amount)
+	}
+	if rep.Summary == nil {
+		rep.Summary = []ReconciliationBucket{}
+	}
+	return rep, nil
+}
+
+// WriteText prints the report for the command line.
+func (rep *ReconciliationReport) WriteText(w io.Writer) error {
+	imp := rep.Import
+	fmt.Fprintf(w, "Import #%d: %s (%s), %d lines, %d skipped (debits), %d duplicates\n",
+		imp.ImportID, imp.FileName, imp.Format, imp.TotalLines, imp.SkippedLines, imp.DuplicateLines)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "STATUS\tLINES\tAMOUNT")
+	for _, b := range rep.Summary {
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", b.MatchStatus, b.Count, b.Amount)
+	}
+	tw.Flush()
+	var review []InboundTransfer
+	for _, t := range rep.Lines {
+		if transferNeedsReview(t.MatchStatus) {
+			review = append(review, t)
+		}
+	}
+	if len(review) == 0 {
+		_, err := fmt.Fprintln(w, "Nothing to review.")
+		return err
+	}
+	fmt.Fprintf(w, "\n%d line(s) queued for review:\n", len(review))
+	fmt.Fprintln(tw, "TRANSFER\tDATE\tAMOUNT\tREFERENCE\tSTATUS\tCANDIDATE BILLS")
+	for _, t := range review {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%v\n", t.TransferID, displayDate(t.TransferredAt), t.Amount.Format(t.Currency),
+			t.PaymentReference, t.MatchStatus, t.CandidateBillIDs)
+	}
+	return tw.Flush()
+}
+
+// uploadBankStatement imports a statement sent as multipart form field "file" or
+// as the raw request body. ?format= (csv, fixed or auto) and ?currency= are optional.
+func uploadBankStatement(w http.ResponseWriter, r *http.Request) {
+	log.Println("API: uploadBankStatement called")
+	r.Body = http.MaxBytesReader(w, r.Body, maxStatementSize)
+	fileName := r.URL.Query().Get("filename")
+	var data []byte
+	var err error
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, header, ferr := r.FormFile("file")
+		if ferr != nil {
+			respondWithError(w, http.StatusBadRequest, "Missing statement file: "+ferr.Error())
+			return
+		}
+		defer file.Close()
+		fileName = header.Filename
+		data, err = io.ReadAll(file)
+	} else {
+		data, err = io.ReadAll(r.Body)
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Failed to read statement file: "+err.Error())
+		return
+	}
+	if len(data) == 0 {
+		respondWithError(w, http.StatusBadRequest, "Statement file is empty")
+		return
+	}
+	currency := strings.ToUpper(r.URL.Query().Get("currency"))
+	if currency == "" {
+		currency = DefaultCurrency
+	}
+
+	rep, err := importBankStatement(fileName, r.URL.Query().Get("format"), data, currency, auditFor(r))
+	if err != nil {
+		if perr, ok := err.(statementParseError); ok {
+			respondWithJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{"error": "Invalid bank statement", "line_errors": []StatementLineError(perr)})
+			return
+		}
+		respondWithServerError(w, "Failed to import bank statement", err)
+		return
+	}
+	log.Printf("Imported bank statement %q as import %d", fileName, rep.Import.ImportID)
+	respondWithJSON(w, http.StatusCreated, rep)
+}
+
+// getBankStatementImports lists imported statements, newest first.
+func getBankStatementImports(w http.ResponseWriter, r *http.Request) {
+	log.Println("API: getBankStatementImports called")
+	rows, err := db.Query(`SELECT ` + bankStatementImportColumns + ` FROM "bank_statement_import" ORDER BY "import_id" DESC`)
+	if err != nil {
+		respondWithServerError(w, "Failed to retrieve statement imports", err)
+		return
+	}
+	defer rows.Close()
+	imports := []BankStatementImport{}
+	for rows.Next() {
+		var s BankStatementImport
+		if err := scanBankStatementImport(rows, &s); err != nil {
+			respondWithServerError(w, "Error scanning statement import", err)
+			return
+		}
+		imports = append(imports, s)
+	}
+	if err = rows.Err(); err != nil {
+		respondWithServerError(w, "Error iterating statement import rows", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, imports)
+}
+
+// getBankStatementReport returns the reconciliation report of an import.
+func getBankStatementReport(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Import ID")
+		return
+	}
+	log.Printf("API: getBankStatementReport called for Import ID: %d", id)
+	rep, err := loadReconciliationReport(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, http.StatusNotFound, "Statement import not found")
+		} else {
+			respondWithServerError(w, "Failed to build reconciliation report", err)
+		}
+		return
+	}
+	respondWithJSON(w, http.StatusOK, rep)
+}
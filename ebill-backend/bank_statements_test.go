@@ -0,0 +1,293 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestParseStatementDate(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"2024-04-15", "2024-04-15 00:00"},
+		{" 2024-04-15 13:05:09 ", "2024-04-15 13:05"},
+		{"2024-04-15T13:05:00+07:00", "2024-04-15 13:05"},
+		{"15/04/2024", "2024-04-15 00:00"},
+		{"15/04/2024 13:05", "2024-04-15 13:05"},
+		{"5/4/2024", "2024-04-05 00:00"},
+		{"15-04-2024", "2024-04-15 00:00"},
+		{"15042024", "2024-04-15 00:00"},
+		// Buddhist Era years
+		{"15/04/2567", "2024-04-15 00:00"},
+		{"2567-04-15", "2024-04-15 00:00"},
+		{"29022567", "2024-02-29 00:00"},
+		{"29/02/2567 08:30", "2024-02-29 08:30"},
+		{"25122567", "2024-12-25 00:00"},
+		{"25122024", "2024-12-25 00:00"},
+		{"25/12/2024", "2024-12-25 00:00"},
+	}
+	for _, tt := range tests {
+		got, err := parseStatementDate(tt.in)
+		if err != nil {
+			t.Errorf("parseStatementDate(%q): %v", tt.in, err)
+		} else if got.Format("2006-01-02 15:04") != tt.want {
+			t.Errorf("parseStatementDate(%q) = %s, want %s", tt.in, got.Format("2006-01-02 15:04"), tt.want)
+		}
+	}
+	for _, in := range []string{"", "2024/04/15", "31/02/2024", "29/02/2566", "15 April 2024", "20240415"} {
+		if got, err := parseStatementDate(in); err == nil {
+			t.Errorf("parseStatementDate(%q) = %s, want an error", in, got)
+		}
+	}
+}
+
+func TestParseStatementAmount(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"1,234.50", "1234.50"},
+		{" 20 ", "20.00"},
+		{"1 000.00", "1000.00"},
+		{"-20.00", "-20.00"},
+		{"(20.00)", "-20.00"},
+		{"(1,234.56)", "-1234.56"},
+		{"", "0.00"},
+	}
+	for _, tt := range tests {
+		got, err := parseStatementAmount(tt.in)
+		if err != nil {
+			t.Errorf("parseStatementAmount(%q): %v", tt.in, err)
+		} else if got.Cmp(mustParseMoney(t, tt.want)) != 0 {
+			t.Errorf("parseStatementAmount(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+	for _, in := range []string{"abc", "(20.00", "20.00)", "()", "1.2.3", "THB 20"} {
+		if got, err := parseStatementAmount(in); err == nil {
+			t.Errorf("parseStatementAmount(%q) = %s, want an error", in, got)
+		}
+	}
+}
+
+var lineRefPattern = regexp.MustCompile(`^line:[0-9a-f]{40}:\d+$`)
+
+func TestLineHashesRef(t *testing.T) {
+	h := lineHashes{}
+	a1, b1, a2 := h.ref("a"), h.ref("b"), h.ref("a")
+	for _, ref := range []string{a1, b1, a2} {
+		if !lineRefPattern.MatchString(ref) {
+			t.Errorf("ref %q is not line:<sha1>:<occurrence>", ref)
+		}
+	}
+	if a1 == b1 || a1 == a2 {
+		t.Errorf("refs %q, %q, %q are not distinct", a1, b1, a2)
+	}
+	if !strings.HasSuffix(a1, ":1") || !strings.HasSuffix(a2, ":2") || strings.TrimSuffix(a1, "1") != strings.TrimSuffix(a2, "2") {
+		t.Errorf("repeated line got %q then %q, want the same hash with occurrences 1 and 2", a1, a2)
+	}
+	// Importing the same file again must give the same references.
+	again := lineHashes{}
+	if got := []string{again.ref("a"), again.ref("b"), again.ref("a")}; fmt.Sprint(got) != fmt.Sprint([]string{a1, b1, a2}) {
+		t.Errorf("second import refs = %v, want %v", got, []string{a1, b1, a2})
+	}
+}
+
+func TestParseCSVStatement(t *testing.T) {
+	data := "\xef\xbb\xbfTransaction Date, Details,Ref 1,Credit,Debit,Payer Name,Txn ID\n" +
+		"15/04/2567,Transfer,INV-202404-00001,\"1,234.50\",,Somchai,TX1\n" +
+		"\n" +
+		"16/04/2024,Fee,,,(20.00),Bank,\n" +
+		"16/04/2024,Cash deposit,INV2,100.00,,,\n" +
+		"16/04/2024,Cash deposit,INV2,100.00,,,\n"
+	lines, err := parseCSVStatement([]byte(data), "THB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		lineNo                         int
+		date, ref, desc, payer, amount string
+	}{
+		{2, "2024-04-15", "INV-202404-00001", "Transfer", "Somchai", "1234.50"},
+		{4, "2024-04-16", "", "Fee", "Bank", "20.00"}, // A negative debit is money in
+		{5, "2024-04-16", "INV2", "Cash deposit", "", "100.00"},
+		{6, "2024-04-16", "INV2", "Cash deposit", "", "100.00"},
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("got %d lines, want %d: %+v", len(lines), len(want), lines)
+	}
+	for i, w := range want {
+		l := lines[i]
+		if l.LineNo != w.lineNo || l.Date.Format("2006-01-02") != w.date || l.Reference != w.ref || l.Description != w.desc ||
+			l.PayerName != w.payer || l.Amount.Cmp(mustParseMoney(t, w.amount)) != 0 || l.Currency != "THB" {
+			t.Errorf("line %d = %+v, want %+v", i, l, w)
+		}
+	}
+	if lines[0].TransactionRef != "TX1" {
+		t.Errorf("TransactionRef = %q, want the bank's TX1", lines[0].TransactionRef)
+	}
+	if lines[2].TransactionRef == lines[3].TransactionRef || !lineRefPattern.MatchString(lines[2].TransactionRef) {
+		t.Errorf("identical lines got refs %q and %q, want distinct line hashes", lines[2].TransactionRef, lines[3].TransactionRef)
+	}
+	again, err := parseCSVStatement([]byte(data), "THB")
+	if err != nil || again[3].TransactionRef != lines[3].TransactionRef {
+		t.Errorf("parsing the file again did not give the same line references")
+	}
+}
+
+func TestParseCSVStatementAmountAndCurrency(t *testing.T) {
+	data := "Date,Amount,Currency,Reference\n2024-04-15,-50.00,usd,R1\n2024-04-16,75,,R2\n"
+	lines, err := parseCSVStatement([]byte(data), "THB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 2 || lines[0].Amount.Cmp(mustParseMoney(t, "-50")) != 0 || lines[0].Currency != "USD" ||
+		lines[1].Amount.Cmp(mustParseMoney(t, "75")) != 0 || lines[1].Currency != "THB" {
+		t.Errorf("got %+v", lines)
+	}
+}
+
+func TestParseCSVStatementErrors(t *testing.T) {
+	tests := []struct {
+		name, data string
+		lines      []int // Lines reported, in order
+	}{
+		{"empty file", "", []int{1}},
+		{"no amount column", "Date,Description\n2024-04-15,x\n", []int{1}},
+		{"no date column", "Description,Amount\nx,1.00\n", []int{1}},
+		{"every bad line", "Date,Amount\n2024-04-15,1.00\nyesterday,1.00\n2024-04-15,lots\n2024-04-15,2.00\n", []int{3, 4}},
+	}
+	for _, tt := range tests {
+		lines, err := parseCSVStatement([]byte(tt.data), "THB")
+		var perr statementParseError
+		if !errors.As(err, &perr) {
+			t.Errorf("%s: got %v, %v; want a statementParseError", tt.name, lines, err)
+			continue
+		}
+		var got []int
+		for _, le := range perr {
+			got = append(got, le.LineNo)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.lines) {
+			t.Errorf("%s: errors on lines %v, want %v (%v)", tt.name, got, tt.lines, err)
+		}
+	}
+}
+
+func TestDecodeStatementLine(t *testing.T) {
+	tests := []struct {
+		in   []byte
+		want string
+	}{
+		{[]byte("D000001 ABC"), "D000001 ABC"},
+		{[]byte("สมชาย"), "สมชาย"},           // UTF-8
+		{tis620("สมชาย ใจดี"), "สมชาย ใจดี"}, // TIS-620
+		{[]byte{0xA1, 0xFB, 0xA0, 0xFC}, "ก๛ ü"},
+	}
+	for _, tt := range tests {
+		if got := string(decodeStatementLine(tt.in)); got != tt.want {
+			t.Errorf("decodeStatementLine(% X) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+	// Columns count characters, so a TIS-620 line and its UTF-8 form line up.
+	if a, b := decodeStatementLine(tis620("กขค 1")), decodeStatementLine([]byte("กขค 1")); len(a) != 5 || string(a) != string(b) {
+		t.Errorf("TIS-620 and UTF-8 lines decode to %q and %q", string(a), string(b))
+	}
+}
+
+func TestParseFixedWidthStatement(t *testing.T) {
+	credit := fixedWidthRecord(map[fixedWidthField]string{
+		fwSequence: "000001", fwBankCode: "014", fwPaymentDate: "15042567", fwPaymentTime: "130509",
+		fwPayerName: "สมชาย ใจดี", fwRef1: "INV20240400001", fwRef2: "CUST42", fwRef3: "",
+		fwKind: "C", fwAmount: "0000000123450",
+	})
+	reversal := fixedWidthRecord(map[fixedWidthField]string{
+		fwSequence: "000002", fwPaymentDate: "29022567", fwPaymentTime: "080000",
+		fwRef1: "INV20240400002", fwRef2: "A", fwRef3: "B", fwKind: "D", fwAmount: "0000000002000",
+	})
+	if len([]rune(credit)) != 256 {
+		t.Fatalf("test record is %d characters, want 256", len([]rune(credit)))
+	}
+	file := append(tis620("H000000 PROVINCIAL ELECTRICITY\r\n"), tis620(credit+"\r\n")...)
+	file = append(file, []byte(reversal+"\n\nT000003\n")...)
+	lines, err := parseFixedWidthStatement(file, "THB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2: %+v", len(lines), lines)
+	}
+	l := lines[0]
+	if l.LineNo != 2 || l.Date.Format("2006-01-02 15:04:05") != "2024-04-15 13:05:09" || l.PayerName != "สมชาย ใจดี" ||
+		l.Reference != "INV20240400001" || l.Description != "CUST42" || l.Amount.Cmp(mustParseMoney(t, "1234.50")) != 0 || l.Currency != "THB" {
+		t.Errorf("credit line = %+v", l)
+	}
+	l = lines[1]
+	if l.LineNo != 3 || l.Date.Format("2006-01-02") != "2024-02-29" || l.Reference != "INV20240400002" ||
+		l.Description != "A B" || l.Amount.Cmp(mustParseMoney(t, "-20.00")) != 0 {
+		t.Errorf("reversal line = %+v", l)
+	}
+	if !lineRefPattern.MatchString(lines[0].TransactionRef) || lines[0].TransactionRef == lines[1].TransactionRef {
+		t.Errorf("refs %q and %q, want distinct line hashes", lines[0].TransactionRef, lines[1].TransactionRef)
+	}
+	// The same record in a later file is recognised as already imported.
+	again, err := parseFixedWidthStatement(tis620(credit), "THB")
+	if err != nil || len(again) != 1 || again[0].TransactionRef != lines[0].TransactionRef {
+		t.Errorf("re-importing the credit record gave %+v, %v; want ref %s", again, err, lines[0].TransactionRef)
+	}
+}
+
+func TestParseFixedWidthStatementErrors(t *testing.T) {
+	good := map[fixedWidthField]string{fwPaymentDate: "15042024", fwPaymentTime: "130509", fwKind: "C", fwAmount: "0000000000100"}
+	with := func(f fixedWidthField, v string) string {
+		fields := map[fixedWidthField]string{}
+		for k, s := range good {
+			fields[k] = s
+		}
+		fields[f] = v
+		return fixedWidthRecord(fields)
+	}
+	file := strings.Join([]string{
+		"H header",
+		fixedWidthRecord(good),
+		"D000002 too short",
+		with(fwPaymentDate, "31022024"),
+		with(fwAmount, "00000000001.0"),
+		fixedWidthRecord(good),
+	}, "\n")
+	lines, err := parseFixedWidthStatement([]byte(file), "THB")
+	var perr statementParseError
+	if !errors.As(err, &perr) {
+		t.Fatalf("got %v, %v; want a statementParseError", lines, err)
+	}
+	var got []int
+	for _, le := range perr {
+		got = append(got, le.LineNo)
+	}
+	if fmt.Sprint(got) != "[3 4 5]" {
+		t.Errorf("errors on lines %v, want [3 4 5] (%v)", got, err)
+	}
+}
+
+// fixedWidthRecord lays fields out in a 256-character detail record.
+func fixedWidthRecord(fields map[fixedWidthField]string) string {
+	rec := []rune("D" + strings.Repeat(" ", 255))
+	for f, v := range fields {
+		copy(rec[f.start-1:f.start-1+f.width], []rune(v))
+	}
+	return string(rec)
+}
+
+// tis620 encodes s, which must hold only ASCII and Thai, as TIS-620.
+func tis620(s string) []byte {
+	var out []byte
+	for _, r := range s {
+		if r >= 0x0E01 && r <= 0x0E5B {
+			out = append(out, byte(r-0x0E01+0xA1))
+		} else {
+			out = append(out, byte(r))
+		}
+	}
+	return out
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// Bill lifecycle states, matching the CHECK constraint on Billing.Bill_Status.
+//
+//	draft ──issue──▶ issued ──payment──▶ partially_paid ──payment──▶ paid
+//	  │                │                      │
+//	  └──void──▶ void ◀┘            write-off ┴──▶ written_off
+//
+// Only drafts may be edited or deleted. Issued bills are corrected by voiding
+// and reissuing them, or with credit/debit notes, so nothing is overwritten.
+const (
+	BillStatusDraft         = "draft"
+	BillStatusIssued        = "issued"
+	BillStatusPartiallyPaid = "partially_paid"
+	BillStatusPaid          = "paid"
+	BillStatusVoid          = "void"
+	BillStatusWrittenOff    = "written_off"
+)
+
+// billTransitions lists the manual transitions allowed from each state.
+// issued → partially_paid → paid is driven by the Payment trigger, not by users.
+var billTransitions = map[string][]string{
+	BillStatusDraft:         {BillStatusIssued, BillStatusVoid},
+	BillStatusIssued:        {BillStatusVoid, BillStatusWrittenOff},
+	BillStatusPartiallyPaid: {BillStatusWrittenOff},
+}
+
+// canTransitionBill reports whether a bill may be moved from one state to another by hand.
+func canTransitionBill(from, to string) bool {
+	for _, s := range billTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// isBillPayable reports whether payments may be taken against a bill in this state.
+func isBillPayable(status string) bool {
+	return status == BillStatusIssued || status == BillStatusPartiallyPaid
+}
+
+// errInvalidBillTransition is returned when a state change is not allowed.
+var errInvalidBillTransition = errors.New("invalid bill status transition")
+
+// BillStatusChange is one row of bill_status_history.
+type BillStatusChange struct {
+	HistoryID  int    `json:"History_ID"`
+	BillID     int    `json:"Bill_ID"`
+	FromStatus string `json:"From_Status"`
+	ToStatus   string `json:"To_Status"`
+	Reason     string `json:"Reason"`
+	ChangedBy  *int   `json:"Changed_By"`
+	ChangedAt  string `json:"Changed_At"`
+}
+
+// BillStatusRequest is the body accepted by the void, write-off and reissue endpoints.
+type BillStatusRequest struct {
+	Reason string `json:"reason"`
+}
+
+// transitionBill moves a bill to a new state inside tx and records the change.
+// The bill row is locked so concurrent transitions are serialized.
+func transitionBill(tx *sql.Tx, billID int, to, reason string, actor *int) (from string, err error) {
+	err = tx.QueryRow(`SELECT "bill_status" FROM "billing" WHERE "bill_id" = $1 FOR UPDATE`, billID).Scan(&from)
+	if err != nil {
+		return "", err
+	}
+	if !canTransitionBill(from, to) {
+		return from, fmt.Errorf("%w: cannot change bill from %s to %s", errInvalidBillTransition, from, to)
+	}
+	if _, err = tx.Exec(`UPDATE "billing" SET "bill_status" = $1 WHERE "bill_id" = $2`, to, billID); err != nil {
+		return from, err
+	}
+	_, err = tx.Exec(`INSERT INTO "bill_status_history" ("bill_id", "from_status", "to_status", "reason", "changed_by")
+	                  VALUES ($1, $2, $3, $4, $5)`, billID, from, to, reason, actor)
+	return from, err
+}
+
+// changeBillStatus returns a handler that moves the bill in the path to the given state.
+func changeBillStatus(to string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		id, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid Bill ID")
+			return
+		}
+		log.Printf("API: changeBillStatus called for Bill ID: %d to %s", id, to)
+
+		var req BillStatusRequest
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				respondWithError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+				return
+			}
+		}
+		from, invoiceNumber, err := billSvc.ChangeStatus(auditFor(r), id, to, req.Reason)
+		if err != nil {
+			respondWithServerError(w, "Failed to update bill status", err)
+			return
+		}
+		resp := map[string]string{"message": "Bill status changed from " + from + " to " + to, "Bill_Status": to}
+		if invoiceNumber != "" {
+			resp["Invoice_Number"] = invoiceNumber
+		}
+		respondWithJSON(w, http.StatusOK, resp)
+	}
+}
+
+// billActionName maps a target state to the verb used in messages.
+func billActionName(status string) string {
+	switch status {
+	case BillStatusIssued:
+		return "issue"
+	case BillStatusVoid:
+		return "void"
+	case BillStatusWrittenOff:
+		return "write off"
+	}
+	return "change"
+}
+
+// reissueBilling voids an issued bill and creates a new draft copy that
+// references it, so the corrected bill can be edited and issued again.
+func reissueBilling(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Bill ID")
+		return
+	}
+	log.Printf("API: reissueBilling called for Bill ID: %d", id)
+
+	var req BillStatusRequest
+	json.NewDecoder(r.Body).Decode(&req) // A missing or malformed body is reported as a missing reason
+	b, err := billSvc.Reissue(auditFor(r), id, req.Reason)
+	if err != nil {
+		respondWithServerError(w, "Failed to reissue bill", err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, b)
+}
+
+// getBillStatusHistory lists every state change of a bill, oldest first.
+func getBillStatusHistory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Bill ID")
+		return
+	}
+	rows, err := db.Query(`SELECT "history_id", "bill_id", "from_status", "to_status", "reason", "changed_by", "changed_at"
+	                        FROM "bill_status_history" WHERE "bill_id" = $1 ORDER BY "changed_at" ASC, "history_id" ASC`, id)
+	if err != nil {
+		respondWithServerError(w, "Failed to retrieve bill history", err)
+		return
+	}
+	defer rows.Close()
+	history := []BillStatusChange{}
+	for rows.Next() {
+		var h BillStatusChange
+		if err := rows.Scan(&h.HistoryID, &h.BillID, &h.FromStatus, &h.ToStatus, &h.Reason, &h.ChangedBy, &h.ChangedAt); err != nil {
+			respondWithServerError(w, "Error scanning bill history", err)
+			return
+		}
+		history = append(history, h)
+	}
+	if err = rows.Err(); err != nil {
+		respondWithServerError(w, "Error iterating bill history rows", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, history)
+}
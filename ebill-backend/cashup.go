@@ -0,0 +1,565 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Cash handling: staff open a shift with the float in their drawer, take
+// payments, and close it by declaring the cash they count. The cash they
+// should have is the float plus the cash payments they recorded during the
+// shift, less the cash they refunded during it; any difference is flagged for
+// a supervisor, who reviews it. Operators see their own shifts and cash-up;
+// administrators supervise everyone's.
+
+// Shift states.
+const (
+	ShiftOpen     = "open"
+	ShiftClosed   = "closed"
+	ShiftReviewed = "reviewed"
+)
+
+// CashShift is one operator's cash drawer from opening to close.
+type CashShift struct {
+	ShiftID          int     `json:"shift_id"`
+	OperatorID       int     `json:"operator_id"`
+	OperatorUsername string  `json:"operator_username"`
+	Currency         string  `json:"currency"`
+	OpeningFloat     Money   `json:"opening_float"`
+	Status           string  `json:"status"` // open, closed or reviewed
+	OpenedAt         string  `json:"opened_at"`
+	ClosedAt         *string `json:"closed_at"`
+	DeclaredCash     *Money  `json:"declared_cash"` // Counted by the operator at close
+	RecordedCash     *Money  `json:"recorded_cash"` // Cash payments recorded during the shift less cash refunded during it
+	ExpectedCash     *Money  `json:"expected_cash"` // Opening float plus recorded cash
+	Discrepancy      *Money  `json:"discrepancy"`   // Declared minus expected; negative when cash is missing
+	Flagged          bool    `json:"flagged"`       // The discrepancy is not zero
+	CloseNote        string  `json:"close_note"`
+	ReviewedBy       *int    `json:"reviewed_by"`
+	ReviewedAt       *string `json:"reviewed_at"`
+	ReviewNote       string  `json:"review_note"`
+}
+
+const cashShiftColumns = `s.shift_id, s.operator_id, u.username, s.currency, s.opening_float, s.status, s.opened_at, s.closed_at,
+	s.declared_cash, s.recorded_cash, s.expected_cash, s.discrepancy, s.flagged, s.close_note, s.reviewed_by, s.reviewed_at, s.review_note`
+
+func scanCashShift(row rowScanner, s *CashShift) error {
+	return row.Scan(&s.ShiftID, &s.OperatorID, &s.OperatorUsername, &s.Currency, &s.OpeningFloat, &s.Status, &s.OpenedAt, &s.ClosedAt,
+		&s.DeclaredCash, &s.RecordedCash, &s.ExpectedCash, &s.Discrepancy, &s.Flagged, &s.CloseNote, &s.ReviewedBy, &s.ReviewedAt, &s.ReviewNote)
+}
+
+var cashShiftList = listResource{
+	columns: cashShiftColumns,
+	from:    `cash_shift s JOIN users u ON u.id = s.operator_id`,
+	key:     `s.shift_id`,
+	filters: map[string]listFilter{
+		"operator_id":    {`s.operator_id`, filterInt},
+		"status":         {`s.status`, filterText},
+		"flagged":        {`s.flagged`, filterBool},
+		"currency":       {`s.currency`, filterText},
+		"opened_at_from": {`s.opened_at::date`, filterDateFrom},
+		"opened_at_to":   {`s.opened_at::date`, filterDateTo},
+	},
+	sorts: map[string]string{
+		"shift_id":  `s.shift_id`,
+		"opened_at": `s.opened_at`,
+	},
+	defaultSort: "-shift_id",
+}
+
+// OpenShiftRequest opens a shift for the caller.
+type OpenShiftRequest struct {
+	OpeningFloat Money  `json:"opening_float" validate:"gte=0"`
+	Currency     string `json:"currency" validate:"currency"` // Default THB
+}
+
+// CloseShiftRequest closes a shift with the cash counted in the drawer.
+type CloseShiftRequest struct {
+	DeclaredCash *Money `json:"declared_cash" validate:"required"`
+	Note         string `json:"note" validate:"max=255"`
+}
+
+// ReviewShiftRequest records a supervisor's review of a closed shift.
+type ReviewShiftRequest struct {
+	Note string `json:"note" validate:"max=255"`
+}
+
+// canHandleCash reports whether the caller takes payments.
+func canHandleCash(r *http.Request) bool {
+	p := currentPermission(r)
+	return p == "administrator" || p == "operator"
+}
+
+// isSupervisor reports whether the caller may see and review every operator's cash.
+func isSupervisor(r *http.Request) bool {
+	return currentPermission(r) == "administrator"
+}
+
+func loadCashShift(id int) (CashShift, error) {
+	var s CashShift
+	err := scanCashShift(db.QueryRow(`SELECT `+cashShiftColumns+` FROM `+cashShiftList.from+` WHERE s.shift_id = $1`, id), &s)
+	if err == sql.ErrNoRows {
+		return s, notFoundError("Shift")
+	}
+	return s, err
+}
+
+// openShift opens a shift for operator; 409 when one is already open.
+func openShift(operator int, req OpenShiftRequest) (CashShift, error) {
+	if req.Currency == "" {
+		req.Currency = DefaultCurrency
+	}
+	if verr := validateStruct("shift", &req); verr != nil {
+		return CashShift{}, verr
+	}
+	var id int
+	err := db.QueryRow(`INSERT INTO "cash_shift" ("operator_id", "currency", "opening_float")
+	                    SELECT $1, $2, $3 WHERE NOT EXISTS (SELECT 1 FROM "cash_shift" WHERE "operator_id" = $1 AND "status" = 'open')
+	                    RETURNING "shift_id"`, operator, req.Currency, req.OpeningFloat).Scan(&id)
+	if err == sql.ErrNoRows {
+		return CashShift{}, &APIError{Status: http.StatusConflict, Code: ErrCodeConflict, Message: "You already have an open shift; close it first"}
+	} else if err != nil {
+		return CashShift{}, err
+	}
+	return loadCashShift(id)
+}
+
+// closeShift counts a shift's recorded cash and closes it with the
+// declared amount.
+func closeShift(id int, req CloseShiftRequest) (CashShift, error) {
+	if verr := validateStruct("shift", &req); verr != nil {
+		return CashShift{}, verr
+	}
+	if req.DeclaredCash.Sign() < 0 {
+		return CashShift{}, &APIError{Status: http.StatusUnprocessableEntity, Code: ErrCodeValidation, Message: "Invalid shift: declared_cash must not be negative",
+			Details: []FieldError{{Field: "declared_cash", Code: "out_of_range", Message: "declared_cash must not be negative"}}}
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return CashShift{}, err
+	}
+	defer tx.Rollback()
+	var operator int
+	var currency, status string
+	var float Money
+	err = tx.QueryRow(`SELECT "operator_id", "currency", "opening_float", "status" FROM "cash_shift" WHERE "shift_id" = $1 FOR UPDATE`, id).
+		Scan(&operator, &currency, &float, &status)
+	if err == sql.ErrNoRows {
+		return CashShift{}, notFoundError("Shift")
+	} else if err != nil {
+		return CashShift{}, err
+	}
+	if status != ShiftOpen {
+		return CashShift{}, &APIError{Status: http.StatusConflict, Code: ErrCodeConflict, Message: "Shift is already " + status}
+	}
+	// Cash taken during the shift, refunded since or not, less cash handed back
+	// during the shift for refunds, whenever those payments were taken.
+	var recorded Money
+	err = tx.QueryRow(`SELECT COALESCE(SUM(p."amount_paid") FILTER (WHERE p."recorded_at" >= s."opened_at" AND p."recorded_at" <= LOCALTIMESTAMP), 0)
+	                        - COALESCE(SUM(p."amount_paid") FILTER (WHERE p."refunded_at" >= s."opened_at" AND p."refunded_at" <= LOCALTIMESTAMP), 0)
+	                   FROM "payment" p, "cash_shift" s
+	                   WHERE s."shift_id" = $1 AND p."processed_by" = s."operator_id" AND p."currency" = s."currency"
+	                     AND p."payment_method" = 'Cash' AND p."payment_status" IN ('Completed', 'Refunded')`, id).Scan(&recorded)
+	if err != nil {
+		return CashShift{}, err
+	}
+	expected := float.Add(recorded)
+	discrepancy := req.DeclaredCash.Sub(expected)
+	_, err = tx.Exec(`UPDATE "cash_shift" SET "status" = 'closed', "closed_at" = LOCALTIMESTAMP, "declared_cash" = $2, "recorded_cash" = $3,
+	                  "expected_cash" = $4, "discrepancy" = $5, "flagged" = $6, "close_note" = $7 WHERE "shift_id" = $1`,
+		id, *req.DeclaredCash, recorded, expected, discrepancy, !discrepancy.IsZero(), req.Note)
+	if err != nil {
+		return CashShift{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return CashShift{}, err
+	}
+	if !discrepancy.IsZero() {
+		log.Printf("Shift %d of user %d closed with a discrepancy of %s %s", id, operator, discrepancy, currency)
+	}
+	return loadCashShift(id)
+}
+
+// reviewShift marks a closed shift as reviewed by supervisor.
+func reviewShift(id, supervisor int, req ReviewShiftRequest) (CashShift, error) {
+	if verr := validateStruct("review", &req); verr != nil {
+		return CashShift{}, verr
+	}
+	res, err := db.Exec(`UPDATE "cash_shift" SET "status" = 'reviewed', "reviewed_by" = $2, "reviewed_at" = LOCALTIMESTAMP, "review_note" = $3
+	                     WHERE "shift_id" = $1 AND "status" = 'closed'`, id, supervisor, req.Note)
+	if err != nil {
+		return CashShift{}, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		s, err := loadCashShift(id)
+		if err != nil {
+			return s, err
+		}
+		return s, &APIError{Status: http.StatusConflict, Code: ErrCodeConflict, Message: "Only closed shifts can be reviewed; this one is " + s.Status}
+	}
+	return loadCashShift(id)
+}
+
+// --- Cash-up report ---
+
+// CashUpMethod is what an operator took with one payment method.
+type CashUpMethod struct {
+	PaymentMethod string `json:"payment_method"`
+	Currency      string `json:"currency"`
+	Payments      int    `json:"payments"` // Completed or since refunded
+	Taken         Money  `json:"taken"`
+	Refunds       int    `json:"refunds"` // Refunded within the dates, whenever they were taken
+	Refunded      Money  `json:"refunded"`
+	Net           Money  `json:"net"`
+}
+
+// CashUpTotal is what an operator took in one currency.
+type CashUpTotal struct {
+	Currency string `json:"currency"`
+	Payments int    `json:"payments"`
+	Taken    Money  `json:"taken"`
+	Refunds  int    `json:"refunds"`
+	Refunded Money  `json:"refunded"`
+	Net      Money  `json:"net"`
+}
+
+// OperatorCashUp is one operator's activity over the report's dates.
+type OperatorCashUp struct {
+	ProcessedBy int            `json:"processed_by"`
+	Username    string         `json:"username"`
+	Methods     []CashUpMethod `json:"methods"`
+	Totals      []CashUpTotal  `json:"totals"`
+	VoidedBills int            `json:"voided_bills"`
+	Shifts      []CashShift    `json:"shifts"` // Opened within the dates
+}
+
+// CashUp is the end-of-day report of payments taken by staff. Payments made
+// through the customer portal or matched from transfers and bank statements
+// are booked to the system user (ID 0) and are left out.
+type CashUp struct {
+	From      string           `json:"from" export:"date"`
+	To        string           `json:"to" export:"date"`
+	Operators []OperatorCashUp `json:"operators"`
+}
+
+// CashUpLine is one row of an exported cash-up.
+type CashUpLine struct {
+	ProcessedBy   int    `json:"processed_by"`
+	Username      string `json:"username"`
+	PaymentMethod string `json:"payment_method"`
+	Currency      string `json:"currency"`
+	Payments      int    `json:"payments"`
+	Taken         Money  `json:"taken"`
+	Refunds       int    `json:"refunds"`
+	Refunded      Money  `json:"refunded"`
+	Net           Money  `json:"net"`
+}
+
+// rows lets reportHandler export the method lines.
+func (c CashUp) rows() interface{} {
+	lines := []CashUpLine{}
+	for _, o := range c.Operators {
+		for _, m := range o.Methods {
+			lines = append(lines, CashUpLine{o.ProcessedBy, o.Username, m.PaymentMethod, m.Currency, m.Payments, m.Taken, m.Refunds, m.Refunded, m.Net})
+		}
+	}
+	return lines
+}
+
+// cashUpReport reports payments taken, refunds and bill voids per operator
+// from ?from= to ?to=, both today by default, optionally for one
+// ?processed_by= user.
+func cashUpReport(q url.Values) (CashUp, error) {
+	rg, err := parseReportRange(q)
+	if err != nil {
+		return CashUp{}, err
+	}
+	if rg.From == "" && rg.To == "" {
+		rg.From = time.Now().Format("2006-01-02")
+		rg.To = rg.From
+	}
+	c := CashUp{From: rg.From, To: rg.To, Operators: []OperatorCashUp{}}
+	var operator *int
+	if v := q.Get("processed_by"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return c, listParamErrorf("processed_by must be an integer")
+		}
+		operator = &id
+	}
+	byOperator := map[int]*OperatorCashUp{}
+	var order []int
+	get := func(id int, username string) *OperatorCashUp {
+		o, ok := byOperator[id]
+		if !ok {
+			o = &OperatorCashUp{ProcessedBy: id, Username: username, Methods: []CashUpMethod{}, Totals: []CashUpTotal{}, Shifts: []CashShift{}}
+			byOperator[id] = o
+			order = append(order, id)
+		}
+		return o
+	}
+	// where limits dateExpr to the range and operatorExpr to ?processed_by=,
+	// along with the extra conditions.
+	where := func(dateExpr, operatorExpr string, args *[]interface{}, extra ...string) string {
+		conds := append(rg.conditions(dateExpr, args), extra...)
+		if operator != nil {
+			*args = append(*args, *operator)
+			conds = append(conds, fmt.Sprintf("%s = $%d", operatorExpr, len(*args)))
+		}
+		return whereClause(conds)
+	}
+
+	// Payments count on the day they were taken and refunds on the day they
+	// were refunded, so a refund of an earlier payment lands in today's report.
+	var args []interface{}
+	query := `SELECT t.processed_by, u.username, t.payment_method, t.currency,
+	                     SUM(t.payments), COALESCE(SUM(t.taken), 0), SUM(t.refunds), COALESCE(SUM(t.refunded), 0)
+	          FROM (SELECT p.processed_by, p.payment_method, p.currency, 1 AS payments, p.amount_paid AS taken, 0 AS refunds, NULL::numeric AS refunded
+	                FROM payment p` +
+		where("p.payment_date", "p.processed_by", &args, `p.processed_by <> 0`, `p.payment_status IN ('Completed', 'Refunded')`) + `
+	                UNION ALL
+	                SELECT p.processed_by, p.payment_method, p.currency, 0, NULL, 1, p.amount_paid
+	                FROM payment p` +
+		where("p.refunded_at::date", "p.processed_by", &args, `p.processed_by <> 0`, `p.payment_status = 'Refunded'`) + `
+	          ) t JOIN users u ON u.id = t.processed_by
+	          GROUP BY 1, 2, 3, 4 ORDER BY 2, 4, 3`
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return c, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var username string
+		var m CashUpMethod
+		if err := rows.Scan(&id, &username, &m.PaymentMethod, &m.Currency, &m.Payments, &m.Taken, &m.Refunds, &m.Refunded); err != nil {
+			return c, err
+		}
+		m.Net = m.Taken.Sub(m.Refunded)
+		o := get(id, username)
+		o.Methods = append(o.Methods, m)
+		if n := len(o.Totals); n == 0 || o.Totals[n-1].Currency != m.Currency {
+			o.Totals = append(o.Totals, CashUpTotal{Currency: m.Currency})
+		}
+		t := &o.Totals[len(o.Totals)-1]
+		t.Payments += m.Payments
+		t.Taken = t.Taken.Add(m.Taken)
+		t.Refunds += m.Refunds
+		t.Refunded = t.Refunded.Add(m.Refunded)
+		t.Net = t.Net.Add(m.Net)
+	}
+	if err := rows.Err(); err != nil {
+		return c, err
+	}
+
+	args = nil
+	voids, err := db.Query(`SELECT h.changed_by, u.username, COUNT(*) FROM bill_status_history h JOIN users u ON u.id = h.changed_by`+
+		where("h.changed_at::date", "h.changed_by", &args, `h.to_status = 'void'`)+` GROUP BY 1, 2`, args...)
+	if err != nil {
+		return c, err
+	}
+	defer voids.Close()
+	for voids.Next() {
+		var id, n int
+		var username string
+		if err := voids.Scan(&id, &username, &n); err != nil {
+			return c, err
+		}
+		get(id, username).VoidedBills = n
+	}
+	if err := voids.Err(); err != nil {
+		return c, err
+	}
+
+	args = nil
+	shifts, err := db.Query(`SELECT `+cashShiftColumns+` FROM `+cashShiftList.from+
+		where("s.opened_at::date", "s.operator_id", &args)+` ORDER BY s.opened_at`, args...)
+	if err != nil {
+		return c, err
+	}
+	defer shifts.Close()
+	for shifts.Next() {
+		var s CashShift
+		if err := scanCashShift(shifts, &s); err != nil {
+			return c, err
+		}
+		o := get(s.OperatorID, s.OperatorUsername)
+		o.Shifts = append(o.Shifts, s)
+	}
+	if err := shifts.Err(); err != nil {
+		return c, err
+	}
+	for _, id := range order {
+		c.Operators = append(c.Operators, *byOperator[id])
+	}
+	return c, nil
+}
+
+// --- Handlers ---
+
+// getCashUpV2 serves the cash-up report; operators only get their own.
+func getCashUpV2(w http.ResponseWriter, r *http.Request) {
+	if currentPermission(r) == "operator" {
+		q := r.URL.Query()
+		q.Set("processed_by", strconv.Itoa(*currentUserID(r)))
+		r.URL.RawQuery = q.Encode()
+	}
+	reportHandler("cash-up", func(q url.Values) (interface{}, error) { return cashUpReport(q) })(w, r)
+}
+
+func getShiftsV2(w http.ResponseWriter, r *http.Request) {
+	log.Println("API v2: getShifts called")
+	if !canHandleCash(r) {
+		respondWithError(w, http.StatusForbidden, "Shifts are available to operators and administrators")
+		return
+	}
+	q := r.URL.Query()
+	if !isSupervisor(r) {
+		q.Set("operator_id", strconv.Itoa(*currentUserID(r)))
+	}
+	shifts := []CashShift{}
+	meta, err := cashShiftList.query(q, func(row rowScanner) error {
+		var s CashShift
+		if err := scanCashShift(row, &s); err != nil {
+			return err
+		}
+		shifts = append(shifts, s)
+		return nil
+	})
+	if err != nil {
+		respondWithListError(w, "shifts", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, ListResponse{Data: shifts, Meta: meta})
+}
+
+// shiftForCaller loads the shift in the path, provided the caller may see it.
+func shiftForCaller(w http.ResponseWriter, r *http.Request) (CashShift, bool) {
+	if !canHandleCash(r) {
+		respondWithError(w, http.StatusForbidden, "Shifts are available to operators and administrators")
+		return CashShift{}, false
+	}
+	id, ok := v2ID(w, r)
+	if !ok {
+		return CashShift{}, false
+	}
+	s, err := loadCashShift(id)
+	if err == nil && !isSupervisor(r) && s.OperatorID != *currentUserID(r) {
+		err = notFoundError("Shift") // Other operators' shifts are not disclosed
+	}
+	if err != nil {
+		respondWithServerError(w, "Failed to retrieve shift", err)
+		return s, false
+	}
+	return s, true
+}
+
+func getShiftV2(w http.ResponseWriter, r *http.Request) {
+	log.Println("API v2: getShift called")
+	if s, ok := shiftForCaller(w, r); ok {
+		respondV2(w, http.StatusOK, s)
+	}
+}
+
+// getCurrentShiftV2 returns the caller's open shift.
+func getCurrentShiftV2(w http.ResponseWriter, r *http.Request) {
+	log.Println("API v2: getCurrentShift called")
+	if !canHandleCash(r) {
+		respondWithError(w, http.StatusForbidden, "Shifts are available to operators and administrators")
+		return
+	}
+	var id int
+	err := db.QueryRow(`SELECT "shift_id" FROM "cash_shift" WHERE "operator_id" = $1 AND "status" = 'open'`, *currentUserID(r)).Scan(&id)
+	if err == sql.ErrNoRows {
+		respondWithAPIError(w, &APIError{Status: http.StatusNotFound, Code: ErrCodeNotFound, Message: "You have no open shift"})
+		return
+	} else if err != nil {
+		respondWithServerError(w, "Failed to retrieve shift", err)
+		return
+	}
+	s, err := loadCashShift(id)
+	if err != nil {
+		respondWithServerError(w, "Failed to retrieve shift", err)
+		return
+	}
+	respondV2(w, http.StatusOK, s)
+}
+
+func openShiftV2(w http.ResponseWriter, r *http.Request) {
+	log.Println("API v2: openShift called")
+	if !canHandleCash(r) {
+		respondWithError(w, http.StatusForbidden, "Shifts are available to operators and administrators")
+		return
+	}
+	var req OpenShiftRequest
+	if r.ContentLength != 0 && !decodeV2(w, r, &req) {
+		return
+	}
+	s, err := openShift(*currentUserID(r), req)
+	if err != nil {
+		respondWithServerError(w, "Failed to open shift", err)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("/api/v2/shifts/%d", s.ShiftID))
+	respondV2(w, http.StatusCreated, s)
+}
+
+// closeShiftV2 closes the shift in the path: the operator's own, or anyone's
+// for a supervisor.
+func closeShiftV2(w http.ResponseWriter, r *http.Request) {
+	log.Println("API v2: closeShift called")
+	s, ok := shiftForCaller(w, r)
+	if !ok {
+		return
+	}
+	var req CloseShiftRequest
+	if !decodeV2(w, r, &req) {
+		return
+	}
+	s, err := closeShift(s.ShiftID, req)
+	if err != nil {
+		respondWithServerError(w, "Failed to close shift", err)
+		return
+	}
+	respondV2(w, http.StatusOK, s)
+}
+
+func reviewShiftV2(w http.ResponseWriter, r *http.Request) {
+	log.Println("API v2: reviewShift called")
+	if !isSupervisor(r) {
+		respondWithError(w, http.StatusForbidden, "Only administrators can review shifts")
+		return
+	}
+	id, ok := v2ID(w, r)
+	if !ok {
+		return
+	}
+	var req ReviewShiftRequest
+	if r.ContentLength != 0 && !decodeV2(w, r, &req) {
+		return
+	}
+	s, err := reviewShift(id, *currentUserID(r), req)
+	if err != nil {
+		respondWithServerError(w, "Failed to review shift", err)
+		return
+	}
+	respondV2(w, http.StatusOK, s)
+}
+
+// registerShifts adds the shift and cash-up routes.
+func registerShifts(router *mux.Router) {
+	router.HandleFunc("/shifts", getShiftsV2).Methods("GET", "OPTIONS")
+	router.HandleFunc("/shifts", openShiftV2).Methods("POST", "OPTIONS")
+	router.HandleFunc("/shifts/current", getCurrentShiftV2).Methods("GET", "OPTIONS")
+	router.HandleFunc("/shifts/{id}", getShiftV2).Methods("GET", "OPTIONS")
+	router.HandleFunc("/shifts/{id}/close", closeShiftV2).Methods("POST", "OPTIONS")
+	router.HandleFunc("/shifts/{id}/review", reviewShiftV2).Methods("POST", "OPTIONS")
+	router.HandleFunc("/reports/cash-up", getCashUpV2).Methods("GET", "OPTIONS")
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Running the binary with a command name runs that command against the
+// configured database instead of starting the API server, e.g.
+//
+//	ebill-backend import-statement -format fixed KTB-20240405.txt
+type cliCommand struct {
+	summary string
+	run     func(args []string) error
+	offline bool // Runs without connecting to the database
+}
+
+var cliCommands = map[string]cliCommand{
+	"import":               {"import customers, meters or meter readings from a CSV or XLSX file", runImportCLI, false},
+	"import-statement":     {"import bank statement files and reconcile them against open bills", runImportStatement, false},
+	"month-end-statements": {"generate and store every customer's statement for a month", runMonthEndStatementsCLI, false},
+	"openapi":              {"check the OpenAPI document against the routes and print it", runOpenAPICLI, true},
+}
+
+// runCLI runs the command named by args[0] and returns the process exit code.
+func runCLI(args []string) int {
+	if args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		printCLIUsage()
+		return 0
+	}
+	cmd, ok := cliCommands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[0])
+		printCLIUsage()
+		return 2
+	}
+	if !cmd.offline {
+		connectDB()
+	}
+	if err := cmd.run(args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", args[0], err)
+		return 1
+	}
+	return 0
+}
+
+func printCLIUsage() {
+	fmt.Fprintln(os.Stderr, "usage: ebill-backend [command] [flags]\n\nWithout a command the API server is started. Commands:")
+	names := make([]string, 0, len(cliCommands))
+	for name := range cliCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-22s %s\n", name, cliCommands[name].summary)
+	}
+}
+
+func runImportStatement(args []string) error {
+	fs := flag.NewFlagSet("import-statement", flag.ContinueOnError)
+	format := fs.String("format", "auto", "statement format: csv, fixed or auto")
+	currency := fs.String("currency", DefaultCurrency, "currency of amounts that do not state one")
+	asJSON := fs.Bool("json", false, "print the reconciliation report as JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: ebill-backend import-statement [flags] FILE...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("no statement files given")
+	}
+	for _, path := range fs.Args() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rep, err := importBankStatement(path, *format, data, strings.ToUpper(*currency), auditContext{})
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if *asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(rep); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := rep.WriteText(os.Stdout); err != nil {
+			return err
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+func runMonthEndStatementsCLI(args []string) error {
+	fs := flag.NewFlagSet("month-end-statements", flag.ContinueOnError)
+	month := fs.String("month", previousMonth(time.Now()).Format("2006-01"), "statement month as YYYY-MM")
+	outDir := fs.String("out", "", "also write each statement to this directory")
+	format := fs.String("format", StatementOutputPDF, "file format when -out is given: pdf, csv or json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	start, err := time.Parse("2006-01", *month)
+	if err != nil {
+		return fmt.Errorf("-month must be YYYY-MM")
+	}
+	switch *format {
+	case StatementOutputPDF, StatementOutputCSV, StatementOutputJSON:
+	default:
+		return fmt.Errorf("-format must be pdf, csv or json")
+	}
+	run, err := generateMonthEndStatements(start, *outDir, *format)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Statements %s to %s: %d generated, %d skipped\n", run.PeriodStart, run.PeriodEnd, run.Generated, run.Skipped)
+	for _, f := range run.Files {
+		fmt.Println("  " + f)
+	}
+	return nil
+}
+
+// runOpenAPICLI fails when a route and its OpenAPI entry have drifted apart,
+// which makes it usable as a CI check, and otherwise writes the document.
+func runOpenAPICLI(args []string) error {
+	fs := flag.NewFlagSet("openapi", flag.ContinueOnError)
+	out := fs.String("o", "", "write the document to this file instead of standard output")
+	check := fs.Bool("check", false, "only check the routes; print nothing")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := checkOpenAPIRoutes(newRouter()); err != nil {
+		return err
+	}
+	if *check {
+		return nil
+	}
+	if *out != "" {
+		return os.WriteFile(*out, openAPIDocument(), 0o644)
+	}
+	_, err := os.Stdout.Write(openAPIDocument())
+	return err
+}
+
+// runImportCLI runs an import job to completion in the foreground. Its rows
+// and totals are recorded exactly as for an upload through the API.
+func runImportCLI(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	kind := fs.String("kind", "", "what the file holds: customers, meters or readings")
+	format := fs.String("format", "auto", "file format: csv, xlsx or auto")
+	dryRun := fs.Bool("dry-run", false, "validate every row and report the outcome without writing anything")
+	resume := fs.Int("resume", 0, "resume the failed or interrupted job with this ID instead of starting one")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: ebill-backend import -kind KIND [flags] FILE\n       ebill-backend import -resume JOB_ID")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	var run *importRun
+	var err error
+	if *resume != 0 {
+		run, err = claimImportJob(*resume)
+	} else {
+		if fs.NArg() != 1 {
+			fs.Usage()
+			return fmt.Errorf("give exactly one file to import")
+		}
+		var data []byte
+		if data, err = os.ReadFile(fs.Arg(0)); err != nil {
+			return err
+		}
+		var job ImportJob
+		if job, err = createImportJob(*kind, filepath.Base(fs.Arg(0)), *format, data, *dryRun, nil); err == nil {
+			run, err = claimImportJob(job.JobID)
+		}
+	}
+	if err != nil {
+		if apiErr, ok := err.(*APIError); ok {
+			for _, d := range apiErr.Details {
+				fmt.Fprintf(os.Stderr, "  %s: %s\n", d.Field, d.Message)
+			}
+		}
+		return err
+	}
+	runErr := run.run()
+	failed, err := failedImportRows(run.job.JobID)
+	if err != nil {
+		return err
+	}
+	if err := writeImportReport(os.Stdout, run.job, failed); err != nil {
+		return err
+	}
+	return runErr
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+)
+
+// Consumption analytics read the customer_consumption view and group each
+// meter's bills by the month of their billing date. Changes compare a month
+// with the previous calendar month and with the same month a year earlier,
+// so a ?from= limit still reports changes for its first months.
+
+// ConsumptionPoint is the usage of one month.
+type ConsumptionPoint struct {
+	Month             string   `json:"month"` // YYYY-MM
+	Bills             int      `json:"bills"`
+	Units             Decimal  `json:"units"`
+	AverageDailyUnits *Decimal `json:"average_daily_units"` // Units per day of the billing periods; null when they have no length
+	MoMChange         *Decimal `json:"mom_change"`          // Percentage change from the previous month; null without usage then
+	YoYChange         *Decimal `json:"yoy_change"`          // Percentage change from the same month last year; null without usage then
+}
+
+// MeterConsumption is the monthly usage of one meter.
+type MeterConsumption struct {
+	MeterID     int                `json:"meter_id"`
+	MeterNumber string             `json:"meter_number"`
+	MeterType   string             `json:"meter_type"`
+	CustomerID  int                `json:"customer_id"`
+	Points      []ConsumptionPoint `json:"points"`
+}
+
+// CustomerConsumption is the monthly usage of a customer's meters, and of
+// all of them together. The combined average daily usage is the sum of the
+// meters' averages.
+type CustomerConsumption struct {
+	CustomerID   int                `json:"customer_id"`
+	CustomerName string             `json:"customer_name"`
+	Points       []ConsumptionPoint `json:"points"`
+	Meters       []MeterConsumption `json:"meters"`
+}
+
+// MeterTypeConsumption is the usage of all meters of one type in a month.
+type MeterTypeConsumption struct {
+	Month             string  `json:"month"` // YYYY-MM
+	MeterType         string  `json:"meter_type"`
+	Meters            int     `json:"meters"`
+	Bills             int     `json:"bills"`
+	Units             Decimal `json:"units"`
+	AverageMeterUnits Decimal `json:"average_meter_units"`
+}
+
+var percent = NewDecimal(100, 0)
+
+// percentChange returns the change from prev to cur as a percentage.
+func percentChange(prev, cur Decimal) *Decimal {
+	if prev.IsZero() {
+		return nil
+	}
+	d := cur.Sub(prev).Mul(percent).Quo(prev, 2)
+	return &d
+}
+
+// monthOffset returns the YYYY-MM month n months from month.
+func monthOffset(month string, n int) string {
+	t, err := time.Parse("2006-01", month)
+	if err != nil {
+		return ""
+	}
+	return t.AddDate(0, n, 0).Format("2006-01")
+}
+
+// fillChanges sets the month-over-month and year-over-year changes of points,
+// which are in month order, and drops the points before from (YYYY-MM-DD).
+func fillChanges(points []ConsumptionPoint, from string) []ConsumptionPoint {
+	units := map[string]Decimal{}
+	for _, p := range points {
+		units[p.Month] = p.Units
+	}
+	out := []ConsumptionPoint{}
+	for _, p := range points {
+		if prev, ok := units[monthOffset(p.Month, -1)]; ok {
+			p.MoMChange = percentChange(prev, p.Units)
+		}
+		if prev, ok := units[monthOffset(p.Month, -12)]; ok {
+			p.YoYChange = percentChange(prev, p.Units)
+		}
+		if from == "" || p.Month >= from[:7] {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// loadMeterConsumption returns the monthly usage of the meters matching
+// cond, with $1 as arg, in meter order. The points start a year before
+// rg.From so that fillChanges can compare the first months of the range.
+func loadMeterConsumption(cond string, arg interface{}, rg reportRange) ([]MeterConsumption, error) {
+	args := []interface{}{arg}
+	conds := []string{cond}
+	if rg.From != "" {
+		from, _ := time.Parse("2006-01-02", rg.From)
+		args = append(args, from.AddDate(-1, 0, 0).Format("2006-01")+"-01")
+		conds = append(conds, fmt.Sprintf(`"billing_date" >= $%d`, len(args)))
+	}
+	if rg.To != "" {
+		args = append(args, rg.To)
+		conds = append(conds, fmt.Sprintf(`"billing_date" <= $%d`, len(args)))
+	}
+	rows, err := db.Query(`SELECT "meter_id", "meter_number", "meter_type", "customer_id", TO_CHAR("billing_date", 'YYYY-MM'),
+	                              COUNT(*), COALESCE(SUM("total_unit"), 0), COALESCE(SUM("period_days"), 0)
+	                       FROM "customer_consumption"`+whereClause(conds)+`
+	                       GROUP BY 1, 2, 3, 4, 5 ORDER BY 1, 5`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	meters := []MeterConsumption{}
+	for rows.Next() {
+		var m MeterConsumption
+		var p ConsumptionPoint
+		var days int
+		if err := rows.Scan(&m.MeterID, &m.MeterNumber, &m.MeterType, &m.CustomerID, &p.Month, &p.Bills, &p.Units, &days); err != nil {
+			return nil, err
+		}
+		if days > 0 {
+			avg := p.Units.Quo(NewDecimal(int64(days), 0), 2)
+			p.AverageDailyUnits = &avg
+		}
+		if n := len(meters); n == 0 || meters[n-1].MeterID != m.MeterID {
+			meters = append(meters, m)
+		}
+		last := &meters[len(meters)-1]
+		last.Points = append(last.Points, p)
+	}
+	return meters, rows.Err()
+}
+
+// meterConsumption returns the monthly usage of one meter.
+func meterConsumption(id int, rg reportRange) (MeterConsumption, error) {
+	meters, err := loadMeterConsumption(`"meter_id" = $1`, id, rg)
+	if err != nil {
+		return MeterConsumption{}, err
+	}
+	if len(meters) > 0 {
+		m := meters[0]
+		m.Points = fillChanges(m.Points, rg.From)
+		return m, nil
+	}
+	// No bills in the range: still describe the meter.
+	m := MeterConsumption{MeterID: id, Points: []ConsumptionPoint{}}
+	err = db.QueryRow(`SELECT "meter_number", "meter_type", "customer_id" FROM "meter" WHERE "meter_id" = $1`, id).
+		Scan(&m.MeterNumber, &m.MeterType, &m.CustomerID)
+	if err == sql.ErrNoRows {
+		return m, notFoundError("Meter")
+	}
+	return m, err
+}
+
+// customerConsumption returns the monthly usage of a customer's meters.
+func customerConsumption(id int, rg reportRange) (CustomerConsumption, error) {
+	c := CustomerConsumption{CustomerID: id, Points: []ConsumptionPoint{}}
+	err := db.QueryRow(`SELECT "name" FROM "customer" WHERE "customer_id" = $1`, id).Scan(&c.CustomerName)
+	if err == sql.ErrNoRows {
+		return c, notFoundError("Customer")
+	} else if err != nil {
+		return c, err
+	}
+	if c.Meters, err = loadMeterConsumption(`"customer_id" = $1`, id, rg); err != nil {
+		return c, err
+	}
+	byMonth := map[string]*ConsumptionPoint{}
+	for i, m := range c.Meters {
+		for _, p := range m.Points {
+			t, ok := byMonth[p.Month]
+			if !ok {
+				t = &ConsumptionPoint{Month: p.Month}
+				byMonth[p.Month] = t
+			}
+			t.Bills += p.Bills
+			t.Units = t.Units.Add(p.Units)
+			if p.AverageDailyUnits != nil {
+				avg := *p.AverageDailyUnits
+				if t.AverageDailyUnits != nil {
+					avg = avg.Add(*t.AverageDailyUnits)
+				}
+				t.AverageDailyUnits = &avg
+			}
+		}
+		c.Meters[i].Points = fillChanges(m.Points, rg.From)
+	}
+	months := make([]string, 0, len(byMonth))
+	for m := range byMonth {
+		months = append(months, m)
+	}
+	sort.Strings(months)
+	for _, m := range months {
+		c.Points = append(c.Points, *byMonth[m])
+	}
+	c.Points = fillChanges(c.Points, rg.From)
+	return c, nil
+}
+
+// consumptionByMeterType totals usage per month and meter type.
+func consumptionByMeterType(q url.Values) ([]MeterTypeConsumption, error) {
+	rg, err := parseReportRange(q)
+	if err != nil {
+		return nil, err
+	}
+	var args []interface{}
+	conds := rg.conditions(`"billing_date"`, &args)
+	if v := q.Get("meter_type"); v != "" {
+		args = append(args, v)
+		conds = append(conds, fmt.Sprintf(`"meter_type" = $%d`, len(args)))
+	}
+	rows, err := db.Query(`SELECT TO_CHAR("billing_date", 'YYYY-MM'), "meter_type", COUNT(DISTINCT "meter_id"), COUNT(*),
+	                              COALESCE(SUM("total_unit"), 0)
+	                       FROM "customer_consumption"`+whereClause(conds)+`
+	                       GROUP BY 1, 2 ORDER BY 1, 2`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	report := []MeterTypeConsumption{}
+	for rows.Next() {
+		var t MeterTypeConsumption
+		if err := rows.Scan(&t.Month, &t.MeterType, &t.Meters, &t.Bills, &t.Units); err != nil {
+			return nil, err
+		}
+		t.AverageMeterUnits = t.Units.Quo(NewDecimal(int64(t.Meters), 0), 2)
+		report = append(report, t)
+	}
+	return report, rows.Err()
+}
+
+// --- Handlers ---
+
+// getCustomerConsumptionV2 returns the monthly usage of the customer in the path.
+func getCustomerConsumptionV2(w http.ResponseWriter, r *http.Request) {
+	log.Println("API v2: getCustomerConsumption called")
+	id, ok := v2ID(w, r)
+	if !ok {
+		return
+	}
+	rg, err := parseReportRange(r.URL.Query())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid parameters: "+err.Error())
+		return
+	}
+	c, err := customerConsumption(id, rg)
+	if err != nil {
+		respondWithServerError(w, "Failed to retrieve consumption", err)
+		return
+	}
+	respondV2(w, http.StatusOK, c)
+}
+
+// getMeterConsumptionV2 returns the monthly usage of the meter in the path.
+func getMeterConsumptionV2(w http.ResponseWriter, r *http.Request) {
+	log.Println("API v2: getMeterConsumption called")
+	id, ok := v2ID(w, r)
+	if !ok {
+		return
+	}
+	rg, err := parseReportRange(r.URL.Query())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid parameters: "+err.Error())
+		return
+	}
+	m, err := meterConsumption(id, rg)
+	if err != nil {
+		respondWithServerError(w, "Failed to retrieve consumption", err)
+		return
+	}
+	respondV2(w, http.StatusOK, m)
+}
+
+// getPortalConsumptionV2 returns the monthly usage of the customer with the
+// email or phone number in ?identifier=, for the customer portal.
+func getPortalConsumptionV2(w http.ResponseWriter, r *http.Request) {
+	log.Println("API v2: getPortalConsumption called")
+	q := r.URL.Query()
+	identifier := q.Get("identifier")
+	if identifier == "" {
+		respondWithError(w, http.StatusBadRequest, "Identifier (email or phone number) is required")
+		return
+	}
+	rg, err := parseReportRange(q)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid parameters: "+err.Error())
+		return
+	}
+	var id int
+	err = db.QueryRow(`SELECT "customer_id" FROM "customer" WHERE ("email" = $1 OR "phone_number" = $1)`, identifier).Scan(&id)
+	if err == sql.ErrNoRows {
+		err = notFoundError("Customer")
+	}
+	if err != nil {
+		respondWithServerError(w, "Failed to retrieve consumption", err)
+		return
+	}
+	c, err := customerConsumption(id, rg)
+	if err != nil {
+		respondWithServerError(w, "Failed to retrieve consumption", err)
+		return
+	}
+	respondV2(w, http.StatusOK, c)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// DocumentNumberFormat describes how a document series is numbered.
+// The template may contain {YYYY}, {YY} and {MM} for the period and must contain
+// exactly one {SEQ:n}, the sequence zero-padded to n digits. The sequence restarts
+// for every distinct period the template can express: monthly when {MM} is used,
+// yearly when only the year is used, and never otherwise.
+//
+// Example: "INV-{YYYY}{MM}-{SEQ:5}" gives INV-202404-00001, INV-202404-00002, ...
+type DocumentNumberFormat struct {
+	Series   string // document_sequence.Series key, e.g. "INV"
+	Template string
+}
+
+var seqToken = regexp.MustCompile(`\{SEQ:(\d+)\}`)
+
+// maxDocumentNumberLength is the size of the Invoice_Number and Note_Number columns.
+const maxDocumentNumberLength = 30
+
+// Validate checks that the template has exactly one well-formed sequence token
+// and that the numbers it gives fit the database columns.
+func (f DocumentNumberFormat) Validate() error {
+	matches := seqToken.FindAllStringSubmatch(f.Template, -1)
+	if len(matches) != 1 {
+		return fmt.Errorf("document number template %q must contain exactly one {SEQ:n}", f.Template)
+	}
+	width, _ := strconv.Atoi(matches[0][1])
+	if width < 1 || width > 12 {
+		return fmt.Errorf("document number template %q: sequence width must be between 1 and 12", f.Template)
+	}
+	rest := seqToken.ReplaceAllString(f.Template, "")
+	for _, tok := range []string{"{YYYY}", "{YY}", "{MM}"} {
+		rest = strings.ReplaceAll(rest, tok, "")
+	}
+	if strings.ContainsAny(rest, "{}") {
+		return fmt.Errorf("document number template %q contains an unknown token", f.Template)
+	}
+	// The period tokens have fixed widths, so the last number of the sequence
+	// is as long as any.
+	widest := f.Format(time.Now(), int(math.Pow10(width))-1)
+	if n := utf8.RuneCountInString(widest); n > maxDocumentNumberLength {
+		return fmt.Errorf("document number template %q gives numbers such as %s, which are %d characters; at most %d fit",
+			f.Template, widest, n, maxDocumentNumberLength)
+	}
+	return nil
+}
+
+// Period returns the key under which the sequence is counted for a document dated t.
+func (f DocumentNumberFormat) Period(t time.Time) string {
+	hasYear := strings.Contains(f.Template, "{YYYY}") || strings.Contains(f.Template, "{YY}")
+	switch {
+	case strings.Contains(f.Template, "{MM}"):
+		return t.Format("200601")
+	case hasYear:
+		return t.Format("2006")
+	}
+	return ""
+}
+
+// Format renders the document number for sequence value seq dated t.
+func (f DocumentNumberFormat) Format(t time.Time, seq int) string {
+	out := seqToken.ReplaceAllStringFunc(f.Template, func(tok string) string {
+		width, _ := strconv.Atoi(seqToken.FindStringSubmatch(tok)[1])
+		return fmt.Sprintf("%0*d", width, seq)
+	})
+	return strings.NewReplacer(
+		"{YYYY}", t.Format("2006"),
+		"{YY}", t.Format("06"),
+		"{MM}", t.Format("01"),
+	).Replace(out)
+}
+
+// Next allocates the next number in the series inside tx.
+// The counter row for the period stays locked until tx ends, so concurrent
+// callers are serialized, and a rolled-back transaction also rolls back its
+// increment: numbers are sequential and gap-free.
+func (f DocumentNumberFormat) Next(tx *sql.Tx, t time.Time) (string, error) {
+	var seq int
+	err := tx.QueryRow(`INSERT INTO "document_sequence" ("series", "period", "last_value") VALUES ($1, $2, 1)
+	                    ON CONFLICT ("series", "period") DO UPDATE SET "last_value" = "document_sequence"."last_value" + 1
+	                    RETURNING "last_value"`, f.Series, f.Period(t)).Scan(&seq)
+	if err != nil {
+		return "", err
+	}
+	return f.Format(t, seq), nil
+}
+
+// Document number series. The invoice template can be overridden with INVOICE_NUMBER_FORMAT.
+var (
+	invoiceNumberFormat = DocumentNumberFormat{Series: "INV", Template: "INV-{YYYY}{MM}-{SEQ:5}"}
+	creditNoteFormat    = DocumentNumberFormat{Series: "CN", Template: "CN-{SEQ:6}"}
+	debitNoteFormat     = DocumentNumberFormat{Series: "DN", Template: "DN-{SEQ:6}"}
+)
+
+// loadDocumentNumberConfig applies and validates the numbering configuration.
+func loadDocumentNumberConfig() error {
+	if v := os.Getenv("INVOICE_NUMBER_FORMAT"); v != "" {
+		invoiceNumberFormat.Template = v
+	}
+	for _, f := range []DocumentNumberFormat{invoiceNumberFormat, creditNoteFormat, debitNoteFormat} {
+		if err := f.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// assignInvoiceNumber gives a bill that is being issued its legal invoice number
+// and issue date. It must run in the same transaction that issues the bill, just
+// before the status change. A bill that already has a number keeps it.
+func assignInvoiceNumber(tx *sql.Tx, billID int, issuedAt time.Time) (string, error) {
+	var existing sql.NullString
+	if err := tx.QueryRow(`SELECT "invoice_number" FROM "billing" WHERE "bill_id" = $1 FOR UPDATE`, billID).Scan(&existing); err != nil {
+		return "", err
+	}
+	if existing.Valid {
+		return existing.String, nil
+	}
+	number, err := invoiceNumberFormat.Next(tx, issuedAt)
+	if err != nil {
+		return "", err
+	}
+	_, err = tx.Exec(`UPDATE "billing" SET "invoice_number" = $1, "issued_date" = $2 WHERE "bill_id" = $3`,
+		number, issuedAt.Format("2006-01-02"), billID)
+	return number, err
+}
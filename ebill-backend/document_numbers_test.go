@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDocumentNumberFormatValidate(t *testing.T) {
+	valid := []string{
+		"INV-{YYYY}{MM}-{SEQ:5}",
+		"CN-{SEQ:6}",
+		"{SEQ:1}",
+		"INVOICE-{YYYY}-{MM}-X-{SEQ:12}", // 30 characters at most
+		"ใบแจ้งหนี้-{YY}{MM}-{SEQ:12}", // Columns count characters, not bytes
+	}
+	for _, tmpl := range valid {
+		if err := (DocumentNumberFormat{Series: "INV", Template: tmpl}).Validate(); err != nil {
+			t.Errorf("Validate(%q): %v", tmpl, err)
+		}
+	}
+	invalid := []string{
+		"INV-{YYYY}{MM}",
+		"INV-{SEQ:3}-{SEQ:3}",
+		"INV-{SEQ:0}",
+		"INV-{SEQ:13}",
+		"INV-{DD}-{SEQ:5}",
+		"INVOICE-{YYYY}-{MM}-XY-{SEQ:12}",
+		"PROVINCIAL-ELECTRICITY-{YYYY}{MM}-{SEQ:5}",
+	}
+	for _, tmpl := range invalid {
+		if err := (DocumentNumberFormat{Series: "INV", Template: tmpl}).Validate(); err == nil {
+			t.Errorf("Validate(%q) succeeded, want an error", tmpl)
+		}
+	}
+}
+
+func TestDocumentNumberFormat(t *testing.T) {
+	date := time.Date(2024, 4, 15, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		template     string
+		seq          int
+		want, period string
+	}{
+		{"INV-{YYYY}{MM}-{SEQ:5}", 1, "INV-202404-00001", "202404"},
+		{"INV{YY}-{SEQ:3}", 1234, "INV24-1234", "2024"},
+		{"CN-{SEQ:6}", 42, "CN-000042", ""},
+	}
+	for _, tt := range tests {
+		f := DocumentNumberFormat{Series: "X", Template: tt.template}
+		if got := f.Format(date, tt.seq); got != tt.want {
+			t.Errorf("Format(%q, %d) = %q, want %q", tt.template, tt.seq, got, tt.want)
+		}
+		if got := f.Period(date); got != tt.period {
+			t.Errorf("Period(%q) = %q, want %q", tt.template, got, tt.period)
+		}
+	}
+}
@@ -0,0 +1,610 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// DocumentBranding is the issuer information printed on invoices and receipts.
+type DocumentBranding struct {
+	CompanyName string
+	Address     string
+	TaxID       string
+	Phone       string
+}
+
+// branding can be overridden with COMPANY_NAME, COMPANY_ADDRESS, COMPANY_TAX_ID and COMPANY_PHONE.
+var branding = DocumentBranding{CompanyName: "E-Bill Electricity Authority"}
+
+// Fonts used for documents. Without a TrueType font the documents fall back
+// to Helvetica and English-only labels, and Thai text cannot be shown.
+var pdfRegularTTF, pdfBoldTTF *TrueTypeFont
+
+// defaultPDFFontPaths are probed, in order, when PDF_FONT_PATH is not set.
+// Each entry is a regular/bold pair of fonts with Thai coverage.
+var defaultPDFFontPaths = [][2]string{
+	{"/usr/share/fonts/truetype/noto/NotoSansThai-Regular.ttf", "/usr/share/fonts/truetype/noto/NotoSansThai-Bold.ttf"},
+	{"/usr/share/fonts/truetype/tlwg/Garuda.ttf", "/usr/share/fonts/truetype/tlwg/Garuda-Bold.ttf"},
+	{"/usr/share/fonts/truetype/thai-tlwg/Garuda.ttf", "/usr/share/fonts/truetype/thai-tlwg/Garuda-Bold.ttf"},
+	{`C:\Windows\Fonts\tahoma.ttf`, `C:\Windows\Fonts\tahomabd.ttf`},
+}
+
+// Brand colour of the document header band.
+const brandR, brandG, brandB = 0.05, 0.33, 0.60
+
+// loadDocumentConfig reads the branding and locates fonts for PDF documents.
+// PDF_FONT_PATH (and optionally PDF_BOLD_FONT_PATH) select a TrueType font; if
+// set, they must load. Otherwise a few common Thai fonts are tried.
+func loadDocumentConfig() error {
+	for env, field := range map[string]*string{
+		"COMPANY_NAME":    &branding.CompanyName,
+		"COMPANY_ADDRESS": &branding.Address,
+		"COMPANY_TAX_ID":  &branding.TaxID,
+		"COMPANY_PHONE":   &branding.Phone,
+	} {
+		if v := os.Getenv(env); v != "" {
+			*field = v
+		}
+	}
+
+	if path := os.Getenv("PDF_FONT_PATH"); path != "" {
+		f, err := LoadTrueTypeFont(path, "FU")
+		if err != nil {
+			return fmt.Errorf("PDF_FONT_PATH: %w", err)
+		}
+		pdfRegularTTF, pdfBoldTTF = f, nil
+		if bold := os.Getenv("PDF_BOLD_FONT_PATH"); bold != "" {
+			if pdfBoldTTF, err = LoadTrueTypeFont(bold, "FUB"); err != nil {
+				return fmt.Errorf("PDF_BOLD_FONT_PATH: %w", err)
+			}
+		}
+		log.Printf("PDF documents: using font %s", path)
+		return nil
+	}
+	for _, pair := range defaultPDFFontPaths {
+		f, err := LoadTrueTypeFont(pair[0], "FU")
+		if err != nil {
+			continue
+		}
+		pdfRegularTTF = f
+		if b, err := LoadTrueTypeFont(pair[1], "FUB"); err == nil {
+			pdfBoldTTF = b
+		}
+		log.Printf("PDF documents: using font %s", pair[0])
+		return nil
+	}
+	log.Println("PDF documents: no Thai-capable font found (set PDF_FONT_PATH); using Helvetica with English labels")
+	return nil
+}
+
+// documentFonts are the fonts of one document.
+type documentFonts struct {
+	regular, bold PDFFont
+	bilingual     bool // Thai labels are shown next to the English ones
+}
+
+func newDocumentFonts() documentFonts {
+	if pdfRegularTTF == nil {
+		return documentFonts{regular: pdfHelvetica, bold: pdfHelveticaBold}
+	}
+	regular := pdfRegularTTF.forDocument()
+	var bold PDFFont = regular
+	if pdfBoldTTF != nil {
+		bold = pdfBoldTTF.forDocument()
+	}
+	return documentFonts{regular: regular, bold: bold, bilingual: true}
+}
+
+// label returns "English / ไทย" when Thai can be rendered, else just the English text.
+func (f documentFonts) label(en, th string) string {
+	if f.bilingual {
+		return en + " / " + th
+	}
+	return en
+}
+
+// lineItemThaiLabels translate the standard bill line item types.
+var lineItemThaiLabels = map[string]string{
+	LineItemEnergy:  "ค่าพลังงานไฟฟ้า",
+	LineItemService: "ค่าบริการ",
+	LineItemFt:      "ค่าไฟฟ้าผันแปร (Ft)",
+	LineItemVAT:     "ภาษีมูลค่าเพิ่ม",
+}
+
+// formatAmount renders m with thousands separators, e.g. "1,234.50".
+func formatAmount(m Money) string {
+	s := m.String()
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+	intPart, frac, _ := strings.Cut(s, ".")
+	var b strings.Builder
+	for i, c := range intPart {
+		if i > 0 && (len(intPart)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(c)
+	}
+	out := b.String() + "." + frac
+	if neg {
+		out = "-" + out
+	}
+	return out
+}
+
+// displayDate formats a DATE column value as e.g. "05 Apr 2024".
+func displayDate(s string) string {
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.Format("02 Jan 2006")
+		}
+	}
+	return s
+}
+
+// wrapText breaks s into lines no wider than width. Words are split on spaces;
+// a word that is itself too wide (e.g. Thai, which has no spaces) is broken by character.
+func wrapText(f PDFFont, size float64, s string, width float64) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(s) {
+		candidate := word
+		if line != "" {
+			candidate = line + " " + word
+		}
+		if f.width(candidate, size) <= width {
+			line = candidate
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+			line = ""
+		}
+		for f.width(word, size) > width {
+			runes := []rune(word)
+			n := len(runes) - 1
+			for n > 1 && f.width(string(runes[:n]), size) > width {
+				n--
+			}
+			lines = append(lines, string(runes[:n]))
+			word = string(runes[n:])
+		}
+		line = word
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// drawDocumentHeader draws the branded header band with the document title.
+func drawDocumentHeader(p *PDFPage, fonts documentFonts, title string) {
+	p.SetFillColor(brandR, brandG, brandB)
+	p.FillRect(0, 0, pdfPageWidth, 96)
+	p.SetFillColor(1, 1, 1)
+	p.Text(40, 38, fonts.bold, 18, branding.CompanyName)
+	y := 56.0
+	if branding.Address != "" {
+		p.Text(40, y, fonts.regular, 9, branding.Address)
+		y += 12
+	}
+	var contact []string
+	if branding.TaxID != "" {
+		contact = append(contact, fonts.label("Tax ID", "เลขประจำตัวผู้เสียภาษี")+" "+branding.TaxID)
+	}
+	if branding.Phone != "" {
+		contact = append(contact, fonts.label("Tel.", "โทร.")+" "+branding.Phone)
+	}
+	p.Text(40, y, fonts.regular, 9, strings.Join(contact, "   "))
+	p.TextRight(pdfPageWidth-40, 50, fonts.bold, 20, title)
+	p.SetFillColor(0, 0, 0)
+}
+
+// drawField draws a label and its value on one line.
+func drawField(p *PDFPage, fonts documentFonts, x, y, valueX float64, label, value string) {
+	p.SetFillColor(0.35, 0.35, 0.35)
+	p.Text(x, y, fonts.regular, 9, label)
+	p.SetFillColor(0, 0, 0)
+	p.Text(valueX, y, fonts.bold, 10, value)
+}
+
+// drawFooter prints the generation time at the bottom of the page.
+func drawFooter(p *PDFPage, fonts documentFonts) {
+	p.SetStrokeColor(0.8, 0.8, 0.8)
+	p.Line(40, pdfPageHeight-50, pdfPageWidth-40, pdfPageHeight-50, 0.5)
+	p.SetFillColor(0.45, 0.45, 0.45)
+	p.Text(40, pdfPageHeight-36, fonts.regular, 8,
+		fonts.label("Computer-generated document", "เอกสารนี้ออกโดยระบบคอมพิวเตอร์")+" - "+time.Now().Format("02 Jan 2006 15:04"))
+	p.SetFillColor(0, 0, 0)
+}
+
+// invoiceDocument is everything printed on an invoice.
+type invoiceDocument struct {
+	Bill        Billing
+	Customer    Customer
+	MeterNumber string
+	Adjustments Money // debit notes minus credit notes
+	Paid        Money // completed payments
+}
+
+// Balance is what the customer still owes on the bill.
+func (d *invoiceDocument) Balance() Money {
+	return d.Bill.AmountDue.Add(d.Adjustments).Sub(d.Paid)
+}
+
+// paymentReference identifies the bill when it is paid, e.g. on a bank transfer.
+func (d *invoiceDocument) paymentReference() string {
+	if d.Bill.InvoiceNumber != nil {
+		return *d.Bill.InvoiceNumber
+	}
+	return fmt.Sprintf("BILL-%d", d.Bill.BillID)
+}
+
+// loadInvoiceDocument gathers the bill, customer, meter, line items and balance.
+func loadInvoiceDocument(billID int) (*invoiceDocument, error) {
+	d := &invoiceDocument{}
+	b, c := &d.Bill, &d.Customer
+	err := db.QueryRow(`SELECT b.bill_id, b.customer_id, b.meter_id, b.billing_date, b.due_date,
+	                    b.previous_reading, b.current_reading, b.rate_applied, b.total_unit, b.amount_due, b.currency, b.paid_status,
+	                    b.bill_status, b.replaces_bill_id, b.invoice_number, b.issued_date,
+	                    c.customer_id, c.name, c.address, c.email, c.phone_number, m.meter_number
+	                    FROM billing b JOIN customer c ON c.customer_id = b.customer_id JOIN meter m ON m.meter_id = b.meter_id
+	                    WHERE b.bill_id = $1`, billID).Scan(&b.BillID, &b.CustomerID, &b.MeterID, &b.BillingDate, &b.DueDate,
+		&b.ReadingPrevious, &b.ReadingCurrent, &b.RateApplied, &b.TotalUnit, &b.AmountDue, &b.Currency, &b.PaidStatus,
+		&b.Status, &b.ReplacesBillID, &b.InvoiceNumber, &b.IssuedDate,
+		&c.CustomerID, &c.Name, &c.Address, &c.Email, &c.PhoneNumber, &d.MeterNumber)
+	if err != nil {
+		return nil, err
+	}
+	b.Customer_Name = c.Name
+	if b.LineItems, err = getBillLineItems(billID); err != nil {
+		return nil, err
+	}
+	err = db.QueryRow(`SELECT COALESCE(SUM(CASE "note_type" WHEN 'debit' THEN "amount" ELSE -"amount" END), 0)
+	                   FROM "billing_note" WHERE "bill_id" = $1`, billID).Scan(&d.Adjustments)
+	if err != nil {
+		return nil, err
+	}
+	err = db.QueryRow(`SELECT COALESCE(SUM("amount_paid"), 0) FROM "payment" WHERE "bill_id" = $1 AND "payment_status" = 'Completed'`,
+		billID).Scan(&d.Paid)
+	return d, err
+}
+
+// renderInvoicePDF lays out an invoice on a single A4 page.
+func renderInvoicePDF(d *invoiceDocument) (*PDFDocument, error) {
+	b := &d.Bill
+	doc := NewPDFDocument("Invoice " + d.paymentReference())
+	fonts := newDocumentFonts()
+	p := doc.AddPage()
+
+	if b.Status == BillStatusDraft || b.Status == BillStatusVoid {
+		p.SetFillColor(0.92, 0.92, 0.92)
+		p.TextRotated(150, 620, 40, fonts.bold, 110, strings.ToUpper(b.Status))
+		p.SetFillColor(0, 0, 0)
+	}
+	drawDocumentHeader(p, fonts, fonts.label("INVOICE", "ใบแจ้งค่าไฟฟ้า"))
+
+	// Customer block on the left, invoice details on the right.
+	p.SetFillColor(brandR, brandG, brandB)
+	p.Text(40, 130, fonts.bold, 10, fonts.label("Bill to", "ผู้ใช้ไฟฟ้า"))
+	p.SetFillColor(0, 0, 0)
+	p.Text(40, 148, fonts.bold, 12, d.Customer.Name)
+	y := 163.0
+	for _, line := range wrapText(fonts.regular, 10, d.Customer.Address, 250) {
+		p.Text(40, y, fonts.regular, 10, line)
+		y += 13
+	}
+	drawField(p, fonts, 40, y+6, 150, fonts.label("Meter no.", "เลขมิเตอร์"), d.MeterNumber)
+	drawField(p, fonts, 40, y+20, 150, fonts.label("Customer no.", "รหัสผู้ใช้"), strconv.Itoa(d.Customer.CustomerID))
+
+	invoiceNumber := "-"
+	if b.InvoiceNumber != nil {
+		invoiceNumber = *b.InvoiceNumber
+	}
+	issued := "-"
+	if b.IssuedDate != nil {
+		issued = displayDate(*b.IssuedDate)
+	}
+	fields := [][2]string{
+		{fonts.label("Invoice no.", "เลขที่"), invoiceNumber},
+		{fonts.label("Billing date", "วันที่จดหน่วย"), displayDate(b.BillingDate)},
+		{fonts.label("Issue date", "วันที่ออก"), issued},
+		{fonts.label("Due date", "กำหนดชำระ"), displayDate(b.DueDate)},
+		{fonts.label("Status", "สถานะ"), strings.ReplaceAll(b.Status, "_", " ")},
+	}
+	for i, f := range fields {
+		drawField(p, fonts, 330, 130+float64(i)*15, 440, f[0], f[1])
+	}
+
+	// Meter readings.
+	y = max(y+50, 225)
+	readings := [][2]string{
+		{fonts.label("Previous", "ครั้งก่อน"), b.ReadingPrevious.StringFixed(2)},
+		{fonts.label("Current", "ครั้งนี้"), b.ReadingCurrent.StringFixed(2)},
+		{fonts.label("Units (kWh)", "หน่วย"), b.TotalUnit.StringFixed(2)},
+		{fonts.label("Rate", "อัตรา"), b.RateApplied.StringFixed(4)},
+	}
+	colW := (pdfPageWidth - 80) / float64(len(readings))
+	p.SetFillColor(0.95, 0.96, 0.98)
+	p.FillRect(40, y, pdfPageWidth-80, 40)
+	for i, r := range readings {
+		x := 40 + float64(i)*colW + colW/2
+		p.SetFillColor(0.35, 0.35, 0.35)
+		p.TextCenter(x, y+15, fonts.regular, 8, r[0])
+		p.SetFillColor(0, 0, 0)
+		p.TextCenter(x, y+31, fonts.bold, 12, r[1])
+	}
+
+	// Line items.
+	y += 70
+	const colQty, colPrice, colAmount = 360.0, 450.0, pdfPageWidth - 40
+	p.SetFillColor(brandR, brandG, brandB)
+	p.FillRect(40, y-13, pdfPageWidth-80, 19)
+	p.SetFillColor(1, 1, 1)
+	p.Text(46, y, fonts.bold, 9, fonts.label("Description", "รายการ"))
+	p.TextRight(colQty, y, fonts.bold, 9, fonts.label("Quantity", "จำนวน"))
+	p.TextRight(colPrice, y, fonts.bold, 9, fonts.label("Unit price", "ราคา"))
+	p.TextRight(colAmount-6, y, fonts.bold, 9, fonts.label("Amount", "จำนวนเงิน"))
+	p.SetFillColor(0, 0, 0)
+	p.SetStrokeColor(0.85, 0.85, 0.85)
+	for _, li := range b.LineItems {
+		y += 20
+		desc := li.Description
+		if th, ok := lineItemThaiLabels[li.ItemType]; ok && fonts.bilingual {
+			desc += " / " + th
+		}
+		p.Text(46, y, fonts.regular, 10, desc)
+		qty, price := li.Quantity.StringFixed(2), li.UnitPrice.StringFixed(4)
+		if li.ItemType == LineItemVAT {
+			price = strings.TrimSuffix(strings.TrimRight(li.UnitPrice.Mul(NewDecimal(100, 0)).StringFixed(2), "0"), ".") + "%"
+		}
+		p.TextRight(colQty, y, fonts.regular, 10, qty)
+		p.TextRight(colPrice, y, fonts.regular, 10, price)
+		p.TextRight(colAmount-6, y, fonts.regular, 10, formatAmount(li.Amount))
+		p.Line(40, y+7, pdfPageWidth-40, y+7, 0.5)
+	}
+
+	// Totals.
+	y += 28
+	totals := [][2]string{{fonts.label("Total", "รวมเงิน"), formatAmount(b.AmountDue)}}
+	if !d.Adjustments.IsZero() {
+		totals = append(totals, [2]string{fonts.label("Adjustments", "ปรับปรุง"), formatAmount(d.Adjustments)})
+	}
+	if !d.Paid.IsZero() {
+		totals = append(totals, [2]string{fonts.label("Paid", "ชำระแล้ว"), formatAmount(d.Paid.Neg())})
+	}
+	for _, t := range totals {
+		p.TextRight(colPrice, y, fonts.regular, 10, t[0])
+		p.TextRight(colAmount-6, y, fonts.regular, 10, t[1])
+		y += 16
+	}
+	p.SetFillColor(0.95, 0.96, 0.98)
+	p.FillRect(300, y-8, pdfPageWidth-340, 26)
+	p.SetFillColor(0, 0, 0)
+	p.TextRight(colPrice, y+9, fonts.bold, 12, fonts.label("Balance due", "ยอดที่ต้องชำระ"))
+	p.TextRight(colAmount-6, y+9, fonts.bold, 12, formatAmount(d.Balance())+" "+b.Currency)
+
+	// PromptPay QR code for bills that can still be paid.
+	pp, _, err := promptPayQRFor(d)
+	if err != nil {
+		return nil, fmt.Errorf("build PromptPay payload: %w", err)
+	}
+	if pp != nil {
+		qr, err := EncodeQR([]byte(pp.Payload), QRLevelM)
+		if err != nil {
+			return nil, fmt.Errorf("encode payment QR code: %w", err)
+		}
+		qy := pdfPageHeight - 210
+		p.QRCode(40, qy, 120, qr)
+		p.Text(175, qy+20, fonts.bold, 11, fonts.label("Scan to pay with PromptPay", "สแกนจ่ายด้วยพร้อมเพย์"))
+		p.Text(175, qy+38, fonts.regular, 9, fonts.label("Reference", "เลขอ้างอิง")+": "+pp.Reference)
+		p.Text(175, qy+52, fonts.regular, 9, fonts.label("Amount", "จำนวนเงิน")+": "+pp.Amount.Format(pp.Currency))
+		p.Text(175, qy+66, fonts.regular, 9, fonts.label("Please pay by", "กรุณาชำระภายใน")+" "+displayDate(b.DueDate))
+	}
+	drawFooter(p, fonts)
+	return doc, nil
+}
+
+// receiptDocument is everything printed on a payment receipt.
+type receiptDocument struct {
+	Payment Payment
+	Invoice *invoiceDocument
+}
+
+// receiptNumber is the printed number of a payment receipt.
+func (d *receiptDocument) receiptNumber() string {
+	return fmt.Sprintf("RC-%06d", d.Payment.PaymentID)
+}
+
+// loadReceiptDocument gathers a payment and the invoice it was made against.
+func loadReceiptDocument(paymentID int) (*receiptDocument, error) {
+	d := &receiptDocument{}
+	p := &d.Payment
+	err := db.QueryRow(`SELECT "payment_id", "bill_id", "processed_by", "payment_date", "amount_paid", "currency", "payment_method", "payment_status"
+	                    FROM "payment" WHERE "payment_id" = $1`, paymentID).Scan(&p.PaymentID, &p.BillID, &p.ProcessedBy, &p.PaymentDate,
+		&p.AmountPaid, &p.Currency, &p.PaymentMethod, &p.PaymentStatus)
+	if err != nil {
+		return nil, err
+	}
+	d.Invoice, err = loadInvoiceDocument(p.BillID)
+	return d, err
+}
+
+// renderReceiptPDF lays out a payment receipt on a single A4 page.
+func renderReceiptPDF(d *receiptDocument) *PDFDocument {
+	pay, inv := &d.Payment, d.Invoice
+	doc := NewPDFDocument("Receipt " + d.receiptNumber())
+	fonts := newDocumentFonts()
+	p := doc.AddPage()
+
+	if pay.PaymentStatus != "Completed" {
+		p.SetFillColor(0.92, 0.92, 0.92)
+		p.TextRotated(150, 620, 40, fonts.bold, 90, strings.ToUpper(pay.PaymentStatus))
+		p.SetFillColor(0, 0, 0)
+	}
+	drawDocumentHeader(p, fonts, fonts.label("RECEIPT", "ใบเสร็จรับเงิน"))
+
+	p.SetFillColor(brandR, brandG, brandB)
+	p.Text(40, 130, fonts.bold, 10, fonts.label("Received from", "ได้รับเงินจาก"))
+	p.SetFillColor(0, 0, 0)
+	p.Text(40, 148, fonts.bold, 12, inv.Customer.Name)
+	y := 163.0
+	for _, line := range wrapText(fonts.regular, 10, inv.Customer.Address, 250) {
+		p.Text(40, y, fonts.regular, 10, line)
+		y += 13
+	}
+	drawField(p, fonts, 40, y+6, 150, fonts.label("Meter no.", "เลขมิเตอร์"), inv.MeterNumber)
+
+	fields := [][2]string{
+		{fonts.label("Receipt no.", "เลขที่"), d.receiptNumber()},
+		{fonts.label("Payment date", "วันที่ชำระ"), displayDate(pay.PaymentDate)},
+		{fonts.label("Method", "ช่องทาง"), pay.PaymentMethod},
+		{fonts.label("Status", "สถานะ"), pay.PaymentStatus},
+	}
+	for i, f := range fields {
+		drawField(p, fonts, 330, 130+float64(i)*15, 440, f[0], f[1])
+	}
+
+	y = max(y+50, 225)
+	p.SetFillColor(brandR, brandG, brandB)
+	p.FillRect(40, y-13, pdfPageWidth-80, 19)
+	p.SetFillColor(1, 1, 1)
+	p.Text(46, y, fonts.bold, 9, fonts.label("For invoice", "ชำระค่าไฟฟ้าตามใบแจ้งหนี้"))
+	p.TextRight(pdfPageWidth-46, y, fonts.bold, 9, fonts.label("Amount", "จำนวนเงิน"))
+	p.SetFillColor(0, 0, 0)
+	y += 22
+	p.Text(46, y, fonts.regular, 10, fmt.Sprintf("%s  (%s %s, %s %s)", inv.paymentReference(),
+		fonts.label("billed", "วันที่"), displayDate(inv.Bill.BillingDate),
+		fonts.label("due", "ครบกำหนด"), displayDate(inv.Bill.DueDate)))
+	p.TextRight(pdfPageWidth-46, y, fonts.regular, 10, formatAmount(pay.AmountPaid))
+	p.SetStrokeColor(0.85, 0.85, 0.85)
+	p.Line(40, y+7, pdfPageWidth-40, y+7, 0.5)
+
+	y += 30
+	p.SetFillColor(0.95, 0.96, 0.98)
+	p.FillRect(300, y-8, pdfPageWidth-340, 26)
+	p.SetFillColor(0, 0, 0)
+	p.TextRight(450, y+9, fonts.bold, 12, fonts.label("Amount received", "ยอดรับชำระ"))
+	p.TextRight(pdfPageWidth-46, y+9, fonts.bold, 12, formatAmount(pay.AmountPaid)+" "+pay.Currency)
+	y += 40
+	p.TextRight(450, y, fonts.regular, 10, fonts.label("Invoice balance", "ยอดคงค้างตามใบแจ้งหนี้"))
+	p.TextRight(pdfPageWidth-46, y, fonts.regular, 10, formatAmount(inv.Balance())+" "+inv.Bill.Currency)
+
+	drawFooter(p, fonts)
+	return doc
+}
+
+// writePDF sends doc as an inline PDF attachment.
+func writePDF(w http.ResponseWriter, filename string, doc *PDFDocument) {
+	var buf bytes.Buffer
+	if _, err := doc.WriteTo(&buf); err != nil {
+		respondWithServerError(w, "Failed to render PDF", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", filename))
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// serveInvoicePDF renders the invoice of a bill; when identifier is non-empty
+// the bill must belong to the customer with that email or phone number and be issued.
+func serveInvoicePDF(w http.ResponseWriter, r *http.Request, identifier string) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Bill ID")
+		return
+	}
+	d, err := loadInvoiceDocument(id)
+	if err == nil && identifier != "" && !ownsDocument(d, identifier) {
+		err = sql.ErrNoRows
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, http.StatusNotFound, "Bill not found")
+		} else {
+			respondWithServerError(w, "Failed to retrieve bill", err)
+		}
+		return
+	}
+	doc, err := renderInvoicePDF(d)
+	if err != nil {
+		respondWithServerError(w, "Failed to render invoice", err)
+		return
+	}
+	writePDF(w, "invoice-"+d.paymentReference()+".pdf", doc)
+}
+
+// serveReceiptPDF renders the receipt of a payment, with the same ownership rule as serveInvoicePDF.
+func serveReceiptPDF(w http.ResponseWriter, r *http.Request, identifier string) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Payment ID")
+		return
+	}
+	d, err := loadReceiptDocument(id)
+	if err == nil && identifier != "" && !ownsDocument(d.Invoice, identifier) {
+		err = sql.ErrNoRows
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, http.StatusNotFound, "Payment not found")
+		} else {
+			respondWithServerError(w, "Failed to retrieve payment", err)
+		}
+		return
+	}
+	writePDF(w, "receipt-"+d.receiptNumber()+".pdf", renderReceiptPDF(d))
+}
+
+// ownsDocument reports whether a portal user identified by email or phone may
+// see the invoice. Drafts are never shown to customers.
+func ownsDocument(d *invoiceDocument, identifier string) bool {
+	if d.Bill.Status == BillStatusDraft {
+		return false
+	}
+	return d.Customer.Email == identifier || d.Customer.PhoneNumber == identifier
+}
+
+func getBillingPDF(w http.ResponseWriter, r *http.Request) {
+	log.Println("API: getBillingPDF called")
+	serveInvoicePDF(w, r, "")
+}
+
+func getPaymentReceiptPDF(w http.ResponseWriter, r *http.Request) {
+	log.Println("API: getPaymentReceiptPDF called")
+	serveReceiptPDF(w, r, "")
+}
+
+// --- Customer portal equivalents, scoped by ?identifier= like /customer-bills ---
+
+func getCustomerBillPDF(w http.ResponseWriter, r *http.Request) {
+	log.Println("API: getCustomerBillPDF called")
+	identifier := r.URL.Query().Get("identifier")
+	if identifier == "" {
+		respondWithError(w, http.StatusBadRequest, "Identifier (email or phone number) is required")
+		return
+	}
+	serveInvoicePDF(w, r, identifier)
+}
+
+func getCustomerReceiptPDF(w http.ResponseWriter, r *http.Request) {
+	log.Println("API: getCustomerReceiptPDF called")
+	identifier := r.URL.Query().Get("identifier")
+	if identifier == "" {
+		respondWithError(w, http.StatusBadRequest, "Identifier (email or phone number) is required")
+		return
+	}
+	serveReceiptPDF(w, r, identifier)
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Error codes are part of the API: clients branch on them instead of on the
+// English message, which may change.
+const (
+	ErrCodeBadRequest           = "bad_request"
+	ErrCodeValidation           = "validation_failed"
+	ErrCodeUnauthorized         = "unauthorized"
+	ErrCodeForbidden            = "forbidden"
+	ErrCodeNotFound             = "not_found"
+	ErrCodeConflict             = "conflict"
+	ErrCodeDuplicate            = "duplicate"
+	ErrCodeInUse                = "in_use"
+	ErrCodeInvalidReference     = "invalid_reference"
+	ErrCodePreconditionFailed   = "precondition_failed"
+	ErrCodePreconditionRequired = "precondition_required"
+	ErrCodeUnsupportedMediaType = "unsupported_media_type"
+	ErrCodeInternal             = "internal_error"
+)
+
+// requestIDHeader carries the ID that ties an error response to the server log.
+const requestIDHeader = "X-Request-ID"
+
+// FieldError is a problem with one member of the request body.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ErrorResponse is the body of every error response. Error holds the
+// human-readable message, as it always has.
+type ErrorResponse struct {
+	Error     string       `json:"error"`
+	Code      string       `json:"code"`
+	Details   []FieldError `json:"details,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+}
+
+// APIError is an error that knows how it should be reported to the client.
+type APIError struct {
+	Status  int
+	Code    string
+	Message string
+	Details []FieldError
+
+	version int // Current row version of a 412, sent as the ETag
+}
+
+func (e *APIError) Error() string { return e.Message }
+
+// notFoundError is the 404 for a missing record; noun is capitalized, e.g. "Bill".
+func notFoundError(noun string) *APIError {
+	return &APIError{Status: http.StatusNotFound, Code: ErrCodeNotFound, Message: noun + " not found"}
+}
+
+// errorCodeFor is the code used when a handler only supplies a status.
+func errorCodeFor(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return ErrCodeBadRequest
+	case http.StatusUnauthorized:
+		return ErrCodeUnauthorized
+	case http.StatusForbidden:
+		return ErrCodeForbidden
+	case http.StatusNotFound:
+		return ErrCodeNotFound
+	case http.StatusConflict:
+		return ErrCodeConflict
+	case http.StatusPreconditionFailed:
+		return ErrCodePreconditionFailed
+	case http.StatusPreconditionRequired:
+		return ErrCodePreconditionRequired
+	case http.StatusUnsupportedMediaType:
+		return ErrCodeUnsupportedMediaType
+	case http.StatusUnprocessableEntity:
+		return ErrCodeValidation
+	}
+	if status >= 500 {
+		return ErrCodeInternal
+	}
+	return ErrCodeBadRequest
+}
+
+// respondWithAPIError sends e as an ErrorResponse, or an ErrorResponseV2 to
+// /api/v2 requests.
+func respondWithAPIError(w http.ResponseWriter, e *APIError) {
+	requestID := w.Header().Get(requestIDHeader)
+	if e.version != 0 {
+		setETag(w, e.version)
+	}
+	log.Printf("Error response: request_id=%s status=%d code=%s message=%s", requestID, e.Status, e.Code, e.Message)
+	if isV2Response(w) {
+		respondWithJSON(w, e.Status, ErrorResponseV2{Error: errorBodyV2(e, requestID)})
+		return
+	}
+	respondWithJSON(w, e.Status, ErrorResponse{Error: e.Message, Code: e.Code, Details: e.Details, RequestID: requestID})
+}
+
+// respondWithServerError reports an error from the database or another
+// dependency. Constraint violations are translated into 409 or 422 naming the
+// offending field; anything else is logged and answered with a generic 500 so
+// that driver messages and schema details do not reach the client.
+func respondWithServerError(w http.ResponseWriter, message string, err error) {
+	if apiErr := translateDBError(err); apiErr != nil {
+		respondWithAPIError(w, apiErr)
+		return
+	}
+	log.Printf("%s: %v (request_id=%s)", message, err, w.Header().Get(requestIDHeader))
+	respondWithAPIError(w, &APIError{Status: http.StatusInternalServerError, Code: ErrCodeInternal, Message: message})
+}
+
+// constraintFields names the field behind constraints whose name does not
+// follow PostgreSQL's <table>_<column>_<suffix> default.
+var constraintFields = map[string]string{
+	"valid_due_date":                 "Due_Date",
+	"issued_bill_has_invoice_number": "Invoice_Number",
+}
+
+var constraintSuffix = regexp.MustCompile(`_(key|fkey|check)$`)
+
+// dbTables lists the table prefixes of default constraint names, longest first.
+var dbTables = []string{
+	"billing_note_line_item", "bank_statement_import", "bill_status_history", "customer_statement", "document_sequence",
+	"inbound_transfer", "bill_line_item", "import_job_row", "billing_note", "import_job", "cash_shift", "customer", "billing", "payment",
+	"meter", "users",
+}
+
+// fieldForConstraint maps a constraint name such as customer_phone_number_key
+// to the JSON field it guards, here Phone_Number.
+func fieldForConstraint(constraint string) string {
+	if f, ok := constraintFields[constraint]; ok {
+		return f
+	}
+	column := constraintSuffix.ReplaceAllString(constraint, "")
+	for _, t := range dbTables {
+		if strings.HasPrefix(column, t+"_") {
+			column = strings.TrimPrefix(column, t+"_")
+			break
+		}
+	}
+	return jsonFieldName(column)
+}
+
+// jsonFieldName converts a column name to the field names used in request and
+// response bodies: phone_number becomes Phone_Number, customer_id Customer_ID.
+func jsonFieldName(column string) string {
+	if column == "" {
+		return ""
+	}
+	parts := strings.Split(strings.ToLower(column), "_")
+	for i, p := range parts {
+		if p == "id" {
+			parts[i] = "ID"
+		} else if p != "" {
+			parts[i] = strings.ToUpper(p[:1]) + p[1:]
+		}
+	}
+	return strings.Join(parts, "_")
+}
+
+// translateDBError maps PostgreSQL integrity errors to client errors, or
+// returns nil when err is not one the client can act on.
+func translateDBError(err error) *APIError {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return nil
+	}
+	switch pqErr.Code.Name() {
+	case "unique_violation":
+		field := fieldForConstraint(pqErr.Constraint)
+		return &APIError{Status: http.StatusConflict, Code: ErrCodeDuplicate, Message: field + " is already in use",
+			Details: []FieldError{{Field: field, Code: ErrCodeDuplicate, Message: field + " must be unique"}}}
+	case "foreign_key_violation":
+		field := fieldForConstraint(pqErr.Constraint)
+		if strings.Contains(pqErr.Detail, "is still referenced") {
+			return &APIError{Status: http.StatusConflict, Code: ErrCodeInUse,
+				Message: "Record is still referenced by " + pqErr.Table + " records and cannot be removed"}
+		}
+		return &APIError{Status: http.StatusUnprocessableEntity, Code: ErrCodeInvalidReference, Message: field + " does not exist",
+			Details: []FieldError{{Field: field, Code: ErrCodeInvalidReference, Message: field + " does not refer to an existing record"}}}
+	case "check_violation":
+		if pqErr.Constraint == "" {
+			// Raised by a trigger guarding a business rule; its message is written for users.
+			return &APIError{Status: http.StatusConflict, Code: ErrCodeConflict, Message: pqErr.Message}
+		}
+		field := fieldForConstraint(pqErr.Constraint)
+		return &APIError{Status: http.StatusUnprocessableEntity, Code: ErrCodeValidation, Message: field + " is invalid",
+			Details: []FieldError{{Field: field, Code: "invalid", Message: field + " has a value that is not allowed"}}}
+	case "not_null_violation":
+		field := jsonFieldName(pqErr.Column)
+		return &APIError{Status: http.StatusUnprocessableEntity, Code: ErrCodeValidation, Message: field + " is required",
+			Details: []FieldError{{Field: field, Code: "required", Message: field + " is required"}}}
+	case "string_data_right_truncation", "numeric_value_out_of_range", "invalid_datetime_format",
+		"datetime_field_overflow", "invalid_text_representation":
+		return &APIError{Status: http.StatusUnprocessableEntity, Code: ErrCodeValidation, Message: "A value is too long or badly formatted"}
+	case "serialization_failure", "deadlock_detected":
+		return &APIError{Status: http.StatusConflict, Code: ErrCodeConflict, Message: "The record was changed concurrently; retry the request"}
+	}
+	return nil
+}
+
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// withRequestID tags each request with an ID, taken from the client's
+// X-Request-ID when present, and echoes it in the response headers.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			b := make([]byte, 8)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), RequestIDKey, id)))
+	})
+}
+
+// requestID returns the ID withRequestID gave the request.
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(RequestIDKey).(string)
+	return id
+}
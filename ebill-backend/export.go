@@ -0,0 +1,570 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Exports stream every row of a list resource that matches the list
+// endpoint's filters, in its sort order, as CSV, XLSX or JSON Lines:
+//
+//	GET /api/v2/bills/export?format=xlsx&columns=bill_id,amount_due&billing_date_from=2026-09-01
+//
+// Rows are written as they are read from the database, so an export of any
+// size uses the same memory. Columns carry their v2 member names.
+
+// Export formats.
+const (
+	ExportFormatCSV    = "csv"
+	ExportFormatXLSX   = "xlsx"
+	ExportFormatNDJSON = "ndjson"
+)
+
+// exportFlushRows is how many rows are written between flushes to the client.
+const exportFlushRows = 500
+
+var exportContentTypes = map[string]string{
+	ExportFormatCSV:    "text/csv; charset=utf-8",
+	ExportFormatXLSX:   "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	ExportFormatNDJSON: "application/x-ndjson",
+}
+
+type exportKind int
+
+const (
+	exportText      exportKind = iota
+	exportInt                  // JSON number
+	exportDecimal              // JSON string, as in the API
+	exportMoney                // Like exportDecimal; two places in XLSX
+	exportBool                 // JSON boolean
+	exportDate                 // YYYY-MM-DD
+	exportTimestamp            // YYYY-MM-DD HH:MM:SS
+	exportJSON                 // Embedded as is in JSON Lines
+)
+
+type exportColumn struct {
+	name string // v2 member name
+	expr string // SQL expression, in terms of the list's FROM clause
+	kind exportKind
+}
+
+// selectExpr returns expr as text in the format the exporters expect.
+func (c exportColumn) selectExpr() string {
+	switch c.kind {
+	case exportDate:
+		return fmt.Sprintf("to_char(%s, 'YYYY-MM-DD')", c.expr)
+	case exportTimestamp:
+		return fmt.Sprintf("to_char(%s, 'YYYY-MM-DD HH24:MI:SS')", c.expr)
+	}
+	return fmt.Sprintf("(%s)::text", c.expr)
+}
+
+// exportResource is a list resource that can be exported.
+type exportResource struct {
+	name    string // Used in file names, sheet names and log lines
+	list    *listResource
+	columns []exportColumn // Exported when ?columns= is not given
+}
+
+var customerExport = exportResource{name: "customers", list: &customerList, columns: []exportColumn{
+	{"customer_id", `"customer_id"`, exportInt},
+	{"name", `"name"`, exportText},
+	{"address", `"address"`, exportText},
+	{"email", `"email"`, exportText},
+	{"phone_number", `"phone_number"`, exportText},
+	{"registration_date", `"registration_date"`, exportDate},
+	{"version", `"version"`, exportInt},
+}}
+
+var meterExport = exportResource{name: "meters", list: &meterList, columns: []exportColumn{
+	{"meter_id", `"meter_id"`, exportInt},
+	{"customer_id", `"customer_id"`, exportInt},
+	{"meter_number", `"meter_number"`, exportText},
+	{"installation_date", `"installation_date"`, exportDate},
+	{"active_status", `"active_status"`, exportBool},
+	{"version", `"version"`, exportInt},
+}}
+
+var billExport = exportResource{name: "bills", list: &billingList, columns: []exportColumn{
+	{"bill_id", `b.bill_id`, exportInt},
+	{"customer_id", `b.customer_id`, exportInt},
+	{"customer_name", `c.name`, exportText},
+	{"meter_id", `b.meter_id`, exportInt},
+	{"billing_date", `b.billing_date`, exportDate},
+	{"due_date", `b.due_date`, exportDate},
+	{"previous_reading", `b.previous_reading`, exportDecimal},
+	{"current_reading", `b.current_reading`, exportDecimal},
+	{"rate_applied", `b.rate_applied`, exportDecimal},
+	{"total_unit", `b.total_unit`, exportDecimal},
+	{"amount_due", `b.amount_due`, exportMoney},
+	{"currency", `b.currency`, exportText},
+	{"paid_status", `b.paid_status`, exportBool},
+	{"bill_status", `b.bill_status`, exportText},
+	{"replaces_bill_id", `b.replaces_bill_id`, exportInt},
+	{"invoice_number", `b.invoice_number`, exportText},
+	{"issued_date", `b.issued_date`, exportDate},
+	{"version", `b.version`, exportInt},
+}}
+
+var paymentExport = exportResource{name: "payments", list: &paymentList, columns: []exportColumn{
+	{"payment_id", `"payment_id"`, exportInt},
+	{"bill_id", `"bill_id"`, exportInt},
+	{"processed_by", `"processed_by"`, exportInt},
+	{"payment_date", `"payment_date"`, exportDate},
+	{"amount_paid", `"amount_paid"`, exportMoney},
+	{"currency", `"currency"`, exportText},
+	{"payment_method", `"payment_method"`, exportText},
+	{"payment_status", `"payment_status"`, exportText},
+	{"version", `"version"`, exportInt},
+}}
+
+var userExport = exportResource{name: "users", list: &userList, columns: []exportColumn{
+	{"id", `"id"`, exportInt},
+	{"username", `"username"`, exportText},
+	{"email", `"email"`, exportText},
+	{"permission", `"permission"`, exportText},
+}}
+
+var importJobExport = exportResource{name: "imports", list: &importJobList, columns: []exportColumn{
+	{"job_id", `"job_id"`, exportInt},
+	{"kind", `"kind"`, exportText},
+	{"file_name", `"file_name"`, exportText},
+	{"format", `"format"`, exportText},
+	{"dry_run", `"dry_run"`, exportBool},
+	{"status", `"status"`, exportText},
+	{"total_rows", `"total_rows"`, exportInt},
+	{"processed_rows", `"processed_rows"`, exportInt},
+	{"created_rows", `"created_rows"`, exportInt},
+	{"updated_rows", `"updated_rows"`, exportInt},
+	{"unchanged_rows", `"unchanged_rows"`, exportInt},
+	{"failed_rows", `"failed_rows"`, exportInt},
+	{"error", `"error"`, exportText},
+	{"created_by", `"created_by"`, exportInt},
+	{"created_at", `"created_at"`, exportTimestamp},
+	{"started_at", `"started_at"`, exportTimestamp},
+	{"finished_at", `"finished_at"`, exportTimestamp},
+}}
+
+var importRowExport = exportResource{name: "import-rows", list: &importRowList, columns: []exportColumn{
+	{"row_number", `"row_number"`, exportInt},
+	{"action", `"action"`, exportText},
+	{"entity_id", `"entity_id"`, exportInt},
+	{"errors", `"errors"`, exportJSON},
+}}
+
+// columnNames lists the names of the resource's columns.
+func (e *exportResource) columnNames() []string {
+	names := make([]string, len(e.columns))
+	for i, c := range e.columns {
+		names[i] = c.name
+	}
+	return names
+}
+
+// selectColumns returns the columns named in the comma-separated list, in
+// its order, or every column when the list is empty.
+func (e *exportResource) selectColumns(list string) ([]exportColumn, error) {
+	if strings.TrimSpace(list) == "" {
+		return e.columns, nil
+	}
+	byName := map[string]exportColumn{}
+	for _, c := range e.columns {
+		byName[c.name] = c
+	}
+	var cols []exportColumn
+	seen := map[string]bool{}
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		c, ok := byName[name]
+		if !ok {
+			return nil, listParamErrorf("unknown column %q; columns are %s", name, strings.Join(e.columnNames(), ", "))
+		}
+		if !seen[name] {
+			seen[name] = true
+			cols = append(cols, c)
+		}
+	}
+	return cols, nil
+}
+
+// exportWriter writes rows in one format. Values are nil for SQL NULL.
+type exportWriter interface {
+	WriteRow(values []*string) error
+	Flush() error
+	Close() error
+}
+
+type csvExportWriter struct{ cw *csv.Writer }
+
+func (x csvExportWriter) WriteRow(values []*string) error {
+	record := make([]string, len(values))
+	for i, v := range values {
+		if v != nil {
+			record[i] = *v
+		}
+	}
+	return x.cw.Write(record)
+}
+
+func (x csvExportWriter) Flush() error {
+	x.cw.Flush()
+	return x.cw.Error()
+}
+
+func (x csvExportWriter) Close() error { return x.Flush() }
+
+// ndjsonExportWriter writes one JSON object per line, with members in column
+// order.
+type ndjsonExportWriter struct {
+	bw   *bufio.Writer
+	cols []exportColumn
+	keys [][]byte
+}
+
+func (x *ndjsonExportWriter) WriteRow(values []*string) error {
+	x.bw.WriteByte('{')
+	for i, v := range values {
+		if i > 0 {
+			x.bw.WriteByte(',')
+		}
+		x.bw.Write(x.keys[i])
+		x.bw.WriteByte(':')
+		switch {
+		case v == nil:
+			x.bw.WriteString("null")
+		case x.cols[i].kind == exportInt || x.cols[i].kind == exportBool || x.cols[i].kind == exportJSON:
+			x.bw.WriteString(*v)
+		default:
+			b, _ := json.Marshal(*v)
+			x.bw.Write(b)
+		}
+	}
+	x.bw.WriteString("}\n")
+	return nil
+}
+
+func (x *ndjsonExportWriter) Flush() error { return x.bw.Flush() }
+func (x *ndjsonExportWriter) Close() error { return x.bw.Flush() }
+
+type xlsxExportWriter struct {
+	*xlsxWriter
+	cols []exportColumn
+}
+
+func (x xlsxExportWriter) WriteRow(values []*string) error {
+	cells := make([]xlsxCell, len(values))
+	for i, v := range values {
+		if v == nil {
+			continue
+		}
+		c := xlsxCell{Type: "s", Value: *v}
+		switch x.cols[i].kind {
+		case exportInt, exportDecimal:
+			c.Type = "n"
+		case exportMoney:
+			c.Type, c.Style = "n", xlsxStyleMoney
+		case exportBool:
+			c.Type, c.Value = "b", map[string]string{"true": "1", "false": "0"}[*v]
+		case exportDate:
+			if t, err := time.Parse("2006-01-02", *v); err == nil {
+				c = xlsxDateCell(t, xlsxStyleDate)
+			}
+		case exportTimestamp:
+			if t, err := time.Parse("2006-01-02 15:04:05", *v); err == nil {
+				c = xlsxDateCell(t, xlsxStyleDateTime)
+			}
+		}
+		cells[i] = c
+	}
+	return x.xlsxWriter.WriteRow(cells)
+}
+
+// newExportWriter starts an export in format with a header of column names,
+// where the format has one.
+func newExportWriter(w io.Writer, format, sheetName string, cols []exportColumn) (exportWriter, error) {
+	names := make([]string, len(cols))
+	for i, c := range cols {
+		names[i] = c.name
+	}
+	switch format {
+	case ExportFormatXLSX:
+		xw, err := newXLSXWriter(w, sheetName)
+		if err != nil {
+			return nil, err
+		}
+		header := make([]xlsxCell, len(names))
+		for i, n := range names {
+			header[i] = xlsxCell{Type: "s", Value: n, Style: xlsxStyleHeader}
+		}
+		return xlsxExportWriter{xw, cols}, xw.WriteRow(header)
+	case ExportFormatNDJSON:
+		x := &ndjsonExportWriter{bw: bufio.NewWriter(w), cols: cols}
+		for _, n := range names {
+			key, _ := json.Marshal(n)
+			x.keys = append(x.keys, key)
+		}
+		return x, nil
+	default:
+		x := csvExportWriter{csv.NewWriter(w)}
+		return x, x.cw.Write(names)
+	}
+}
+
+// serve streams the rows matching q. Parameter and database errors are
+// reported as usual until the first byte is sent; after that a failure can
+// only abort the response, which the client sees as a truncated download.
+func (e *exportResource) serve(w http.ResponseWriter, q url.Values) {
+	format := q.Get("format")
+	if format == "" {
+		format = ExportFormatCSV
+	}
+	if _, ok := exportContentTypes[format]; !ok {
+		respondWithError(w, http.StatusBadRequest, "format must be csv, xlsx or ndjson")
+		return
+	}
+	cols, err := e.selectColumns(q.Get("columns"))
+	if err != nil {
+		respondWithListError(w, e.name, err)
+		return
+	}
+	if format == ExportFormatXLSX {
+		n, err := e.list.count(q)
+		if err != nil {
+			respondWithListError(w, e.name, err)
+			return
+		}
+		if n >= maxXLSXRows {
+			respondWithAPIError(w, &APIError{Status: http.StatusUnprocessableEntity, Code: ErrCodeValidation,
+				Message: fmt.Sprintf("%d rows do not fit in one worksheet; narrow the filters or use csv or ndjson", n)})
+			return
+		}
+	}
+	exprs := make([]string, len(cols))
+	for i, c := range cols {
+		exprs[i] = c.selectExpr()
+	}
+	rows, err := e.list.all(q, strings.Join(exprs, ", "))
+	if err != nil {
+		respondWithListError(w, e.name, err)
+		return
+	}
+	defer rows.Close()
+
+	setExportHeaders(w, e.name, format)
+	n, err := e.write(w, format, cols, rows)
+	if err != nil {
+		log.Printf("Export of %s failed after %d rows: %v", e.name, n, err)
+		panic(http.ErrAbortHandler)
+	}
+	log.Printf("Exported %d %s as %s", n, e.name, format)
+}
+
+// setExportHeaders makes the response a download named after the export and today's date.
+func setExportHeaders(w http.ResponseWriter, name, format string) {
+	w.Header().Set("Content-Type", exportContentTypes[format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q",
+		fmt.Sprintf("%s-%s.%s", name, time.Now().Format("2006-01-02"), format)))
+}
+
+// write copies rows to w and returns how many were written.
+func (e *exportResource) write(w http.ResponseWriter, format string, cols []exportColumn, rows *sql.Rows) (int, error) {
+	ew, err := newExportWriter(w, format, e.name, cols)
+	if err != nil {
+		return 0, err
+	}
+	flusher, _ := w.(http.Flusher)
+	values := make([]sql.NullString, len(cols))
+	dest := make([]interface{}, len(cols))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	out := make([]*string, len(cols))
+	n := 0
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return n, err
+		}
+		for i := range values {
+			out[i] = nil
+			if values[i].Valid {
+				out[i] = &values[i].String
+			}
+		}
+		if err := ew.WriteRow(out); err != nil {
+			return n, err
+		}
+		n++
+		if n%exportFlushRows == 0 && flusher != nil {
+			if err := ew.Flush(); err != nil {
+				return n, err
+			}
+			flusher.Flush()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return n, err
+	}
+	return n, ew.Close()
+}
+
+// structExportColumns describes the JSON members of a struct type as export
+// columns. String fields tagged export:"date" are dates.
+func structExportColumns(t reflect.Type) []exportColumn {
+	var cols []exportColumn
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		c := exportColumn{name: name, kind: exportText}
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		switch {
+		case ft == reflect.TypeOf(Money{}):
+			c.kind = exportMoney
+		case ft == reflect.TypeOf(Decimal{}):
+			c.kind = exportDecimal
+		case ft.Kind() == reflect.Int:
+			c.kind = exportInt
+		case ft.Kind() == reflect.Bool:
+			c.kind = exportBool
+		case f.Tag.Get("export") == "date":
+			c.kind = exportDate
+		}
+		cols = append(cols, c)
+	}
+	return cols
+}
+
+// structExportValues returns the values of v's JSON members in the order of
+// structExportColumns.
+func structExportValues(v reflect.Value, out []*string) {
+	n := 0
+	for i := 0; i < v.NumField(); i++ {
+		name := strings.Split(v.Type().Field(i).Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		f := v.Field(i)
+		if f.Kind() == reflect.Ptr {
+			if f.IsNil() {
+				out[n] = nil
+				n++
+				continue
+			}
+			f = f.Elem()
+		}
+		var s string
+		switch x := f.Interface().(type) {
+		case fmt.Stringer:
+			s = x.String()
+		default:
+			s = fmt.Sprint(x)
+		}
+		out[n] = &s
+		n++
+	}
+}
+
+// respondWithRows answers with rows, a slice of structs, as a download in
+// format. The rows are already in memory, so this suits aggregates rather
+// than whole tables.
+func respondWithRows(w http.ResponseWriter, name, format string, rows interface{}) {
+	v := reflect.ValueOf(rows)
+	cols := structExportColumns(v.Type().Elem())
+	setExportHeaders(w, name, format)
+	ew, err := newExportWriter(w, format, name, cols)
+	out := make([]*string, len(cols))
+	for i := 0; err == nil && i < v.Len(); i++ {
+		structExportValues(v.Index(i), out)
+		err = ew.WriteRow(out)
+	}
+	if err == nil {
+		err = ew.Close()
+	}
+	if err != nil {
+		log.Printf("Export of %s failed: %v", name, err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// handler serves exports of e filtered by the request's query parameters.
+func (e *exportResource) handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("API v2: export %s called", e.name)
+		e.serve(w, r.URL.Query())
+	}
+}
+
+// exportImportRowsV2 exports the row outcomes of the import job in the path.
+func exportImportRowsV2(w http.ResponseWriter, r *http.Request) {
+	log.Println("API v2: exportImportRows called")
+	id, ok := v2ID(w, r)
+	if !ok {
+		return
+	}
+	if _, err := loadImportJob(id); err != nil {
+		respondWithServerError(w, "Failed to retrieve import job", err)
+		return
+	}
+	q := url.Values{}
+	for k, v := range r.URL.Query() {
+		q[k] = v
+	}
+	q.Set("job_id", strconv.Itoa(id))
+	importRowExport.serve(w, q)
+}
+
+// registerExports adds the export routes. They must be registered before the
+// resources' own routes, or /{id} would match "export".
+func registerExports(router *mux.Router) {
+	for path, e := range map[string]*exportResource{
+		"/users":     &userExport,
+		"/customers": &customerExport,
+		"/meters":    &meterExport,
+		"/bills":     &billExport,
+		"/payments":  &paymentExport,
+		"/imports":   &importJobExport,
+	} {
+		router.HandleFunc(path+"/export", e.handler()).Methods("GET", "OPTIONS")
+	}
+	router.HandleFunc("/imports/{id}/rows/export", exportImportRowsV2).Methods("GET", "OPTIONS")
+}
+
+// exportParams documents the query parameters of an export of e.
+func exportParams(e *exportResource) []apiParam {
+	names := e.columnNames()
+	params := []apiParam{
+		{Name: "format", Enum: []string{ExportFormatCSV, ExportFormatXLSX, ExportFormatNDJSON}, Description: "Default csv"},
+		{Name: "columns", Description: "Comma-separated columns, in output order; default " + strings.Join(names, ",")},
+	}
+	return append(params, filterParams(e.list)...)
+}
+
+// exportOperation documents the export route of e at path.
+func exportOperation(path, tag string, e *exportResource) apiOperation {
+	produces := make([]string, 0, len(exportContentTypes))
+	for _, ct := range exportContentTypes {
+		produces = append(produces, ct)
+	}
+	sort.Strings(produces)
+	return apiOperation{Method: "GET", Path: path, Tag: tag, Summary: "Export " + strings.ReplaceAll(e.name, "-", " ") +
+		" matching the list filters as CSV, XLSX or JSON Lines", Params: exportParams(e), Produces: produces}
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Forecasts project a monthly series, either one meter's consumption or the
+// amount billed in one currency, with additive Holt-Winters smoothing over a
+// twelve-month season. Series shorter than two seasons fall back to Holt's
+// linear trend, and very short ones to their mean. Smoothing parameters are
+// chosen by a grid search minimising the one-step-ahead squared error, and
+// intervals widen with the horizon using the method's variance factors.
+//
+// History ends with the last complete month unless ?to= is given, and months
+// without bills count as zero.
+
+const (
+	forecastSeason         = 12
+	defaultForecastPeriods = 12
+	maxForecastPeriods     = 36
+)
+
+// Forecast targets.
+const (
+	ForecastMeter   = "meter"
+	ForecastRevenue = "revenue"
+)
+
+// Forecast methods.
+const (
+	ForecastHoltWinters = "holt_winters"
+	ForecastHolt        = "holt"
+	ForecastMean        = "mean"
+)
+
+// forecastZ maps a confidence level to its two-sided normal quantile.
+var forecastZ = map[string]float64{"80": 1.2816, "90": 1.6449, "95": 1.9600}
+
+// ForecastPoint is one month of a forecast: an actual value for history, or
+// a projection with its interval.
+type ForecastPoint struct {
+	Month    string   `json:"month"` // YYYY-MM
+	Actual   *Decimal `json:"actual"`
+	Forecast *Decimal `json:"forecast"`
+	Lower    *Decimal `json:"lower"`
+	Upper    *Decimal `json:"upper"`
+}
+
+// Forecast is a projection of a monthly series.
+type Forecast struct {
+	Target   string          `json:"target"`   // meter or revenue
+	MeterID  *int            `json:"meter_id"` // For meter forecasts
+	Currency *string         `json:"currency"` // For revenue forecasts
+	Method   string          `json:"method"`   // holt_winters, holt or mean
+	Alpha    float64         `json:"alpha"`
+	Beta     float64         `json:"beta"`
+	Gamma    float64         `json:"gamma"`
+	RMSE     Decimal         `json:"rmse"`  // Root mean squared one-step-ahead error after the values that initialise the model
+	Level    int             `json:"level"` // Confidence level of the intervals, in percent
+	Points   []ForecastPoint `json:"points"`
+}
+
+// rows lets reportHandler export the points.
+func (f Forecast) rows() interface{} { return f.Points }
+
+// smoothing is a fitted model: its one-step-ahead error and a function
+// projecting h periods past the end of the series.
+type smoothing struct {
+	method             string
+	alpha, beta, gamma float64
+	sse                float64
+	errors             int
+	project            func(h int) float64
+	varianceFactor     func(h int) float64 // Multiple of the one-step variance at horizon h
+}
+
+func (s smoothing) sigma() float64 {
+	if s.errors == 0 {
+		return 0
+	}
+	return math.Sqrt(s.sse / float64(s.errors))
+}
+
+// smoothingGrid lists the parameter values tried for each of alpha, beta and gamma.
+var smoothingGrid = func() []float64 {
+	var g []float64
+	for i := 1; i <= 19; i++ {
+		g = append(g, float64(i*5)/100)
+	}
+	return g
+}()
+
+// holtWinters fits additive Holt-Winters smoothing with season length m to
+// y, which must hold at least two seasons.
+func holtWinters(y []float64, m int) smoothing {
+	run := func(alpha, beta, gamma float64) (level, trend float64, season []float64, sse float64) {
+		var first, second float64
+		for i := 0; i < m; i++ {
+			first += y[i]
+			second += y[m+i]
+		}
+		level = first / float64(m)
+		trend = (second - first) / float64(m*m)
+		season = make([]float64, m)
+		for i := 0; i < m; i++ {
+			season[i] = y[i] - level
+		}
+		for t, v := range y {
+			s := season[t%m]
+			if t >= m { // The first season set the indices, so it cannot be out of fit
+				e := v - (level + trend + s)
+				sse += e * e
+			}
+			prev := level
+			level = alpha*(v-s) + (1-alpha)*(level+trend)
+			trend = beta*(level-prev) + (1-beta)*trend
+			season[t%m] = gamma*(v-level) + (1-gamma)*s
+		}
+		return
+	}
+	best := smoothing{method: ForecastHoltWinters, sse: math.Inf(1), errors: len(y) - m}
+	for _, a := range smoothingGrid {
+		for _, b := range smoothingGrid {
+			for _, g := range smoothingGrid {
+				if _, _, _, sse := run(a, b, g); sse < best.sse {
+					best.alpha, best.beta, best.gamma, best.sse = a, b, g, sse
+				}
+			}
+		}
+	}
+	level, trend, season, _ := run(best.alpha, best.beta, best.gamma)
+	n := len(y)
+	a, b, g := best.alpha, best.beta, best.gamma
+	best.project = func(h int) float64 { return level + float64(h)*trend + season[(n+h-1)%m] }
+	best.varianceFactor = func(h int) float64 {
+		v := 1.0
+		for j := 1; j < h; j++ {
+			c := a * (1 + float64(j)*b)
+			if j%m == 0 {
+				c += g * (1 - a)
+			}
+			v += c * c
+		}
+		return v
+	}
+	return best
+}
+
+// holt fits Holt's linear trend smoothing to y, which must hold at least
+// two values.
+func holt(y []float64) smoothing {
+	run := func(alpha, beta float64) (level, trend, sse float64) {
+		level, trend = y[0], y[1]-y[0]
+		for t, v := range y[1:] {
+			if t > 0 { // The second value set the trend, so it cannot be out of fit
+				e := v - (level + trend)
+				sse += e * e
+			}
+			prev := level
+			level = alpha*v + (1-alpha)*(level+trend)
+			trend = beta*(level-prev) + (1-beta)*trend
+		}
+		return
+	}
+	best := smoothing{method: ForecastHolt, sse: math.Inf(1), errors: len(y) - 2}
+	for _, a := range smoothingGrid {
+		for _, b := range smoothingGrid {
+			if _, _, sse := run(a, b); sse < best.sse {
+				best.alpha, best.beta, best.sse = a, b, sse
+			}
+		}
+	}
+	level, trend, _ := run(best.alpha, best.beta)
+	a, b := best.alpha, best.beta
+	best.project = func(h int) float64 { return level + float64(h)*trend }
+	best.varianceFactor = func(h int) float64 {
+		v := 1.0
+		for j := 1; j < h; j++ {
+			c := a * (1 + float64(j)*b)
+			v += c * c
+		}
+		return v
+	}
+	return best
+}
+
+// meanSmoothing fits the series mean to y, which must not be empty.
+func meanSmoothing(y []float64) smoothing {
+	var sum float64
+	for _, v := range y {
+		sum += v
+	}
+	mu := sum / float64(len(y))
+	s := smoothing{method: ForecastMean, errors: len(y)}
+	for _, v := range y {
+		s.sse += (v - mu) * (v - mu)
+	}
+	s.project = func(int) float64 { return mu }
+	s.varianceFactor = func(int) float64 { return 1 + 1/float64(len(y)) }
+	return s
+}
+
+// fitSmoothing picks the richest method the length of y supports.
+func fitSmoothing(y []float64) smoothing {
+	switch {
+	case len(y) >= 2*forecastSeason:
+		return holtWinters(y, forecastSeason)
+	case len(y) >= 4:
+		return holt(y)
+	default:
+		return meanSmoothing(y)
+	}
+}
+
+// decimalFromFloat rounds v to two places.
+func decimalFromFloat(v float64) Decimal {
+	d, _ := ParseDecimal(strconv.FormatFloat(v, 'f', 2, 64))
+	return d
+}
+
+// monthlySeries runs query, which selects (YYYY-MM, value) rows in month
+// order, and returns the months from the first with a value to end, counting
+// missing months as zero.
+func monthlySeries(end, query string, args ...interface{}) ([]string, []float64, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	var months []string
+	var values []float64
+	for rows.Next() {
+		var month string
+		var v Decimal
+		if err := rows.Scan(&month, &v); err != nil {
+			return nil, nil, err
+		}
+		months, values = zeroFillMonths(months, values, month)
+		months = append(months, month)
+		values = append(values, v.Float64())
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	months, values = zeroFillMonths(months, values, monthOffset(end, 1))
+	return months, values, nil
+}
+
+// zeroFillMonths appends zeros for the months after the last of months and
+// before next, both YYYY-MM. An empty series is left empty.
+func zeroFillMonths(months []string, values []float64, next string) ([]string, []float64) {
+	for len(months) > 0 && monthOffset(months[len(months)-1], 1) < next {
+		months = append(months, monthOffset(months[len(months)-1], 1))
+		values = append(values, 0)
+	}
+	return months, values
+}
+
+// forecastReport projects the series selected by ?target= (meter, with
+// ?meter_id=, or revenue, with ?currency= defaulting to THB) for ?periods=
+// months with ?level= percent intervals.
+func forecastReport(q url.Values) (Forecast, error) {
+	f := Forecast{Target: q.Get("target"), Level: 95}
+	rg, err := parseReportRange(q)
+	if err != nil {
+		return f, err
+	}
+	if rg.To == "" {
+		// Leave out the current month, whose bills are not all in yet.
+		now := time.Now()
+		rg.To = time.Date(now.Year(), now.Month(), 0, 0, 0, 0, 0, time.Local).Format("2006-01-02")
+	}
+	periods := defaultForecastPeriods
+	if v := q.Get("periods"); v != "" {
+		if periods, err = strconv.Atoi(v); err != nil || periods < 1 || periods > maxForecastPeriods {
+			return f, listParamErrorf("periods must be between 1 and %d", maxForecastPeriods)
+		}
+	}
+	z := forecastZ["95"]
+	if v := q.Get("level"); v != "" {
+		var ok bool
+		if z, ok = forecastZ[v]; !ok {
+			return f, listParamErrorf("level must be 80, 90 or 95")
+		}
+		f.Level, _ = strconv.Atoi(v)
+	}
+
+	var args []interface{}
+	var months []string
+	var values []float64
+	switch f.Target {
+	case ForecastMeter:
+		id, err := strconv.Atoi(q.Get("meter_id"))
+		if err != nil {
+			return f, listParamErrorf("meter_id must be an integer")
+		}
+		f.MeterID = &id
+		var exists bool
+		if err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM "meter" WHERE "meter_id" = $1)`, id).Scan(&exists); err != nil {
+			return f, err
+		}
+		if !exists {
+			return f, notFoundError("Meter")
+		}
+		args = append(args, id)
+		conds := append([]string{`"meter_id" = $1`}, rg.conditions(`"billing_date"`, &args)...)
+		months, values, err = monthlySeries(rg.To[:7], `SELECT TO_CHAR("billing_date", 'YYYY-MM'), SUM("total_unit") FROM "customer_consumption"`+
+			whereClause(conds)+` GROUP BY 1 ORDER BY 1`, args...)
+		if err != nil {
+			return f, err
+		}
+	case ForecastRevenue:
+		currency := q.Get("currency")
+		if currency == "" {
+			currency = "THB"
+		}
+		f.Currency = &currency
+		args = append(args, currency)
+		conds := append([]string{`"currency" = $1`, `"bill_status" IN ` + reportBilledStatuses}, rg.conditions(`"billing_date"`, &args)...)
+		months, values, err = monthlySeries(rg.To[:7], `SELECT TO_CHAR("billing_date", 'YYYY-MM'), COALESCE(SUM("amount_due"), 0) FROM "billing"`+
+			whereClause(conds)+` GROUP BY 1 ORDER BY 1`, args...)
+		if err != nil {
+			return f, err
+		}
+	default:
+		return f, listParamErrorf("target must be meter or revenue")
+	}
+	if len(values) == 0 {
+		return f, &APIError{Status: http.StatusUnprocessableEntity, Code: ErrCodeValidation, Message: "There is no billing history to forecast from"}
+	}
+
+	model := fitSmoothing(values)
+	f.Method, f.Alpha, f.Beta, f.Gamma = model.method, model.alpha, model.beta, model.gamma
+	sigma := model.sigma()
+	f.RMSE = decimalFromFloat(sigma)
+	for i, m := range months {
+		actual := decimalFromFloat(values[i])
+		f.Points = append(f.Points, ForecastPoint{Month: m, Actual: &actual})
+	}
+	last := months[len(months)-1]
+	for h := 1; h <= periods; h++ {
+		// Neither consumption nor revenue can be negative.
+		v := math.Max(model.project(h), 0)
+		width := z * sigma * math.Sqrt(model.varianceFactor(h))
+		point, lower, upper := decimalFromFloat(v), decimalFromFloat(math.Max(v-width, 0)), decimalFromFloat(v+width)
+		f.Points = append(f.Points, ForecastPoint{Month: monthOffset(last, h), Forecast: &point, Lower: &lower, Upper: &upper})
+	}
+	return f, nil
+}
+
+// forecastParams documents the parameters specific to forecasts.
+var forecastParams = []apiParam{
+	{Name: "target", Required: true, Enum: []string{ForecastMeter, ForecastRevenue}},
+	queryParam("meter_id", "integer", "Meter to forecast; required when target is meter"),
+	{Name: "currency", Description: "Currency of the revenue forecast (default THB)"},
+	queryParam("periods", "integer", fmt.Sprintf("Months to project, at most %d (default %d)", maxForecastPeriods, defaultForecastPeriods)),
+	{Name: "level", Enum: []string{"80", "90", "95"}, Description: "Confidence level of the intervals (default 95)"},
+}
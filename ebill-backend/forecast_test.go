@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+// seasonalShape is a zero-sum monthly pattern: high in the hot months.
+var seasonalShape = []float64{30, 20, 10, 0, -10, -20, -30, -20, -10, 0, 10, 20}
+
+func TestHoltWintersSeasonal(t *testing.T) {
+	var y []float64
+	for i := 0; i < 36; i++ {
+		y = append(y, 50+seasonalShape[i%12])
+	}
+	model := holtWinters(y, 12)
+	// The first season fixes the level at 50 and the indices at the shape, so
+	// every later month is forecast exactly whatever the parameters.
+	if model.errors != 24 {
+		t.Errorf("errors = %d, want 24: the first season must not count", model.errors)
+	}
+	if model.sse > 1e-9 || model.sigma() > 1e-6 {
+		t.Errorf("sse = %g, sigma = %g; want 0", model.sse, model.sigma())
+	}
+	for h := 1; h <= 24; h++ {
+		want := 50 + seasonalShape[(36+h-1)%12]
+		if got := model.project(h); math.Abs(got-want) > 1e-9 {
+			t.Errorf("project(%d) = %g, want %g", h, got, want)
+		}
+	}
+}
+
+func TestHoltWintersTrendAndSeason(t *testing.T) {
+	var y []float64
+	for i := 0; i < 36; i++ {
+		y = append(y, 100+2*float64(i)+seasonalShape[i%12])
+	}
+	model := holtWinters(y, 12)
+	if model.errors != 24 {
+		t.Errorf("errors = %d, want 24", model.errors)
+	}
+	for h := 1; h <= 12; h++ {
+		n := 35 + h
+		want := 100 + 2*float64(n) + seasonalShape[n%12]
+		if got := model.project(h); math.Abs(got-want) > 3 {
+			t.Errorf("project(%d) = %.2f, want %.2f ± 3", h, got, want)
+		}
+	}
+	// The trend must carry on: a year ahead is about 24 higher than the last
+	// observed year.
+	if d := model.project(12) - y[35]; math.Abs(d-24) > 3 {
+		t.Errorf("project(12) - y[35] = %.2f, want about 24", d)
+	}
+	if v1, v12 := model.varianceFactor(1), model.varianceFactor(12); v1 != 1 || v12 <= v1 {
+		t.Errorf("varianceFactor(1) = %g, varianceFactor(12) = %g; want 1 and more than 1", v1, v12)
+	}
+}
+
+func TestHoltLinear(t *testing.T) {
+	y := []float64{10, 13, 16, 19, 22, 25, 28, 31}
+	model := holt(y)
+	if model.errors != 6 {
+		t.Errorf("errors = %d, want 6: the value that sets the trend must not count", model.errors)
+	}
+	if model.sse > 1e-9 {
+		t.Errorf("sse = %g, want 0", model.sse)
+	}
+	for h := 1; h <= 6; h++ {
+		if got, want := model.project(h), 31+3*float64(h); math.Abs(got-want) > 1e-9 {
+			t.Errorf("project(%d) = %g, want %g", h, got, want)
+		}
+	}
+}
+
+func TestHoltNoisy(t *testing.T) {
+	y := []float64{10, 14, 15, 20, 21, 26, 27, 32}
+	model := holt(y)
+	// Recompute the one-step errors of the chosen parameters from the third
+	// value on; sigma must be their root mean square.
+	level, trend := y[0], y[1]-y[0]
+	var sse float64
+	for t, v := range y[1:] {
+		if t > 0 {
+			e := v - (level + trend)
+			sse += e * e
+		}
+		prev := level
+		level = model.alpha*v + (1-model.alpha)*(level+trend)
+		trend = model.beta*(level-prev) + (1-model.beta)*trend
+	}
+	if want := math.Sqrt(sse / 6); math.Abs(model.sigma()-want) > 1e-9 {
+		t.Errorf("sigma = %g, want %g", model.sigma(), want)
+	}
+}
+
+func TestFitSmoothing(t *testing.T) {
+	tests := []struct {
+		n    int
+		want string
+	}{
+		{1, ForecastMean},
+		{3, ForecastMean},
+		{4, ForecastHolt},
+		{23, ForecastHolt},
+		{24, ForecastHoltWinters},
+	}
+	for _, tt := range tests {
+		y := make([]float64, tt.n)
+		for i := range y {
+			y[i] = float64(100 + i)
+		}
+		if got := fitSmoothing(y).method; got != tt.want {
+			t.Errorf("fitSmoothing of %d values uses %s, want %s", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestZeroFillMonths(t *testing.T) {
+	tests := []struct {
+		months []string
+		next   string
+		want   []string
+	}{
+		{nil, "2024-05", nil},
+		{[]string{"2024-01"}, "2024-01", []string{"2024-01"}},
+		{[]string{"2024-01"}, "2024-02", []string{"2024-01"}},
+		{[]string{"2023-11"}, "2024-03", []string{"2023-11", "2023-12", "2024-01", "2024-02"}},
+	}
+	for _, tt := range tests {
+		values := make([]float64, len(tt.months))
+		for i := range values {
+			values[i] = 7
+		}
+		months, values := zeroFillMonths(tt.months, values, tt.next)
+		if fmt.Sprint(months) != fmt.Sprint(tt.want) {
+			t.Errorf("zeroFillMonths(%v, %s) months = %v, want %v", tt.months, tt.next, months, tt.want)
+			continue
+		}
+		for i, v := range values[len(tt.months):] {
+			if v != 0 {
+				t.Errorf("zeroFillMonths(%v, %s) padded value %d = %g, want 0", tt.months, tt.next, i, v)
+			}
+		}
+	}
+}
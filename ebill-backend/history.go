@@ -0,0 +1,158 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Customers, meters and bills keep every version of their rows in history
+// tables, written by the record_row_history trigger. A version is valid from
+// its valid_from until its valid_to, which the current version lacks; the
+// history tables have the same columns as the live tables, so a record as it
+// was at some moment is read with the same column list and scanner.
+
+// RecordVersion is one stored version of a record.
+type RecordVersion[M any] struct {
+	HistoryID int     `json:"history_id"`
+	ValidFrom string  `json:"valid_from"`
+	ValidTo   *string `json:"valid_to"`   // Null for the current version
+	ChangedBy *int    `json:"changed_by"` // User who made the change; null outside a signed-in request
+	Record    M       `json:"record"`
+}
+
+// historyService is implemented by the services of records with a history.
+type historyService[M any] interface {
+	GetAsOf(id int, asOf string) (M, error)
+	History(id int, q url.Values) ([]RecordVersion[M], ListMeta, error)
+}
+
+// asOfCondition selects the version valid at $2, a moment given to
+// parseAsOf.
+const asOfCondition = `"valid_from" <= $2::timestamptz AND ("valid_to" IS NULL OR "valid_to" > $2::timestamptz)`
+
+// parseAsOf checks an as_of query parameter: an RFC 3339 timestamp, a local
+// date and time, or a date, which stands for the end of that day.
+func parseAsOf(v string) (string, error) {
+	if _, err := time.Parse(time.RFC3339Nano, v); err == nil {
+		return v, nil
+	}
+	if _, err := time.Parse("2006-01-02T15:04:05", v); err == nil {
+		return v, nil
+	}
+	if _, err := time.Parse("2006-01-02", v); err == nil {
+		return v + "T23:59:59.999999", nil
+	}
+	return "", listParamErrorf("as_of must be a date (YYYY-MM-DD) or an RFC 3339 timestamp")
+}
+
+// historyList lists the versions of one record of table, the scope given by
+// idColumn, oldest first.
+func historyList(table, idColumn, columns string) listResource {
+	return listResource{
+		columns: `"history_id", "valid_from", "valid_to", "changed_by", ` + columns,
+		from:    `"` + table + `_history"`,
+		key:     `"history_id"`,
+		filters: map[string]listFilter{
+			idColumn:          {`"` + idColumn + `"`, filterInt},
+			"changed_by":      {`"changed_by"`, filterInt},
+			"valid_from_from": {`"valid_from"::date`, filterDateFrom},
+			"valid_from_to":   {`"valid_from"::date`, filterDateTo},
+		},
+		sorts: map[string]string{
+			"history_id": `"history_id"`,
+			"valid_from": `"valid_from"`,
+		},
+		defaultSort: "valid_from",
+		scope:       idColumn,
+	}
+}
+
+// prefixScanner scans the leading columns of a row into prefix, such as the
+// version columns ahead of a record's own, and the rest into dest.
+type prefixScanner struct {
+	row    rowScanner
+	prefix []interface{}
+}
+
+func (s prefixScanner) Scan(dest ...interface{}) error {
+	return s.row.Scan(append(s.prefix, dest...)...)
+}
+
+// listHistory lists the versions of record id from list, scanning each
+// record with scan. A record that never existed is reported as noun not found.
+func listHistory[M any](list *listResource, id int, q url.Values, noun string, scan func(rowScanner, *M) error) ([]RecordVersion[M], ListMeta, error) {
+	scoped := url.Values{}
+	for k, v := range q {
+		scoped[k] = v
+	}
+	scoped.Set(list.scope, strconv.Itoa(id))
+	versions := []RecordVersion[M]{}
+	meta, err := list.query(scoped, func(row rowScanner) error {
+		var v RecordVersion[M]
+		if err := scan(prefixScanner{row, []interface{}{&v.HistoryID, &v.ValidFrom, &v.ValidTo, &v.ChangedBy}}, &v.Record); err != nil {
+			return err
+		}
+		versions = append(versions, v)
+		return nil
+	})
+	if err != nil || meta.Total > 0 {
+		return versions, meta, err
+	}
+	var exists bool
+	err = db.QueryRow(`SELECT EXISTS (SELECT 1 FROM `+list.from+` WHERE "`+list.scope+`" = $1)`, id).Scan(&exists)
+	if err == nil && !exists {
+		err = notFoundError(noun)
+	}
+	return versions, meta, err
+}
+
+// getAsOf reads record id as it was at asOf from table's history.
+func getAsOf[M any](table, idColumn, columns string, id int, asOf, noun string, scan func(rowScanner, *M) error) (M, error) {
+	var m M
+	err := scan(db.QueryRow(`SELECT `+columns+` FROM "`+table+`_history" WHERE "`+idColumn+`" = $1 AND `+asOfCondition, id, asOf), &m)
+	if err == sql.ErrNoRows {
+		return m, &APIError{Status: http.StatusNotFound, Code: ErrCodeNotFound, Message: noun + " did not exist at that time"}
+	}
+	return m, err
+}
+
+// serveAsOf answers a v1 get with ?as_of=: the record as it was at that
+// moment, from its history. Past versions carry no ETag, as they cannot be
+// written.
+func serveAsOf[M any](w http.ResponseWriter, r *http.Request, hs historyService[M], id int, noun string) {
+	asOf, err := parseAsOf(r.URL.Query().Get("as_of"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid parameters: "+err.Error())
+		return
+	}
+	m, err := hs.GetAsOf(id, asOf)
+	if err != nil {
+		respondWithServerError(w, "Failed to retrieve "+noun, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, m)
+}
+
+// historyHandler serves the v1 /{id}/history route of a record with a history.
+func historyHandler[M any](hs historyService[M], noun string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("API: get %s history called", noun)
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid "+noun+" ID")
+			return
+		}
+		versions, meta, err := hs.History(id, r.URL.Query())
+		if err != nil {
+			respondWithListError(w, noun+" history", err)
+			return
+		}
+		respondWithJSON(w, http.StatusOK, ListResponse{Data: versions, Meta: meta})
+	}
+}
@@ -0,0 +1,1044 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Bulk imports load customers, meters or meter readings from a CSV or XLSX
+// file. Each import is an import_job that stores the file and is processed in
+// batches; a batch is committed together with the outcome of its rows, so a
+// job that stops part-way (a crash, a lost connection) resumes at the first
+// row without an outcome. Rows are matched to existing records by natural key
+// (customer email, meter number, and meter number plus billing date for
+// readings) and created or updated. A row that fails validation or a database
+// constraint is recorded with its field errors and does not stop the job. A
+// dry run records the same outcomes but rolls every row back.
+
+// Kinds of import.
+const (
+	ImportKindCustomers = "customers"
+	ImportKindMeters    = "meters"
+	ImportKindReadings  = "readings" // Draft bills, one per meter and billing date
+)
+
+// File formats accepted by the importer.
+const (
+	ImportFormatCSV  = "csv"
+	ImportFormatXLSX = "xlsx"
+)
+
+// Import job states. A job still running when the server stopped is marked
+// interrupted at the next start; failed and interrupted jobs can be resumed.
+const (
+	ImportPending     = "pending"
+	ImportRunning     = "running"
+	ImportCompleted   = "completed"
+	ImportFailed      = "failed"
+	ImportInterrupted = "interrupted"
+)
+
+// Row outcomes.
+const (
+	ImportRowCreated   = "created"
+	ImportRowUpdated   = "updated"
+	ImportRowUnchanged = "unchanged"
+	ImportRowFailed    = "failed"
+)
+
+const (
+	maxImportSize   = 20 << 20
+	importBatchSize = 200 // Rows per transaction
+)
+
+// ImportJob is one bulk import and its progress.
+type ImportJob struct {
+	JobID         int     `json:"job_id"`
+	Kind          string  `json:"kind"`
+	FileName      string  `json:"file_name"`
+	Format        string  `json:"format"`
+	DryRun        bool    `json:"dry_run"`
+	Status        string  `json:"status"`
+	TotalRows     int     `json:"total_rows"`
+	ProcessedRows int     `json:"processed_rows"`
+	CreatedRows   int     `json:"created_rows"`
+	UpdatedRows   int     `json:"updated_rows"`
+	UnchangedRows int     `json:"unchanged_rows"`
+	FailedRows    int     `json:"failed_rows"`
+	Error         *string `json:"error"` // Why the job stopped, when it failed
+	CreatedBy     *int    `json:"created_by"`
+	CreatedAt     string  `json:"created_at"`
+	StartedAt     *string `json:"started_at"`
+	FinishedAt    *string `json:"finished_at"`
+}
+
+const importJobColumns = `"job_id", "kind", "file_name", "format", "dry_run", "status", "total_rows", "processed_rows", "created_rows",
+	"updated_rows", "unchanged_rows", "failed_rows", "error", "created_by", "created_at", "started_at", "finished_at"`
+
+func scanImportJob(row rowScanner, j *ImportJob) error {
+	return row.Scan(&j.JobID, &j.Kind, &j.FileName, &j.Format, &j.DryRun, &j.Status, &j.TotalRows, &j.ProcessedRows, &j.CreatedRows,
+		&j.UpdatedRows, &j.UnchangedRows, &j.FailedRows, &j.Error, &j.CreatedBy, &j.CreatedAt, &j.StartedAt, &j.FinishedAt)
+}
+
+// ImportRowResult is the outcome of one row of an import.
+type ImportRowResult struct {
+	RowNumber int          `json:"row_number"`
+	Action    string       `json:"action"`
+	EntityID  *int         `json:"entity_id"` // Customer, meter or bill ID; null when failed or only a dry run
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+func scanImportRow(row rowScanner, res *ImportRowResult) error {
+	var errs []byte
+	if err := row.Scan(&res.RowNumber, &res.Action, &res.EntityID, &errs); err != nil {
+		return err
+	}
+	if errs == nil {
+		return nil
+	}
+	return json.Unmarshal(errs, &res.Errors)
+}
+
+var importJobList = listResource{
+	columns: importJobColumns,
+	from:    `"import_job"`,
+	key:     `"job_id"`,
+	filters: map[string]listFilter{
+		"kind":            {`"kind"`, filterText},
+		"status":          {`"status"`, filterText},
+		"dry_run":         {`"dry_run"`, filterBool},
+		"created_by":      {`"created_by"`, filterInt},
+		"created_at_from": {`"created_at"::date`, filterDateFrom},
+		"created_at_to":   {`"created_at"::date`, filterDateTo},
+	},
+	sorts: map[string]string{
+		"job_id":     `"job_id"`,
+		"created_at": `"created_at"`,
+	},
+	defaultSort: "-job_id",
+}
+
+// importRowList is always scoped to one job, which makes row_number unique.
+var importRowList = listResource{
+	columns: `"row_number", "action", "entity_id", "errors"`,
+	from:    `"import_job_row"`,
+	key:     `"row_number"`,
+	filters: map[string]listFilter{
+		"job_id": {`"job_id"`, filterInt},
+		"action": {`"action"`, filterText},
+	},
+	sorts: map[string]string{
+		"row_number": `"row_number"`,
+	},
+	defaultSort: "row_number",
+	scope:       "job_id",
+}
+
+// --- Reading files ---
+
+// detectImportFormat guesses the format from the file name, then the content.
+func detectImportFormat(fileName string, data []byte) string {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".xlsx":
+		return ImportFormatXLSX
+	case ".csv", ".txt":
+		return ImportFormatCSV
+	}
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		return ImportFormatXLSX
+	}
+	return ImportFormatCSV
+}
+
+// readCSVRows returns the non-empty records of a CSV file with their line numbers.
+func readCSVRows(data []byte) ([]sheetRow, error) {
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	var rows []sheetRow
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue
+		}
+		line, _ := r.FieldPos(0)
+		rows = append(rows, sheetRow{Number: line, Cells: record})
+	}
+}
+
+// importValues holds the cells of one row by import column, trimmed.
+type importValues map[string]string
+
+// importSheet is an uploaded file whose header has been matched to the
+// columns of an import kind.
+type importSheet struct {
+	columns map[string]int // Import column → cell index
+	rows    []sheetRow     // Data rows, after the header
+}
+
+func (s *importSheet) values(row sheetRow) importValues {
+	v := importValues{}
+	for col, i := range s.columns {
+		if i < len(row.Cells) {
+			v[col] = strings.TrimSpace(row.Cells[i])
+		}
+	}
+	return v
+}
+
+// readImportSheet reads the file and finds the columns of kind k in its
+// first row. Columns are found by name (see importKind.columns); unknown
+// columns are ignored.
+func readImportSheet(k importKind, format string, data []byte) (*importSheet, error) {
+	var rows []sheetRow
+	var err error
+	if format == ImportFormatXLSX {
+		rows, err = readXLSX(data)
+	} else {
+		rows, err = readCSVRows(data)
+	}
+	if err != nil {
+		return nil, &APIError{Status: http.StatusUnprocessableEntity, Code: ErrCodeValidation, Message: "File could not be read: " + err.Error()}
+	}
+	if len(rows) == 0 {
+		return nil, &APIError{Status: http.StatusUnprocessableEntity, Code: ErrCodeValidation, Message: "File is empty"}
+	}
+	s := &importSheet{columns: map[string]int{}, rows: rows[1:]}
+	for i, name := range rows[0].Cells {
+		key := strings.ToLower(nonAlphanumeric.ReplaceAllString(strings.ToUpper(name), ""))
+		if col, ok := k.columns[key]; ok {
+			if _, dup := s.columns[col]; !dup {
+				s.columns[col] = i
+			}
+		}
+	}
+	var missing []FieldError
+	for _, req := range k.required {
+		alternatives := strings.Split(req, "|")
+		found := false
+		for _, col := range alternatives {
+			_, ok := s.columns[col]
+			found = found || ok
+		}
+		if !found {
+			missing = append(missing, FieldError{Field: alternatives[0], Code: "required",
+				Message: "the header row has no " + strings.Join(alternatives, " or ") + " column"})
+		}
+	}
+	if len(missing) > 0 {
+		return nil, &APIError{Status: http.StatusUnprocessableEntity, Code: ErrCodeValidation,
+			Message: "File is missing required columns", Details: missing}
+	}
+	return s, nil
+}
+
+// --- Row values ---
+
+// rowErrors collects every problem with one row, by import column.
+type rowErrors []FieldError
+
+func (e *rowErrors) add(column, code, message string) {
+	*e = append(*e, FieldError{Field: column, Code: code, Message: message})
+}
+
+func (e rowErrors) has(column string) bool {
+	return slices.ContainsFunc(e, func(fe FieldError) bool { return fe.Field == column })
+}
+
+// parseImportDate parses a date cell: any layout parseStatementDate accepts,
+// or a spreadsheet serial number (days since 1899-12-30), which is how XLSX
+// stores dates.
+func parseImportDate(s string) (time.Time, error) {
+	if n, err := strconv.ParseFloat(s, 64); err == nil && n >= 1 && n < 100000 {
+		return time.Date(1899, time.December, 30, 0, 0, 0, 0, time.UTC).AddDate(0, 0, int(n)), nil
+	}
+	return parseStatementDate(s)
+}
+
+// date returns a date column as YYYY-MM-DD, or "" when it is empty or invalid.
+func (e *rowErrors) date(v importValues, column string) string {
+	if v[column] == "" {
+		return ""
+	}
+	t, err := parseImportDate(v[column])
+	if err != nil {
+		e.add(column, "invalid", column+" must be a date such as 2024-01-31")
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
+
+// decimal returns a number column; ok is false when it is empty or invalid.
+func (e *rowErrors) decimal(v importValues, column string) (d Decimal, ok bool) {
+	if v[column] == "" {
+		return d, false
+	}
+	d, err := ParseDecimal(strings.NewReplacer(",", "", " ", "").Replace(v[column]))
+	if err != nil {
+		e.add(column, "invalid", column+" must be a number")
+		return d, false
+	}
+	return d, true
+}
+
+// boolean returns a yes/no column; ok is false when it is empty or invalid.
+func (e *rowErrors) boolean(v importValues, column string) (b, ok bool) {
+	switch strings.ToLower(v[column]) {
+	case "":
+		return false, false
+	case "true", "yes", "y", "1", "active":
+		return true, true
+	case "false", "no", "n", "0", "inactive":
+		return false, true
+	}
+	e.add(column, "invalid", column+" must be true or false")
+	return false, false
+}
+
+// validation adds the details of a model validation error, in v2 form, for
+// columns without an error yet. Fields in resolved were looked up from other
+// columns, whose errors were already reported.
+func (e *rowErrors) validation(apiErr *APIError, resolved ...string) {
+	if apiErr == nil {
+		return
+	}
+	for _, d := range errorBodyV2(apiErr, "").Details {
+		if !e.has(d.Field) && !slices.Contains(resolved, d.Field) {
+			*e = append(*e, d)
+		}
+	}
+}
+
+func (e rowErrors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return &APIError{Status: http.StatusUnprocessableEntity, Code: ErrCodeValidation, Message: "Row is invalid", Details: e}
+}
+
+// dateOnly reduces a DATE column read back as RFC 3339 to YYYY-MM-DD.
+func dateOnly(s string) string {
+	if t, ok := parseDate(s); ok {
+		return t.Format("2006-01-02")
+	}
+	return s
+}
+
+// normalizePhone writes a 10-digit phone number in the ###-###-#### form the
+// Customer table requires; anything else is left for validation to reject.
+func normalizePhone(s string) string {
+	if digits := nonDigits.ReplaceAllString(s, ""); len(digits) == 10 {
+		return digits[:3] + "-" + digits[3:6] + "-" + digits[6:]
+	}
+	return s
+}
+
+// --- Kinds ---
+
+// importKind describes what one kind of import reads and how it writes a row.
+type importKind struct {
+	columns    map[string]string // Normalized header name → import column
+	required   []string          // Columns the header must have; "a|b" means either
+	keyColumns []string          // The natural key, for reporting repeated rows
+	key        func(importValues) string
+	// apply creates or updates the record of one row inside tx and returns
+	// the action taken and the record's ID. *APIError and constraint
+	// violations reject the row; any other error stops the job.
+	apply func(tx *sql.Tx, v importValues) (action string, id int, err error)
+}
+
+var importKinds = map[string]importKind{
+	ImportKindCustomers: {
+		columns: map[string]string{
+			"name": "name", "customername": "name", "address": "address",
+			"email": "email", "emailaddress": "email",
+			"phone": "phone_number", "phonenumber": "phone_number", "telephone": "phone_number", "mobile": "phone_number",
+			"registrationdate": "registration_date", "registered": "registration_date",
+		},
+		required:   []string{"name", "address", "email", "phone_number"},
+		keyColumns: []string{"email"},
+		key:        func(v importValues) string { return strings.ToLower(v["email"]) },
+		apply:      importCustomer,
+	},
+	ImportKindMeters: {
+		columns: map[string]string{
+			"meternumber": "meter_number", "meterno": "meter_number", "meter": "meter_number",
+			"customeremail": "customer_email", "email": "customer_email", "customerid": "customer_id",
+			"installationdate": "installation_date", "installed": "installation_date",
+			"active": "active_status", "activestatus": "active_status",
+		},
+		required:   []string{"meter_number", "customer_email|customer_id", "installation_date"},
+		keyColumns: []string{"meter_number"},
+		key:        func(v importValues) string { return v["meter_number"] },
+		apply:      importMeter,
+	},
+	ImportKindReadings: {
+		columns: map[string]string{
+			"meternumber": "meter_number", "meterno": "meter_number", "meter": "meter_number",
+			"billingdate": "billing_date", "readingdate": "billing_date", "date": "billing_date",
+			"duedate": "due_date", "currentreading": "current_reading", "reading": "current_reading",
+			"previousreading": "previous_reading", "rate": "rate_applied", "rateapplied": "rate_applied", "currency": "currency",
+		},
+		required:   []string{"meter_number", "billing_date", "due_date", "current_reading", "rate_applied"},
+		keyColumns: []string{"meter_number", "billing_date"},
+		key: func(v importValues) string {
+			if v["meter_number"] == "" || v["billing_date"] == "" {
+				return ""
+			}
+			date := v["billing_date"]
+			if t, err := parseImportDate(date); err == nil {
+				date = t.Format("2006-01-02")
+			}
+			return v["meter_number"] + "\x00" + date
+		},
+		apply: importReading,
+	},
+}
+
+// importCustomer creates or updates the customer with the row's email. An
+// empty registration_date keeps the stored one, or is today for a new customer.
+func importCustomer(tx *sql.Tx, v importValues) (string, int, error) {
+	var errs rowErrors
+	c := Customer{Name: v["name"], Address: v["address"], Email: v["email"], PhoneNumber: normalizePhone(v["phone_number"]),
+		RegistrationDate: errs.date(v, "registration_date")}
+	var old Customer
+	exists := false
+	if c.Email != "" {
+		err := tx.QueryRow(`SELECT "customer_id", "name", "address", "email", "phone_number", "registration_date" FROM "customer"
+		                    WHERE LOWER("email") = LOWER($1) FOR UPDATE`, c.Email).Scan(&old.CustomerID, &old.Name, &old.Address, &old.Email, &old.PhoneNumber, &old.RegistrationDate)
+		if err != nil && err != sql.ErrNoRows {
+			return "", 0, err
+		}
+		exists = err == nil
+	}
+	if v["registration_date"] == "" {
+		c.RegistrationDate = time.Now().Format("2006-01-02")
+		if exists {
+			c.RegistrationDate = dateOnly(old.RegistrationDate)
+		}
+	}
+	errs.validation(c.validate())
+	if err := errs.err(); err != nil {
+		return "", 0, err
+	}
+
+	if !exists {
+		err := tx.QueryRow(`INSERT INTO "customer" ("name", "address", "email", "phone_number", "registration_date")
+		                    VALUES ($1, $2, $3, $4, $5) RETURNING "customer_id"`,
+			c.Name, c.Address, c.Email, c.PhoneNumber, c.RegistrationDate).Scan(&c.CustomerID)
+		return ImportRowCreated, c.CustomerID, err
+	}
+	if c.Name == old.Name && c.Address == old.Address && c.Email == old.Email && c.PhoneNumber == old.PhoneNumber &&
+		c.RegistrationDate == dateOnly(old.RegistrationDate) {
+		return ImportRowUnchanged, old.CustomerID, nil
+	}
+	_, err := tx.Exec(`UPDATE "customer" SET "name" = $1, "address" = $2, "email" = $3, "phone_number" = $4, "registration_date" = $5
+	                   WHERE "customer_id" = $6`, c.Name, c.Address, c.Email, c.PhoneNumber, c.RegistrationDate, old.CustomerID)
+	return ImportRowUpdated, old.CustomerID, err
+}
+
+// importMeter creates or updates the meter with the row's number. The owner
+// is found by customer_email, or else customer_id. An empty active_status
+// keeps the stored one, or is true for a new meter.
+func importMeter(tx *sql.Tx, v importValues) (string, int, error) {
+	var errs rowErrors
+	m := Meter{MeterNumber: v["meter_number"], InstallationDate: errs.date(v, "installation_date"), ActiveStatus: true}
+	active, hasActive := errs.boolean(v, "active_status")
+	switch {
+	case v["customer_email"] != "":
+		err := tx.QueryRow(`SELECT "customer_id" FROM "customer" WHERE LOWER("email") = LOWER($1)`, v["customer_email"]).Scan(&m.CustomerID)
+		if err == sql.ErrNoRows {
+			errs.add("customer_email", ErrCodeInvalidReference, "customer_email does not belong to any customer")
+		} else if err != nil {
+			return "", 0, err
+		}
+	case v["customer_id"] != "":
+		id, err := strconv.Atoi(v["customer_id"])
+		if err != nil {
+			errs.add("customer_id", "invalid", "customer_id must be an integer")
+			break
+		}
+		var found bool
+		if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM "customer" WHERE "customer_id" = $1)`, id).Scan(&found); err != nil {
+			return "", 0, err
+		}
+		if !found {
+			errs.add("customer_id", ErrCodeInvalidReference, "customer_id does not refer to an existing customer")
+		}
+		m.CustomerID = id
+	default:
+		errs.add("customer_email", "required", "customer_email or customer_id is required")
+	}
+
+	var old Meter
+	exists := false
+	if m.MeterNumber != "" {
+		err := tx.QueryRow(`SELECT "meter_id", "customer_id", "meter_number", "installation_date", "active_status" FROM "meter"
+		                    WHERE "meter_number" = $1 FOR UPDATE`, m.MeterNumber).Scan(&old.MeterID, &old.CustomerID, &old.MeterNumber, &old.InstallationDate, &old.ActiveStatus)
+		if err != nil && err != sql.ErrNoRows {
+			return "", 0, err
+		}
+		exists = err == nil
+	}
+	if hasActive {
+		m.ActiveStatus = active
+	} else if exists {
+		m.ActiveStatus = old.ActiveStatus
+	}
+	errs.validation(m.validate(), "customer_id")
+	if err := errs.err(); err != nil {
+		return "", 0, err
+	}
+
+	if !exists {
+		err := tx.QueryRow(`INSERT INTO "meter" ("customer_id", "meter_number", "installation_date", "active_status") VALUES ($1, $2, $3, $4)
+		                    RETURNING "meter_id"`, m.CustomerID, m.MeterNumber, m.InstallationDate, m.ActiveStatus).Scan(&m.MeterID)
+		return ImportRowCreated, m.MeterID, err
+	}
+	if m.CustomerID == old.CustomerID && m.InstallationDate == dateOnly(old.InstallationDate) && m.ActiveStatus == old.ActiveStatus {
+		return ImportRowUnchanged, old.MeterID, nil
+	}
+	_, err := tx.Exec(`UPDATE "meter" SET "customer_id" = $1, "installation_date" = $2, "active_status" = $3 WHERE "meter_id" = $4`,
+		m.CustomerID, m.InstallationDate, m.ActiveStatus, old.MeterID)
+	return ImportRowUpdated, old.MeterID, err
+}
+
+// importReading creates or updates the draft bill of the row's meter and
+// billing date, billed to the meter's customer. previous_reading defaults to
+// the stored draft's, or else the current reading of the meter's last earlier
+// bill (0 for a meter's first bill); an empty currency keeps the stored one.
+// A reading whose bill has been issued is rejected: it can only be corrected
+// by voiding and reissuing the bill.
+func importReading(tx *sql.Tx, v importValues) (string, int, error) {
+	var errs rowErrors
+	b := Billing{BillingDate: errs.date(v, "billing_date"), DueDate: errs.date(v, "due_date"), Currency: strings.ToUpper(v["currency"])}
+	b.ReadingCurrent, _ = errs.decimal(v, "current_reading")
+	b.RateApplied, _ = errs.decimal(v, "rate_applied")
+	previous, hasPrevious := errs.decimal(v, "previous_reading")
+
+	if v["meter_number"] != "" {
+		err := tx.QueryRow(`SELECT "meter_id", "customer_id" FROM "meter" WHERE "meter_number" = $1`, v["meter_number"]).Scan(&b.MeterID, &b.CustomerID)
+		if err == sql.ErrNoRows {
+			errs.add("meter_number", ErrCodeInvalidReference, "meter_number does not belong to any meter")
+		} else if err != nil {
+			return "", 0, err
+		}
+	} else {
+		errs.add("meter_number", "required", "meter_number is required")
+	}
+
+	var old Billing
+	exists := false
+	if b.MeterID != 0 && b.BillingDate != "" {
+		err := tx.QueryRow(`SELECT "bill_id", "customer_id", "due_date", "previous_reading", "current_reading", "rate_applied", "currency", "bill_status"
+		                    FROM "billing" WHERE "meter_id" = $1 AND "billing_date" = $2 AND "bill_status" <> $3
+		                    ORDER BY "bill_id" DESC LIMIT 1 FOR UPDATE`, b.MeterID, b.BillingDate, BillStatusVoid).Scan(
+			&old.BillID, &old.CustomerID, &old.DueDate, &old.ReadingPrevious, &old.ReadingCurrent, &old.RateApplied, &old.Currency, &old.Status)
+		if err != nil && err != sql.ErrNoRows {
+			return "", 0, err
+		}
+		exists = err == nil
+		if exists && old.Status != BillStatusDraft {
+			return "", 0, &APIError{Status: http.StatusConflict, Code: ErrCodeConflict,
+				Message: fmt.Sprintf("Bill %d for this meter and billing date is %s and can no longer be changed", old.BillID, old.Status)}
+		}
+		switch {
+		case hasPrevious:
+			b.ReadingPrevious = previous
+		case exists:
+			b.ReadingPrevious = old.ReadingPrevious
+		default:
+			err := tx.QueryRow(`SELECT "current_reading" FROM "billing" WHERE "meter_id" = $1 AND "billing_date" < $2 AND "bill_status" <> $3
+			                    ORDER BY "billing_date" DESC, "bill_id" DESC LIMIT 1`, b.MeterID, b.BillingDate, BillStatusVoid).Scan(&b.ReadingPrevious)
+			if err != nil && err != sql.ErrNoRows {
+				return "", 0, err
+			}
+		}
+		if b.Currency == "" && exists {
+			b.Currency = old.Currency
+		}
+	}
+	b.normalize()
+	errs.validation(b.validate(), "customer_id", "meter_id")
+	if err := errs.err(); err != nil {
+		return "", 0, err
+	}
+
+	if !exists {
+		b.Status = BillStatusDraft
+		err := tx.QueryRow(`INSERT INTO "billing" ("customer_id", "meter_id", "billing_date", "due_date", "previous_reading", "current_reading",
+		                    "rate_applied", "currency", "bill_status") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING "bill_id"`,
+			b.CustomerID, b.MeterID, b.BillingDate, b.DueDate, b.ReadingPrevious, b.ReadingCurrent, b.RateApplied, b.Currency, b.Status).Scan(&b.BillID)
+		if err != nil {
+			return "", 0, err
+		}
+		return ImportRowCreated, b.BillID, replaceBillLineItems(tx, &b, tariff)
+	}
+	b.BillID = old.BillID
+	if b.CustomerID == old.CustomerID && b.DueDate == dateOnly(old.DueDate) && b.ReadingPrevious.Cmp(old.ReadingPrevious) == 0 &&
+		b.ReadingCurrent.Cmp(old.ReadingCurrent) == 0 && b.RateApplied.Cmp(old.RateApplied) == 0 && b.Currency == old.Currency {
+		return ImportRowUnchanged, b.BillID, nil
+	}
+	_, err := tx.Exec(`UPDATE "billing" SET "customer_id" = $1, "due_date" = $2, "previous_reading" = $3, "current_reading" = $4,
+	                   "rate_applied" = $5, "currency" = $6 WHERE "bill_id" = $7`,
+		b.CustomerID, b.DueDate, b.ReadingPrevious, b.ReadingCurrent, b.RateApplied, b.Currency, b.BillID)
+	if err != nil {
+		return "", 0, err
+	}
+	return ImportRowUpdated, b.BillID, replaceBillLineItems(tx, &b, tariff)
+}
+
+// --- Jobs ---
+
+func loadImportJob(id int) (ImportJob, error) {
+	var j ImportJob
+	err := scanImportJob(db.QueryRow(`SELECT `+importJobColumns+` FROM "import_job" WHERE "job_id" = $1`, id), &j)
+	if err == sql.ErrNoRows {
+		return j, notFoundError("Import job")
+	}
+	return j, err
+}
+
+// createImportJob checks that the file can be read and has the columns its
+// kind needs, and stores it as a pending job. format may be "" or "auto" to
+// detect it.
+func createImportJob(kind, fileName, format string, data []byte, dryRun bool, actor *int) (ImportJob, error) {
+	job := ImportJob{Kind: kind, FileName: fileName, Format: format, DryRun: dryRun, Status: ImportPending, CreatedBy: actor}
+	k, ok := importKinds[kind]
+	if !ok {
+		return job, &APIError{Status: http.StatusBadRequest, Code: ErrCodeBadRequest, Message: "kind must be customers, meters or readings"}
+	}
+	switch format {
+	case "", "auto":
+		job.Format = detectImportFormat(fileName, data)
+	case ImportFormatCSV, ImportFormatXLSX:
+	default:
+		return job, &APIError{Status: http.StatusBadRequest, Code: ErrCodeBadRequest, Message: "format must be csv, xlsx or auto"}
+	}
+	if job.FileName == "" {
+		job.FileName = kind + "." + job.Format
+	}
+	sheet, err := readImportSheet(k, job.Format, data)
+	if err != nil {
+		return job, err
+	}
+	job.TotalRows = len(sheet.rows)
+	err = db.QueryRow(`INSERT INTO "import_job" ("kind", "file_name", "format", "file_data", "dry_run", "total_rows", "created_by")
+	                   VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING "job_id", "created_at"`,
+		job.Kind, job.FileName, job.Format, data, job.DryRun, job.TotalRows, job.CreatedBy).Scan(&job.JobID, &job.CreatedAt)
+	return job, err
+}
+
+// importRun is a job claimed for processing by this process.
+type importRun struct {
+	job   ImportJob
+	kind  importKind
+	sheet *importSheet
+}
+
+// claimImportJob marks a pending, failed or interrupted job as running and
+// loads its file. The status check makes the claim atomic, so a job is never
+// processed by two runs at once.
+func claimImportJob(id int) (*importRun, error) {
+	var data []byte
+	err := db.QueryRow(`UPDATE "import_job" SET "status" = $2, "error" = NULL, "finished_at" = NULL,
+	                    "started_at" = COALESCE("started_at", CURRENT_TIMESTAMP)
+	                    WHERE "job_id" = $1 AND "status" IN ($3, $4, $5) RETURNING "file_data"`,
+		id, ImportRunning, ImportPending, ImportFailed, ImportInterrupted).Scan(&data)
+	if err == sql.ErrNoRows {
+		job, err := loadImportJob(id)
+		if err != nil {
+			return nil, err
+		}
+		return nil, &APIError{Status: http.StatusConflict, Code: ErrCodeConflict, Message: "Import job is " + job.Status + " and cannot be resumed"}
+	} else if err != nil {
+		return nil, err
+	}
+	run := &importRun{}
+	if run.job, err = loadImportJob(id); err != nil {
+		return nil, err
+	}
+	run.kind = importKinds[run.job.Kind]
+	if run.sheet, err = readImportSheet(run.kind, run.job.Format, data); err != nil {
+		return nil, run.finish(err)
+	}
+	return run, nil
+}
+
+// run processes the rows after the last one with an outcome and marks the
+// job completed, or failed when an error that is not a row's fault stops it.
+func (run *importRun) run() error {
+	return run.finish(run.process())
+}
+
+// finish records how the run ended and returns err.
+func (run *importRun) finish(err error) error {
+	run.job.Status = ImportCompleted
+	run.job.Error = nil
+	if err != nil {
+		msg := err.Error()
+		run.job.Status, run.job.Error = ImportFailed, &msg
+	}
+	_, uerr := db.Exec(`UPDATE "import_job" SET "status" = $2, "error" = $3, "finished_at" = CURRENT_TIMESTAMP WHERE "job_id" = $1`,
+		run.job.JobID, run.job.Status, run.job.Error)
+	if uerr != nil {
+		log.Printf("Import job %d: could not record status %s: %v", run.job.JobID, run.job.Status, uerr)
+	}
+	return err
+}
+
+func (run *importRun) process() error {
+	rows := run.sheet.rows
+	// A row repeating the key of an earlier row fails, also when the earlier
+	// row was processed before the job was resumed.
+	duplicateOf := make([]int, len(rows))
+	first := map[string]int{}
+	for i, row := range rows {
+		key := run.kind.key(run.sheet.values(row))
+		if key == "" {
+			continue
+		}
+		if n, ok := first[key]; ok {
+			duplicateOf[i] = n
+		} else {
+			first[key] = row.Number
+		}
+	}
+	for start := run.job.ProcessedRows; start < len(rows); start += importBatchSize {
+		end := min(start+importBatchSize, len(rows))
+		if err := run.processBatch(rows[start:end], duplicateOf[start:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// processBatch applies rows and records their outcomes and the job's new
+// totals in one transaction.
+func (run *importRun) processBatch(rows []sheetRow, duplicateOf []int) error {
+	tx, err := auditContext{ActorID: run.job.CreatedBy}.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	counts := map[string]int{}
+	for i, row := range rows {
+		var res ImportRowResult
+		if n := duplicateOf[i]; n != 0 {
+			res = ImportRowResult{Action: ImportRowFailed, Errors: []FieldError{{Field: run.kind.keyColumns[0], Code: ErrCodeDuplicate,
+				Message: fmt.Sprintf("%s repeats row %d", strings.Join(run.kind.keyColumns, " and "), n)}}}
+		} else if res, err = run.applyRow(tx, run.sheet.values(row)); err != nil {
+			return fmt.Errorf("row %d: %w", row.Number, err)
+		}
+		res.RowNumber = row.Number
+		var errs []byte
+		if res.Errors != nil {
+			errs, _ = json.Marshal(res.Errors)
+		}
+		_, err = tx.Exec(`INSERT INTO "import_job_row" ("job_id", "row_number", "action", "entity_id", "errors") VALUES ($1, $2, $3, $4, $5)`,
+			run.job.JobID, res.RowNumber, res.Action, res.EntityID, errs)
+		if err != nil {
+			return fmt.Errorf("row %d: %w", row.Number, err)
+		}
+		counts[res.Action]++
+	}
+	_, err = tx.Exec(`UPDATE "import_job" SET "processed_rows" = "processed_rows" + $2, "created_rows" = "created_rows" + $3,
+	                  "updated_rows" = "updated_rows" + $4, "unchanged_rows" = "unchanged_rows" + $5, "failed_rows" = "failed_rows" + $6
+	                  WHERE "job_id" = $1`, run.job.JobID, len(rows), counts[ImportRowCreated], counts[ImportRowUpdated],
+		counts[ImportRowUnchanged], counts[ImportRowFailed])
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	run.job.ProcessedRows += len(rows)
+	run.job.CreatedRows += counts[ImportRowCreated]
+	run.job.UpdatedRows += counts[ImportRowUpdated]
+	run.job.UnchangedRows += counts[ImportRowUnchanged]
+	run.job.FailedRows += counts[ImportRowFailed]
+	return nil
+}
+
+// applyRow applies one row inside a savepoint, so that a row the database
+// rejects does not abort the rest of the batch. A dry run always rolls the
+// row back, and reports no ID for records it would have created.
+func (run *importRun) applyRow(tx *sql.Tx, v importValues) (ImportRowResult, error) {
+	var res ImportRowResult
+	if _, err := tx.Exec(`SAVEPOINT import_row`); err != nil {
+		return res, err
+	}
+	action, id, err := run.kind.apply(tx, v)
+	if err != nil {
+		apiErr := translateDBError(err)
+		if apiErr == nil {
+			return res, err
+		}
+		if _, err := tx.Exec(`ROLLBACK TO SAVEPOINT import_row`); err != nil {
+			return res, err
+		}
+		body := errorBodyV2(apiErr, "")
+		res.Action, res.Errors = ImportRowFailed, body.Details
+		if len(res.Errors) == 0 {
+			res.Errors = []FieldError{{Code: body.Code, Message: body.Message}}
+		}
+		return res, nil
+	}
+	end := `RELEASE SAVEPOINT import_row`
+	if run.job.DryRun {
+		end = `ROLLBACK TO SAVEPOINT import_row`
+		if action == ImportRowCreated {
+			id = 0
+		}
+	}
+	if _, err := tx.Exec(end); err != nil {
+		return res, err
+	}
+	res.Action = action
+	if id != 0 {
+		res.EntityID = &id
+	}
+	return res, nil
+}
+
+// start processes the job in the background, logging how it ended.
+func (run *importRun) start() {
+	go func() {
+		if err := run.run(); err != nil {
+			log.Printf("Import job %d stopped after %d of %d rows: %v", run.job.JobID, run.job.ProcessedRows, run.job.TotalRows, err)
+			return
+		}
+		log.Printf("Import job %d completed: %d created, %d updated, %d unchanged, %d failed", run.job.JobID,
+			run.job.CreatedRows, run.job.UpdatedRows, run.job.UnchangedRows, run.job.FailedRows)
+	}()
+}
+
+// markInterruptedImports marks the jobs that were running when the server
+// last stopped, so that they can be resumed.
+func markInterruptedImports() error {
+	res, err := db.Exec(`UPDATE "import_job" SET "status" = $1 WHERE "status" = $2`, ImportInterrupted, ImportRunning)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("%d import job(s) were interrupted and can be resumed", n)
+	}
+	return nil
+}
+
+// failedImportRows returns the failed rows of a job in file order.
+func failedImportRows(jobID int) ([]ImportRowResult, error) {
+	rows, err := db.Query(`SELECT "row_number", "action", "entity_id", "errors" FROM "import_job_row"
+	                       WHERE "job_id" = $1 AND "action" = $2 ORDER BY "row_number"`, jobID, ImportRowFailed)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var results []ImportRowResult
+	for rows.Next() {
+		var res ImportRowResult
+		if err := scanImportRow(rows, &res); err != nil {
+			return nil, err
+		}
+		results = append(results, res)
+	}
+	return results, rows.Err()
+}
+
+// writeImportReport prints a job's totals and the errors of its failed rows
+// for the command line.
+func writeImportReport(w io.Writer, job ImportJob, failed []ImportRowResult) error {
+	mode := ""
+	if job.DryRun {
+		mode = " (dry run)"
+	}
+	fmt.Fprintf(w, "Import #%d%s: %s from %s (%s), %s\n", job.JobID, mode, job.Kind, job.FileName, job.Format, job.Status)
+	fmt.Fprintf(w, "%d of %d rows processed: %d created, %d updated, %d unchanged, %d failed\n", job.ProcessedRows, job.TotalRows,
+		job.CreatedRows, job.UpdatedRows, job.UnchangedRows, job.FailedRows)
+	if job.Error != nil {
+		fmt.Fprintf(w, "Stopped: %s (resume with -resume %d)\n", *job.Error, job.JobID)
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "\nROW\tCOLUMN\tERROR")
+	for _, res := range failed {
+		for _, e := range res.Errors {
+			fmt.Fprintf(tw, "%d\t%s\t%s\n", res.RowNumber, e.Field, e.Message)
+		}
+	}
+	return tw.Flush()
+}
+
+// --- Handlers ---
+
+// canImport reports whether the caller may start or resume imports.
+func canImport(r *http.Request) bool {
+	p := currentPermission(r)
+	return p == "administrator" || p == "operator"
+}
+
+// createImportV2 starts importing the file sent as multipart form field
+// "file" or as the raw request body. ?kind= is required; ?format= (csv, xlsx
+// or auto), ?filename= and ?dry_run= are optional. The job runs in the
+// background: the response is 202 with the job, whose progress can be polled.
+func createImportV2(w http.ResponseWriter, r *http.Request) {
+	log.Println("API v2: createImport called")
+	if !canImport(r) {
+		respondWithError(w, http.StatusForbidden, "Imports are available to operators and administrators")
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	q := r.URL.Query()
+	fileName := q.Get("filename")
+	var data []byte
+	var err error
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, header, ferr := r.FormFile("file")
+		if ferr != nil {
+			respondWithError(w, http.StatusBadRequest, "Missing import file: "+ferr.Error())
+			return
+		}
+		defer file.Close()
+		fileName = header.Filename
+		data, err = io.ReadAll(file)
+	} else {
+		data, err = io.ReadAll(r.Body)
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Failed to read import file: "+err.Error())
+		return
+	}
+	if len(data) == 0 {
+		respondWithError(w, http.StatusBadRequest, "Import file is empty")
+		return
+	}
+	dryRun := false
+	if v := q.Get("dry_run"); v != "" {
+		if dryRun, err = strconv.ParseBool(v); err != nil {
+			respondWithError(w, http.StatusBadRequest, "dry_run must be true or false")
+			return
+		}
+	}
+
+	job, err := createImportJob(q.Get("kind"), filepath.Base(fileName), q.Get("format"), data, dryRun, currentUserID(r))
+	if err != nil {
+		respondWithServerError(w, "Failed to create import job", err)
+		return
+	}
+	run, err := claimImportJob(job.JobID)
+	if err != nil {
+		respondWithServerError(w, "Failed to start import job", err)
+		return
+	}
+	job = run.job
+	run.start()
+	log.Printf("Started import job %d: %s from %q, %d rows", job.JobID, job.Kind, job.FileName, job.TotalRows)
+	w.Header().Set("Location", fmt.Sprintf("/api/v2/imports/%d", job.JobID))
+	respondV2(w, http.StatusAccepted, job)
+}
+
+func getImportsV2(w http.ResponseWriter, r *http.Request) {
+	jobs := []ImportJob{}
+	meta, err := importJobList.query(r.URL.Query(), func(row rowScanner) error {
+		var j ImportJob
+		if err := scanImportJob(row, &j); err != nil {
+			return err
+		}
+		jobs = append(jobs, j)
+		return nil
+	})
+	if err != nil {
+		respondWithListError(w, "import jobs", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, ListResponse{Data: jobs, Meta: meta})
+}
+
+func getImportV2(w http.ResponseWriter, r *http.Request) {
+	id, ok := v2ID(w, r)
+	if !ok {
+		return
+	}
+	job, err := loadImportJob(id)
+	if err != nil {
+		respondWithServerError(w, "Failed to retrieve import job", err)
+		return
+	}
+	respondV2(w, http.StatusOK, job)
+}
+
+// getImportRowsV2 lists the outcome of each processed row of a job;
+// ?action=failed gives the rows to fix.
+func getImportRowsV2(w http.ResponseWriter, r *http.Request) {
+	id, ok := v2ID(w, r)
+	if !ok {
+		return
+	}
+	if _, err := loadImportJob(id); err != nil {
+		respondWithServerError(w, "Failed to retrieve import job", err)
+		return
+	}
+	q := url.Values{}
+	for k, v := range r.URL.Query() {
+		q[k] = v
+	}
+	q.Set("job_id", strconv.Itoa(id))
+	results := []ImportRowResult{}
+	meta, err := importRowList.query(q, func(row rowScanner) error {
+		var res ImportRowResult
+		if err := scanImportRow(row, &res); err != nil {
+			return err
+		}
+		results = append(results, res)
+		return nil
+	})
+	if err != nil {
+		respondWithListError(w, "import rows", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, ListResponse{Data: results, Meta: meta})
+}
+
+// resumeImportV2 continues a failed or interrupted job after its last
+// processed row.
+func resumeImportV2(w http.ResponseWriter, r *http.Request) {
+	id, ok := v2ID(w, r)
+	if !ok {
+		return
+	}
+	if !canImport(r) {
+		respondWithError(w, http.StatusForbidden, "Imports are available to operators and administrators")
+		return
+	}
+	run, err := claimImportJob(id)
+	if err != nil {
+		respondWithServerError(w, "Failed to resume import job", err)
+		return
+	}
+	job := run.job
+	run.start()
+	log.Printf("Resumed import job %d at row %d of %d", job.JobID, job.ProcessedRows+1, job.TotalRows)
+	respondV2(w, http.StatusAccepted, job)
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// Line item types, matching the CHECK constraint on bill_line_item.Item_Type.
+const (
+	LineItemEnergy  = "energy"
+	LineItemService = "service"
+	LineItemFt      = "ft"
+	LineItemVAT     = "vat"
+)
+
+// BillLineItem is one component of a bill's Amount_Due.
+// For the VAT line, Quantity is the taxable base and Unit_Price the VAT rate.
+type BillLineItem struct {
+	LineItemID  int     `json:"Line_Item_ID"`
+	BillID      int     `json:"Bill_ID"`
+	ItemType    string  `json:"Item_Type"`
+	Description string  `json:"Description"`
+	Quantity    Decimal `json:"Quantity"`
+	UnitPrice   Decimal `json:"Unit_Price"`
+	Amount      Money   `json:"Amount"`
+}
+
+// TariffConfig holds the charges applied on top of the energy charge.
+type TariffConfig struct {
+	ServiceCharge Money   // flat charge per bill
+	FtRate        Decimal // fuel adjustment (Ft) charge per unit
+	VATRate       Decimal // e.g. 0.07 for 7%
+}
+
+// tariff defaults follow the current residential schedule and can be
+// overridden with TARIFF_SERVICE_CHARGE, TARIFF_FT_RATE and VAT_RATE.
+var tariff = TariffConfig{
+	ServiceCharge: MoneyFromSatang(3822),
+	FtRate:        MustParseDecimal("0.3972"),
+	VATRate:       MustParseDecimal("0.07"),
+}
+
+// loadTariffConfig applies any tariff overrides from the environment.
+func loadTariffConfig() error {
+	if v := os.Getenv("TARIFF_SERVICE_CHARGE"); v != "" {
+		m, err := ParseMoney(v)
+		if err != nil {
+			return fmt.Errorf("TARIFF_SERVICE_CHARGE: %w", err)
+		}
+		tariff.ServiceCharge = m
+	}
+	if v := os.Getenv("TARIFF_FT_RATE"); v != "" {
+		d, err := ParseDecimal(v)
+		if err != nil {
+			return fmt.Errorf("TARIFF_FT_RATE: %w", err)
+		}
+		tariff.FtRate = d
+	}
+	if v := os.Getenv("VAT_RATE"); v != "" {
+		d, err := ParseDecimal(v)
+		if err != nil {
+			return fmt.Errorf("VAT_RATE: %w", err)
+		}
+		tariff.VATRate = d
+	}
+	log.Printf("Tariff: service charge=%s, Ft rate=%s, VAT rate=%s", tariff.ServiceCharge, tariff.FtRate, tariff.VATRate)
+	return nil
+}
+
+// computeLineItems derives the line items of a bill from its readings and rate.
+// VAT is charged on the sum of the energy, service and Ft lines.
+func computeLineItems(b *Billing, t TariffConfig) []BillLineItem {
+	units := b.ReadingCurrent.Sub(b.ReadingPrevious)
+	energy := MoneyFromDecimal(units.Mul(b.RateApplied))
+	ft := MoneyFromDecimal(units.Mul(t.FtRate))
+	taxable := energy.Add(t.ServiceCharge).Add(ft)
+	return []BillLineItem{
+		{ItemType: LineItemEnergy, Description: "Energy charge", Quantity: units, UnitPrice: b.RateApplied, Amount: energy},
+		{ItemType: LineItemService, Description: "Service charge", Quantity: NewDecimal(1, 0), UnitPrice: t.ServiceCharge.Decimal(), Amount: t.ServiceCharge},
+		{ItemType: LineItemFt, Description: "Fuel adjustment (Ft) charge", Quantity: units, UnitPrice: t.FtRate, Amount: ft},
+		{ItemType: LineItemVAT, Description: "VAT", Quantity: taxable.Decimal(), UnitPrice: t.VATRate, Amount: taxable.MulRate(t.VATRate)},
+	}
+}
+
+// tariffFromLineItems recovers the tariff a bill was charged from its line
+// items. Charges the items do not show are taken from fallback.
+func tariffFromLineItems(items []BillLineItem, fallback TariffConfig) TariffConfig {
+	t := fallback
+	for _, li := range items {
+		switch li.ItemType {
+		case LineItemService:
+			t.ServiceCharge = MoneyFromDecimal(li.UnitPrice)
+		case LineItemFt:
+			t.FtRate = li.UnitPrice
+		case LineItemVAT:
+			t.VATRate = li.UnitPrice
+		}
+	}
+	return t
+}
+
+// billTariff returns the tariff that bill billID was charged, for bills made
+// before the current tariff took effect.
+func billTariff(tx *sql.Tx, billID int) (TariffConfig, error) {
+	rows, err := tx.Query(`SELECT "item_type", "unit_price" FROM "bill_line_item" WHERE "bill_id" = $1`, billID)
+	if err != nil {
+		return tariff, err
+	}
+	defer rows.Close()
+	var items []BillLineItem
+	for rows.Next() {
+		var li BillLineItem
+		if err := rows.Scan(&li.ItemType, &li.UnitPrice); err != nil {
+			return tariff, err
+		}
+		items = append(items, li)
+	}
+	return tariffFromLineItems(items, tariff), rows.Err()
+}
+
+// replaceBillLineItems recomputes and stores the line items of b inside tx,
+// charging tariff t. The bill_line_item trigger keeps Billing.Amount_Due equal
+// to their sum; b.LineItems and b.AmountDue are updated to match.
+func replaceBillLineItems(tx *sql.Tx, b *Billing, t TariffConfig) error {
+	if _, err := tx.Exec(`DELETE FROM "bill_line_item" WHERE "bill_id" = $1`, b.BillID); err != nil {
+		return fmt.Errorf("clear line items: %w", err)
+	}
+	items := computeLineItems(b, t)
+	total := Money{}
+	for i := range items {
+		items[i].BillID = b.BillID
+		err := tx.QueryRow(`INSERT INTO "bill_line_item" ("bill_id", "item_type", "description", "quantity", "unit_price", "amount")
+		                    VALUES ($1, $2, $3, $4, $5, $6) RETURNING "line_item_id"`,
+			b.BillID, items[i].ItemType, items[i].Description, items[i].Quantity, items[i].UnitPrice, items[i].Amount).Scan(&items[i].LineItemID)
+		if err != nil {
+			return fmt.Errorf("insert %s line item: %w", items[i].ItemType, err)
+		}
+		total = total.Add(items[i].Amount)
+	}
+	b.LineItems = items
+	b.AmountDue = total
+	return nil
+}
+
+// getBillLineItems loads the stored line items of a bill in display order.
+func getBillLineItems(billID int) ([]BillLineItem, error) {
+	rows, err := db.Query(`SELECT "line_item_id", "bill_id", "item_type", "description", "quantity", "unit_price", "amount"
+	                        FROM "bill_line_item" WHERE "bill_id" = $1 ORDER BY "line_item_id" ASC`, billID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []BillLineItem{}
+	for rows.Next() {
+		var li BillLineItem
+		if err := rows.Scan(&li.LineItemID, &li.BillID, &li.ItemType, &li.Description, &li.Quantity, &li.UnitPrice, &li.Amount); err != nil {
+			return nil, err
+		}
+		items = append(items, li)
+	}
+	return items, rows.Err()
+}
+
+// MonthlyTaxSummary is one row of the monthly_tax_summary view.
+type MonthlyTaxSummary struct {
+	Month       string `json:"Month"` // YYYY-MM
+	Currency    string `json:"Currency"`
+	BillCount   int    `json:"Bill_Count"`
+	TaxableBase Money  `json:"Taxable_Base"`
+	VATAmount   Money  `json:"VAT_Amount"`
+	TotalBilled Money  `json:"Total_Billed"`
+}
+
+// getTaxSummary returns monthly VAT totals, optionally limited to ?year=YYYY.
+func getTaxSummary(w http.ResponseWriter, r *http.Request) {
+	log.Println("API: getTaxSummary called")
+	query := `SELECT "month", "currency", "bill_count", "taxable_base", "vat_amount", "total_billed" FROM "monthly_tax_summary"`
+	args := []interface{}{}
+	if yearStr := r.URL.Query().Get("year"); yearStr != "" {
+		year, err := strconv.Atoi(yearStr)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid year: "+err.Error())
+			return
+		}
+		query += ` WHERE "month" LIKE $1`
+		args = append(args, fmt.Sprintf("%04d-%%", year))
+	}
+	query += ` ORDER BY "month" ASC, "currency" ASC`
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		respondWithServerError(w, "Failed to retrieve tax summary", err)
+		return
+	}
+	defer rows.Close()
+	summary := []MonthlyTaxSummary{}
+	for rows.Next() {
+		var s MonthlyTaxSummary
+		if err := rows.Scan(&s.Month, &s.Currency, &s.BillCount, &s.TaxableBase, &s.VATAmount, &s.TotalBilled); err != nil {
+			respondWithServerError(w, "Error scanning tax summary", err)
+			return
+		}
+		summary = append(summary, s)
+	}
+	if err = rows.Err(); err != nil {
+		respondWithServerError(w, "Error iterating tax summary rows", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, summary)
+}
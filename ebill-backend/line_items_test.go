@@ -0,0 +1,51 @@
+package main
+
+import "testing"
+
+func TestComputeLineItems(t *testing.T) {
+	b := &Billing{ReadingPrevious: MustParseDecimal("1000.00"), ReadingCurrent: MustParseDecimal("1250.50"), RateApplied: MustParseDecimal("4.4217")}
+	items := computeLineItems(b, TariffConfig{ServiceCharge: MoneyFromSatang(3822), FtRate: MustParseDecimal("0.3972"), VATRate: MustParseDecimal("0.07")})
+	// 250.50 units: energy 1107.64, Ft 99.50, taxable 1245.36, VAT 87.18.
+	want := []struct{ itemType, quantity, unitPrice, amount string }{
+		{LineItemEnergy, "250.50", "4.4217", "1107.64"},
+		{LineItemService, "1", "38.22", "38.22"},
+		{LineItemFt, "250.50", "0.3972", "99.50"},
+		{LineItemVAT, "1245.36", "0.07", "87.18"},
+	}
+	if len(items) != len(want) {
+		t.Fatalf("got %d line items, want %d", len(items), len(want))
+	}
+	for i, w := range want {
+		li := items[i]
+		if li.ItemType != w.itemType || li.Quantity.Cmp(MustParseDecimal(w.quantity)) != 0 ||
+			li.UnitPrice.Cmp(MustParseDecimal(w.unitPrice)) != 0 || li.Amount.Cmp(mustParseMoney(t, w.amount)) != 0 {
+			t.Errorf("line %d = %s %s × %s = %s, want %s %s × %s = %s", i,
+				li.ItemType, li.Quantity, li.UnitPrice, li.Amount, w.itemType, w.quantity, w.unitPrice, w.amount)
+		}
+	}
+}
+
+// TestTariffFromLineItems checks that a reissued bill is charged what the
+// original was, whatever the current tariff.
+func TestTariffFromLineItems(t *testing.T) {
+	old := TariffConfig{ServiceCharge: MoneyFromSatang(2462), FtRate: MustParseDecimal("-0.1160"), VATRate: MustParseDecimal("0.07")}
+	current := TariffConfig{ServiceCharge: MoneyFromSatang(3822), FtRate: MustParseDecimal("0.3972"), VATRate: MustParseDecimal("0.10")}
+	b := &Billing{ReadingPrevious: MustParseDecimal("10"), ReadingCurrent: MustParseDecimal("310"), RateApplied: MustParseDecimal("3.2484")}
+	original := computeLineItems(b, old)
+
+	got := tariffFromLineItems(original, current)
+	if got.ServiceCharge.Cmp(old.ServiceCharge) != 0 || got.FtRate.Cmp(old.FtRate) != 0 || got.VATRate.Cmp(old.VATRate) != 0 {
+		t.Errorf("tariffFromLineItems = %+v, want %+v", got, old)
+	}
+	for i, li := range computeLineItems(b, got) {
+		if li.Amount.Cmp(original[i].Amount) != 0 || li.UnitPrice.Cmp(original[i].UnitPrice) != 0 {
+			t.Errorf("reissued %s line = %s at %s, want %s at %s", li.ItemType, li.Amount, li.UnitPrice, original[i].Amount, original[i].UnitPrice)
+		}
+	}
+
+	// A bill stored without some lines falls back to the current charges.
+	got = tariffFromLineItems(original[:1], current)
+	if got.ServiceCharge.Cmp(current.ServiceCharge) != 0 || got.FtRate.Cmp(current.FtRate) != 0 || got.VATRate.Cmp(current.VATRate) != 0 {
+		t.Errorf("tariffFromLineItems of the energy line only = %+v, want %+v", got, current)
+	}
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// List endpoints take ?limit= (default 50, at most 500) and either ?offset= or
+// the ?cursor= returned in the previous page's meta. ?sort= names one of the
+// resource's sort fields, prefixed with "-" for descending order. Every other
+// recognised query parameter is a filter.
+const (
+	defaultListLimit = 50
+	maxListLimit     = 500
+)
+
+// ListMeta describes the page returned by a list endpoint.
+type ListMeta struct {
+	Total      int    `json:"total"` // Rows matching the filters, across all pages
+	Limit      int    `json:"limit"`
+	Offset     int    `json:"offset"`
+	Sort       string `json:"sort"`
+	HasMore    bool   `json:"has_more"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// ListResponse is the envelope every list endpoint responds with.
+type ListResponse struct {
+	Data interface{} `json:"data"`
+	Meta ListMeta    `json:"meta"`
+}
+
+type filterKind int
+
+const (
+	filterText     filterKind = iota // Exact match; a comma-separated value matches any of its items
+	filterInt                        // Integer equality
+	filterBool                       // true or false
+	filterDateFrom                   // expr >= YYYY-MM-DD
+	filterDateTo                     // expr <= YYYY-MM-DD
+	filterContains                   // Case-insensitive substring
+)
+
+type listFilter struct {
+	expr string
+	kind filterKind
+}
+
+// listResource describes how a list endpoint queries one table. Sort
+// expressions must never be NULL so that they can be used for keyset paging.
+type listResource struct {
+	columns     string // SELECT list, in the order the handler scans
+	from        string // FROM clause including joins
+	key         string // Unique column that breaks sort ties
+	filters     map[string]listFilter
+	sorts       map[string]string
+	defaultSort string
+	scope       string // Filter set by the handler from the path, such as a parent's ID; not a query parameter
+}
+
+// listParamError is an invalid list query parameter, reported as 400.
+type listParamError struct{ msg string }
+
+func (e *listParamError) Error() string { return e.msg }
+
+func listParamErrorf(format string, args ...interface{}) error {
+	return &listParamError{fmt.Sprintf(format, args...)}
+}
+
+// listCursor is the position after the last row of a page.
+type listCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	Key   string `json:"k"`
+}
+
+func (c listCursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeListCursor(s string) (listCursor, error) {
+	var c listCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err == nil {
+		err = json.Unmarshal(b, &c)
+	}
+	if err != nil {
+		return c, listParamErrorf("invalid cursor")
+	}
+	return c, nil
+}
+
+// cursorScanner appends the cursor columns to the handler's scan destinations.
+type cursorScanner struct {
+	rows       *sql.Rows
+	value, key *string
+}
+
+func (s cursorScanner) Scan(dest ...interface{}) error {
+	return s.rows.Scan(append(dest, s.value, s.key)...)
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// conditions turns the filter parameters in q into SQL conditions and arguments.
+func (res *listResource) conditions(q url.Values) ([]string, []interface{}, error) {
+	var conds []string
+	var args []interface{}
+	for name, f := range res.filters {
+		v := q.Get(name)
+		if v == "" {
+			continue
+		}
+		var arg interface{} = v
+		op := "="
+		switch f.kind {
+		case filterText:
+			if strings.Contains(v, ",") {
+				arg = pq.Array(strings.Split(v, ","))
+				args = append(args, arg)
+				conds = append(conds, fmt.Sprintf("%s = ANY($%d)", f.expr, len(args)))
+				continue
+			}
+		case filterInt:
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, nil, listParamErrorf("%s must be an integer", name)
+			}
+			arg = n
+		case filterBool:
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, nil, listParamErrorf("%s must be true or false", name)
+			}
+			arg = b
+		case filterDateFrom, filterDateTo:
+			if _, err := time.Parse("2006-01-02", v); err != nil {
+				return nil, nil, listParamErrorf("%s must be YYYY-MM-DD", name)
+			}
+			op = ">="
+			if f.kind == filterDateTo {
+				op = "<="
+			}
+		case filterContains:
+			arg = "%" + likeEscaper.Replace(v) + "%"
+			op = "ILIKE"
+		}
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf("%s %s $%d", f.expr, op, len(args)))
+	}
+	return conds, args, nil
+}
+
+// order returns the expression, direction and keyset comparison for a sort
+// field name, prefixed with "-" for descending order.
+func (res *listResource) order(sort string) (expr, dir, cmp string, err error) {
+	expr, ok := res.sorts[strings.TrimPrefix(sort, "-")]
+	if !ok {
+		return "", "", "", listParamErrorf("cannot sort by %q", strings.TrimPrefix(sort, "-"))
+	}
+	if strings.HasPrefix(sort, "-") {
+		return expr, "DESC", "<", nil
+	}
+	return expr, "ASC", ">", nil
+}
+
+func whereClause(conds []string) string {
+	if len(conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(conds, " AND ")
+}
+
+// query runs the list request with query parameters q against res, calling
+// scan once per row of the page, and returns the page metadata. Errors of type
+// *listParamError are the caller's fault.
+func (res *listResource) query(q url.Values, scan func(row rowScanner) error) (ListMeta, error) {
+	meta := ListMeta{Limit: defaultListLimit, Sort: res.defaultSort}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxListLimit {
+			return meta, listParamErrorf("limit must be between 1 and %d", maxListLimit)
+		}
+		meta.Limit = n
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return meta, listParamErrorf("offset must be a non-negative integer")
+		}
+		meta.Offset = n
+	}
+	if v := q.Get("sort"); v != "" {
+		meta.Sort = v
+	}
+	sortExpr, dir, cmp, err := res.order(meta.Sort)
+	if err != nil {
+		return meta, err
+	}
+
+	conds, args, err := res.conditions(q)
+	if err != nil {
+		return meta, err
+	}
+	where := whereClause(conds)
+	if err := db.QueryRow("SELECT COUNT(*) FROM "+res.from+where, args...).Scan(&meta.Total); err != nil {
+		return meta, err
+	}
+
+	if v := q.Get("cursor"); v != "" {
+		if meta.Offset != 0 {
+			return meta, listParamErrorf("use either offset or cursor, not both")
+		}
+		c, err := decodeListCursor(v)
+		if err != nil {
+			return meta, err
+		}
+		if c.Sort != meta.Sort {
+			return meta, listParamErrorf("cursor was issued for sort %q", c.Sort)
+		}
+		args = append(args, c.Value, c.Key)
+		conds = append(conds, fmt.Sprintf("(%s, %s) %s ($%d, $%d)", sortExpr, res.key, cmp, len(args)-1, len(args)))
+		where = whereClause(conds)
+	}
+	args = append(args, meta.Limit+1, meta.Offset)
+	rows, err := db.Query(fmt.Sprintf("SELECT %s, (%s)::text, (%s)::text FROM %s%s ORDER BY %s %s, %s %s LIMIT $%d OFFSET $%d",
+		res.columns, sortExpr, res.key, res.from, where, sortExpr, dir, res.key, dir, len(args)-1, len(args)), args...)
+	if err != nil {
+		return meta, err
+	}
+	defer rows.Close()
+	var last listCursor
+	n := 0
+	for rows.Next() {
+		if n == meta.Limit {
+			meta.HasMore = true
+			break
+		}
+		last = listCursor{Sort: meta.Sort}
+		if err := scan(cursorScanner{rows, &last.Value, &last.Key}); err != nil {
+			return meta, err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return meta, err
+	}
+	if meta.HasMore {
+		meta.NextCursor = last.encode()
+	}
+	return meta, nil
+}
+
+// all runs the list request with query parameters q against res without
+// paging, selecting columns instead of res.columns. The rows are read from
+// the database as the caller iterates, so result size does not affect memory
+// use. Paging parameters are ignored.
+func (res *listResource) all(q url.Values, columns string) (*sql.Rows, error) {
+	sort := res.defaultSort
+	if v := q.Get("sort"); v != "" {
+		sort = v
+	}
+	sortExpr, dir, _, err := res.order(sort)
+	if err != nil {
+		return nil, err
+	}
+	conds, args, err := res.conditions(q)
+	if err != nil {
+		return nil, err
+	}
+	return db.Query(fmt.Sprintf("SELECT %s FROM %s%s ORDER BY %s %s, %s %s",
+		columns, res.from, whereClause(conds), sortExpr, dir, res.key, dir), args...)
+}
+
+// count returns the number of rows matching the filters in q.
+func (res *listResource) count(q url.Values) (int, error) {
+	conds, args, err := res.conditions(q)
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	err = db.QueryRow("SELECT COUNT(*) FROM "+res.from+whereClause(conds), args...).Scan(&n)
+	return n, err
+}
+
+// respondWithListError reports a failed list query: 400 for bad parameters, 500 otherwise.
+func respondWithListError(w http.ResponseWriter, what string, err error) {
+	if _, ok := err.(*listParamError); ok {
+		respondWithError(w, http.StatusBadRequest, "Invalid list parameters: "+err.Error())
+		return
+	}
+	respondWithServerError(w, "Failed to retrieve "+what, err)
+}
+
+var userList = listResource{
+	columns: `"id", "username", "email", "permission"`,
+	from:    `"users"`,
+	key:     `"id"`,
+	filters: map[string]listFilter{
+		"permission": {`"permission"`, filterText},
+		"username":   {`"username"`, filterContains},
+		"email":      {`"email"`, filterContains},
+	},
+	sorts: map[string]string{
+		"id":       `"id"`,
+		"username": `"username"`,
+		"email":    `"email"`,
+	},
+	defaultSort: "id",
+}
+
+var customerList = listResource{
+	columns: `"customer_id", "name", "address", "email", "phone_number", "registration_date", "version"`,
+	from:    `"customer"`,
+	key:     `"customer_id"`,
+	filters: map[string]listFilter{
+		"name":                   {`"name"`, filterContains},
+		"email":                  {`"email"`, filterContains},
+		"phone_number":           {`"phone_number"`, filterContains},
+		"address":                {`"address"`, filterContains},
+		"registration_date_from": {`"registration_date"`, filterDateFrom},
+		"registration_date_to":   {`"registration_date"`, filterDateTo},
+	},
+	sorts: map[string]string{
+		"customer_id":       `"customer_id"`,
+		"name":              `"name"`,
+		"email":             `"email"`,
+		"registration_date": `"registration_date"`,
+	},
+	defaultSort: "customer_id",
+}
+
+var meterList = listResource{
+	columns: `"meter_id", "customer_id", "meter_number", "installation_date", "active_status", "version"`,
+	from:    `"meter"`,
+	key:     `"meter_id"`,
+	filters: map[string]listFilter{
+		"customer_id":            {`"customer_id"`, filterInt},
+		"meter_number":           {`"meter_number"`, filterContains},
+		"active_status":          {`"active_status"`, filterBool},
+		"installation_date_from": {`"installation_date"`, filterDateFrom},
+		"installation_date_to":   {`"installation_date"`, filterDateTo},
+	},
+	sorts: map[string]string{
+		"meter_id":          `"meter_id"`,
+		"customer_id":       `"customer_id"`,
+		"meter_number":      `"meter_number"`,
+		"installation_date": `"installation_date"`,
+	},
+	defaultSort: "meter_id",
+}
+
+var billingList = listResource{
+	columns: `c.name, b.bill_id, b.customer_id, b.meter_id, b.billing_date, b.due_date, b.previous_reading, b.current_reading,
+	          b.rate_applied, b.total_unit, b.amount_due, b.currency, b.paid_status, b.bill_status, b.replaces_bill_id,
+	          b.invoice_number, b.issued_date, b.version`,
+	from: `billing b JOIN customer c ON c.customer_id = b.customer_id`,
+	key:  `b.bill_id`,
+	filters: map[string]listFilter{
+		"customer_id":       {`b.customer_id`, filterInt},
+		"meter_id":          {`b.meter_id`, filterInt},
+		"paid_status":       {`b.paid_status`, filterBool},
+		"bill_status":       {`b.bill_status`, filterText},
+		"currency":          {`b.currency`, filterText},
+		"invoice_number":    {`b.invoice_number`, filterText},
+		"customer_name":     {`c.name`, filterContains},
+		"billing_date_from": {`b.billing_date`, filterDateFrom},
+		"billing_date_to":   {`b.billing_date`, filterDateTo},
+		"due_date_from":     {`b.due_date`, filterDateFrom},
+		"due_date_to":       {`b.due_date`, filterDateTo},
+	},
+	sorts: map[string]string{
+		"bill_id":       `b.bill_id`,
+		"billing_date":  `b.billing_date`,
+		"due_date":      `b.due_date`,
+		"amount_due":    `COALESCE(b.amount_due, 0)`,
+		"customer_name": `c.name`,
+	},
+	defaultSort: "bill_id",
+}
+
+var paymentList = listResource{
+	columns: `"payment_id", "bill_id", "processed_by", "payment_date", "amount_paid", "currency", "payment_method", "payment_status", "version"`,
+	from:    `"payment"`,
+	key:     `"payment_id"`,
+	filters: map[string]listFilter{
+		"bill_id":           {`"bill_id"`, filterInt},
+		"processed_by":      {`"processed_by"`, filterInt},
+		"payment_method":    {`"payment_method"`, filterText},
+		"payment_status":    {`"payment_status"`, filterText},
+		"currency":          {`"currency"`, filterText},
+		"payment_date_from": {`"payment_date"`, filterDateFrom},
+		"payment_date_to":   {`"payment_date"`, filterDateTo},
+	},
+	sorts: map[string]string{
+		"payment_id":   `"payment_id"`,
+		"payment_date": `"payment_date"`,
+		"amount_paid":  `"amount_paid"`,
+	},
+	defaultSort: "payment_id",
+}
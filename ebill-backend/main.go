@@ -10,12 +10,10 @@ import (
 	"os"
 	"strconv" // For converting string IDs from path params to int
 	"strings"
-	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/gorilla/mux"
 	_ "github.com/lib/pq" // PostgreSQL driver
-	"golang.org/x/crypto/bcrypt"
 )
 
 var db *sql.DB
@@ -37,53 +35,106 @@ type User struct {
 // Customer struct
 type Customer struct {
 	CustomerID       int    `json:"Customer_ID"`
-	Name             string `json:"Name"`
-	Address          string `json:"Address"`
-	Email            string `json:"Email"`
-	PhoneNumber      string `json:"Phone_Number"`
-	RegistrationDate string `json:"Registration_Date"`
+	Name             string `json:"Name" validate:"required,max=100"`
+	Address          string `json:"Address" validate:"required,max=255"`
+	Email            string `json:"Email" validate:"required,max=100,email"`
+	PhoneNumber      string `json:"Phone_Number" validate:"required,phone"`
+	RegistrationDate string `json:"Registration_Date" validate:"required,date"`
+	Version          int    `json:"Version,omitempty"` // Sent back in If-Match when updating
+}
+
+// validate checks c against its validate tags.
+func (c *Customer) validate() *APIError {
+	return validateStruct("customer", c)
 }
 
 // Meter struct
 type Meter struct {
 	MeterID          int    `json:"Meter_ID"`
-	CustomerID       int    `json:"Customer_ID"` // Foreign Key
-	MeterNumber      string `json:"Meter_Number"`
-	InstallationDate string `json:"Installation_Date"` // Store as YYYY-MM-DD string, or use time.Time and handle formatting
+	CustomerID       int    `json:"Customer_ID" validate:"required"` // Foreign Key
+	MeterNumber      string `json:"Meter_Number" validate:"required,max=50"`
+	InstallationDate string `json:"Installation_Date" validate:"required,date"` // Store as YYYY-MM-DD string, or use time.Time and handle formatting
 	ActiveStatus     bool   `json:"Active_Status"`
+	Version          int    `json:"Version,omitempty"`
+}
+
+// validate checks m against its validate tags.
+func (m *Meter) validate() *APIError {
+	return validateStruct("meter", m)
 }
 
 // Billing struct
 type Billing struct {
 	BillID          int     `json:"Bill_ID"`
-	CustomerID      int     `json:"Customer_ID"` // Foreign Key
+	CustomerID      int     `json:"Customer_ID" validate:"required"` // Foreign Key
 	Customer_Name   string  `json:"Customer_Name"`
-	MeterID         int     `json:"Meter_ID"`     // Foreign Key
-	BillingDate     string  `json:"Billing_Date"` // Store as YYYY-MM-DD string, or use time.Time
-	DueDate         string  `json:"Due_Date"`     // Store as YYYY-MM-DD string, or use time.Time
-	ReadingPrevious float64 `json:"Previous_Reading"`
-	ReadingCurrent  float64 `json:"Current_Reading"`
-	RateApplied     float64 `json:"Rate_Applied"`
-	TotalUnit       float64 `json:"Total_Unit"` // Corresponds to DECIMAL(10, 2)
-	AmountDue       float64 `json:"Amount_Due"` // Corresponds to DECIMAL(10, 2)
-	PaidStatus      bool    `json:"Paid_Status"`
+	MeterID         int     `json:"Meter_ID" validate:"required"`                            // Foreign Key
+	BillingDate     string  `json:"Billing_Date" validate:"required,date"`                   // Store as YYYY-MM-DD string, or use time.Time
+	DueDate         string  `json:"Due_Date" validate:"required,date,gtefield=Billing_Date"` // Store as YYYY-MM-DD string, or use time.Time
+	ReadingPrevious Decimal `json:"Previous_Reading" validate:"gte=0"`                       // Corresponds to DECIMAL(10, 2)
+	ReadingCurrent  Decimal `json:"Current_Reading" validate:"gtefield=Previous_Reading"`    // Corresponds to DECIMAL(10, 2)
+	RateApplied     Decimal `json:"Rate_Applied" validate:"gt=0"`                            // Corresponds to DECIMAL(10, 4)
+	TotalUnit       Decimal `json:"Total_Unit"`                                              // Corresponds to DECIMAL(10, 2)
+	AmountDue       Money   `json:"Amount_Due"`                                              // Corresponds to DECIMAL(10, 2)
+	Currency        string  `json:"Currency" validate:"required,currency"`                   // ISO 4217 code for AmountDue
+	PaidStatus      bool    `json:"Paid_Status"`                                             // Maintained by the Payment trigger
+	Status          string  `json:"Bill_Status"`                                             // draft, issued, partially_paid, paid, void or written_off
+	ReplacesBillID  *int    `json:"Replaces_Bill_ID,omitempty"`
+	InvoiceNumber   *string `json:"Invoice_Number"` // Assigned when the bill is issued
+	IssuedDate      *string `json:"Issued_Date"`
+	Version         int     `json:"Version,omitempty"`
+
+	LineItems []BillLineItem `json:"Line_Items,omitempty"` // Breakdown of AmountDue, loaded by getBillingByID
+}
+
+// normalize rounds client-supplied readings and rate to the column scales,
+// so the values echoed back match what PostgreSQL stores.
+func (b *Billing) normalize() {
+	b.ReadingPrevious = b.ReadingPrevious.Round(2)
+	b.ReadingCurrent = b.ReadingCurrent.Round(2)
+	b.RateApplied = b.RateApplied.Round(4)
+	if b.Currency == "" {
+		b.Currency = DefaultCurrency
+	}
+}
+
+// validate checks the client-editable fields of a bill, including that
+// Due_Date is not before Billing_Date and the readings do not go backwards.
+func (b *Billing) validate() *APIError {
+	return validateStruct("bill", b)
 }
 
 // Payment struct
 type Payment struct {
-	PaymentID     int     `json:"Payment_ID"`
-	BillID        int     `json:"Bill_ID"` // Foreign Key
-	ProcessedBy   int     `json:"Processed_By"`
-	PaymentDate   string  `json:"Payment_Date"` // Store as YYYY-MM-DD string, or use time.Time
-	AmountPaid    float64 `json:"Amount_Paid"`  // Corresponds to DECIMAL(10, 2)
-	PaymentMethod string  `json:"Payment_Method"`
-	PaymentStatus string  `json:"Payment_Status"`
-}
+	PaymentID     int    `json:"Payment_ID"`
+	BillID        int    `json:"Bill_ID" validate:"required"` // Foreign Key
+	ProcessedBy   int    `json:"Processed_By"`
+	PaymentDate   string `json:"Payment_Date" validate:"required,date"` // Store as YYYY-MM-DD string, or use time.Time
+	AmountPaid    Money  `json:"Amount_Paid" validate:"gt=0"`           // Corresponds to DECIMAL(10, 2)
+	Currency      string `json:"Currency" validate:"required,currency"` // ISO 4217 code for AmountPaid
+	PaymentMethod string `json:"Payment_Method" validate:"required,oneof=Credit Card|Bank Transfer|Cash|Check|Online Portal|PromptPay"`
+	PaymentStatus string `json:"Payment_Status" validate:"required,oneof=Pending|Completed|Failed|Refunded"`
+	Version       int    `json:"Version,omitempty"`
+}
+
+// validate checks p against its validate tags.
+func (p *Payment) validate() *APIError {
+	return validateStruct("payment", p)
+}
+
+// Fields that PATCH requests may not change.
+var (
+	customerReadOnlyFields = []string{"Customer_ID", "Version"}
+	meterReadOnlyFields    = []string{"Meter_ID", "Version"}
+	billingReadOnlyFields  = []string{"Bill_ID", "Customer_Name", "Total_Unit", "Amount_Due", "Paid_Status", "Bill_Status",
+		"Replaces_Bill_ID", "Invoice_Number", "Issued_Date", "Line_Items", "Version"}
+	paymentReadOnlyFields = []string{"Payment_ID", "Version"}
+)
 
 // Credentials struct for login request
 type Credentials struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username string `json:"username" validate:"required,max=50"`
+	Password string `json:"password" validate:"required"`
 }
 
 // CustomClaims includes standard claims and custom ones like Permission.
@@ -99,9 +150,13 @@ type ContextKey string
 const (
 	// UserClaimsKey is the key for storing user claims in the request context.
 	UserClaimsKey ContextKey = "userClaims"
+	// RequestIDKey is the key for storing the request ID in the request context.
+	RequestIDKey ContextKey = "requestID"
 )
 
-func init() {
+// connectDB opens the database named by the DB_* environment variables and
+// exits if it cannot be reached.
+func connectDB() {
 	var err error
 	dbHost := os.Getenv("DB_HOST")
 	dbPort := os.Getenv("DB_PORT")
@@ -143,10 +198,7 @@ func init() {
 
 // respondWithError sends a JSON error message with a specific status code.
 func respondWithError(w http.ResponseWriter, code int, message string) {
-	log.Printf("Error response: status=%d, message=%s", code, message)
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(code)
-	json.NewEncoder(w).Encode(map[string]string{"error": message})
+	respondWithAPIError(w, &APIError{Status: code, Code: errorCodeFor(code), Message: message})
 }
 
 // respondWithJSON sends a JSON success message.
@@ -156,11 +208,17 @@ func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	json.NewEncoder(w).Encode(payload)
 }
 
+// rowScanner is satisfied by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
 func enableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "http://localhost:3000") // Your React app's origin
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, If-Match, If-None-Match, X-Request-ID")
+		w.Header().Set("Access-Control-Expose-Headers", "ETag, X-Request-ID, API-Version, Deprecation, Sunset, Link")
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
 			return
@@ -175,44 +233,13 @@ func handleLogin(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusBadRequest, "Invalid request payload: "+err.Error())
 		return
 	}
-
-	var user User
-	query := "SELECT id, username, email, permission, password_hash FROM users WHERE username = $1"
-	err := db.QueryRow(query, creds.Username).Scan(&user.ID, &user.Username, &user.Email, &user.Permission, &user.PasswordHash)
+	token, user, _, err := authenticate(creds)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			respondWithError(w, http.StatusUnauthorized, "Invalid username or password")
-		} else {
-			log.Printf("Database error during login for user '%s': %v", creds.Username, err)
-			respondWithError(w, http.StatusInternalServerError, "Internal server error")
-		}
-		return
-	}
-
-	if err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(creds.Password)); err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Invalid username or password")
-		return
-	}
-
-	expirationTime := time.Now().Add(24 * time.Hour)
-	claims := &CustomClaims{
-		Username:   user.Username,
-		Permission: user.Permission,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expirationTime),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			Issuer:    "ebill-app",
-			Subject:   fmt.Sprintf("%d", user.ID),
-		},
-	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtKey)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Could not generate token")
+		respondWithServerError(w, "Internal server error", err)
 		return
 	}
 	respondWithJSON(w, http.StatusOK, map[string]string{
-		"token":      tokenString,
+		"token":      token,
 		"username":   user.Username,
 		"permission": user.Permission,
 	})
@@ -252,54 +279,49 @@ func authMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// --- User Handlers ---
-func getUsers(w http.ResponseWriter, r *http.Request) {
-	rows, err := db.Query("SELECT id, username, email, permission FROM users")
+// currentUserID returns the users.id of the authenticated caller, or nil for
+// unauthenticated (public) requests. The ID is carried in the JWT subject.
+func currentUserID(r *http.Request) *int {
+	claims, ok := r.Context().Value(UserClaimsKey).(*CustomClaims)
+	if !ok {
+		return nil
+	}
+	id, err := strconv.Atoi(claims.Subject)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve users: "+err.Error())
-		return
+		return nil
 	}
-	defer rows.Close()
-	var usersList []User
-	for rows.Next() {
-		var u User
-		if err := rows.Scan(&u.ID, &u.Username, &u.Email, &u.Permission); err != nil {
-			respondWithError(w, http.StatusInternalServerError, "Error processing user data: "+err.Error())
-			return
-		}
-		usersList = append(usersList, u)
+	return &id
+}
+
+// currentPermission returns the permission claim of the authenticated caller,
+// or "" for unauthenticated (public) requests.
+func currentPermission(r *http.Request) string {
+	claims, ok := r.Context().Value(UserClaimsKey).(*CustomClaims)
+	if !ok {
+		return ""
 	}
-	if err = rows.Err(); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error retrieving user data: "+err.Error())
+	return claims.Permission
+}
+
+// --- User Handlers ---
+func getUsers(w http.ResponseWriter, r *http.Request) {
+	usersList, meta, err := listUsers(r.URL.Query())
+	if err != nil {
+		respondWithListError(w, "users", err)
 		return
 	}
-	respondWithJSON(w, http.StatusOK, usersList)
+	respondWithJSON(w, http.StatusOK, ListResponse{Data: usersList, Meta: meta})
 }
 
 // --- Customer Handlers ---
 func getCustomers(w http.ResponseWriter, r *http.Request) {
 	log.Println("API: getCustomers called")
-	rows, err := db.Query(`SELECT "customer_id", "name", "address", "email", "phone_number", "registration_date" FROM "customer" 
-							ORDER BY customer_id ASC`)
+	customers, meta, err := customerSvc.List(r.URL.Query())
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve customers: "+err.Error())
+		respondWithListError(w, "customers", err)
 		return
 	}
-	defer rows.Close()
-	customers := []Customer{}
-	for rows.Next() {
-		var c Customer
-		if err := rows.Scan(&c.CustomerID, &c.Name, &c.Address, &c.Email, &c.PhoneNumber, &c.RegistrationDate); err != nil {
-			respondWithError(w, http.StatusInternalServerError, "Error scanning customer data: "+err.Error())
-			return
-		}
-		customers = append(customers, c)
-	}
-	if err = rows.Err(); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error iterating customer rows: "+err.Error())
-		return
-	}
-	respondWithJSON(w, http.StatusOK, customers)
+	respondWithJSON(w, http.StatusOK, ListResponse{Data: customers, Meta: meta})
 }
 func createCustomer(w http.ResponseWriter, r *http.Request) {
 	log.Println("API: createCustomer called")
@@ -309,13 +331,8 @@ func createCustomer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// ID will be auto-generated by the database
-	// We expect other fields to be validated as necessary (e.g., Name not empty)
-
-	sqlStatement := `INSERT INTO "customer" ("name", "address", "email", "phone_number", "registration_date") VALUES ($1, $2, $3, $4, $5) 
-					RETURNING "customer_id"`
-	err := db.QueryRow(sqlStatement, c.Name, c.Address, c.Email, c.PhoneNumber, c.RegistrationDate).Scan(&c.CustomerID)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to create customer: "+err.Error())
+	if err := customerSvc.Create(auditFor(r), &c); err != nil {
+		respondWithServerError(w, "Failed to create customer", err)
 		return
 	}
 	respondWithJSON(w, http.StatusCreated, c)
@@ -333,16 +350,17 @@ func getCustomerByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	log.Printf("API: getCustomerByID called for ID: %d", id)
+	if r.URL.Query().Has("as_of") {
+		serveAsOf(w, r, customerSvc, id, "customer")
+		return
+	}
 
-	var c Customer
-	sqlStatement := `SELECT "customer_id", "name", "address", "email", "phone_number", "registration_date" FROM "customer" WHERE "customer_id" = $1`
-	err = db.QueryRow(sqlStatement, id).Scan(&c.CustomerID, &c.Name, &c.Address, &c.Email, &c.PhoneNumber, &c.RegistrationDate)
+	c, err := customerSvc.Get(id)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			respondWithError(w, http.StatusNotFound, "Customer not found")
-		} else {
-			respondWithError(w, http.StatusInternalServerError, "Failed to retrieve customer: "+err.Error())
-		}
+		respondWithServerError(w, "Failed to retrieve customer", err)
+		return
+	}
+	if notModified(w, r, c.Version) {
 		return
 	}
 	respondWithJSON(w, http.StatusOK, c)
@@ -360,30 +378,45 @@ func updateCustomer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	log.Printf("API: updateCustomer called for ID: %d", id)
+	version, ok := requireIfMatch(w, r)
+	if !ok {
+		return
+	}
 
 	var c Customer
 	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
 		respondWithError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
 		return
 	}
+	if err := customerSvc.Update(auditFor(r), id, &c, version); err != nil {
+		respondWithServerError(w, "Failed to update customer", err)
+		return
+	}
+	setETag(w, c.Version)
+	respondWithJSON(w, http.StatusOK, c)
+}
 
-	sqlStatement := `UPDATE "customer" SET "name" = $1, "address" = $2, "email" = $3, "phone_number" = $4, "registration_date" 
-					= $5 WHERE "customer_id" = $6`
-	res, err := db.Exec(sqlStatement, c.Name, c.Address, c.Email, c.PhoneNumber, c.RegistrationDate, id)
+// patchCustomer applies a JSON Merge Patch to a customer.
+func patchCustomer(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to update customer: "+err.Error())
+		respondWithError(w, http.StatusBadRequest, "Invalid Customer ID format: "+err.Error())
 		return
 	}
-	count, err := res.RowsAffected()
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to check affected rows: "+err.Error())
+	log.Printf("API: patchCustomer called for ID: %d", id)
+	version, ok := requireIfMatch(w, r)
+	if !ok {
 		return
 	}
-	if count == 0 {
-		respondWithError(w, http.StatusNotFound, "Customer not found or no changes made")
+	c, err := customerSvc.Patch(auditFor(r), id, version, func(c *Customer) error {
+		return applyMergePatch(r, c, customerReadOnlyFields)
+	})
+	if err != nil {
+		respondWithPatchError(w, "Failed to update customer", err)
 		return
 	}
-	c.CustomerID = id // Ensure the returned customer has the correct ID from the path
+	setETag(w, c.Version)
 	respondWithJSON(w, http.StatusOK, c)
 }
 func deleteCustomer(w http.ResponseWriter, r *http.Request) {
@@ -399,20 +432,12 @@ func deleteCustomer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	log.Printf("API: deleteCustomer called for ID: %d", id)
-
-	sqlStatement := `DELETE FROM "customer" WHERE "customer_id" = $1`
-	res, err := db.Exec(sqlStatement, id)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to delete customer: "+err.Error())
-		return
-	}
-	count, err := res.RowsAffected()
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to check affected rows: "+err.Error())
+	version, ok := requireIfMatch(w, r)
+	if !ok {
 		return
 	}
-	if count == 0 {
-		respondWithError(w, http.StatusNotFound, "Customer not found")
+	if err := customerSvc.Delete(auditFor(r), id, version); err != nil {
+		respondWithServerError(w, "Failed to delete customer", err)
 		return
 	}
 	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Customer deleted successfully"})
@@ -421,26 +446,12 @@ func deleteCustomer(w http.ResponseWriter, r *http.Request) {
 // --- Meter Handlers ---
 func getMeters(w http.ResponseWriter, r *http.Request) {
 	log.Println("API: getMeters called")
-	rows, err := db.Query(`SELECT "meter_id", "customer_id", "meter_number", "installation_date", "active_status" FROM "meter" ORDER BY meter_id ASC`)
+	meters, meta, err := meterSvc.List(r.URL.Query())
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve meters: "+err.Error())
-		return
-	}
-	defer rows.Close()
-	meters := []Meter{}
-	for rows.Next() {
-		var m Meter
-		if err := rows.Scan(&m.MeterID, &m.CustomerID, &m.MeterNumber, &m.InstallationDate, &m.ActiveStatus); err != nil {
-			respondWithError(w, http.StatusInternalServerError, "Error scanning meter data: "+err.Error())
-			return
-		}
-		meters = append(meters, m)
-	}
-	if err = rows.Err(); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error iterating meter rows: "+err.Error())
+		respondWithListError(w, "meters", err)
 		return
 	}
-	respondWithJSON(w, http.StatusOK, meters)
+	respondWithJSON(w, http.StatusOK, ListResponse{Data: meters, Meta: meta})
 }
 func createMeter(w http.ResponseWriter, r *http.Request) {
 	log.Println("API: createMeter called")
@@ -449,15 +460,9 @@ func createMeter(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
 		return
 	}
-	if m.CustomerID == 0 { // Foreign key, should be validated if it exists in Customer table
-		respondWithError(w, http.StatusBadRequest, "Customer_ID is required for meter and cannot be 0")
-		return
-	}
 	// Meter_ID will be auto-generated
-	sqlStatement := `INSERT INTO "meter" ("customer_id", "meter_number", "installation_date", "active_status") VALUES ($1, $2, $3, $4) RETURNING "meter_id"`
-	err := db.QueryRow(sqlStatement, m.CustomerID, m.MeterNumber, m.InstallationDate, m.ActiveStatus).Scan(&m.MeterID)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to create meter: "+err.Error())
+	if err := meterSvc.Create(auditFor(r), &m); err != nil {
+		respondWithServerError(w, "Failed to create meter", err)
 		return
 	}
 	respondWithJSON(w, http.StatusCreated, m)
@@ -471,15 +476,16 @@ func getMeterByID(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusBadRequest, "Invalid Meter ID format")
 		return
 	}
-	var m Meter
-	sqlStatement := `SELECT "meter_id", "customer_id", "meter_number", "installation_date", "active_status" FROM "meter" WHERE "meter_id" = $1`
-	err = db.QueryRow(sqlStatement, id).Scan(&m.MeterID, &m.CustomerID, &m.MeterNumber, &m.InstallationDate, &m.ActiveStatus)
+	if r.URL.Query().Has("as_of") {
+		serveAsOf(w, r, meterSvc, id, "meter")
+		return
+	}
+	m, err := meterSvc.Get(id)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			respondWithError(w, http.StatusNotFound, "Meter not found")
-		} else {
-			respondWithError(w, http.StatusInternalServerError, "Failed to retrieve meter: "+err.Error())
-		}
+		respondWithServerError(w, "Failed to retrieve meter", err)
+		return
+	}
+	if notModified(w, r, m.Version) {
 		return
 	}
 	respondWithJSON(w, http.StatusOK, m)
@@ -493,23 +499,44 @@ func updateMeter(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusBadRequest, "Invalid Meter ID format")
 		return
 	}
+	version, ok := requireIfMatch(w, r)
+	if !ok {
+		return
+	}
 	var m Meter
 	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
 		respondWithError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
 		return
 	}
-	sqlStatement := `UPDATE "meter" SET "customer_id"=$1, "meter_number"=$2, "installation_date"=$3, "active_status"=$4 WHERE "meter_id"=$5`
-	res, err := db.Exec(sqlStatement, m.CustomerID, m.MeterNumber, m.InstallationDate, m.ActiveStatus, id)
+	if err := meterSvc.Update(auditFor(r), id, &m, version); err != nil {
+		respondWithServerError(w, "Failed to update meter", err)
+		return
+	}
+	setETag(w, m.Version)
+	respondWithJSON(w, http.StatusOK, m)
+}
+
+// patchMeter applies a JSON Merge Patch to a meter.
+func patchMeter(w http.ResponseWriter, r *http.Request) {
+	log.Println("API: patchMeter called")
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to update meter: "+err.Error())
+		respondWithError(w, http.StatusBadRequest, "Invalid Meter ID format")
 		return
 	}
-	count, _ := res.RowsAffected()
-	if count == 0 {
-		respondWithError(w, http.StatusNotFound, "Meter not found or no changes made")
+	version, ok := requireIfMatch(w, r)
+	if !ok {
 		return
 	}
-	m.MeterID = id
+	m, err := meterSvc.Patch(auditFor(r), id, version, func(m *Meter) error {
+		return applyMergePatch(r, m, meterReadOnlyFields)
+	})
+	if err != nil {
+		respondWithPatchError(w, "Failed to update meter", err)
+		return
+	}
+	setETag(w, m.Version)
 	respondWithJSON(w, http.StatusOK, m)
 }
 func deleteMeter(w http.ResponseWriter, r *http.Request) {
@@ -521,15 +548,12 @@ func deleteMeter(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusBadRequest, "Invalid Meter ID format")
 		return
 	}
-	sqlStatement := `DELETE FROM "meter" WHERE "meter_id" = $1`
-	res, err := db.Exec(sqlStatement, id)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to delete meter: "+err.Error())
+	version, ok := requireIfMatch(w, r)
+	if !ok {
 		return
 	}
-	count, _ := res.RowsAffected()
-	if count == 0 {
-		respondWithError(w, http.StatusNotFound, "Meter not found")
+	if err := meterSvc.Delete(auditFor(r), id, version); err != nil {
+		respondWithServerError(w, "Failed to delete meter", err)
 		return
 	}
 	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Meter deleted successfully"})
@@ -538,29 +562,12 @@ func deleteMeter(w http.ResponseWriter, r *http.Request) {
 // --- Billing Handlers ---
 func getBillings(w http.ResponseWriter, r *http.Request) {
 	log.Println("API: getBillings called")
-	rows, err := db.Query(`SELECT c.Name, b.bill_id, b.customer_id, b.meter_id, b.billing_date, b.due_date, 
-	                        b.previous_reading, b.current_reading, b.rate_applied, b.total_unit, b.amount_due, b.paid_status FROM billing b, customer c, meter m
-	                        WHERE b.customer_id = c.customer_id AND b.meter_id = m.meter_id ORDER BY bill_id ASC`)
+	billings, meta, err := billSvc.List(r.URL.Query())
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve bills: "+err.Error())
+		respondWithListError(w, "bills", err)
 		return
 	}
-	defer rows.Close()
-	billings := []Billing{}
-	for rows.Next() {
-		var b Billing
-		if err := rows.Scan(&b.Customer_Name, &b.BillID, &b.CustomerID, &b.MeterID, &b.BillingDate, &b.DueDate,
-			&b.ReadingPrevious, &b.ReadingCurrent, &b.RateApplied, &b.TotalUnit, &b.AmountDue, &b.PaidStatus); err != nil {
-			respondWithError(w, http.StatusInternalServerError, "Error scanning bill data: "+err.Error())
-			return
-		}
-		billings = append(billings, b)
-	}
-	if err = rows.Err(); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error iterating bill rows: "+err.Error())
-		return
-	}
-	respondWithJSON(w, http.StatusOK, billings)
+	respondWithJSON(w, http.StatusOK, ListResponse{Data: billings, Meta: meta})
 }
 func createBilling(w http.ResponseWriter, r *http.Request) {
 	log.Println("API: createBilling called")
@@ -569,23 +576,8 @@ func createBilling(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
 		return
 	}
-	if b.CustomerID == 0 {
-		respondWithError(w, http.StatusBadRequest, "Customer_ID is required for billing and cannot be 0")
-		return
-	}
-	if b.MeterID == 0 {
-		respondWithError(w, http.StatusBadRequest, "Meter_ID is required for billing and cannot be 0")
-		return
-	}
-	// Bill_ID will be auto-generated
-	// Total_Unit is generated by the database, so we don't insert it directly. We can return it.
-	sqlStatement := `INSERT INTO "billing" ("customer_id", "meter_id", "billing_date", "due_date", 
-	                 "previous_reading", "current_reading", "rate_applied", "amount_due", "paid_status") 
-	                 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING "bill_id", "total_unit"`
-	err := db.QueryRow(sqlStatement, b.CustomerID, b.MeterID, b.BillingDate, b.DueDate,
-		b.ReadingPrevious, b.ReadingCurrent, b.RateApplied, b.AmountDue, b.PaidStatus).Scan(&b.BillID, &b.TotalUnit)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to create bill: "+err.Error())
+	if err := billSvc.Create(auditFor(r), &b); err != nil {
+		respondWithServerError(w, "Failed to create bill", err)
 		return
 	}
 	respondWithJSON(w, http.StatusCreated, b)
@@ -599,18 +591,16 @@ func getBillingByID(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusBadRequest, "Invalid Bill ID")
 		return
 	}
-	var b Billing
-	sqlStatement := `SELECT "bill_id", "customer_id", "meter_id", "billing_date", "due_date", 
-	                 "previous_reading", "current_reading", "rate_applied", "total_unit", "amount_due", "paid_status" 
-									 FROM "billing" WHERE "bill_id" = $1`
-	err = db.QueryRow(sqlStatement, id).Scan(&b.BillID, &b.CustomerID, &b.MeterID, &b.BillingDate, &b.DueDate,
-		&b.ReadingPrevious, &b.ReadingCurrent, &b.RateApplied, &b.TotalUnit, &b.AmountDue, &b.PaidStatus)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			respondWithError(w, http.StatusNotFound, "Bill not found")
-		} else {
-			respondWithError(w, http.StatusInternalServerError, "Failed to retrieve bill: "+err.Error())
-		}
+	if r.URL.Query().Has("as_of") {
+		serveAsOf(w, r, billSvc, id, "bill")
+		return
+	}
+	b, err := billSvc.Get(id)
+	if err != nil {
+		respondWithServerError(w, "Failed to retrieve bill", err)
+		return
+	}
+	if notModified(w, r, b.Version) {
 		return
 	}
 	respondWithJSON(w, http.StatusOK, b)
@@ -624,26 +614,44 @@ func updateBilling(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusBadRequest, "Invalid Bill ID")
 		return
 	}
+	version, ok := requireIfMatch(w, r)
+	if !ok {
+		return
+	}
 	var b Billing
 	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
 		respondWithError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
 		return
 	}
-	// Total_Unit is generated, so not included in SET. We can return it.
-	sqlStatement := `UPDATE "billing" SET "customer_id"=$1, "meter_id"=$2, "billing_date"=$3, "due_date"=$4, 
-	                 "previous_reading"=$5, "current_reading"=$6, "rate_applied"=$7, "amount_due"=$8, "paid_status"=$9 
-									 WHERE "bill_id"=$10 RETURNING "total_unit"`
-	err = db.QueryRow(sqlStatement, b.CustomerID, b.MeterID, b.BillingDate, b.DueDate,
-		b.ReadingPrevious, b.ReadingCurrent, b.RateApplied, b.AmountDue, b.PaidStatus, id).Scan(&b.TotalUnit)
+	if err := billSvc.Update(auditFor(r), id, &b, version); err != nil {
+		respondWithServerError(w, "Failed to update bill", err)
+		return
+	}
+	setETag(w, b.Version)
+	respondWithJSON(w, http.StatusOK, b)
+}
+
+// patchBilling applies a JSON Merge Patch to a draft bill.
+func patchBilling(w http.ResponseWriter, r *http.Request) {
+	log.Println("API: patchBilling called")
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		if err == sql.ErrNoRows { // If QueryRow finds no rows, Scan returns sql.ErrNoRows
-			respondWithError(w, http.StatusNotFound, "Bill not found or no changes made")
-			return
-		}
-		respondWithError(w, http.StatusInternalServerError, "Failed to update bill: "+err.Error())
+		respondWithError(w, http.StatusBadRequest, "Invalid Bill ID")
+		return
+	}
+	version, ok := requireIfMatch(w, r)
+	if !ok {
 		return
 	}
-	b.BillID = id
+	b, err := billSvc.Patch(auditFor(r), id, version, func(b *Billing) error {
+		return applyMergePatch(r, b, billingReadOnlyFields)
+	})
+	if err != nil {
+		respondWithPatchError(w, "Failed to update bill", err)
+		return
+	}
+	setETag(w, b.Version)
 	respondWithJSON(w, http.StatusOK, b)
 }
 func deleteBilling(w http.ResponseWriter, r *http.Request) {
@@ -655,15 +663,12 @@ func deleteBilling(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusBadRequest, "Invalid Bill ID")
 		return
 	}
-	sqlStatement := `DELETE FROM "billing" WHERE "bill_id" = $1`
-	res, err := db.Exec(sqlStatement, id)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to delete bill: "+err.Error())
+	version, ok := requireIfMatch(w, r)
+	if !ok {
 		return
 	}
-	count, _ := res.RowsAffected()
-	if count == 0 {
-		respondWithError(w, http.StatusNotFound, "Bill not found")
+	if err := billSvc.Delete(auditFor(r), id, version); err != nil {
+		respondWithServerError(w, "Failed to delete bill", err)
 		return
 	}
 	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Bill deleted successfully"})
@@ -672,26 +677,12 @@ func deleteBilling(w http.ResponseWriter, r *http.Request) {
 // --- Payment Handlers ---
 func getPayments(w http.ResponseWriter, r *http.Request) {
 	log.Println("API: getPayments called")
-	rows, err := db.Query(`SELECT "payment_id", "bill_id", "processed_by", "payment_date", "amount_paid", "payment_method", "payment_status" FROM "payment" ORDER BY payment_id ASC`)
+	payments, meta, err := paymentSvc.List(r.URL.Query())
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve payments: "+err.Error())
+		respondWithListError(w, "payments", err)
 		return
 	}
-	defer rows.Close()
-	payments := []Payment{}
-	for rows.Next() {
-		var p Payment
-		if err := rows.Scan(&p.PaymentID, &p.BillID, &p.ProcessedBy, &p.PaymentDate, &p.AmountPaid, &p.PaymentMethod, &p.PaymentStatus); err != nil {
-			respondWithError(w, http.StatusInternalServerError, "Error scanning payment data: "+err.Error())
-			return
-		}
-		payments = append(payments, p)
-	}
-	if err = rows.Err(); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error iterating payment rows: "+err.Error())
-		return
-	}
-	respondWithJSON(w, http.StatusOK, payments)
+	respondWithJSON(w, http.StatusOK, ListResponse{Data: payments, Meta: meta})
 }
 func createPayment(w http.ResponseWriter, r *http.Request) {
 	log.Println("API: createPayment called")
@@ -700,16 +691,9 @@ func createPayment(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
 		return
 	}
-	if p.BillID == 0 { // Foreign key
-		respondWithError(w, http.StatusBadRequest, "Bill_ID is required for payment and cannot be 0")
-		return
-	}
 	// Payment_ID will be auto-generated
-	sqlStatement := `INSERT INTO "payment" ("bill_id", "processed_by", "payment_date", "amount_paid", "payment_method", "payment_status") 
-	                 VALUES ($1, $2, $3, $4, $5, $6) RETURNING "payment_id"`
-	err := db.QueryRow(sqlStatement, p.BillID, p.ProcessedBy, p.PaymentDate, p.AmountPaid, p.PaymentMethod, p.PaymentStatus).Scan(&p.PaymentID)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to create payment: "+err.Error())
+	if err := paymentSvc.Create(auditFor(r), &p); err != nil {
+		respondWithServerError(w, "Failed to create payment", err)
 		return
 	}
 	respondWithJSON(w, http.StatusCreated, p)
@@ -723,16 +707,12 @@ func getPaymentByID(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusBadRequest, "Invalid Payment ID")
 		return
 	}
-	var p Payment
-	sqlStatement := `SELECT "payment_id", "bill_id", "processed_by", "payment_date", "amount_paid", "payment_method", "payment_status" 
-	                 FROM "payment" WHERE "payment_id" = $1`
-	err = db.QueryRow(sqlStatement, id).Scan(&p.PaymentID, &p.BillID, &p.ProcessedBy, &p.PaymentDate, &p.AmountPaid, &p.PaymentMethod, &p.PaymentStatus)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			respondWithError(w, http.StatusNotFound, "Payment not found")
-		} else {
-			respondWithError(w, http.StatusInternalServerError, "Failed to retrieve payment: "+err.Error())
-		}
+	p, err := paymentSvc.Get(id)
+	if err != nil {
+		respondWithServerError(w, "Failed to retrieve payment", err)
+		return
+	}
+	if notModified(w, r, p.Version) {
 		return
 	}
 	respondWithJSON(w, http.StatusOK, p)
@@ -746,24 +726,44 @@ func updatePayment(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusBadRequest, "Invalid Payment ID")
 		return
 	}
+	version, ok := requireIfMatch(w, r)
+	if !ok {
+		return
+	}
 	var p Payment
 	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
 		respondWithError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
 		return
 	}
-	sqlStatement := `UPDATE "payment" SET "bill_id"=$1, "processed_by"=$2, "payment_date"=$3, "amount_paid"=$4, "payment_method"=$5, "payment_status"=$6 
-	                 WHERE "payment_id"=$7`
-	res, err := db.Exec(sqlStatement, p.BillID, p.ProcessedBy, p.PaymentDate, p.AmountPaid, p.PaymentMethod, p.PaymentStatus, id)
+	if err := paymentSvc.Update(auditFor(r), id, &p, version); err != nil {
+		respondWithServerError(w, "Failed to update payment", err)
+		return
+	}
+	setETag(w, p.Version)
+	respondWithJSON(w, http.StatusOK, p)
+}
+
+// patchPayment applies a JSON Merge Patch to a payment.
+func patchPayment(w http.ResponseWriter, r *http.Request) {
+	log.Println("API: patchPayment called")
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to update payment: "+err.Error())
+		respondWithError(w, http.StatusBadRequest, "Invalid Payment ID")
 		return
 	}
-	count, _ := res.RowsAffected()
-	if count == 0 {
-		respondWithError(w, http.StatusNotFound, "Payment not found or no changes made")
+	version, ok := requireIfMatch(w, r)
+	if !ok {
 		return
 	}
-	p.PaymentID = id
+	p, err := paymentSvc.Patch(auditFor(r), id, version, func(p *Payment) error {
+		return applyMergePatch(r, p, paymentReadOnlyFields)
+	})
+	if err != nil {
+		respondWithPatchError(w, "Failed to update payment", err)
+		return
+	}
+	setETag(w, p.Version)
 	respondWithJSON(w, http.StatusOK, p)
 }
 func deletePayment(w http.ResponseWriter, r *http.Request) {
@@ -775,15 +775,12 @@ func deletePayment(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusBadRequest, "Invalid Payment ID")
 		return
 	}
-	sqlStatement := `DELETE FROM "payment" WHERE "payment_id" = $1`
-	res, err := db.Exec(sqlStatement, id)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to delete payment: "+err.Error())
+	version, ok := requireIfMatch(w, r)
+	if !ok {
 		return
 	}
-	count, _ := res.RowsAffected()
-	if count == 0 {
-		respondWithError(w, http.StatusNotFound, "Payment not found")
+	if err := paymentSvc.Delete(auditFor(r), id, version); err != nil {
+		respondWithServerError(w, "Failed to delete payment", err)
 		return
 	}
 	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Payment deleted successfully"})
@@ -792,52 +789,11 @@ func deletePayment(w http.ResponseWriter, r *http.Request) {
 // --- Public Customer Portal Handlers ---
 func getCustomerUnpaidBills(w http.ResponseWriter, r *http.Request) {
 	log.Println("API: getCustomerUnpaidBills called")
-	identifier := r.URL.Query().Get("identifier")
-
-	if identifier == "" {
-		respondWithError(w, http.StatusBadRequest, "Identifier (email or phone number) is required")
-		return
-	}
-
-	var customerID int
-	// Try to find customer by email or phone number
-	// Ensure the customer is active if that's a business rule
-	err := db.QueryRow(`SELECT "customer_id" FROM "customer" WHERE ("email" = $1 OR "phone_number" = $1)`, identifier).Scan(&customerID)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			log.Printf("No customer found for identifier: %s", identifier)
-			respondWithJSON(w, http.StatusOK, []Billing{}) // Return empty array if no customer found
-			return
-		}
-		respondWithError(w, http.StatusInternalServerError, "Error finding customer: "+err.Error())
-		return
-	}
-
-	// Fetch unpaid bills for the customer
-	rows, err := db.Query(`SELECT "bill_id", "customer_id", "meter_id", "billing_date", "due_date", 
-	                        "previous_reading", "current_reading", "rate_applied", "total_unit", "amount_due", "paid_status" 
-	                        FROM "billing" WHERE "customer_id" = $1 AND "paid_status" = FALSE ORDER BY "due_date" ASC`, customerID)
+	bills, err := billSvc.OpenForCustomer(r.URL.Query().Get("identifier"))
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve bills: "+err.Error())
+		respondWithServerError(w, "Failed to retrieve bills", err)
 		return
 	}
-	defer rows.Close()
-
-	bills := []Billing{}
-	for rows.Next() {
-		var b Billing
-		if err := rows.Scan(&b.BillID, &b.CustomerID, &b.MeterID, &b.BillingDate, &b.DueDate,
-			&b.ReadingPrevious, &b.ReadingCurrent, &b.RateApplied, &b.TotalUnit, &b.AmountDue, &b.PaidStatus); err != nil {
-			respondWithError(w, http.StatusInternalServerError, "Error scanning bill data: "+err.Error())
-			return
-		}
-		bills = append(bills, b)
-	}
-	if err = rows.Err(); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error iterating bill rows: "+err.Error())
-		return
-	}
-
 	respondWithJSON(w, http.StatusOK, bills)
 }
 
@@ -863,88 +819,33 @@ func handlePayBillPublic(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
 		return
 	}
-	if req.PaymentMethod == "" {
-		respondWithError(w, http.StatusBadRequest, "Payment method is required")
-		return
-	}
-
 	log.Printf("API: handlePayBillPublic called for Bill ID: %d with method: %s", billID, req.PaymentMethod)
 
-	// Start a transaction
-	tx, err := db.Begin()
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to start database transaction: "+err.Error())
-		return
-	}
-	defer tx.Rollback() // Rollback if anything goes wrong
-
-	// 1. Fetch the bill to get amount_due and check if already paid
-	var billToPay Billing
-	selectBillSQL := `SELECT "amount_due", "paid_status" FROM "billing" WHERE "bill_id" = $1 FOR UPDATE` // Lock the row
-	err = tx.QueryRow(selectBillSQL, billID).Scan(&billToPay.AmountDue, &billToPay.PaidStatus)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			respondWithError(w, http.StatusNotFound, "Bill not found")
-		} else {
-			respondWithError(w, http.StatusInternalServerError, "Failed to retrieve bill details: "+err.Error())
-		}
-		return
-	}
-
-	if billToPay.PaidStatus {
-		respondWithJSON(w, http.StatusOK, map[string]string{"message": "This bill has already been paid."})
-		return // No need to commit, transaction will be rolled back by defer
-	}
-
-	// 2. Update billing table
-	updateBillSQL := `UPDATE "billing" SET "paid_status" = TRUE WHERE "bill_id" = $1`
-	_, err = tx.Exec(updateBillSQL, billID)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to update bill status: "+err.Error())
-		return
-	}
-
-	// 3. Insert into payment table
-	// For ProcessedBy, using 0 as a placeholder for system/public payment.
-	// Adjust if you have a specific system user ID or if the column is nullable.
-	insertPaymentSQL := `INSERT INTO "payment" ("bill_id", "processed_by", "payment_date", "amount_paid", "payment_method", "payment_status")
-	                     VALUES ($1, $2, $3, $4, $5, $6) RETURNING "payment_id"`
-	paymentDate := time.Now().Format("2006-01-02")
-	var paymentID int
-
-	log.Printf("Attempting to insert payment with values: BillID=%d, ProcessedBy=nil, PaymentDate='%s', AmountPaid=%.2f, PaymentMethod='%s', PaymentStatus='Completed'",
-		billID,
-		paymentDate,
-		billToPay.AmountDue,
-		req.PaymentMethod)
-
-	err = tx.QueryRow(insertPaymentSQL, billID, 0, paymentDate, billToPay.AmountDue, req.PaymentMethod, "Completed").Scan(&paymentID)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to record payment: "+err.Error())
+	paymentID, err := paymentSvc.PayOutstanding(auditFor(r), billID, req.PaymentMethod)
+	if err == errBillAlreadyPaid {
+		respondWithJSON(w, http.StatusOK, map[string]string{"message": errBillAlreadyPaid.Message})
 		return
-	}
-
-	// Commit the transaction
-	if err := tx.Commit(); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to commit transaction: "+err.Error())
+	} else if err != nil {
+		respondWithServerError(w, "Failed to record payment", err)
 		return
 	}
-
-	log.Printf("Payment successful for Bill ID: %d, new Payment ID: %d", billID, paymentID)
 	respondWithJSON(w, http.StatusOK, map[string]interface{}{"message": "Payment successful!", "payment_id": paymentID})
 }
 
-func main() {
-	envJwtKey := os.Getenv("JWT_SECRET_KEY")
-	if envJwtKey != "" {
-		jwtKey = []byte(envJwtKey)
-		log.Println("JWT_SECRET_KEY loaded from environment variable.")
-	} else if os.Getenv("APP_ENV") != "production" {
-		log.Println("Warning: JWT_SECRET_KEY not set. Using default insecure key for development.")
-	}
-
+// newRouter registers every API route. Routes added here must also be
+// documented in apiOperations (openapi.go).
+func newRouter() *mux.Router {
 	router := mux.NewRouter()
+	router.Use(withRequestID)
 	router.Use(enableCORS) // Apply CORS to all routes
+	router.Use(deprecateV1(router))
+
+	// Version 2 of the API; registered first so that the /api subrouter below does not claim its paths
+	registerV2(router)
+
+	// OpenAPI document and documentation page (public)
+	router.HandleFunc("/openapi.json", getOpenAPI).Methods("GET", "OPTIONS")
+	router.HandleFunc("/docs", getAPIDocs).Methods("GET", "OPTIONS")
 
 	// Authentication route (not under /api, not typically auth-protected itself)
 	router.HandleFunc("/login", handleLogin).Methods("POST", "OPTIONS")
@@ -958,10 +859,16 @@ func main() {
 	// and importantly, it bypasses apiRouter's authMiddleware for this specific path.
 	router.HandleFunc("/api/public/customer-bills", getCustomerUnpaidBills).Methods("GET", "OPTIONS")
 	router.HandleFunc("/api/public/bills/{id}/pay", handlePayBillPublic).Methods("POST", "OPTIONS")
+	router.HandleFunc("/api/public/bills/{id}/pdf", getCustomerBillPDF).Methods("GET", "OPTIONS")
+	router.HandleFunc("/api/public/bills/{id}/qr", getCustomerBillQR).Methods("GET", "OPTIONS")
+	router.HandleFunc("/api/public/payments/{id}/receipt.pdf", getCustomerReceiptPDF).Methods("GET", "OPTIONS")
 
 	publicRouter := router.PathPrefix("/public").Subrouter()
 	publicRouter.HandleFunc("/customer-bills", getCustomerUnpaidBills).Methods("GET", "OPTIONS")
 	publicRouter.HandleFunc("/bills/{id}/pay", handlePayBillPublic).Methods("POST", "OPTIONS") // New route for paying a bill
+	publicRouter.HandleFunc("/bills/{id}/pdf", getCustomerBillPDF).Methods("GET", "OPTIONS")
+	publicRouter.HandleFunc("/bills/{id}/qr", getCustomerBillQR).Methods("GET", "OPTIONS")
+	publicRouter.HandleFunc("/payments/{id}/receipt.pdf", getCustomerReceiptPDF).Methods("GET", "OPTIONS")
 
 	// API Subrouter for all other authenticated CRUD operations
 	apiRouter := router.PathPrefix("/api").Subrouter()
@@ -972,28 +879,113 @@ func main() {
 	apiRouter.HandleFunc("/customers", createCustomer).Methods("POST", "OPTIONS")
 	apiRouter.HandleFunc("/customers/{id}", getCustomerByID).Methods("GET", "OPTIONS")
 	apiRouter.HandleFunc("/customers/{id}", updateCustomer).Methods("PUT", "OPTIONS")
+	apiRouter.HandleFunc("/customers/{id}", patchCustomer).Methods("PATCH", "OPTIONS")
 	apiRouter.HandleFunc("/customers/{id}", deleteCustomer).Methods("DELETE", "OPTIONS")
+	apiRouter.HandleFunc("/customers/{id}/history", historyHandler(customerSvc, "customer")).Methods("GET", "OPTIONS")
+	apiRouter.HandleFunc("/customers/{id}/ledger", getCustomerLedger).Methods("GET", "OPTIONS")
+	apiRouter.HandleFunc("/customers/{id}/statements", getCustomerStatement).Methods("GET", "OPTIONS")
+	apiRouter.HandleFunc("/statements", getStoredStatements).Methods("GET", "OPTIONS")
+	apiRouter.HandleFunc("/statements/month-end", runMonthEndStatements).Methods("POST", "OPTIONS")
 
 	// Meter Routes
 	apiRouter.HandleFunc("/meters", getMeters).Methods("GET", "OPTIONS")
 	apiRouter.HandleFunc("/meters", createMeter).Methods("POST", "OPTIONS")
 	apiRouter.HandleFunc("/meters/{id}", getMeterByID).Methods("GET", "OPTIONS")
 	apiRouter.HandleFunc("/meters/{id}", updateMeter).Methods("PUT", "OPTIONS")
+	apiRouter.HandleFunc("/meters/{id}", patchMeter).Methods("PATCH", "OPTIONS")
 	apiRouter.HandleFunc("/meters/{id}", deleteMeter).Methods("DELETE", "OPTIONS")
+	apiRouter.HandleFunc("/meters/{id}/history", historyHandler(meterSvc, "meter")).Methods("GET", "OPTIONS")
 
 	// Billing Routes
 	apiRouter.HandleFunc("/billing", getBillings).Methods("GET", "OPTIONS")
 	apiRouter.HandleFunc("/billing", createBilling).Methods("POST", "OPTIONS")
 	apiRouter.HandleFunc("/billing/{id}", getBillingByID).Methods("GET", "OPTIONS")
 	apiRouter.HandleFunc("/billing/{id}", updateBilling).Methods("PUT", "OPTIONS")
+	apiRouter.HandleFunc("/billing/{id}", patchBilling).Methods("PATCH", "OPTIONS")
 	apiRouter.HandleFunc("/billing/{id}", deleteBilling).Methods("DELETE", "OPTIONS")
+	apiRouter.HandleFunc("/billing/{id}/issue", changeBillStatus(BillStatusIssued)).Methods("POST", "OPTIONS")
+	apiRouter.HandleFunc("/billing/{id}/void", changeBillStatus(BillStatusVoid)).Methods("POST", "OPTIONS")
+	apiRouter.HandleFunc("/billing/{id}/write-off", changeBillStatus(BillStatusWrittenOff)).Methods("POST", "OPTIONS")
+	apiRouter.HandleFunc("/billing/{id}/reissue", reissueBilling).Methods("POST", "OPTIONS")
+	apiRouter.HandleFunc("/billing/{id}/history", historyHandler(billSvc, "bill")).Methods("GET", "OPTIONS")
+	apiRouter.HandleFunc("/billing/{id}/status-history", getBillStatusHistory).Methods("GET", "OPTIONS")
+	apiRouter.HandleFunc("/billing/{id}/pdf", getBillingPDF).Methods("GET", "OPTIONS")
+	apiRouter.HandleFunc("/billing/{id}/qr", getBillingQR).Methods("GET", "OPTIONS")
+	apiRouter.HandleFunc("/billing/{id}/notes", getBillingNotes).Methods("GET", "OPTIONS")
+	apiRouter.HandleFunc("/billing/{id}/credit-notes", createBillingNote(NoteTypeCredit)).Methods("POST", "OPTIONS")
+	apiRouter.HandleFunc("/billing/{id}/debit-notes", createBillingNote(NoteTypeDebit)).Methods("POST", "OPTIONS")
+	apiRouter.HandleFunc("/billing-notes/{id}", getBillingNoteByID).Methods("GET", "OPTIONS")
 
 	// Payment Routes
 	apiRouter.HandleFunc("/payments", getPayments).Methods("GET", "OPTIONS")
 	apiRouter.HandleFunc("/payments", createPayment).Methods("POST", "OPTIONS")
 	apiRouter.HandleFunc("/payments/{id}", getPaymentByID).Methods("GET", "OPTIONS")
 	apiRouter.HandleFunc("/payments/{id}", updatePayment).Methods("PUT", "OPTIONS")
+	apiRouter.HandleFunc("/payments/{id}", patchPayment).Methods("PATCH", "OPTIONS")
 	apiRouter.HandleFunc("/payments/{id}", deletePayment).Methods("DELETE", "OPTIONS")
+	apiRouter.HandleFunc("/payments/{id}/receipt.pdf", getPaymentReceiptPDF).Methods("GET", "OPTIONS")
+	apiRouter.HandleFunc("/payments/promptpay", reconcilePromptPayTransfer).Methods("POST", "OPTIONS")
+	apiRouter.HandleFunc("/inbound-transfers", getInboundTransfers).Methods("GET", "OPTIONS")
+	apiRouter.HandleFunc("/inbound-transfers/review", getReconciliationQueue).Methods("GET", "OPTIONS")
+	apiRouter.HandleFunc("/inbound-transfers/{id}/match", reviewInboundTransfer("match")).Methods("POST", "OPTIONS")
+	apiRouter.HandleFunc("/inbound-transfers/{id}/ignore", reviewInboundTransfer("ignore")).Methods("POST", "OPTIONS")
+	apiRouter.HandleFunc("/bank-statements", getBankStatementImports).Methods("GET", "OPTIONS")
+	apiRouter.HandleFunc("/bank-statements", uploadBankStatement).Methods("POST", "OPTIONS")
+	apiRouter.HandleFunc("/bank-statements/{id}/report", getBankStatementReport).Methods("GET", "OPTIONS")
+
+	// Report Routes
+	apiRouter.HandleFunc("/reports/tax", getTaxSummary).Methods("GET", "OPTIONS")
+	registerReports(apiRouter)
+
+	// Search Routes
+	apiRouter.HandleFunc("/search", search).Methods("GET", "OPTIONS")
+
+	// Audit Routes
+	apiRouter.HandleFunc("/audit", getAudit).Methods("GET", "OPTIONS")
+
+	return router
+}
+
+func main() {
+	envJwtKey := os.Getenv("JWT_SECRET_KEY")
+	if envJwtKey != "" {
+		jwtKey = []byte(envJwtKey)
+		log.Println("JWT_SECRET_KEY loaded from environment variable.")
+	} else if os.Getenv("APP_ENV") != "production" {
+		log.Println("Warning: JWT_SECRET_KEY not set. Using default insecure key for development.")
+	}
+	if err := loadTariffConfig(); err != nil {
+		log.Fatalf("Invalid tariff configuration: %v", err)
+	}
+	if err := loadDocumentNumberConfig(); err != nil {
+		log.Fatalf("Invalid document number configuration: %v", err)
+	}
+	if err := loadDocumentConfig(); err != nil {
+		log.Fatalf("Invalid PDF document configuration: %v", err)
+	}
+	if err := loadPromptPayConfig(); err != nil {
+		log.Fatalf("Invalid PromptPay configuration: %v", err)
+	}
+	if err := loadStatementConfig(); err != nil {
+		log.Fatalf("Invalid statement configuration: %v", err)
+	}
+	if err := loadAPIVersionConfig(); err != nil {
+		log.Fatalf("Invalid API version configuration: %v", err)
+	}
+	if len(os.Args) > 1 {
+		os.Exit(runCLI(os.Args[1:]))
+	}
+
+	router := newRouter()
+	if err := checkOpenAPIRoutes(router); err != nil {
+		log.Fatal(err)
+	}
+	connectDB()
+
+	startMonthEndStatements()
+	if err := markInterruptedImports(); err != nil {
+		log.Printf("Could not check for interrupted import jobs: %v", err)
+	}
 
 	fmt.Println("Server running at http://localhost:8080")
 	log.Fatal(http.ListenAndServe(":8080", router)) // Pass the main router
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// DefaultCurrency is the ISO 4217 code used when a row does not carry its own.
+const DefaultCurrency = "THB"
+
+// moneyScale is the number of decimal places kept for monetary amounts,
+// matching the DECIMAL(10, 2) columns in initialize_table.sql.
+const moneyScale = 2
+
+// --- Decimal ---
+
+// Decimal is an exact fixed-point number: coef * 10^-scale.
+// It is used for readings, units and rates so values read from NUMERIC
+// columns never pass through float64. A Decimal is immutable; every
+// operation returns a new value, so copies never share state.
+type Decimal struct {
+	coef  *big.Int // nil means zero
+	scale int32
+}
+
+// NewDecimal builds a Decimal from an integer coefficient and a scale,
+// e.g. NewDecimal(5025, 2) is 50.25.
+func NewDecimal(coef int64, scale int32) Decimal {
+	return Decimal{coef: big.NewInt(coef), scale: scale}
+}
+
+// ParseDecimal parses a plain decimal literal such as "-12.3400".
+// Exponent notation is not accepted.
+func ParseDecimal(s string) (Decimal, error) {
+	s = strings.TrimSpace(s)
+	digits := strings.TrimLeft(s, "+-")
+	if len(s)-len(digits) > 1 {
+		return Decimal{}, fmt.Errorf("invalid decimal value %q", s)
+	}
+	intPart, fracPart, _ := strings.Cut(digits, ".")
+	if intPart == "" && fracPart == "" {
+		return Decimal{}, fmt.Errorf("invalid decimal value %q", s)
+	}
+	for _, ch := range intPart + fracPart {
+		if ch < '0' || ch > '9' {
+			return Decimal{}, fmt.Errorf("invalid decimal value %q", s)
+		}
+	}
+	coef, ok := new(big.Int).SetString(intPart+fracPart, 10)
+	if !ok {
+		return Decimal{}, fmt.Errorf("invalid decimal value %q", s)
+	}
+	if strings.HasPrefix(s, "-") {
+		coef.Neg(coef)
+	}
+	return Decimal{coef: coef, scale: int32(len(fracPart))}, nil
+}
+
+// MustParseDecimal is like ParseDecimal but panics on error. Intended for constants.
+func MustParseDecimal(s string) Decimal {
+	d, err := ParseDecimal(s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// Scale returns the number of digits after the decimal point.
+func (d Decimal) Scale() int32 { return d.scale }
+
+// Sign returns -1, 0 or +1.
+func (d Decimal) Sign() int { return d.big().Sign() }
+
+// IsZero reports whether d == 0.
+func (d Decimal) IsZero() bool { return d.Sign() == 0 }
+
+func pow10(n int32) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+func (d Decimal) big() *big.Int {
+	if d.coef == nil {
+		return new(big.Int)
+	}
+	return d.coef
+}
+
+// rescaleUp returns the coefficient of d expressed at a larger scale without loss.
+func (d Decimal) rescaleUp(scale int32) *big.Int {
+	if scale <= d.scale {
+		return d.big()
+	}
+	return new(big.Int).Mul(d.big(), pow10(scale-d.scale))
+}
+
+// Round returns d rounded half-up (away from zero on a tie) to the given scale.
+// This is the rounding rule used for all monetary results.
+func (d Decimal) Round(scale int32) Decimal {
+	if scale >= d.scale {
+		return Decimal{coef: d.rescaleUp(scale), scale: scale}
+	}
+	div := pow10(d.scale - scale)
+	q, r := new(big.Int).QuoRem(new(big.Int).Abs(d.big()), div, new(big.Int))
+	if r.Lsh(r, 1).Cmp(div) >= 0 {
+		q.Add(q, big.NewInt(1))
+	}
+	if d.Sign() < 0 {
+		q.Neg(q)
+	}
+	return Decimal{coef: q, scale: scale}
+}
+
+// Add returns d + o at the larger of the two scales.
+func (d Decimal) Add(o Decimal) Decimal {
+	scale := max(d.scale, o.scale)
+	return Decimal{coef: new(big.Int).Add(d.rescaleUp(scale), o.rescaleUp(scale)), scale: scale}
+}
+
+// Sub returns d - o at the larger of the two scales.
+func (d Decimal) Sub(o Decimal) Decimal { return d.Add(o.Neg()) }
+
+// Neg returns -d.
+func (d Decimal) Neg() Decimal { return Decimal{coef: new(big.Int).Neg(d.big()), scale: d.scale} }
+
+// Mul returns the exact product d * o; its scale is the sum of both scales.
+func (d Decimal) Mul(o Decimal) Decimal {
+	return Decimal{coef: new(big.Int).Mul(d.big(), o.big()), scale: d.scale + o.scale}
+}
+
+// Quo returns d / o rounded half-up to the given scale. Dividing by zero yields zero.
+func (d Decimal) Quo(o Decimal, scale int32) Decimal {
+	if o.IsZero() {
+		return Decimal{scale: scale}
+	}
+	// Compute with one guard digit, then round.
+	num := new(big.Int).Mul(d.big(), pow10(scale+1+o.scale))
+	den := new(big.Int).Mul(o.big(), pow10(d.scale))
+	return Decimal{coef: num.Quo(num, den), scale: scale + 1}.Round(scale)
+}
+
+// Cmp compares d and o and returns -1, 0 or +1.
+func (d Decimal) Cmp(o Decimal) int {
+	scale := max(d.scale, o.scale)
+	return d.rescaleUp(scale).Cmp(o.rescaleUp(scale))
+}
+
+// String formats d with exactly Scale() fractional digits.
+func (d Decimal) String() string {
+	abs := new(big.Int).Abs(d.big()).String()
+	sign := ""
+	if d.Sign() < 0 {
+		sign = "-"
+	}
+	if d.scale <= 0 {
+		return sign + abs
+	}
+	if pad := int(d.scale) + 1 - len(abs); pad > 0 {
+		abs = strings.Repeat("0", pad) + abs
+	}
+	cut := len(abs) - int(d.scale)
+	return sign + abs[:cut] + "." + abs[cut:]
+}
+
+// StringFixed formats d rounded half-up to the given scale.
+func (d Decimal) StringFixed(scale int32) string {
+	return d.Round(scale).String()
+}
+
+// Float64 returns an approximate float64, only for presentation such as charts.
+func (d Decimal) Float64() float64 {
+	f, _ := new(big.Rat).SetFrac(d.big(), pow10(d.scale)).Float64()
+	return f
+}
+
+// MarshalJSON encodes d as a JSON string so clients never see a rounded float.
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON accepts a JSON string ("12.50") or a bare JSON number (12.5).
+// The literal text is parsed directly, never via float64. null leaves d at zero.
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		*d = Decimal{}
+		return nil
+	}
+	s := string(data)
+	if len(data) > 0 && data[0] == '"' {
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+	}
+	parsed, err := ParseDecimal(s)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+// Scan implements sql.Scanner. lib/pq returns NUMERIC columns as []byte text.
+func (d *Decimal) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*d = Decimal{}
+		return nil
+	case []byte:
+		parsed, err := ParseDecimal(string(v))
+		if err != nil {
+			return err
+		}
+		*d = parsed
+		return nil
+	case string:
+		parsed, err := ParseDecimal(v)
+		if err != nil {
+			return err
+		}
+		*d = parsed
+		return nil
+	case int64:
+		*d = NewDecimal(v, 0)
+		return nil
+	}
+	return fmt.Errorf("cannot scan %T into Decimal", src)
+}
+
+// Value implements driver.Valuer, sending the exact text to PostgreSQL.
+func (d Decimal) Value() (driver.Value, error) {
+	return d.String(), nil
+}
+
+// --- Money ---
+
+// Money is an exact monetary amount held at two decimal places (satang for THB).
+// The currency code travels alongside it in the owning struct's Currency field.
+type Money struct {
+	d Decimal
+}
+
+// MoneyFromDecimal rounds d half-up to two places.
+func MoneyFromDecimal(d Decimal) Money {
+	return Money{d: d.Round(moneyScale)}
+}
+
+// ParseMoney parses an amount such as "1250.5", rounding half-up to two places.
+func ParseMoney(s string) (Money, error) {
+	d, err := ParseDecimal(s)
+	if err != nil {
+		return Money{}, err
+	}
+	return MoneyFromDecimal(d), nil
+}
+
+// MoneyFromSatang builds a Money from an amount in minor units.
+func MoneyFromSatang(satang int64) Money {
+	return Money{d: NewDecimal(satang, moneyScale)}
+}
+
+// norm guards against the zero value, whose scale is 0.
+func (m Money) norm() Decimal {
+	if m.d.scale != moneyScale {
+		return m.d.Round(moneyScale)
+	}
+	return m.d
+}
+
+// Decimal returns the amount as a Decimal with scale 2.
+func (m Money) Decimal() Decimal { return m.norm() }
+
+// Add returns m + o.
+func (m Money) Add(o Money) Money { return Money{d: m.norm().Add(o.norm())} }
+
+// Sub returns m - o.
+func (m Money) Sub(o Money) Money { return Money{d: m.norm().Sub(o.norm())} }
+
+// Neg returns -m.
+func (m Money) Neg() Money { return Money{d: m.norm().Neg()} }
+
+// MulRate multiplies m by an arbitrary-precision rate and rounds half-up to two places.
+func (m Money) MulRate(rate Decimal) Money { return MoneyFromDecimal(m.norm().Mul(rate)) }
+
+// Cmp compares m and o and returns -1, 0 or +1.
+func (m Money) Cmp(o Money) int { return m.norm().Cmp(o.norm()) }
+
+// IsZero reports whether m == 0.
+func (m Money) IsZero() bool { return m.d.IsZero() }
+
+// Sign returns -1, 0 or +1.
+func (m Money) Sign() int { return m.d.Sign() }
+
+// String formats m with exactly two decimal places, e.g. "60.25".
+func (m Money) String() string { return m.norm().String() }
+
+// Format renders m with its currency code for human-readable output, e.g. "60.25 THB".
+func (m Money) Format(currency string) string {
+	if currency == "" {
+		currency = DefaultCurrency
+	}
+	return m.String() + " " + currency
+}
+
+// MarshalJSON encodes m as a fixed-scale JSON string.
+func (m Money) MarshalJSON() ([]byte, error) { return json.Marshal(m.String()) }
+
+// UnmarshalJSON accepts a string or number and rounds half-up to two places.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var d Decimal
+	if err := d.UnmarshalJSON(data); err != nil {
+		return err
+	}
+	*m = MoneyFromDecimal(d)
+	return nil
+}
+
+// Scan implements sql.Scanner.
+func (m *Money) Scan(src interface{}) error {
+	var d Decimal
+	if err := d.Scan(src); err != nil {
+		return err
+	}
+	*m = MoneyFromDecimal(d)
+	return nil
+}
+
+// Value implements driver.Valuer.
+func (m Money) Value() (driver.Value, error) { return m.String(), nil }
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestParseDecimal(t *testing.T) {
+	tests := []struct {
+		in    string
+		want  string
+		scale int32
+	}{
+		{"0", "0", 0},
+		{"12.3400", "12.3400", 4},
+		{"-12.34", "-12.34", 2},
+		{"+7", "7", 0},
+		{" 1.5 ", "1.5", 1},
+		{".5", "0.5", 1},
+		{"-.05", "-0.05", 2},
+		{"5.", "5", 0},
+		{"-0.00", "0.00", 2},
+		{"123456789012345678901234567890.123456789", "123456789012345678901234567890.123456789", 9},
+	}
+	for _, tt := range tests {
+		d, err := ParseDecimal(tt.in)
+		if err != nil {
+			t.Errorf("ParseDecimal(%q): %v", tt.in, err)
+			continue
+		}
+		if d.String() != tt.want || d.Scale() != tt.scale {
+			t.Errorf("ParseDecimal(%q) = %s (scale %d), want %s (scale %d)", tt.in, d, d.Scale(), tt.want, tt.scale)
+		}
+	}
+}
+
+func TestParseDecimalInvalid(t *testing.T) {
+	for _, in := range []string{"", "-", ".", "+-1", "--1", "1e5", "1.2.3", "12a", "1,000.00", "NaN"} {
+		if d, err := ParseDecimal(in); err == nil {
+			t.Errorf("ParseDecimal(%q) = %s, want an error", in, d)
+		}
+	}
+}
+
+func TestDecimalRound(t *testing.T) {
+	tests := []struct {
+		in    string
+		scale int32
+		want  string
+	}{
+		{"1.005", 2, "1.01"},
+		{"1.004", 2, "1.00"},
+		{"-1.005", 2, "-1.01"}, // Ties go away from zero
+		{"-1.004", 2, "-1.00"},
+		{"0.125", 2, "0.13"},
+		{"0.135", 2, "0.14"},
+		{"2.5", 0, "3"},
+		{"-2.5", 0, "-3"},
+		{"-0.004", 2, "0.00"},
+		{"9.995", 2, "10.00"},
+		{"0.4999999999999999999999", 0, "0"},
+		{"1.5", 4, "1.5000"},
+		{"-3", 2, "-3.00"},
+	}
+	for _, tt := range tests {
+		got := MustParseDecimal(tt.in).Round(tt.scale)
+		if got.String() != tt.want || got.Scale() != tt.scale {
+			t.Errorf("Round(%s, %d) = %s (scale %d), want %s", tt.in, tt.scale, got, got.Scale(), tt.want)
+		}
+	}
+}
+
+func TestDecimalArithmetic(t *testing.T) {
+	a, b := MustParseDecimal("10.25"), MustParseDecimal("-0.125")
+	if got := a.Add(b).String(); got != "10.125" {
+		t.Errorf("Add = %s, want 10.125", got)
+	}
+	if got := a.Sub(b).String(); got != "10.375" {
+		t.Errorf("Sub = %s, want 10.375", got)
+	}
+	if got := a.Mul(b).String(); got != "-1.28125" {
+		t.Errorf("Mul = %s, want -1.28125", got)
+	}
+	// Scales add up under Mul without losing digits.
+	large := MustParseDecimal("99999999999999999999.9999999999")
+	if got := large.Mul(large).Round(2).String(); got != "9999999999999999999999999999980000000000.00" {
+		t.Errorf("Mul of large values = %s", got)
+	}
+	if a.Cmp(MustParseDecimal("10.2500")) != 0 || b.Cmp(a) >= 0 {
+		t.Error("Cmp ignores scale differences incorrectly")
+	}
+	var zero Decimal
+	if !zero.IsZero() || zero.String() != "0" || zero.Add(a).String() != "10.25" {
+		t.Errorf("zero value misbehaves: %s", zero.Add(a))
+	}
+}
+
+func TestDecimalQuo(t *testing.T) {
+	tests := []struct {
+		num, den string
+		scale    int32
+		want     string
+	}{
+		{"1", "3", 2, "0.33"},
+		{"2", "3", 2, "0.67"},
+		{"-2", "3", 2, "-0.67"},
+		{"2", "-3", 2, "-0.67"},
+		{"1", "8", 2, "0.13"},   // 0.125, a tie
+		{"-1", "8", 2, "-0.13"}, // Ties go away from zero
+		{"1", "16", 3, "0.063"},
+		{"0.0049999", "1", 2, "0.00"}, // The guard digit must not round twice
+		{"10.00", "0.04", 0, "250"},
+		{"7", "0", 2, "0.00"},
+	}
+	for _, tt := range tests {
+		got := MustParseDecimal(tt.num).Quo(MustParseDecimal(tt.den), tt.scale)
+		if got.String() != tt.want {
+			t.Errorf("%s / %s at scale %d = %s, want %s", tt.num, tt.den, tt.scale, got, tt.want)
+		}
+	}
+}
+
+func TestDecimalJSON(t *testing.T) {
+	for _, in := range []string{`"12.50"`, `12.50`, `"-0.001"`, `"123456789012345678901234567890.99"`} {
+		var d Decimal
+		if err := json.Unmarshal([]byte(in), &d); err != nil {
+			t.Errorf("Unmarshal(%s): %v", in, err)
+			continue
+		}
+		out, err := json.Marshal(d)
+		if err != nil {
+			t.Errorf("Marshal(%s): %v", d, err)
+			continue
+		}
+		if want := `"` + strings.Trim(in, `"`) + `"`; string(out) != want {
+			t.Errorf("round trip of %s = %s, want %s", in, out, want)
+		}
+	}
+	var d Decimal
+	if err := json.Unmarshal([]byte(`1e3`), &d); err == nil {
+		t.Errorf("Unmarshal(1e3) = %s, want an error", d)
+	}
+	d = MustParseDecimal("5")
+	if err := json.Unmarshal([]byte(`null`), &d); err != nil || !d.IsZero() {
+		t.Errorf("Unmarshal(null) = %s, %v; want zero", d, err)
+	}
+}
+
+func TestMoney(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"1250.5", "1250.50"},
+		{"0.005", "0.01"},
+		{"-0.005", "-0.01"},
+		{"60.244", "60.24"},
+		{"60.245", "60.25"},
+		{"7", "7.00"},
+	}
+	for _, tt := range tests {
+		m, err := ParseMoney(tt.in)
+		if err != nil {
+			t.Errorf("ParseMoney(%q): %v", tt.in, err)
+			continue
+		}
+		if m.String() != tt.want {
+			t.Errorf("ParseMoney(%q) = %s, want %s", tt.in, m, tt.want)
+		}
+	}
+
+	var zero Money
+	if zero.String() != "0.00" || zero.Format("") != "0.00 THB" {
+		t.Errorf("zero Money = %q, %q", zero.String(), zero.Format(""))
+	}
+	if got := MoneyFromSatang(-6025).String(); got != "-60.25" {
+		t.Errorf("MoneyFromSatang(-6025) = %s", got)
+	}
+	// 1000.00 * 0.07 is exact; 38.22 * 0.3972 = 15.180984 rounds down.
+	if got := MoneyFromSatang(100000).MulRate(MustParseDecimal("0.07")).String(); got != "70.00" {
+		t.Errorf("MulRate = %s, want 70.00", got)
+	}
+	if got := mustParseMoney(t, "38.22").MulRate(MustParseDecimal("0.3972")).String(); got != "15.18" {
+		t.Errorf("MulRate = %s, want 15.18", got)
+	}
+	if got := mustParseMoney(t, "0.10").Add(mustParseMoney(t, "0.20")).Sub(mustParseMoney(t, "0.30")); !got.IsZero() {
+		t.Errorf("0.10 + 0.20 - 0.30 = %s, want 0.00", got)
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	var m Money
+	if err := json.Unmarshal([]byte(`60.245`), &m); err != nil {
+		t.Fatal(err)
+	}
+	out, _ := json.Marshal(m)
+	if string(out) != `"60.25"` {
+		t.Errorf("Marshal = %s, want \"60.25\"", out)
+	}
+	var back Money
+	if err := json.Unmarshal(out, &back); err != nil || back.Cmp(m) != 0 {
+		t.Errorf("round trip = %s, %v; want %s", back, err, m)
+	}
+	out, _ = json.Marshal(struct {
+		Amount Money `json:"amount"`
+	}{})
+	if string(out) != `{"amount":"0.00"}` {
+		t.Errorf("zero Money marshals as %s", out)
+	}
+}
+
+func mustParseMoney(t *testing.T, s string) Money {
+	t.Helper()
+	m, err := ParseMoney(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
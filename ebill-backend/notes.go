@@ -0,0 +1,321 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Note types, matching the CHECK constraint on billing_note.Note_Type.
+// A credit note reduces what the customer owes on a bill; a debit note increases it.
+const (
+	NoteTypeCredit = "credit"
+	NoteTypeDebit  = "debit"
+)
+
+// noteNumberFormats maps a note type to its document number series.
+var noteNumberFormats = map[string]*DocumentNumberFormat{
+	NoteTypeCredit: &creditNoteFormat,
+	NoteTypeDebit:  &debitNoteFormat,
+}
+
+// BillingNote is a credit or debit note correcting an issued bill.
+type BillingNote struct {
+	NoteID     int            `json:"Note_ID"`
+	NoteNumber string         `json:"Note_Number"`
+	NoteType   string         `json:"Note_Type"`
+	BillID     int            `json:"Bill_ID"`
+	CustomerID int            `json:"Customer_ID"`
+	NoteDate   string         `json:"Note_Date"`
+	Reason     string         `json:"Reason"`
+	Amount     Money          `json:"Amount"` // Always positive; the type gives the direction
+	Currency   string         `json:"Currency"`
+	CreatedBy  *int           `json:"Created_By"`
+	LineItems  []NoteLineItem `json:"Line_Items"`
+	ApplyVAT   bool           `json:"Apply_VAT,omitempty"` // Request only: add VAT on top of the lines
+}
+
+// NoteLineItem is one line of a credit or debit note.
+type NoteLineItem struct {
+	NoteLineID  int     `json:"Note_Line_ID"`
+	NoteID      int     `json:"Note_ID"`
+	Description string  `json:"Description"`
+	Quantity    Decimal `json:"Quantity"`
+	UnitPrice   Decimal `json:"Unit_Price"`
+	Amount      Money   `json:"Amount"`
+}
+
+// createBillingNote returns a handler that raises a note of the given type
+// against the bill in the path.
+func createBillingNote(noteType string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		billID, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid Bill ID")
+			return
+		}
+		log.Printf("API: createBillingNote called for Bill ID: %d (%s)", billID, noteType)
+
+		var n BillingNote
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+			return
+		}
+		if n.Reason == "" {
+			respondWithError(w, http.StatusBadRequest, "Reason is required for a "+noteType+" note")
+			return
+		}
+		if len(n.LineItems) == 0 {
+			respondWithError(w, http.StatusBadRequest, "At least one line item is required")
+			return
+		}
+		n.NoteType = noteType
+		n.BillID = billID
+		n.CreatedBy = currentUserID(r)
+		if n.NoteDate == "" {
+			n.NoteDate = time.Now().Format("2006-01-02")
+		}
+
+		// Price the lines; VAT, if requested, is added as a final line.
+		subtotal := Money{}
+		for i := range n.LineItems {
+			li := &n.LineItems[i]
+			if li.Description == "" {
+				respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Line item %d: Description is required", i+1))
+				return
+			}
+			if li.Quantity.IsZero() {
+				li.Quantity = NewDecimal(1, 0)
+			}
+			li.Amount = MoneyFromDecimal(li.Quantity.Mul(li.UnitPrice))
+			if li.Amount.Sign() <= 0 {
+				respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Line item %d: amount must be positive", i+1))
+				return
+			}
+			subtotal = subtotal.Add(li.Amount)
+		}
+		if n.ApplyVAT {
+			n.LineItems = append(n.LineItems, NoteLineItem{
+				Description: "VAT", Quantity: subtotal.Decimal(), UnitPrice: tariff.VATRate, Amount: subtotal.MulRate(tariff.VATRate),
+			})
+		}
+		n.Amount = Money{}
+		for _, li := range n.LineItems {
+			n.Amount = n.Amount.Add(li.Amount)
+		}
+
+		tx, err := auditFor(r).begin()
+		if err != nil {
+			respondWithServerError(w, "Failed to start database transaction", err)
+			return
+		}
+		defer tx.Rollback()
+
+		// Lock the bill so the balance check below cannot race with payments or other notes.
+		var status string
+		var amountDue Money
+		err = tx.QueryRow(`SELECT "customer_id", "currency", "bill_status", "amount_due" FROM "billing" WHERE "bill_id" = $1 FOR UPDATE`,
+			billID).Scan(&n.CustomerID, &n.Currency, &status, &amountDue)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				respondWithError(w, http.StatusNotFound, "Bill not found")
+				return
+			}
+			respondWithServerError(w, "Failed to retrieve bill", err)
+			return
+		}
+		switch status {
+		case BillStatusIssued, BillStatusPartiallyPaid, BillStatusPaid:
+		default:
+			respondWithError(w, http.StatusConflict, "Notes can only be raised against issued bills; this bill is "+status)
+			return
+		}
+		if noteType == NoteTypeCredit {
+			var adjustments Money
+			err = tx.QueryRow(`SELECT COALESCE(SUM(CASE "note_type" WHEN 'debit' THEN "amount" ELSE -"amount" END), 0)
+			                   FROM "billing_note" WHERE "bill_id" = $1`, billID).Scan(&adjustments)
+			if err != nil {
+				respondWithServerError(w, "Failed to retrieve existing notes", err)
+				return
+			}
+			if n.Amount.Cmp(amountDue.Add(adjustments)) > 0 {
+				respondWithError(w, http.StatusUnprocessableEntity, "Credit note of "+n.Amount.Format(n.Currency)+
+					" exceeds the bill's net amount of "+amountDue.Add(adjustments).Format(n.Currency))
+				return
+			}
+		}
+
+		n.NoteNumber, err = noteNumberFormats[noteType].Next(tx, time.Now())
+		if err != nil {
+			respondWithServerError(w, "Failed to allocate note number", err)
+			return
+		}
+		err = tx.QueryRow(`INSERT INTO "billing_note" ("note_number", "note_type", "bill_id", "customer_id", "note_date", "reason", "amount", "currency", "created_by")
+		                   VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING "note_id"`,
+			n.NoteNumber, n.NoteType, n.BillID, n.CustomerID, n.NoteDate, n.Reason, n.Amount, n.Currency, n.CreatedBy).Scan(&n.NoteID)
+		if err != nil {
+			respondWithServerError(w, "Failed to create note", err)
+			return
+		}
+		for i := range n.LineItems {
+			li := &n.LineItems[i]
+			li.NoteID = n.NoteID
+			err = tx.QueryRow(`INSERT INTO "billing_note_line_item" ("note_id", "description", "quantity", "unit_price", "amount")
+			                   VALUES ($1, $2, $3, $4, $5) RETURNING "note_line_id"`,
+				n.NoteID, li.Description, li.Quantity, li.UnitPrice, li.Amount).Scan(&li.NoteLineID)
+			if err != nil {
+				respondWithServerError(w, "Failed to create note line item", err)
+				return
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			respondWithServerError(w, "Failed to commit transaction", err)
+			return
+		}
+		n.ApplyVAT = false
+		respondWithJSON(w, http.StatusCreated, n)
+	}
+}
+
+const billingNoteColumns = `"note_id", "note_number", "note_type", "bill_id", "customer_id", "note_date", "reason", "amount", "currency", "created_by"`
+
+func scanBillingNote(row rowScanner, n *BillingNote) error {
+	return row.Scan(&n.NoteID, &n.NoteNumber, &n.NoteType, &n.BillID, &n.CustomerID, &n.NoteDate, &n.Reason, &n.Amount, &n.Currency, &n.CreatedBy)
+}
+
+// getBillingNotes lists the credit and debit notes raised against a bill.
+func getBillingNotes(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	billID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Bill ID")
+		return
+	}
+	log.Printf("API: getBillingNotes called for Bill ID: %d", billID)
+	rows, err := db.Query(`SELECT `+billingNoteColumns+` FROM "billing_note" WHERE "bill_id" = $1 ORDER BY "note_id" ASC`, billID)
+	if err != nil {
+		respondWithServerError(w, "Failed to retrieve notes", err)
+		return
+	}
+	defer rows.Close()
+	notes := []BillingNote{}
+	for rows.Next() {
+		var n BillingNote
+		if err := scanBillingNote(rows, &n); err != nil {
+			respondWithServerError(w, "Error scanning note data", err)
+			return
+		}
+		notes = append(notes, n)
+	}
+	if err = rows.Err(); err != nil {
+		respondWithServerError(w, "Error iterating note rows", err)
+		return
+	}
+	for i := range notes {
+		if notes[i].LineItems, err = getNoteLineItems(notes[i].NoteID); err != nil {
+			respondWithServerError(w, "Failed to retrieve note line items", err)
+			return
+		}
+	}
+	respondWithJSON(w, http.StatusOK, notes)
+}
+
+// getBillingNoteByID returns one note with its line items.
+func getBillingNoteByID(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Note ID")
+		return
+	}
+	log.Printf("API: getBillingNoteByID called for ID: %d", id)
+	var n BillingNote
+	err = scanBillingNote(db.QueryRow(`SELECT `+billingNoteColumns+` FROM "billing_note" WHERE "note_id" = $1`, id), &n)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, http.StatusNotFound, "Note not found")
+		} else {
+			respondWithServerError(w, "Failed to retrieve note", err)
+		}
+		return
+	}
+	if n.LineItems, err = getNoteLineItems(n.NoteID); err != nil {
+		respondWithServerError(w, "Failed to retrieve note line items", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, n)
+}
+
+func getNoteLineItems(noteID int) ([]NoteLineItem, error) {
+	rows, err := db.Query(`SELECT "note_line_id", "note_id", "description", "quantity", "unit_price", "amount"
+	                        FROM "billing_note_line_item" WHERE "note_id" = $1 ORDER BY "note_line_id" ASC`, noteID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []NoteLineItem{}
+	for rows.Next() {
+		var li NoteLineItem
+		if err := rows.Scan(&li.NoteLineID, &li.NoteID, &li.Description, &li.Quantity, &li.UnitPrice, &li.Amount); err != nil {
+			return nil, err
+		}
+		items = append(items, li)
+	}
+	return items, rows.Err()
+}
+
+// LedgerEntry is one row of the customer_ledger view. Debits increase what the
+// customer owes (bills, debit notes); credits reduce it (payments, credit notes).
+type LedgerEntry struct {
+	EntryDate   string `json:"Entry_Date"`
+	EntryType   string `json:"Entry_Type"` // bill, payment, credit_note or debit_note
+	Reference   string `json:"Reference"`
+	BillID      *int   `json:"Bill_ID"`
+	Description string `json:"Description"`
+	Debit       Money  `json:"Debit"`
+	Credit      Money  `json:"Credit"`
+	Balance     Money  `json:"Balance"` // Running balance after this entry
+	Currency    string `json:"Currency"`
+}
+
+// getCustomerLedger returns every financial movement for a customer with a running balance.
+func getCustomerLedger(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	customerID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Customer ID")
+		return
+	}
+	log.Printf("API: getCustomerLedger called for Customer ID: %d", customerID)
+	rows, err := db.Query(`SELECT "entry_date", "entry_type", "reference", "bill_id", "description", "debit", "credit", "currency"
+	                        FROM "customer_ledger" WHERE "customer_id" = $1 ORDER BY "entry_date" ASC, "entry_order" ASC, "reference" ASC`, customerID)
+	if err != nil {
+		respondWithServerError(w, "Failed to retrieve ledger", err)
+		return
+	}
+	defer rows.Close()
+	entries := []LedgerEntry{}
+	balance := Money{}
+	for rows.Next() {
+		var e LedgerEntry
+		if err := rows.Scan(&e.EntryDate, &e.EntryType, &e.Reference, &e.BillID, &e.Description, &e.Debit, &e.Credit, &e.Currency); err != nil {
+			respondWithServerError(w, "Error scanning ledger data", err)
+			return
+		}
+		balance = balance.Add(e.Debit).Sub(e.Credit)
+		e.Balance = balance
+		entries = append(entries, e)
+	}
+	if err = rows.Err(); err != nil {
+		respondWithServerError(w, "Error iterating ledger rows", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{"Customer_ID": customerID, "Entries": entries, "Balance": balance})
+}
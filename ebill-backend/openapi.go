@@ -0,0 +1,817 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// The OpenAPI 3 document served at /openapi.json is generated from
+// apiOperations below and from the Go types the handlers decode and encode,
+// so field names, formats and validation rules cannot drift from the code.
+// Every route registered in newRouter must have an entry here and vice versa;
+// checkOpenAPIRoutes enforces that at startup, in the "openapi" command and in
+// TestOpenAPIMatchesRoutes.
+
+// openAPISchema is a literal schema, for bodies that have no Go type.
+type openAPISchema map[string]interface{}
+
+type apiParam struct {
+	Name        string
+	In          string // query (default) or header
+	Type        string // string (default), integer, boolean
+	Format      string
+	Description string
+	Required    bool
+	Enum        []string
+}
+
+// apiOperation documents one method on one route.
+type apiOperation struct {
+	Method       string
+	Path         string // mux path template
+	Tag          string
+	Summary      string
+	Public       bool // No bearer token required
+	Params       []apiParam
+	Body         interface{} // Request body: a value of the decoded type, or an openAPISchema
+	BodyType     string      // Request media type; application/json by default
+	OptionalBody bool
+	Status       int         // Success status; 200 by default
+	Response     interface{} // Success body, as for Body; nil for none
+	Produces     []string    // Further media types the success response may have
+	List         *listResource
+	Conditional  bool // Single resource with an ETag: If-None-Match on GET, If-Match required otherwise
+}
+
+func queryParam(name, typ, description string) apiParam {
+	return apiParam{Name: name, Type: typ, Description: description}
+}
+
+var (
+	identifierParam = apiParam{Name: "identifier", Required: true,
+		Description: "The customer's email or phone number, proving the bill or payment belongs to them"}
+	monthParam = apiParam{Name: "month", Format: "YYYY-MM", Description: "Calendar month"}
+)
+
+var messageSchema = openAPISchema{"type": "object", "properties": map[string]interface{}{"message": map[string]interface{}{"type": "string"}}}
+
+var apiOperations = joinOperations([]apiOperation{
+	// Authentication and users
+	{Method: "POST", Path: "/login", Tag: "Authentication", Summary: "Exchange a username and password for a JWT", Public: true,
+		Body: Credentials{}, Response: openAPISchema{"type": "object", "properties": map[string]interface{}{
+			"token": map[string]interface{}{"type": "string"}, "username": map[string]interface{}{"type": "string"},
+			"permission": map[string]interface{}{"type": "string", "enum": []string{"administrator", "operator", "customer", "viewer"}}}}},
+	{Method: "GET", Path: "/users", Tag: "Users", Summary: "List users", List: &userList, Response: User{}},
+
+	// Documentation
+	{Method: "GET", Path: "/openapi.json", Tag: "Documentation", Summary: "This OpenAPI document", Public: true,
+		Response: openAPISchema{"type": "object"}},
+	{Method: "GET", Path: "/docs", Tag: "Documentation", Summary: "API documentation page", Public: true, Produces: []string{"text/html"}},
+
+	// Customer portal, available both under /api/public and /public
+	{Method: "GET", Path: "/api/public/customer-bills", Tag: "Customer portal", Summary: "Unpaid bills of the customer with this email or phone number",
+		Public: true, Params: []apiParam{identifierParam}, Response: []Billing{}},
+	{Method: "POST", Path: "/api/public/bills/{id}/pay", Tag: "Customer portal", Summary: "Pay a bill's outstanding balance", Public: true,
+		Body: PublicPaymentRequest{}, Response: openAPISchema{"type": "object", "properties": map[string]interface{}{
+			"message": map[string]interface{}{"type": "string"}, "payment_id": map[string]interface{}{"type": "integer"}}}},
+	{Method: "GET", Path: "/api/public/bills/{id}/pdf", Tag: "Customer portal", Summary: "Invoice PDF", Public: true,
+		Params: []apiParam{identifierParam}, Produces: []string{"application/pdf"}},
+	{Method: "GET", Path: "/api/public/bills/{id}/qr", Tag: "Customer portal", Summary: "PromptPay QR code for the outstanding balance", Public: true,
+		Params: []apiParam{identifierParam, {Name: "format", Enum: []string{"json", "png"}}}, Response: PromptPayQR{}, Produces: []string{"image/png"}},
+	{Method: "GET", Path: "/api/public/payments/{id}/receipt.pdf", Tag: "Customer portal", Summary: "Payment receipt PDF", Public: true,
+		Params: []apiParam{identifierParam}, Produces: []string{"application/pdf"}},
+	{Method: "GET", Path: "/public/customer-bills", Tag: "Customer portal", Summary: "Same as /api/public/customer-bills", Public: true,
+		Params: []apiParam{identifierParam}, Response: []Billing{}},
+	{Method: "POST", Path: "/public/bills/{id}/pay", Tag: "Customer portal", Summary: "Same as /api/public/bills/{id}/pay", Public: true,
+		Body: PublicPaymentRequest{}, Response: openAPISchema{"type": "object"}},
+	{Method: "GET", Path: "/public/bills/{id}/pdf", Tag: "Customer portal", Summary: "Same as /api/public/bills/{id}/pdf", Public: true,
+		Params: []apiParam{identifierParam}, Produces: []string{"application/pdf"}},
+	{Method: "GET", Path: "/public/bills/{id}/qr", Tag: "Customer portal", Summary: "Same as /api/public/bills/{id}/qr", Public: true,
+		Params: []apiParam{identifierParam, {Name: "format", Enum: []string{"json", "png"}}}, Response: PromptPayQR{}, Produces: []string{"image/png"}},
+	{Method: "GET", Path: "/public/payments/{id}/receipt.pdf", Tag: "Customer portal", Summary: "Same as /api/public/payments/{id}/receipt.pdf", Public: true,
+		Params: []apiParam{identifierParam}, Produces: []string{"application/pdf"}},
+
+	// Customers
+	{Method: "GET", Path: "/api/customers", Tag: "Customers", Summary: "List customers", List: &customerList, Response: Customer{}},
+	{Method: "POST", Path: "/api/customers", Tag: "Customers", Summary: "Create a customer", Body: Customer{}, Status: http.StatusCreated, Response: Customer{}},
+	{Method: "GET", Path: "/api/customers/{id}", Tag: "Customers", Summary: "Get a customer", Params: []apiParam{asOfParam("customer")},
+		Response: Customer{}, Conditional: true},
+	{Method: "PUT", Path: "/api/customers/{id}", Tag: "Customers", Summary: "Replace a customer", Body: Customer{}, Response: Customer{}, Conditional: true},
+	{Method: "PATCH", Path: "/api/customers/{id}", Tag: "Customers", Summary: "Update a customer with a JSON Merge Patch",
+		Body: Customer{}, BodyType: mergePatchContentType, Response: Customer{}, Conditional: true},
+	{Method: "DELETE", Path: "/api/customers/{id}", Tag: "Customers", Summary: "Delete a customer", Response: messageSchema, Conditional: true},
+	{Method: "GET", Path: "/api/customers/{id}/history", Tag: "Customers", Summary: "Versions of the customer, oldest first",
+		List: &customerHistoryList, Response: RecordVersion[Customer]{}},
+	{Method: "GET", Path: "/api/customers/{id}/ledger", Tag: "Customers", Summary: "Every financial movement with a running balance",
+		Response: openAPISchema{"type": "object", "properties": map[string]interface{}{
+			"Customer_ID": map[string]interface{}{"type": "integer"}, "Entries": map[string]interface{}{"type": "array", "items": schemaRef("LedgerEntry")},
+			"Balance": schemaRef("Money")}}},
+	{Method: "GET", Path: "/api/customers/{id}/statements", Tag: "Statements", Summary: "Statement of account for a period",
+		Params: []apiParam{monthParam, {Name: "from", Format: "date"}, {Name: "to", Format: "date"},
+			{Name: "format", Enum: []string{StatementOutputJSON, StatementOutputCSV, StatementOutputPDF}}, queryParam("currency", "string", "Defaults to the customer's billing currency")},
+		Response: CustomerStatement{}, Produces: []string{"text/csv", "application/pdf"}},
+	{Method: "GET", Path: "/api/statements", Tag: "Statements", Summary: "Stored month-end statements",
+		Params: []apiParam{monthParam, queryParam("customer_id", "integer", "")}, Response: []StoredStatement{}},
+	{Method: "POST", Path: "/api/statements/month-end", Tag: "Statements", Summary: "Generate and store every customer's statement for a month",
+		Params: []apiParam{monthParam}, Response: StatementRun{}},
+
+	// Meters
+	{Method: "GET", Path: "/api/meters", Tag: "Meters", Summary: "List meters", List: &meterList, Response: Meter{}},
+	{Method: "POST", Path: "/api/meters", Tag: "Meters", Summary: "Create a meter", Body: Meter{}, Status: http.StatusCreated, Response: Meter{}},
+	{Method: "GET", Path: "/api/meters/{id}", Tag: "Meters", Summary: "Get a meter", Params: []apiParam{asOfParam("meter")},
+		Response: Meter{}, Conditional: true},
+	{Method: "PUT", Path: "/api/meters/{id}", Tag: "Meters", Summary: "Replace a meter", Body: Meter{}, Response: Meter{}, Conditional: true},
+	{Method: "PATCH", Path: "/api/meters/{id}", Tag: "Meters", Summary: "Update a meter with a JSON Merge Patch",
+		Body: Meter{}, BodyType: mergePatchContentType, Response: Meter{}, Conditional: true},
+	{Method: "DELETE", Path: "/api/meters/{id}", Tag: "Meters", Summary: "Delete a meter", Response: messageSchema, Conditional: true},
+	{Method: "GET", Path: "/api/meters/{id}/history", Tag: "Meters", Summary: "Versions of the meter, oldest first",
+		List: &meterHistoryList, Response: RecordVersion[Meter]{}},
+
+	// Bills
+	{Method: "GET", Path: "/api/billing", Tag: "Bills", Summary: "List bills", List: &billingList, Response: Billing{}},
+	{Method: "POST", Path: "/api/billing", Tag: "Bills", Summary: "Create a draft bill", Body: Billing{}, Status: http.StatusCreated, Response: Billing{}},
+	{Method: "GET", Path: "/api/billing/{id}", Tag: "Bills", Summary: "Get a bill with its line items", Params: []apiParam{asOfParam("bill")},
+		Response: Billing{}, Conditional: true},
+	{Method: "PUT", Path: "/api/billing/{id}", Tag: "Bills", Summary: "Replace a draft bill", Body: Billing{}, Response: Billing{}, Conditional: true},
+	{Method: "PATCH", Path: "/api/billing/{id}", Tag: "Bills", Summary: "Update a draft bill with a JSON Merge Patch",
+		Body: Billing{}, BodyType: mergePatchContentType, Response: Billing{}, Conditional: true},
+	{Method: "DELETE", Path: "/api/billing/{id}", Tag: "Bills", Summary: "Delete a draft bill", Response: messageSchema, Conditional: true},
+	{Method: "POST", Path: "/api/billing/{id}/issue", Tag: "Bills", Summary: "Issue a draft bill, assigning its invoice number", Body: BillStatusRequest{}, OptionalBody: true, Response: billStatusSchema},
+	{Method: "POST", Path: "/api/billing/{id}/void", Tag: "Bills", Summary: "Void a bill", Body: BillStatusRequest{}, Response: billStatusSchema},
+	{Method: "POST", Path: "/api/billing/{id}/write-off", Tag: "Bills", Summary: "Write off a bill", Body: BillStatusRequest{}, Response: billStatusSchema},
+	{Method: "POST", Path: "/api/billing/{id}/reissue", Tag: "Bills", Summary: "Void a bill and create a draft replacing it",
+		Body: BillStatusRequest{}, Status: http.StatusCreated, Response: Billing{}},
+	{Method: "GET", Path: "/api/billing/{id}/history", Tag: "Bills", Summary: "Versions of the bill with its line items, oldest first",
+		List: &billHistoryList, Response: RecordVersion[Billing]{}},
+	{Method: "GET", Path: "/api/billing/{id}/status-history", Tag: "Bills", Summary: "Status changes of a bill", Response: []BillStatusChange{}},
+	{Method: "GET", Path: "/api/billing/{id}/pdf", Tag: "Bills", Summary: "Invoice PDF", Produces: []string{"application/pdf"}},
+	{Method: "GET", Path: "/api/billing/{id}/qr", Tag: "Bills", Summary: "PromptPay QR code for the outstanding balance",
+		Params: []apiParam{{Name: "format", Enum: []string{"json", "png"}}}, Response: PromptPayQR{}, Produces: []string{"image/png"}},
+	{Method: "GET", Path: "/api/billing/{id}/notes", Tag: "Bills", Summary: "Credit and debit notes raised against a bill", Response: []BillingNote{}},
+	{Method: "POST", Path: "/api/billing/{id}/credit-notes", Tag: "Bills", Summary: "Raise a credit note", Body: BillingNote{}, Status: http.StatusCreated, Response: BillingNote{}},
+	{Method: "POST", Path: "/api/billing/{id}/debit-notes", Tag: "Bills", Summary: "Raise a debit note", Body: BillingNote{}, Status: http.StatusCreated, Response: BillingNote{}},
+	{Method: "GET", Path: "/api/billing-notes/{id}", Tag: "Bills", Summary: "Get a credit or debit note", Response: BillingNote{}},
+
+	// Payments and reconciliation
+	{Method: "GET", Path: "/api/payments", Tag: "Payments", Summary: "List payments", List: &paymentList, Response: Payment{}},
+	{Method: "POST", Path: "/api/payments", Tag: "Payments", Summary: "Record a payment", Body: Payment{}, Status: http.StatusCreated, Response: Payment{}},
+	{Method: "GET", Path: "/api/payments/{id}", Tag: "Payments", Summary: "Get a payment", Response: Payment{}, Conditional: true},
+	{Method: "PUT", Path: "/api/payments/{id}", Tag: "Payments", Summary: "Replace a payment", Body: Payment{}, Response: Payment{}, Conditional: true},
+	{Method: "PATCH", Path: "/api/payments/{id}", Tag: "Payments", Summary: "Update a payment with a JSON Merge Patch",
+		Body: Payment{}, BodyType: mergePatchContentType, Response: Payment{}, Conditional: true},
+	{Method: "DELETE", Path: "/api/payments/{id}", Tag: "Payments", Summary: "Delete a payment", Response: messageSchema, Conditional: true},
+	{Method: "GET", Path: "/api/payments/{id}/receipt.pdf", Tag: "Payments", Summary: "Payment receipt PDF", Produces: []string{"application/pdf"}},
+	{Method: "POST", Path: "/api/payments/promptpay", Tag: "Reconciliation", Summary: "Notify a PromptPay transfer and match it to a bill",
+		Body: InboundTransfer{}, Status: http.StatusCreated, Response: InboundTransfer{}},
+	{Method: "GET", Path: "/api/inbound-transfers", Tag: "Reconciliation", Summary: "Inbound transfers",
+		Params: []apiParam{queryParam("status", "string", "Match_Status"), queryParam("source", "string", "")}, Response: []InboundTransfer{}},
+	{Method: "GET", Path: "/api/inbound-transfers/review", Tag: "Reconciliation", Summary: "Transfers waiting for manual review", Response: []InboundTransfer{}},
+	{Method: "POST", Path: "/api/inbound-transfers/{id}/match", Tag: "Reconciliation", Summary: "Apply a queued transfer to a bill",
+		Body: TransferReviewRequest{}, Response: InboundTransfer{}},
+	{Method: "POST", Path: "/api/inbound-transfers/{id}/ignore", Tag: "Reconciliation", Summary: "Dismiss a queued transfer",
+		Body: TransferReviewRequest{}, Response: InboundTransfer{}},
+	{Method: "GET", Path: "/api/bank-statements", Tag: "Reconciliation", Summary: "Imported bank statements", Response: []BankStatementImport{}},
+	{Method: "POST", Path: "/api/bank-statements", Tag: "Reconciliation", Summary: "Import a bank statement file and reconcile it",
+		Params: []apiParam{queryParam("filename", "string", "Used to detect the format of a raw upload"),
+			{Name: "format", Enum: []string{"auto", StatementFormatCSV, "fixed"}}, queryParam("currency", "string", "")},
+		Body:     openAPISchema{"type": "object", "properties": map[string]interface{}{"file": map[string]interface{}{"type": "string", "format": "binary"}}},
+		BodyType: "multipart/form-data", Status: http.StatusCreated, Response: ReconciliationReport{}},
+	{Method: "GET", Path: "/api/bank-statements/{id}/report", Tag: "Reconciliation", Summary: "Reconciliation report of an import", Response: ReconciliationReport{}},
+
+	// Reports and search
+	{Method: "GET", Path: "/api/reports/tax", Tag: "Reports", Summary: "Monthly VAT summary",
+		Params: []apiParam{queryParam("year", "integer", "")}, Response: []MonthlyTaxSummary{}},
+	{Method: "GET", Path: "/api/search", Tag: "Search", Summary: "Search customers, meters and bills",
+		Params: []apiParam{{Name: "q", Required: true, Description: fmt.Sprintf("At least %d characters", minSearchLength)},
+			queryParam("type", "string", "Comma-separated: customer, meter, bill"), queryParam("limit", "integer", "")},
+		Response: SearchResponse{}},
+	{Method: "GET", Path: "/api/audit", Tag: "Audit", List: &auditList, Response: AuditEntry{},
+		Summary: "Changes to customers, meters, bills, payments and users, newest first, with who made them (administrators)"},
+},
+	reportOperations("/api", "Reports"),
+	// Version 2
+	[]apiOperation{
+		{Method: "POST", Path: "/api/v2/login", Tag: "v2 Authentication", Summary: "Exchange a username and password for a JWT", Public: true,
+			Body: Credentials{}, Response: SessionV2{}},
+		{Method: "GET", Path: "/api/v2/users", Tag: "v2 Users", Summary: "List users", List: &userList, Response: User{}},
+		{Method: "GET", Path: "/api/v2/public/customer-bills", Tag: "v2 Customer portal", Summary: "Unpaid bills of the customer with this email or phone number",
+			Public: true, Params: []apiParam{identifierParam}, Response: []BillV2{}},
+		{Method: "POST", Path: "/api/v2/public/bills/{id}/pay", Tag: "v2 Customer portal", Summary: "Pay a bill's outstanding balance; 409 when already paid",
+			Public: true, Body: PublicPaymentRequest{}, Status: http.StatusCreated, Response: BillPaymentV2{}},
+	},
+	historyOperationsV2(crudOperationsV2("/api/v2/customers", "v2 Customers", "customer", &customerList, CustomerV2{}),
+		"customer", &customerHistoryList, RecordVersion[CustomerV2]{}),
+	historyOperationsV2(crudOperationsV2("/api/v2/meters", "v2 Meters", "meter", &meterList, MeterV2{}),
+		"meter", &meterHistoryList, RecordVersion[MeterV2]{}),
+	historyOperationsV2(crudOperationsV2("/api/v2/bills", "v2 Bills", "draft bill", &billingList, BillV2{}),
+		"bill", &billHistoryList, RecordVersion[BillV2]{}),
+	[]apiOperation{
+		{Method: "POST", Path: "/api/v2/bills/{id}/issue", Tag: "v2 Bills", Summary: "Issue a draft bill, assigning its invoice number",
+			Body: BillStatusRequest{}, OptionalBody: true, Response: BillV2{}},
+		{Method: "POST", Path: "/api/v2/bills/{id}/void", Tag: "v2 Bills", Summary: "Void a bill", Body: BillStatusRequest{}, Response: BillV2{}},
+		{Method: "POST", Path: "/api/v2/bills/{id}/write-off", Tag: "v2 Bills", Summary: "Write off a bill", Body: BillStatusRequest{}, Response: BillV2{}},
+		{Method: "POST", Path: "/api/v2/bills/{id}/reissue", Tag: "v2 Bills", Summary: "Void a bill and create a draft replacing it",
+			Body: BillStatusRequest{}, Status: http.StatusCreated, Response: BillV2{}},
+	},
+	crudOperationsV2("/api/v2/payments", "v2 Payments", "payment", &paymentList, PaymentV2{}),
+	[]apiOperation{
+		{Method: "GET", Path: "/api/v2/imports", Tag: "v2 Imports", Summary: "Import jobs, newest first", List: &importJobList, Response: ImportJob{}},
+		{Method: "POST", Path: "/api/v2/imports", Tag: "v2 Imports", Summary: "Start importing customers, meters or meter readings from a CSV or XLSX file",
+			Params: []apiParam{{Name: "kind", Required: true, Enum: []string{ImportKindCustomers, ImportKindMeters, ImportKindReadings}},
+				{Name: "format", Enum: []string{"auto", ImportFormatCSV, ImportFormatXLSX}},
+				queryParam("filename", "string", "Used to detect the format of a raw upload"),
+				queryParam("dry_run", "boolean", "Validate every row and record the outcome without writing anything")},
+			Body:     openAPISchema{"type": "object", "properties": map[string]interface{}{"file": map[string]interface{}{"type": "string", "format": "binary"}}},
+			BodyType: "multipart/form-data", Status: http.StatusAccepted, Response: ImportJob{}},
+		{Method: "GET", Path: "/api/v2/imports/{id}", Tag: "v2 Imports", Summary: "Progress and totals of an import job", Response: ImportJob{}},
+		{Method: "GET", Path: "/api/v2/imports/{id}/rows", Tag: "v2 Imports", Summary: "Outcome of each processed row, with its field errors",
+			List: &importRowList, Response: ImportRowResult{}},
+		{Method: "POST", Path: "/api/v2/imports/{id}/resume", Tag: "v2 Imports", Summary: "Resume a failed or interrupted import job",
+			Status: http.StatusAccepted, Response: ImportJob{}},
+	},
+	[]apiOperation{
+		exportOperation("/api/v2/users/export", "v2 Users", &userExport),
+		exportOperation("/api/v2/customers/export", "v2 Customers", &customerExport),
+		exportOperation("/api/v2/meters/export", "v2 Meters", &meterExport),
+		exportOperation("/api/v2/bills/export", "v2 Bills", &billExport),
+		exportOperation("/api/v2/payments/export", "v2 Payments", &paymentExport),
+		exportOperation("/api/v2/imports/export", "v2 Imports", &importJobExport),
+		exportOperation("/api/v2/imports/{id}/rows/export", "v2 Imports", &importRowExport),
+	},
+	[]apiOperation{
+		{Method: "GET", Path: "/api/v2/customers/{id}/consumption", Tag: "v2 Customers", Params: dateRangeParams,
+			Summary: "Monthly consumption of the customer's meters, with changes and average daily usage", Response: CustomerConsumption{}},
+		{Method: "GET", Path: "/api/v2/meters/{id}/consumption", Tag: "v2 Meters", Params: dateRangeParams,
+			Summary: "Monthly consumption of the meter, with changes and average daily usage", Response: MeterConsumption{}},
+		{Method: "GET", Path: "/api/v2/public/consumption", Tag: "v2 Customer portal", Public: true,
+			Summary: "Monthly consumption of the customer with this email or phone number", Response: CustomerConsumption{},
+			Params: append([]apiParam{identifierParam}, dateRangeParams...)},
+	},
+	reportOperations("/api/v2", "v2 Reports"),
+	[]apiOperation{
+		{Method: "GET", Path: "/api/v2/shifts", Tag: "v2 Shifts", Summary: "Cash shifts, newest first; operators only see their own",
+			List: &cashShiftList, Response: CashShift{}},
+		{Method: "POST", Path: "/api/v2/shifts", Tag: "v2 Shifts", Summary: "Open a shift with the float in the cash drawer",
+			Body: OpenShiftRequest{}, OptionalBody: true, Status: http.StatusCreated, Response: CashShift{}},
+		{Method: "GET", Path: "/api/v2/shifts/current", Tag: "v2 Shifts", Summary: "The caller's open shift", Response: CashShift{}},
+		{Method: "GET", Path: "/api/v2/shifts/{id}", Tag: "v2 Shifts", Summary: "A shift with its counted cash and discrepancy", Response: CashShift{}},
+		{Method: "POST", Path: "/api/v2/shifts/{id}/close", Tag: "v2 Shifts",
+			Summary: "Close a shift with the declared cash; a difference from the expected cash flags it", Body: CloseShiftRequest{}, Response: CashShift{}},
+		{Method: "POST", Path: "/api/v2/shifts/{id}/review", Tag: "v2 Shifts", Summary: "Review a closed shift (administrators)",
+			Body: ReviewShiftRequest{}, OptionalBody: true, Response: CashShift{}},
+		reportOperation("/api/v2/reports/cash-up", "v2 Reports", "End-of-day cash-up per operator: payments by method, refunds, voided bills and shifts; operators get their own",
+			CashUp{}, []apiParam{queryParam("processed_by", "integer", "User who took the payments")}),
+	},
+	[]apiOperation{
+		{Method: "GET", Path: "/api/v2/audit", Tag: "v2 Audit", List: &auditList, Response: AuditEntry{},
+			Summary: "Changes to customers, meters, bills, payments and users, newest first, with who made them (administrators)"},
+	},
+)
+
+var dateRangeParams = []apiParam{
+	{Name: "from", Format: "date", Description: "On or after"},
+	{Name: "to", Format: "date", Description: "On or before"},
+}
+
+// joinOperations concatenates groups of operations.
+func joinOperations(groups ...[]apiOperation) []apiOperation {
+	var ops []apiOperation
+	for _, g := range groups {
+		ops = append(ops, g...)
+	}
+	return ops
+}
+
+// crudOperationsV2 documents the routes added by v2Resource.register.
+func crudOperationsV2(path, tag, noun string, list *listResource, v interface{}) []apiOperation {
+	return []apiOperation{
+		{Method: "GET", Path: path, Tag: tag, Summary: "List " + noun + "s", List: list, Response: v},
+		{Method: "POST", Path: path, Tag: tag, Summary: "Create a " + noun, Body: v, Status: http.StatusCreated, Response: v},
+		{Method: "GET", Path: path + "/{id}", Tag: tag, Summary: "Get a " + noun, Response: v, Conditional: true},
+		{Method: "PUT", Path: path + "/{id}", Tag: tag, Summary: "Replace a " + noun, Body: v, Response: v, Conditional: true},
+		{Method: "PATCH", Path: path + "/{id}", Tag: tag, Summary: "Update a " + noun + " with a JSON Merge Patch",
+			Body: v, BodyType: mergePatchContentType, Response: v, Conditional: true},
+		{Method: "DELETE", Path: path + "/{id}", Tag: tag, Summary: "Delete a " + noun, Status: http.StatusNoContent, Conditional: true},
+	}
+}
+
+// asOfParam documents the as_of parameter of the gets of records with a history.
+func asOfParam(noun string) apiParam {
+	return queryParam("as_of", "string", "Return the "+noun+" as it was at this moment: an RFC 3339 timestamp, or a date for the end of that day")
+}
+
+// historyOperationsV2 adds as_of to the get operation of a resource whose
+// service keeps its history, and documents the history route.
+func historyOperationsV2(ops []apiOperation, noun string, list *listResource, version interface{}) []apiOperation {
+	path, tag := ops[0].Path, ops[0].Tag
+	for i := range ops {
+		if ops[i].Method == "GET" && ops[i].Path == path+"/{id}" {
+			ops[i].Params = append(ops[i].Params, asOfParam(noun))
+		}
+	}
+	return append(ops, apiOperation{Method: "GET", Path: path + "/{id}/history", Tag: tag,
+		Summary: "Versions of the " + noun + ", oldest first", List: list, Response: version})
+}
+
+var billStatusSchema = openAPISchema{"type": "object", "properties": map[string]interface{}{
+	"message": map[string]interface{}{"type": "string"}, "Bill_Status": map[string]interface{}{"type": "string"},
+	"Invoice_Number": map[string]interface{}{"type": "string", "description": "Present when the bill was issued"}}}
+
+// readOnlyProperties marks the members clients cannot set.
+var readOnlyProperties = map[reflect.Type][]string{
+	reflect.TypeOf(Customer{}):   customerReadOnlyFields,
+	reflect.TypeOf(Meter{}):      meterReadOnlyFields,
+	reflect.TypeOf(Billing{}):    billingReadOnlyFields,
+	reflect.TypeOf(Payment{}):    paymentReadOnlyFields,
+	reflect.TypeOf(CustomerV2{}): customerV2ReadOnlyFields,
+	reflect.TypeOf(MeterV2{}):    meterV2ReadOnlyFields,
+	reflect.TypeOf(BillV2{}):     billV2ReadOnlyFields,
+	reflect.TypeOf(PaymentV2{}):  paymentV2ReadOnlyFields,
+}
+
+// v2Models maps the v2 representations to the model that validates them; a
+// v2 member takes the validate tag of the model field with the same name.
+var v2Models = map[reflect.Type]reflect.Type{
+	reflect.TypeOf(CustomerV2{}): reflect.TypeOf(Customer{}),
+	reflect.TypeOf(MeterV2{}):    reflect.TypeOf(Meter{}),
+	reflect.TypeOf(BillV2{}):     reflect.TypeOf(Billing{}),
+	reflect.TypeOf(PaymentV2{}):  reflect.TypeOf(Payment{}),
+}
+
+// gteFieldArg matches a gtefield rule, whose argument names a v1 member.
+var gteFieldArg = regexp.MustCompile(`gtefield=\w+`)
+
+// validateTag returns the validate tag that applies to field f of struct t.
+func validateTag(t reflect.Type, f reflect.StructField) string {
+	model, ok := v2Models[t]
+	if !ok {
+		return f.Tag.Get("validate")
+	}
+	for i := 0; i < model.NumField(); i++ {
+		if mf := model.Field(i); strings.ToLower(fieldName(mf)) == fieldName(f) {
+			return gteFieldArg.ReplaceAllStringFunc(mf.Tag.Get("validate"), strings.ToLower)
+		}
+	}
+	return ""
+}
+
+// genericArgs matches the package-qualified type argument in the name of a
+// generic type's instance, such as [ebill-backend.CustomerV2].
+var genericArgs = regexp.MustCompile(`\[(?:[^\]]*\.)?(\w+)\]`)
+
+// schemaName names the schema of struct type t; RecordVersion of CustomerV2
+// becomes RecordVersionCustomerV2.
+func schemaName(t reflect.Type) string {
+	return genericArgs.ReplaceAllString(t.Name(), "$1")
+}
+
+func schemaRef(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+// openAPIBuilder collects the component schemas referenced by operations.
+type openAPIBuilder struct {
+	schemas    map[string]interface{}
+	operations map[string]bool // "GET /api/v2/customers/{id}", for finding v2 successors
+}
+
+var (
+	decimalType = reflect.TypeOf(Decimal{})
+	moneyType   = reflect.TypeOf(Money{})
+	timeType    = reflect.TypeOf(time.Time{})
+	rawJSONType = reflect.TypeOf(json.RawMessage{})
+)
+
+// schemaFor describes a Go value or returns a literal openAPISchema as is.
+func (b *openAPIBuilder) schemaFor(v interface{}) interface{} {
+	if s, ok := v.(openAPISchema); ok {
+		return s
+	}
+	return b.schemaOf(reflect.TypeOf(v))
+}
+
+func (b *openAPIBuilder) schemaOf(t reflect.Type) map[string]interface{} {
+	switch t {
+	case decimalType:
+		b.schemas["Decimal"] = map[string]interface{}{"type": "string", "pattern": `^-?\d+(\.\d+)?$`, "example": "12.5",
+			"description": "Exact decimal, sent as a string; a JSON number is also accepted"}
+		return schemaRef("Decimal")
+	case moneyType:
+		b.schemas["Money"] = map[string]interface{}{"type": "string", "pattern": `^-?\d+\.\d{2}$`, "example": "1250.00",
+			"description": "Amount with two decimal places, sent as a string; a JSON number is also accepted"}
+		return schemaRef("Money")
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case rawJSONType:
+		return map[string]interface{}{"type": "object", "nullable": true}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := b.schemaOf(t.Elem())
+		if _, isRef := s["$ref"]; isRef {
+			return map[string]interface{}{"allOf": []interface{}{s}, "nullable": true}
+		}
+		s["nullable"] = true
+		return s
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": b.schemaOf(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": b.schemaOf(t.Elem())}
+	case reflect.Interface:
+		return map[string]interface{}{}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Struct:
+		name := schemaName(t)
+		if _, done := b.schemas[name]; !done {
+			b.schemas[name] = nil // Placeholder for recursive types
+			b.schemas[name] = b.structSchema(t)
+		}
+		return schemaRef(name)
+	}
+	panic("openapi: cannot describe " + t.String())
+}
+
+// structSchema describes the JSON members of a struct, including the rules
+// from its validate tags.
+func (b *openAPIBuilder) structSchema(t reflect.Type) map[string]interface{} {
+	props := map[string]interface{}{}
+	var required []string
+	readOnly := map[string]bool{}
+	for _, name := range readOnlyProperties[t] {
+		readOnly[name] = true
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() || f.Tag.Get("json") == "-" {
+			continue
+		}
+		name, tag := fieldName(f), validateTag(t, f)
+		s := b.schemaOf(f.Type)
+		if _, isRef := s["$ref"]; isRef && (readOnly[name] || tag != "") {
+			s = map[string]interface{}{"allOf": []interface{}{s}}
+		}
+		for _, rule := range strings.Split(tag, ",") {
+			rule, arg, _ := strings.Cut(rule, "=")
+			switch rule {
+			case "required":
+				required = append(required, name)
+			case "max":
+				s["maxLength"], _ = strconv.Atoi(arg)
+			case "email":
+				s["format"] = "email"
+			case "phone":
+				s["pattern"] = phonePattern.String()
+			case "date":
+				s["format"] = "date"
+			case "currency":
+				s["pattern"] = currencyPattern.String()
+			case "oneof":
+				s["enum"] = strings.Split(arg, "|")
+			case "gt":
+				s["description"] = "Greater than " + arg
+			case "gte":
+				s["description"] = "At least " + arg
+			case "gtefield":
+				s["description"] = "Not less than " + arg
+			}
+		}
+		if readOnly[name] {
+			s["readOnly"] = true
+		}
+		props[name] = s
+	}
+	s := map[string]interface{}{"type": "object", "properties": props}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+// listParams documents the paging, sorting and filter parameters of a list endpoint.
+func listParams(res *listResource) []apiParam {
+	params := []apiParam{
+		queryParam("limit", "integer", fmt.Sprintf("Rows per page, at most %d (default %d)", maxListLimit, defaultListLimit)),
+		queryParam("offset", "integer", "Rows to skip"),
+		queryParam("cursor", "string", "meta.next_cursor of the previous page"),
+	}
+	return append(params, filterParams(res)...)
+}
+
+// filterParams documents the sort and filter parameters of res.
+func filterParams(res *listResource) []apiParam {
+	var sorts []string
+	for name := range res.sorts {
+		sorts = append(sorts, name, "-"+name)
+	}
+	sort.Strings(sorts)
+	params := []apiParam{{Name: "sort", Enum: sorts, Description: "Default " + res.defaultSort + "; a leading - sorts descending"}}
+	names := make([]string, 0, len(res.filters))
+	for name := range res.filters {
+		if name != res.scope {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		p := apiParam{Name: name}
+		switch res.filters[name].kind {
+		case filterText:
+			p.Description = "Exact match; a comma-separated list matches any item"
+		case filterInt:
+			p.Type = "integer"
+		case filterBool:
+			p.Type = "boolean"
+		case filterDateFrom:
+			p.Format, p.Description = "date", "On or after"
+		case filterDateTo:
+			p.Format, p.Description = "date", "On or before"
+		case filterContains:
+			p.Description = "Case-insensitive substring"
+		}
+		params = append(params, p)
+	}
+	return params
+}
+
+func (p apiParam) spec() map[string]interface{} {
+	in, typ := p.In, p.Type
+	if in == "" {
+		in = "query"
+	}
+	if typ == "" {
+		typ = "string"
+	}
+	schema := map[string]interface{}{"type": typ}
+	if p.Format != "" {
+		schema["format"] = p.Format
+	}
+	if len(p.Enum) > 0 {
+		schema["enum"] = p.Enum
+	}
+	s := map[string]interface{}{"name": p.Name, "in": in, "required": p.Required, "schema": schema}
+	if p.Description != "" {
+		s["description"] = p.Description
+	}
+	return s
+}
+
+// errorResponses are the error statuses documented in components/responses.
+var errorResponses = map[int]string{
+	http.StatusBadRequest:           "The request is malformed",
+	http.StatusUnauthorized:         "Missing or invalid bearer token",
+	http.StatusForbidden:            "The token's role may not do this",
+	http.StatusNotFound:             "No such resource",
+	http.StatusConflict:             "The resource is in a state that does not allow this, or a unique value is already in use",
+	http.StatusPreconditionFailed:   "If-Match names a version that is no longer current",
+	http.StatusUnsupportedMediaType: "Unsupported Content-Type",
+	http.StatusUnprocessableEntity:  "Field-level validation failed; see details",
+	http.StatusPreconditionRequired: "If-Match is missing",
+	http.StatusInternalServerError:  "Unexpected server error",
+}
+
+func (b *openAPIBuilder) operation(op apiOperation) map[string]interface{} {
+	var params []interface{}
+	for _, seg := range strings.Split(op.Path, "/") {
+		if strings.HasPrefix(seg, "{") {
+			params = append(params, map[string]interface{}{"name": strings.Trim(seg, "{}"), "in": "path", "required": true,
+				"schema": map[string]interface{}{"type": "integer"}})
+		}
+	}
+	extra := op.Params
+	if op.List != nil {
+		extra = append(listParams(op.List), extra...)
+	}
+	if op.Conditional {
+		if op.Method == "GET" {
+			extra = append(extra, apiParam{Name: "If-None-Match", In: "header", Description: "ETag of a cached copy; 304 when unchanged"})
+		} else {
+			extra = append(extra, apiParam{Name: "If-Match", In: "header", Required: true, Description: `ETag last read, or "*" for any version`})
+		}
+	}
+	for _, p := range extra {
+		params = append(params, p.spec())
+	}
+
+	v2 := strings.HasPrefix(op.Path, "/api/v2/")
+	status := op.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	content := map[string]interface{}{}
+	if op.Response != nil {
+		schema := b.schemaFor(op.Response)
+		if op.List != nil {
+			schema = map[string]interface{}{"type": "object", "required": []string{"data", "meta"}, "properties": map[string]interface{}{
+				"data": map[string]interface{}{"type": "array", "items": schema}, "meta": b.schemaOf(reflect.TypeOf(ListMeta{}))}}
+		} else if v2 {
+			schema = map[string]interface{}{"type": "object", "required": []string{"data"}, "properties": map[string]interface{}{"data": schema}}
+		}
+		content["application/json"] = map[string]interface{}{"schema": schema}
+	}
+	for _, mt := range op.Produces {
+		content[mt] = map[string]interface{}{"schema": map[string]interface{}{"type": "string", "format": "binary"}}
+	}
+	success := map[string]interface{}{"description": http.StatusText(status)}
+	if len(content) > 0 {
+		success["content"] = content
+	}
+	if op.Conditional {
+		success["headers"] = map[string]interface{}{"ETag": map[string]interface{}{"schema": map[string]interface{}{"type": "string"}}}
+	}
+	responses := map[string]interface{}{strconv.Itoa(status): success}
+
+	errs := []int{http.StatusInternalServerError}
+	if len(params) > 0 || op.Body != nil {
+		errs = append(errs, http.StatusBadRequest)
+	}
+	if !op.Public {
+		errs = append(errs, http.StatusUnauthorized, http.StatusForbidden)
+	}
+	if strings.Contains(op.Path, "{id}") {
+		errs = append(errs, http.StatusNotFound)
+	}
+	if op.Method != "GET" {
+		errs = append(errs, http.StatusConflict)
+	}
+	if op.Body != nil {
+		errs = append(errs, http.StatusUnprocessableEntity)
+	}
+	if op.Method == "PATCH" {
+		errs = append(errs, http.StatusUnsupportedMediaType)
+	}
+	if op.Conditional && op.Method != "GET" {
+		errs = append(errs, http.StatusPreconditionFailed, http.StatusPreconditionRequired)
+	}
+	if op.Conditional && op.Method == "GET" {
+		responses["304"] = map[string]interface{}{"description": "Not Modified"}
+	}
+	for _, code := range errs {
+		ref := strconv.Itoa(code)
+		if v2 {
+			ref = "v2-" + ref
+		}
+		responses[strconv.Itoa(code)] = map[string]interface{}{"$ref": "#/components/responses/" + ref}
+	}
+
+	spec := map[string]interface{}{
+		"operationId": strings.ToLower(op.Method) + strings.NewReplacer("/", "_", "{", "", "}", "", "-", "_", ".", "_").Replace(op.Path),
+		"tags":        []string{op.Tag},
+		"summary":     op.Summary,
+		"responses":   responses,
+	}
+	if len(params) > 0 {
+		spec["parameters"] = params
+	}
+	if op.Body != nil {
+		bodyType := op.BodyType
+		if bodyType == "" {
+			bodyType = "application/json"
+		}
+		spec["requestBody"] = map[string]interface{}{"required": !op.OptionalBody,
+			"content": map[string]interface{}{bodyType: map[string]interface{}{"schema": b.schemaFor(op.Body)}}}
+	}
+	if op.Public {
+		spec["security"] = []interface{}{}
+	}
+	if !v2 && b.operations[op.Method+" "+v2Path(op.Path)] {
+		spec["deprecated"] = true // See deprecateV1
+	}
+	return spec
+}
+
+// buildOpenAPI generates the OpenAPI document from apiOperations.
+func buildOpenAPI() map[string]interface{} {
+	b := &openAPIBuilder{schemas: map[string]interface{}{}, operations: map[string]bool{}}
+	for _, op := range apiOperations {
+		b.operations[op.Method+" "+op.Path] = true
+	}
+	paths := map[string]map[string]interface{}{}
+	for _, op := range apiOperations {
+		if paths[op.Path] == nil {
+			paths[op.Path] = map[string]interface{}{}
+		}
+		paths[op.Path][strings.ToLower(op.Method)] = b.operation(op)
+	}
+	errorSchema := b.schemaOf(reflect.TypeOf(ErrorResponse{}))
+	errorSchemaV2 := b.schemaOf(reflect.TypeOf(ErrorResponseV2{}))
+	responses := map[string]interface{}{}
+	for code, description := range errorResponses {
+		headers := map[string]interface{}{requestIDHeader: map[string]interface{}{"schema": map[string]interface{}{"type": "string"}}}
+		responses[strconv.Itoa(code)] = map[string]interface{}{"description": description, "headers": headers,
+			"content": map[string]interface{}{"application/json": map[string]interface{}{"schema": errorSchema}}}
+		responses["v2-"+strconv.Itoa(code)] = map[string]interface{}{"description": description, "headers": headers,
+			"content": map[string]interface{}{"application/json": map[string]interface{}{"schema": errorSchemaV2}}}
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{"title": "eBill API", "version": "2.0.0",
+			"description": "Electricity billing: customers, meters, bills, payments and reconciliation. " +
+				"Error responses carry a stable code, field-level details and the request ID also sent in the X-Request-ID header. " +
+				"New clients should use /api/v2, which names every member in snake_case and wraps bodies in a data or error envelope; " +
+				"the original routes that /api/v2 also serves are deprecated and answer with Deprecation and Link headers."},
+		"servers":  []interface{}{map[string]interface{}{"url": "http://localhost:8080"}},
+		"security": []interface{}{map[string]interface{}{"bearerAuth": []string{}}},
+		"paths":    paths,
+		"components": map[string]interface{}{
+			"schemas":         b.schemas,
+			"responses":       responses,
+			"securitySchemes": map[string]interface{}{"bearerAuth": map[string]interface{}{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"}},
+		},
+	}
+}
+
+var (
+	openAPIOnce sync.Once
+	openAPIJSON []byte
+)
+
+// openAPIDocument returns the generated document, encoded once.
+func openAPIDocument() []byte {
+	openAPIOnce.Do(func() {
+		var err error
+		if openAPIJSON, err = json.MarshalIndent(buildOpenAPI(), "", "  "); err != nil {
+			panic("openapi: " + err.Error())
+		}
+	})
+	return openAPIJSON
+}
+
+func getOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPIDocument())
+}
+
+//go:embed docs.html
+var docsPage []byte
+
+func getAPIDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(docsPage)
+}
+
+// checkOpenAPIRoutes reports routes registered on router that apiOperations
+// does not document, and documented operations that have no route.
+func checkOpenAPIRoutes(router *mux.Router) error {
+	documented := map[string]bool{}
+	for _, op := range apiOperations {
+		key := op.Method + " " + op.Path
+		if documented[key] {
+			return fmt.Errorf("%s is documented twice", key)
+		}
+		documented[key] = true
+	}
+	routed := map[string]bool{}
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil // Subrouter prefixes
+		}
+		for _, m := range methods {
+			if m != http.MethodOptions {
+				routed[m+" "+path] = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	var problems []string
+	for key := range routed {
+		if !documented[key] {
+			problems = append(problems, key+" has no OpenAPI entry")
+		}
+	}
+	for key := range documented {
+		if !routed[key] {
+			problems = append(problems, key+" is documented but not routed")
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("OpenAPI document is out of date:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"testing"
+)
+
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	if err := checkOpenAPIRoutes(newRouter()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenAPIReferencesResolve(t *testing.T) {
+	doc := openAPIDocument()
+	var spec struct {
+		Paths      map[string]interface{} `json:"paths"`
+		Components struct {
+			Schemas map[string]interface{} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(doc, &spec); err != nil {
+		t.Fatalf("document is not valid JSON: %v", err)
+	}
+	if len(spec.Paths) == 0 {
+		t.Fatal("document has no paths")
+	}
+	for _, m := range regexp.MustCompile(`"#/components/schemas/([^"]+)"`).FindAllStringSubmatch(string(doc), -1) {
+		if _, ok := spec.Components.Schemas[m[1]]; !ok {
+			t.Errorf("schema %s is referenced but not defined", m[1])
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+)
+
+// mergePatchContentType is the media type of an RFC 7396 JSON Merge Patch.
+// PATCH endpoints also accept plain application/json.
+const mergePatchContentType = "application/merge-patch+json"
+
+// patchError is a PATCH request that cannot be applied.
+type patchError struct {
+	status int
+	msg    string
+}
+
+func (e *patchError) Error() string { return e.msg }
+
+// mergePatch applies patch to target as described in RFC 7396: objects are
+// merged recursively, null removes a member and any other value replaces it.
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = map[string]interface{}{}
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		t[k] = mergePatch(t[k], v)
+	}
+	return t
+}
+
+func decodeJSONValue(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("unexpected data after JSON value")
+	}
+	return v, nil
+}
+
+// applyMergePatch merges the patch in the body of r into the resource held by
+// dst, a pointer to a struct loaded from the database. Members named in
+// readOnly may only be repeated with their current value. Members that are
+// removed with null are reset to their zero value; unknown members are rejected.
+func applyMergePatch(r *http.Request, dst interface{}, readOnly []string) error {
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		mt, _, err := mime.ParseMediaType(ct)
+		if err != nil || (mt != mergePatchContentType && mt != "application/json") {
+			return &patchError{http.StatusUnsupportedMediaType, "Content-Type must be " + mergePatchContentType}
+		}
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return &patchError{http.StatusBadRequest, "Failed to read request body: " + err.Error()}
+	}
+	patch, err := decodeJSONValue(body)
+	if err != nil {
+		return &patchError{http.StatusBadRequest, "Invalid request body: " + err.Error()}
+	}
+	members, ok := patch.(map[string]interface{})
+	if !ok {
+		return &patchError{http.StatusBadRequest, "Invalid request body: a merge patch must be a JSON object"}
+	}
+
+	currentJSON, err := json.Marshal(dst)
+	if err != nil {
+		return err
+	}
+	current, err := decodeJSONValue(currentJSON)
+	if err != nil {
+		return err
+	}
+	for _, name := range readOnly {
+		if v, ok := members[name]; ok && !reflect.DeepEqual(v, current.(map[string]interface{})[name]) {
+			return &patchError{http.StatusBadRequest, name + " cannot be changed"}
+		}
+	}
+
+	merged, err := json.Marshal(mergePatch(current, patch))
+	if err != nil {
+		return err
+	}
+	v := reflect.ValueOf(dst).Elem()
+	v.Set(reflect.Zero(v.Type()))
+	dec := json.NewDecoder(bytes.NewReader(merged))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(dst); err != nil {
+		return &patchError{http.StatusBadRequest, "Invalid request body: " + err.Error()}
+	}
+	return nil
+}
+
+// respondWithPatchError reports a failed PATCH: the applyMergePatch error or
+// the error of saving the result, which message describes.
+func respondWithPatchError(w http.ResponseWriter, message string, err error) {
+	if pe, ok := err.(*patchError); ok {
+		respondWithError(w, pe.status, pe.msg)
+		return
+	}
+	respondWithServerError(w, message, err)
+}
@@ -0,0 +1,557 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+// This file is a minimal PDF 1.4 writer: A4 pages, text in the built-in
+// Helvetica fonts or an embedded TrueType font, lines, rectangles and QR codes.
+// An embedded TrueType font is what makes Thai text possible; the standard
+// fonts only cover WinAnsi (Latin-1) characters.
+
+const (
+	pdfPageWidth  = 595.28 // A4 in points
+	pdfPageHeight = 841.89
+)
+
+// PDFFont is a font that can be used on a PDFPage.
+type PDFFont interface {
+	resourceName() string
+	// encode returns the PDF string operand for s and records the glyphs used.
+	encode(s string) string
+	// width returns the advance width of s at the given size, in points.
+	width(s string, size float64) float64
+	// supports reports whether every rune of s has a glyph.
+	supports(s string) bool
+	// writeObjects writes the font's objects and returns the font dictionary's object number.
+	writeObjects(w *pdfWriter) int
+}
+
+// PDFDocument collects pages and fonts and serializes them.
+type PDFDocument struct {
+	Title string
+	pages []*PDFPage
+	fonts []PDFFont
+}
+
+// PDFPage is one A4 page. Coordinates passed to drawing methods are in points
+// from the top-left corner, which is how layouts are usually reasoned about.
+type PDFPage struct {
+	doc     *PDFDocument
+	content bytes.Buffer
+}
+
+// NewPDFDocument creates an empty document.
+func NewPDFDocument(title string) *PDFDocument {
+	return &PDFDocument{Title: title}
+}
+
+// AddPage appends a new blank page.
+func (d *PDFDocument) AddPage() *PDFPage {
+	p := &PDFPage{doc: d}
+	d.pages = append(d.pages, p)
+	return p
+}
+
+func (d *PDFDocument) useFont(f PDFFont) {
+	for _, existing := range d.fonts {
+		if existing == f {
+			return
+		}
+	}
+	d.fonts = append(d.fonts, f)
+}
+
+// Text draws s with its baseline-left at (x, y).
+func (p *PDFPage) Text(x, y float64, f PDFFont, size float64, s string) {
+	if s == "" {
+		return
+	}
+	p.doc.useFont(f)
+	fmt.Fprintf(&p.content, "BT /%s %.2f Tf %.2f %.2f Td %s Tj ET\n", f.resourceName(), size, x, pdfPageHeight-y, f.encode(s))
+}
+
+// TextRight draws s so that it ends at x.
+func (p *PDFPage) TextRight(x, y float64, f PDFFont, size float64, s string) {
+	p.Text(x-f.width(s, size), y, f, size, s)
+}
+
+// TextCenter draws s centred on x.
+func (p *PDFPage) TextCenter(x, y float64, f PDFFont, size float64, s string) {
+	p.Text(x-f.width(s, size)/2, y, f, size, s)
+}
+
+// TextRotated draws s rotated counter-clockwise by deg degrees about its baseline start (x, y).
+func (p *PDFPage) TextRotated(x, y, deg float64, f PDFFont, size float64, s string) {
+	if s == "" {
+		return
+	}
+	p.doc.useFont(f)
+	sin, cos := math.Sincos(deg * math.Pi / 180)
+	fmt.Fprintf(&p.content, "BT /%s %.2f Tf %.4f %.4f %.4f %.4f %.2f %.2f Tm %s Tj ET\n",
+		f.resourceName(), size, cos, sin, -sin, cos, x, pdfPageHeight-y, f.encode(s))
+}
+
+// SetFillColor sets the colour used by text and filled shapes (components 0–1).
+func (p *PDFPage) SetFillColor(r, g, b float64) {
+	fmt.Fprintf(&p.content, "%.3f %.3f %.3f rg\n", r, g, b)
+}
+
+// SetStrokeColor sets the colour used by lines and outlines (components 0–1).
+func (p *PDFPage) SetStrokeColor(r, g, b float64) {
+	fmt.Fprintf(&p.content, "%.3f %.3f %.3f RG\n", r, g, b)
+}
+
+// Line draws a straight line.
+func (p *PDFPage) Line(x1, y1, x2, y2, width float64) {
+	fmt.Fprintf(&p.content, "%.2f w %.2f %.2f m %.2f %.2f l S\n", width, x1, pdfPageHeight-y1, x2, pdfPageHeight-y2)
+}
+
+// FillRect fills a rectangle whose top-left corner is (x, y).
+func (p *PDFPage) FillRect(x, y, w, h float64) {
+	fmt.Fprintf(&p.content, "%.2f %.2f %.2f %.2f re f\n", x, pdfPageHeight-y-h, w, h)
+}
+
+// StrokeRect outlines a rectangle whose top-left corner is (x, y).
+func (p *PDFPage) StrokeRect(x, y, w, h, lineWidth float64) {
+	fmt.Fprintf(&p.content, "%.2f w %.2f %.2f %.2f %.2f re S\n", lineWidth, x, pdfPageHeight-y-h, w, h)
+}
+
+// QRCode draws q as vector modules in a square of the given size at (x, y).
+// The caller is responsible for leaving a light quiet zone around it.
+func (p *PDFPage) QRCode(x, y, size float64, q *QRCode) {
+	m := size / float64(q.Size)
+	p.content.WriteString("q 0 0 0 rg\n")
+	for row := 0; row < q.Size; row++ {
+		for col := 0; col < q.Size; {
+			if !q.Modules[row][col] {
+				col++
+				continue
+			}
+			// Merge horizontal runs to keep the content stream small.
+			start := col
+			for col < q.Size && q.Modules[row][col] {
+				col++
+			}
+			fmt.Fprintf(&p.content, "%.3f %.3f %.3f %.3f re\n", x+float64(start)*m, pdfPageHeight-y-float64(row+1)*m, float64(col-start)*m, m)
+		}
+	}
+	p.content.WriteString("f Q\n")
+}
+
+// WriteTo serializes the document.
+func (d *PDFDocument) WriteTo(out io.Writer) (int64, error) {
+	w := &pdfWriter{}
+	w.buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	catalog := w.reserve()
+	pagesObj := w.reserve()
+	info := w.reserve()
+
+	var fontRes strings.Builder
+	for _, f := range d.fonts {
+		fmt.Fprintf(&fontRes, "/%s %d 0 R ", f.resourceName(), f.writeObjects(w))
+	}
+
+	var kids strings.Builder
+	for _, p := range d.pages {
+		content := w.stream("", p.content.Bytes())
+		page := w.object(fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << %s>> >> /Contents %d 0 R >>",
+			pagesObj, pdfPageWidth, pdfPageHeight, fontRes.String(), content))
+		fmt.Fprintf(&kids, "%d 0 R ", page)
+	}
+	w.set(pagesObj, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", kids.String(), len(d.pages)))
+	w.set(catalog, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R >>", pagesObj))
+	w.set(info, fmt.Sprintf("<< /Title %s /Producer (ebill-backend) >>", pdfLiteral(d.Title)))
+
+	xref := w.buf.Len()
+	fmt.Fprintf(&w.buf, "xref\n0 %d\n0000000000 65535 f \n", len(w.offsets)+1)
+	for _, off := range w.offsets {
+		fmt.Fprintf(&w.buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&w.buf, "trailer\n<< /Size %d /Root %d 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(w.offsets)+1, catalog, info, xref)
+	n, err := out.Write(w.buf.Bytes())
+	return int64(n), err
+}
+
+// pdfWriter tracks object offsets. Objects may be reserved and written later,
+// but must all be written before the xref table.
+type pdfWriter struct {
+	buf     bytes.Buffer
+	offsets []int
+	pending map[int]bool
+}
+
+func (w *pdfWriter) reserve() int {
+	w.offsets = append(w.offsets, 0)
+	if w.pending == nil {
+		w.pending = map[int]bool{}
+	}
+	n := len(w.offsets)
+	w.pending[n] = true
+	return n
+}
+
+func (w *pdfWriter) set(n int, body string) {
+	w.offsets[n-1] = w.buf.Len()
+	delete(w.pending, n)
+	fmt.Fprintf(&w.buf, "%d 0 obj\n%s\nendobj\n", n, body)
+}
+
+func (w *pdfWriter) object(body string) int {
+	n := w.reserve()
+	w.set(n, body)
+	return n
+}
+
+// stream writes a Flate-compressed stream object; extra is added to its dictionary.
+func (w *pdfWriter) stream(extra string, data []byte) int {
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	zw.Write(data)
+	zw.Close()
+	n := w.reserve()
+	w.offsets[n-1] = w.buf.Len()
+	delete(w.pending, n)
+	fmt.Fprintf(&w.buf, "%d 0 obj\n<< /Length %d /Filter /FlateDecode %s>>\nstream\n", n, z.Len(), extra)
+	w.buf.Write(z.Bytes())
+	w.buf.WriteString("\nendstream\nendobj\n")
+	return n
+}
+
+// pdfLiteral returns s as a PDF literal string, replacing non-Latin-1 runes.
+func pdfLiteral(s string) string {
+	var b strings.Builder
+	b.WriteByte('(')
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= 0x20 && r <= 0x7E:
+			b.WriteRune(r)
+		case r >= 0xA0 && r <= 0xFF:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	b.WriteByte(')')
+	return b.String()
+}
+
+// --- Standard Type 1 fonts ---
+
+// helveticaWidths are the advance widths of Helvetica for ASCII 32–126, per 1000 units.
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+// helveticaBoldWidths are the advance widths of Helvetica-Bold for ASCII 32–126.
+var helveticaBoldWidths = [95]int{
+	278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+	975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+	333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+	611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+}
+
+type standardFont struct {
+	name     string // resource name, e.g. F1
+	baseFont string
+	widths   *[95]int
+}
+
+var (
+	pdfHelvetica     PDFFont = &standardFont{name: "FH", baseFont: "Helvetica", widths: &helveticaWidths}
+	pdfHelveticaBold PDFFont = &standardFont{name: "FHB", baseFont: "Helvetica-Bold", widths: &helveticaBoldWidths}
+)
+
+func (f *standardFont) resourceName() string   { return f.name }
+func (f *standardFont) encode(s string) string { return pdfLiteral(s) }
+
+func (f *standardFont) width(s string, size float64) float64 {
+	total := 0
+	for _, r := range s {
+		if r >= 32 && r <= 126 {
+			total += f.widths[r-32]
+		} else {
+			total += 556
+		}
+	}
+	return float64(total) * size / 1000
+}
+
+func (f *standardFont) supports(s string) bool {
+	for _, r := range s {
+		if r > 0xFF {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *standardFont) writeObjects(w *pdfWriter) int {
+	return w.object(fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", f.baseFont))
+}
+
+// --- Embedded TrueType fonts ---
+
+// TrueTypeFont is a parsed TrueType (glyf-based) font file. The whole file is
+// embedded in each document that uses it, as a CIDFontType2 with Identity-H
+// encoding, so any character the font covers (including Thai) can be shown.
+type TrueTypeFont struct {
+	name       string
+	data       []byte
+	unitsPerEm int
+	bbox       [4]int
+	ascent     int
+	descent    int
+	capHeight  int
+	advances   []int
+	cmap       map[rune]uint16
+}
+
+// docTrueTypeFont is a TrueTypeFont bound to one document, tracking used glyphs.
+type docTrueTypeFont struct {
+	*TrueTypeFont
+	used map[uint16]rune
+}
+
+// LoadTrueTypeFont reads and parses a .ttf file.
+func LoadTrueTypeFont(path, resourceName string) (*TrueTypeFont, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := ParseTrueTypeFont(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	f.name = resourceName
+	return f, nil
+}
+
+// ParseTrueTypeFont extracts the metrics and character map needed for embedding.
+func ParseTrueTypeFont(data []byte) (*TrueTypeFont, error) {
+	if len(data) < 12 {
+		return nil, fmt.Errorf("font file too short")
+	}
+	if string(data[:4]) == "OTTO" {
+		return nil, fmt.Errorf("CFF-based OpenType fonts are not supported; use a TrueType (.ttf) font")
+	}
+	u16 := func(off int) int {
+		if off < 0 || off+2 > len(data) {
+			return 0
+		}
+		return int(binary.BigEndian.Uint16(data[off:]))
+	}
+	i16 := func(off int) int { return int(int16(u16(off))) }
+	u32 := func(off int) int {
+		if off < 0 || off+4 > len(data) {
+			return 0
+		}
+		return int(binary.BigEndian.Uint32(data[off:]))
+	}
+
+	tables := map[string]int{}
+	numTables := u16(4)
+	for i := 0; i < numTables; i++ {
+		rec := 12 + 16*i
+		if rec+16 > len(data) {
+			return nil, fmt.Errorf("truncated table directory")
+		}
+		tables[string(data[rec:rec+4])] = u32(rec + 8)
+	}
+	for _, tag := range []string{"head", "hhea", "hmtx", "maxp", "cmap", "glyf"} {
+		if _, ok := tables[tag]; !ok {
+			return nil, fmt.Errorf("missing %s table", tag)
+		}
+	}
+
+	f := &TrueTypeFont{data: data, cmap: map[rune]uint16{}}
+	head, hhea := tables["head"], tables["hhea"]
+	f.unitsPerEm = u16(head + 18)
+	if f.unitsPerEm == 0 {
+		return nil, fmt.Errorf("invalid unitsPerEm")
+	}
+	f.bbox = [4]int{i16(head + 36), i16(head + 38), i16(head + 40), i16(head + 42)}
+	f.ascent, f.descent = i16(hhea+4), i16(hhea+6)
+	f.capHeight = f.ascent
+	if os2, ok := tables["OS/2"]; ok && u16(os2) >= 2 {
+		f.capHeight = i16(os2 + 88)
+	}
+
+	numGlyphs := u16(tables["maxp"] + 4)
+	numHMetrics := u16(hhea + 34)
+	f.advances = make([]int, numGlyphs)
+	last := 0
+	for g := 0; g < numGlyphs; g++ {
+		if g < numHMetrics {
+			last = u16(tables["hmtx"] + 4*g)
+		}
+		f.advances[g] = last
+	}
+
+	// Prefer a full Unicode (format 12) subtable, then the BMP (format 4) one.
+	cmap := tables["cmap"]
+	var fmt4, fmt12 int
+	for i := 0; i < u16(cmap+2); i++ {
+		rec := cmap + 4 + 8*i
+		platform, encoding, sub := u16(rec), u16(rec+2), cmap+u32(rec+4)
+		switch u16(sub) {
+		case 12:
+			if platform == 3 && encoding == 10 || platform == 0 {
+				fmt12 = sub
+			}
+		case 4:
+			if platform == 3 && encoding == 1 || platform == 0 {
+				fmt4 = sub
+			}
+		}
+	}
+	switch {
+	case fmt12 != 0:
+		for g := 0; g < u32(fmt12+12); g++ {
+			grp := fmt12 + 16 + 12*g
+			start, end, glyph := u32(grp), u32(grp+4), u32(grp+8)
+			for c := start; c <= end && c-start < 0x10000; c++ {
+				f.cmap[rune(c)] = uint16(glyph + c - start)
+			}
+		}
+	case fmt4 != 0:
+		segCount := u16(fmt4+6) / 2
+		ends := fmt4 + 14
+		starts := ends + 2*segCount + 2
+		deltas := starts + 2*segCount
+		rangeOffsets := deltas + 2*segCount
+		for s := 0; s < segCount; s++ {
+			start, end := u16(starts+2*s), u16(ends+2*s)
+			delta, ro := u16(deltas+2*s), u16(rangeOffsets+2*s)
+			for c := start; c <= end && c != 0xFFFF; c++ {
+				var g int
+				if ro == 0 {
+					g = (c + delta) & 0xFFFF
+				} else if g = u16(rangeOffsets + 2*s + ro + 2*(c-start)); g != 0 {
+					g = (g + delta) & 0xFFFF
+				}
+				if g != 0 {
+					f.cmap[rune(c)] = uint16(g)
+				}
+			}
+		}
+	default:
+		return nil, fmt.Errorf("no Unicode cmap subtable")
+	}
+	return f, nil
+}
+
+// forDocument returns a per-document handle that records which glyphs are used.
+func (f *TrueTypeFont) forDocument() *docTrueTypeFont {
+	return &docTrueTypeFont{TrueTypeFont: f, used: map[uint16]rune{}}
+}
+
+func (f *docTrueTypeFont) resourceName() string { return f.name }
+
+func (f *docTrueTypeFont) glyph(r rune) uint16 {
+	return f.cmap[r] // 0 is .notdef
+}
+
+func (f *docTrueTypeFont) encode(s string) string {
+	var b strings.Builder
+	b.WriteByte('<')
+	for _, r := range s {
+		g := f.glyph(r)
+		if _, ok := f.used[g]; !ok {
+			f.used[g] = r
+		}
+		fmt.Fprintf(&b, "%04X", g)
+	}
+	b.WriteByte('>')
+	return b.String()
+}
+
+func (f *docTrueTypeFont) scaled(units int) int { return units * 1000 / f.unitsPerEm }
+
+func (f *docTrueTypeFont) width(s string, size float64) float64 {
+	total := 0
+	for _, r := range s {
+		if g := int(f.glyph(r)); g < len(f.advances) {
+			total += f.advances[g]
+		}
+	}
+	return float64(total) * size / float64(f.unitsPerEm)
+}
+
+func (f *docTrueTypeFont) supports(s string) bool {
+	for _, r := range s {
+		if _, ok := f.cmap[r]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *docTrueTypeFont) writeObjects(w *pdfWriter) int {
+	baseFont := "EBill" + f.name
+	fontFile := w.stream(fmt.Sprintf("/Length1 %d ", len(f.data)), f.data)
+	descriptor := w.object(fmt.Sprintf("<< /Type /FontDescriptor /FontName /%s /Flags 32 /FontBBox [%d %d %d %d] /ItalicAngle 0 /Ascent %d /Descent %d /CapHeight %d /StemV 80 /FontFile2 %d 0 R >>",
+		baseFont, f.scaled(f.bbox[0]), f.scaled(f.bbox[1]), f.scaled(f.bbox[2]), f.scaled(f.bbox[3]),
+		f.scaled(f.ascent), f.scaled(f.descent), f.scaled(f.capHeight), fontFile))
+
+	glyphs := make([]int, 0, len(f.used))
+	for g := range f.used {
+		glyphs = append(glyphs, int(g))
+	}
+	sort.Ints(glyphs)
+	var widths, toUnicode strings.Builder
+	for _, g := range glyphs {
+		adv := 0
+		if g < len(f.advances) {
+			adv = f.advances[g]
+		}
+		fmt.Fprintf(&widths, "%d [%d] ", g, f.scaled(adv))
+	}
+	if len(glyphs) > 0 && glyphs[0] == 0 {
+		glyphs = glyphs[1:] // .notdef has no meaningful Unicode value
+	}
+	// ToUnicode lets viewers copy and search the text; bfchar blocks hold at most 100 entries.
+	toUnicode.WriteString("/CIDInit /ProcSet findresource begin 12 dict begin begincmap\n/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n/CMapName /Adobe-Identity-UCS def /CMapType 2 def\n1 begincodespacerange <0000> <FFFF> endcodespacerange\n")
+	for i := 0; i < len(glyphs); i += 100 {
+		chunk := glyphs[i:min(i+100, len(glyphs))]
+		fmt.Fprintf(&toUnicode, "%d beginbfchar\n", len(chunk))
+		for _, g := range chunk {
+			r := f.used[uint16(g)]
+			utf16 := ""
+			if r > 0xFFFF {
+				r -= 0x10000
+				utf16 = fmt.Sprintf("%04X%04X", 0xD800+(r>>10), 0xDC00+(r&0x3FF))
+			} else {
+				utf16 = fmt.Sprintf("%04X", r)
+			}
+			fmt.Fprintf(&toUnicode, "<%04X> <%s>\n", g, utf16)
+		}
+		toUnicode.WriteString("endbfchar\n")
+	}
+	toUnicode.WriteString("endcmap CMapName currentdict /CMap defineresource pop end end\n")
+	cmap := w.stream("", []byte(toUnicode.String()))
+
+	cidFont := w.object(fmt.Sprintf("<< /Type /Font /Subtype /CIDFontType2 /BaseFont /%s /CIDSystemInfo << /Registry (Adobe) /Ordering (Identity) /Supplement 0 >> /FontDescriptor %d 0 R /W [%s] /CIDToGIDMap /Identity >>",
+		baseFont, descriptor, widths.String()))
+	return w.object(fmt.Sprintf("<< /Type /Font /Subtype /Type0 /BaseFont /%s /Encoding /Identity-H /DescendantFonts [%d 0 R] /ToUnicode %d 0 R >>",
+		baseFont, cidFont, cmap))
+}
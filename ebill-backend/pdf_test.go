@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRenderInvoicePDF(t *testing.T) {
+	defer func(regular, bold *TrueTypeFont, pp PromptPayConfig) {
+		pdfRegularTTF, pdfBoldTTF, promptPay = regular, bold, pp
+	}(pdfRegularTTF, pdfBoldTTF, promptPay)
+	promptPay = PromptPayConfig{AccountID: "0812345678"}
+
+	for _, font := range []*TrueTypeFont{nil, testTrueTypeFont(t, false)} {
+		pdfRegularTTF, pdfBoldTTF = font, nil
+		doc, err := renderInvoicePDF(testInvoiceDocument())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if _, err := doc.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		objects, streams := readPDF(t, buf.Bytes())
+		var content string
+		for n, s := range streams {
+			if !strings.Contains(objects[n], "/Length1") {
+				content += string(s)
+			}
+		}
+		if font == nil {
+			for _, want := range []string{"(INVOICE) Tj", "(INV-202404-00001) Tj", "(Balance due) Tj", "(1,498.00 THB) Tj", "re\nf"} {
+				if !strings.Contains(content, want) {
+					t.Errorf("Helvetica invoice content has no %q", want)
+				}
+			}
+			continue
+		}
+		// The embedded font is written whole, and the bilingual labels use
+		// its glyphs.
+		var embedded bool
+		for n, dict := range objects {
+			if strings.Contains(dict, fmt.Sprintf("/Length1 %d ", len(font.data))) {
+				embedded = bytes.Equal(streams[n], font.data)
+			}
+		}
+		if !embedded {
+			t.Error("the TrueType font is not embedded unchanged")
+		}
+		if !strings.Contains(content, "/FU ") || !strings.Contains(content, "<0001") {
+			t.Error("TrueType invoice content does not use the font's glyphs")
+		}
+	}
+}
+
+func TestPDFTrueTypeText(t *testing.T) {
+	f := testTrueTypeFont(t, false).forDocument()
+	doc := NewPDFDocument("Test (1)")
+	p := doc.AddPage()
+	p.Text(40, 40, f, 12, "ABกข")
+	p.TextRight(300, 60, f, 12, "CA")
+	p.Text(40, 80, pdfHelvetica, 10, "Total (THB)")
+	var buf bytes.Buffer
+	if _, err := doc.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	objects, streams := readPDF(t, buf.Bytes())
+	var all, content strings.Builder
+	for n, dict := range objects {
+		all.WriteString(dict + "\n")
+		if !strings.Contains(dict, "/Length1") {
+			content.Write(streams[n])
+		}
+	}
+	for _, want := range []string{
+		"/Title (Test \\(1\\))",
+		"/Subtype /Type0 /BaseFont /EBillFU /Encoding /Identity-H",
+		"/W [1 [292] 2 [297] 3 [302] 4 [302] 5 [302] ]", // Glyphs 4 and 5 repeat the last hmtx advance
+		"/Ascent 927 /Descent -244 /CapHeight 683",
+		"/FontBBox [-48 -244 976 927]",
+		"/BaseFont /Helvetica /Encoding /WinAnsiEncoding",
+	} {
+		if !strings.Contains(all.String(), want) {
+			t.Errorf("objects have no %q", want)
+		}
+	}
+	for _, want := range []string{
+		"/FU 12.00 Tf 40.00 801.89 Td <0001000200040005> Tj",
+		fmt.Sprintf("/FU 12.00 Tf %.2f 781.89 Td <00030001> Tj", 300-float64(620+600)*12/2048),
+		"(Total \\(THB\\)) Tj",
+		"<0001> <0041>\n<0002> <0042>\n<0003> <0043>\n<0004> <0E01>\n<0005> <0E02>\n",
+	} {
+		if !strings.Contains(content.String(), want) {
+			t.Errorf("streams have no %q", want)
+		}
+	}
+}
+
+func TestParseTrueTypeFont(t *testing.T) {
+	for _, format12 := range []bool{false, true} {
+		f := testTrueTypeFont(t, format12)
+		if f.unitsPerEm != 2048 || f.bbox != [4]int{-100, -500, 2000, 1900} || f.ascent != 1900 || f.descent != -500 || f.capHeight != 1400 {
+			t.Errorf("format12=%v: metrics = %d %v %d %d %d", format12, f.unitsPerEm, f.bbox, f.ascent, f.descent, f.capHeight)
+		}
+		if fmt.Sprint(f.advances) != "[500 600 610 620 620 620]" {
+			t.Errorf("format12=%v: advances = %v", format12, f.advances)
+		}
+		want := map[rune]uint16{'A': 1, 'B': 2, 'C': 3, 'ก': 4, 'ข': 5}
+		if fmt.Sprint(f.cmap) != fmt.Sprint(want) {
+			t.Errorf("format12=%v: cmap = %v, want %v", format12, f.cmap, want)
+		}
+		d := f.forDocument()
+		if !d.supports("CABข") || d.supports("CAB ข") || d.supports("D") {
+			t.Errorf("format12=%v: supports is wrong", format12)
+		}
+		if got, want := d.width("ABz", 2048), 600.0+610+500; got != want {
+			t.Errorf("format12=%v: width = %g, want %g", format12, got, want)
+		}
+	}
+
+	valid := buildTrueTypeFont(false)
+	tests := map[string][]byte{
+		"too short":    valid[:10],
+		"CFF":          append([]byte("OTTO"), valid[4:]...),
+		"no cmap":      bytes.Replace(valid, []byte("cmap"), []byte("xxxx"), 1),
+		"no glyf":      bytes.Replace(valid, []byte("glyf"), []byte("xxxx"), 1),
+		"truncated":    valid[:12+16*3],
+		"zero units":   withU16(valid, tableOffset(valid, "head")+18, 0),
+		"symbol cmap":  withU16(valid, tableOffset(valid, "cmap")+6, 0),
+		"no subtables": withU16(valid, tableOffset(valid, "cmap")+2, 0),
+	}
+	for name, data := range tests {
+		if _, err := ParseTrueTypeFont(data); err == nil {
+			t.Errorf("%s: parsed, want an error", name)
+		}
+	}
+}
+
+// TestDefaultPDFFont parses the first installed font of defaultPDFFontPaths.
+func TestDefaultPDFFont(t *testing.T) {
+	for _, pair := range defaultPDFFontPaths {
+		if _, err := os.Stat(pair[0]); err != nil {
+			continue
+		}
+		f, err := LoadTrueTypeFont(pair[0], "FU")
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := f.forDocument()
+		if !d.supports("Invoice ใบแจ้งค่าไฟฟ้า 0123456789") {
+			t.Errorf("%s does not cover the invoice labels", pair[0])
+		}
+		if g := d.glyph('ก'); int(g) >= len(f.advances) || f.advances[g] == 0 {
+			t.Errorf("%s: glyph %d of ก has no advance", pair[0], g)
+		}
+		return
+	}
+	t.Skip("none of defaultPDFFontPaths is installed")
+}
+
+func testInvoiceDocument() *invoiceDocument {
+	invoice, issued := "INV-202404-00001", "2024-04-05"
+	return &invoiceDocument{
+		Bill: Billing{
+			BillID: 7, CustomerID: 42, MeterID: 3, BillingDate: "2024-04-01", DueDate: "2024-04-30",
+			ReadingPrevious: NewDecimal(100000, 2), ReadingCurrent: NewDecimal(130000, 2), RateApplied: NewDecimal(45000, 4),
+			TotalUnit: NewDecimal(30000, 2), AmountDue: MoneyFromSatang(159800), Currency: "THB",
+			Status: BillStatusPartiallyPaid, InvoiceNumber: &invoice, IssuedDate: &issued,
+			LineItems: []BillLineItem{
+				{ItemType: "energy", Description: "Energy charge", Quantity: NewDecimal(30000, 2), UnitPrice: NewDecimal(45000, 4), Amount: MoneyFromSatang(135000)},
+				{ItemType: "service", Description: "Service charge", Quantity: NewDecimal(1, 0), UnitPrice: NewDecimal(3892, 2), Amount: MoneyFromSatang(3892)},
+				{ItemType: LineItemVAT, Description: "VAT", Quantity: NewDecimal(138892, 2), UnitPrice: NewDecimal(7, 2), Amount: MoneyFromSatang(9722)},
+			},
+		},
+		Customer:    Customer{CustomerID: 42, Name: "Somchai Jaidee", Address: "99 Moo 1, Tambon Suthep, Mueang Chiang Mai, Chiang Mai 50200"},
+		MeterNumber: "MTR-0003",
+		Adjustments: MoneyFromSatang(-10000),
+		Paid:        MoneyFromSatang(0),
+	}
+}
+
+// readPDF checks the structure of a PDF written by PDFDocument: the header,
+// an xref table whose offsets point at every object in turn, the trailer and
+// the %%EOF marker. It returns each object's dictionary and the decompressed
+// data of its stream, if any.
+func readPDF(t *testing.T, data []byte) (objects map[int]string, streams map[int][]byte) {
+	t.Helper()
+	if !bytes.HasPrefix(data, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(data, []byte("\n%%EOF\n")) {
+		t.Fatalf("PDF does not start with %%PDF-1.4 and end with %%%%EOF")
+	}
+	var xref, n int
+	i := bytes.LastIndex(data, []byte("startxref\n"))
+	if _, err := fmt.Sscanf(string(data[i+10:]), "%d\n", &xref); i < 0 || err != nil {
+		t.Fatalf("no startxref: %v", err)
+	}
+	if _, err := fmt.Sscanf(string(data[xref:]), "xref\n0 %d\n", &n); err != nil {
+		t.Fatalf("startxref %d does not point at the xref table: %v", xref, err)
+	}
+	entries := xref + len(fmt.Sprintf("xref\n0 %d\n", n))
+	if string(data[entries:entries+20]) != "0000000000 65535 f \n" {
+		t.Fatalf("xref entry 0 is %q", data[entries:entries+20])
+	}
+	objects, streams = map[int]string{}, map[int][]byte{}
+	for obj := 1; obj < n; obj++ {
+		entry := string(data[entries+20*obj : entries+20*obj+20])
+		var off int
+		if _, err := fmt.Sscanf(entry, "%010d 00000 n \n", &off); err != nil || off >= xref {
+			t.Fatalf("xref entry %d is %q", obj, entry)
+		}
+		header := fmt.Sprintf("%d 0 obj\n", obj)
+		if !bytes.HasPrefix(data[off:], []byte(header)) {
+			t.Fatalf("xref offset %d of object %d points at %q", off, obj, data[off:min(off+20, len(data))])
+		}
+		body := data[off+len(header):]
+		var length int
+		if _, err := fmt.Sscanf(string(body), "<< /Length %d ", &length); err != nil {
+			end := bytes.Index(body, []byte("\nendobj\n"))
+			if end < 0 {
+				t.Fatalf("object %d has no endobj", obj)
+			}
+			objects[obj] = string(body[:end])
+			continue
+		}
+		start := bytes.Index(body, []byte(">>\nstream\n")) + 10
+		if !bytes.HasPrefix(body[start+length:], []byte("\nendstream\nendobj\n")) {
+			t.Fatalf("stream of object %d does not end after its /Length %d", obj, length)
+		}
+		zr, err := zlib.NewReader(bytes.NewReader(body[start : start+length]))
+		if err != nil {
+			t.Fatalf("object %d: %v", obj, err)
+		}
+		if streams[obj], err = io.ReadAll(zr); err != nil {
+			t.Fatalf("object %d: %v", obj, err)
+		}
+		objects[obj] = string(body[:start])
+	}
+	trailer := string(data[entries+20*n:])
+	if !strings.HasPrefix(trailer, fmt.Sprintf("trailer\n<< /Size %d /Root ", n)) {
+		t.Fatalf("trailer is %q", trailer)
+	}
+	var root int
+	fmt.Sscanf(trailer[len(fmt.Sprintf("trailer\n<< /Size %d /Root ", n)):], "%d", &root)
+	if !strings.HasPrefix(objects[root], "<< /Type /Catalog ") {
+		t.Fatalf("root object %d is %q", root, objects[root])
+	}
+	return objects, streams
+}
+
+// testTrueTypeFont parses the font from buildTrueTypeFont.
+func testTrueTypeFont(t *testing.T, format12 bool) *TrueTypeFont {
+	t.Helper()
+	f, err := ParseTrueTypeFont(buildTrueTypeFont(format12))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.name = "FU"
+	return f
+}
+
+// buildTrueTypeFont assembles a small TrueType font with 2048 units per em
+// and six glyphs: .notdef, A, B, C, ก and ข. Only glyphs 0–3 have their own
+// horizontal metrics. The character map is a format 4 subtable using both
+// an idDelta and an idRangeOffset segment, or a format 12 subtable.
+func buildTrueTypeFont(format12 bool) []byte {
+	be := func(vs ...int) []byte {
+		var b []byte
+		for _, v := range vs {
+			b = binary.BigEndian.AppendUint16(b, uint16(v))
+		}
+		return b
+	}
+	head := make([]byte, 54)
+	copy(head[18:], be(2048))
+	copy(head[36:], be(-100, -500, 2000, 1900))
+	hhea := make([]byte, 36)
+	copy(hhea[4:], be(1900, -500))
+	copy(hhea[34:], be(4))
+	maxp := be(0, 0x5000, 6)
+	hmtx := be(500, 0, 600, 0, 610, 0, 620, 0, 0, 0)
+	os2 := make([]byte, 96)
+	copy(os2, be(2))
+	copy(os2[88:], be(1400))
+
+	var sub []byte
+	if format12 {
+		sub = be(12, 0, 0, 40, 0, 0, 0, 2)
+		sub = binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(sub, 'A'), 'C'), 1)
+		sub = binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(sub, 'ก'), 'ข'), 4)
+	} else {
+		// Segments: A–C by idDelta, ก–ข through glyphIdArray, and the final 0xFFFF.
+		sub = be(4, 0, 0, 6, 4, 1, 2)
+		sub = append(sub, be('C', 'ข', 0xFFFF, 0, 'A', 'ก', 0xFFFF, 1-'A', 0, 1, 0, 4, 0, 4, 5)...)
+	}
+	platform, encoding := 3, 1
+	if format12 {
+		encoding = 10
+	}
+	cmap := append(be(0, 1, platform, encoding, 0, 12), sub...)
+
+	tables := []struct {
+		tag  string
+		data []byte
+	}{{"OS/2", os2}, {"cmap", cmap}, {"glyf", nil}, {"head", head}, {"hhea", hhea}, {"hmtx", hmtx}, {"maxp", maxp}}
+	out := be(0x0001, 0, len(tables), 0, 0, 0)
+	off := 12 + 16*len(tables)
+	var body []byte
+	for _, tb := range tables {
+		out = append(out, tb.tag...)
+		out = binary.BigEndian.AppendUint32(out, 0)
+		out = binary.BigEndian.AppendUint32(out, uint32(off+len(body)))
+		out = binary.BigEndian.AppendUint32(out, uint32(len(tb.data)))
+		body = append(body, tb.data...)
+		for len(body)%4 != 0 {
+			body = append(body, 0)
+		}
+	}
+	return append(out, body...)
+}
+
+// tableOffset returns the offset of a table of a font from buildTrueTypeFont.
+func tableOffset(font []byte, tag string) int {
+	for i := 12; i+16 <= len(font); i += 16 {
+		if string(font[i:i+4]) == tag {
+			return int(binary.BigEndian.Uint32(font[i+8:]))
+		}
+	}
+	return -1
+}
+
+// withU16 returns a copy of data with v written at off.
+func withU16(data []byte, off, v int) []byte {
+	out := bytes.Clone(data)
+	binary.BigEndian.PutUint16(out[off:], uint16(v))
+	return out
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// PromptPay QR payloads follow the EMVCo merchant-presented QR specification as
+// profiled by the Bank of Thailand ("Thai QR Payment"). Each field is encoded
+// as a two-digit ID, a two-digit length and the value; the payload ends with a
+// CRC-16/CCITT-FALSE checksum over everything before it.
+//
+// Two receiving modes are supported:
+//   - Bill payment (tag 30) when PROMPTPAY_BILLER_ID is set. The bill reference
+//     travels as Reference 1 and is returned by the bank with the transfer,
+//     which is what automatic reconciliation relies on.
+//   - Credit transfer (tag 29) to PROMPTPAY_ID, a mobile number, national/tax ID
+//     or e-wallet ID. The reference is carried in the additional data field,
+//     which not every banking app passes on.
+const (
+	promptPayCreditTransferAID = "A000000677010111"
+	promptPayBillPaymentAID    = "A000000677010112"
+	thaiBahtNumericCode        = "764"
+	promptPayMethod            = "PromptPay"
+)
+
+// PromptPayConfig is the account that receives PromptPay payments.
+type PromptPayConfig struct {
+	BillerID  string // 15-digit biller ID (tax ID + 2-digit suffix) for bill payment
+	AccountID string // mobile number, 13-digit national/tax ID or 15-digit e-wallet ID
+}
+
+var promptPay PromptPayConfig
+
+var digitsOnly = regexp.MustCompile(`^[0-9]+$`)
+
+// loadPromptPayConfig reads PROMPTPAY_BILLER_ID and PROMPTPAY_ID.
+// Neither is required; without them bills simply have no payment QR code.
+func loadPromptPayConfig() error {
+	promptPay.BillerID = strings.ReplaceAll(os.Getenv("PROMPTPAY_BILLER_ID"), "-", "")
+	promptPay.AccountID = strings.NewReplacer("-", "", " ", "").Replace(os.Getenv("PROMPTPAY_ID"))
+	if id := promptPay.BillerID; id != "" && (len(id) != 15 || !digitsOnly.MatchString(id)) {
+		return fmt.Errorf("PROMPTPAY_BILLER_ID must be 15 digits")
+	}
+	if id := promptPay.AccountID; id != "" {
+		if _, _, err := promptPayAccountField(id); err != nil {
+			return fmt.Errorf("PROMPTPAY_ID: %w", err)
+		}
+	}
+	switch {
+	case promptPay.BillerID != "":
+		log.Printf("PromptPay: bill payment to biller %s", promptPay.BillerID)
+	case promptPay.AccountID != "":
+		log.Printf("PromptPay: credit transfer to %s", promptPay.AccountID)
+	default:
+		log.Println("PromptPay: not configured (set PROMPTPAY_BILLER_ID or PROMPTPAY_ID); bills will have no payment QR code")
+	}
+	return nil
+}
+
+// Configured reports whether PromptPay payments can be received.
+func (c PromptPayConfig) Configured() bool {
+	return c.BillerID != "" || c.AccountID != ""
+}
+
+// promptPayAccountField returns the sub-tag and value identifying a credit transfer target.
+func promptPayAccountField(id string) (tag, value string, err error) {
+	if !digitsOnly.MatchString(id) {
+		return "", "", fmt.Errorf("%q must contain only digits", id)
+	}
+	switch {
+	case len(id) == 10 && id[0] == '0': // mobile number, in international form
+		return "01", "0066" + id[1:], nil
+	case len(id) == 13: // national ID or tax ID
+		return "02", id, nil
+	case len(id) == 15: // e-wallet ID
+		return "03", id, nil
+	}
+	return "", "", fmt.Errorf("%q is not a 10-digit mobile number, 13-digit ID or 15-digit e-wallet ID", id)
+}
+
+// emvField encodes one ID-length-value field.
+func emvField(id, value string) string {
+	return fmt.Sprintf("%s%02d%s", id, len(value), value)
+}
+
+// crc16CCITT computes CRC-16/CCITT-FALSE (polynomial 0x1021, initial value 0xFFFF).
+func crc16CCITT(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+var nonAlphanumeric = regexp.MustCompile(`[^A-Z0-9]`)
+
+// promptPayReference turns an invoice number into the bill reference carried by
+// the QR code: upper-case letters and digits only, as banks require, e.g.
+// INV-202404-00001 becomes INV20240400001. It must match the generated
+// Billing.Payment_Reference column, which is what incoming transfers are matched on.
+func promptPayReference(invoiceNumber string) string {
+	return nonAlphanumeric.ReplaceAllString(strings.ToUpper(invoiceNumber), "")
+}
+
+// PromptPayPayload builds the Thai QR payload for paying amount against reference.
+// The QR is dynamic (single use) because it carries the exact amount.
+func (c PromptPayConfig) PromptPayPayload(reference string, amount Money, merchantName string) (string, error) {
+	if !c.Configured() {
+		return "", fmt.Errorf("PromptPay is not configured")
+	}
+	if len(reference) == 0 || len(reference) > 20 {
+		return "", fmt.Errorf("payment reference %q must be 1 to 20 characters", reference)
+	}
+	if amount.Sign() <= 0 {
+		return "", fmt.Errorf("amount must be positive")
+	}
+	var b strings.Builder
+	b.WriteString(emvField("00", "01"))
+	b.WriteString(emvField("01", "12"))
+	if c.BillerID != "" {
+		b.WriteString(emvField("30", emvField("00", promptPayBillPaymentAID)+emvField("01", c.BillerID)+emvField("02", reference)))
+	} else {
+		tag, value, err := promptPayAccountField(c.AccountID)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(emvField("29", emvField("00", promptPayCreditTransferAID)+emvField(tag, value)))
+	}
+	b.WriteString(emvField("53", thaiBahtNumericCode))
+	b.WriteString(emvField("54", amount.String()))
+	b.WriteString(emvField("58", "TH"))
+	if name := asciiMerchantName(merchantName); name != "" {
+		b.WriteString(emvField("59", name))
+	}
+	b.WriteString(emvField("62", emvField("01", reference)))
+	b.WriteString("6304")
+	return b.String() + fmt.Sprintf("%04X", crc16CCITT([]byte(b.String()))), nil
+}
+
+// asciiMerchantName returns name truncated to the 25 characters allowed in tag 59,
+// or "" if it is not plain ASCII (the tag is optional).
+func asciiMerchantName(name string) string {
+	for _, r := range name {
+		if r < 0x20 || r > 0x7E {
+			return ""
+		}
+	}
+	return strings.TrimSpace(name[:min(len(name), 25)])
+}
+
+// PromptPayQR is the response of the /billing/{id}/qr endpoints.
+type PromptPayQR struct {
+	BillID        int    `json:"Bill_ID"`
+	InvoiceNumber string `json:"Invoice_Number"`
+	Reference     string `json:"Reference"`
+	Amount        Money  `json:"Amount"`
+	Currency      string `json:"Currency"`
+	Payload       string `json:"Payload"`
+	Image         string `json:"Image"` // PNG as a data: URL
+}
+
+// promptPayQRFor builds the PromptPay QR of a payable bill for its outstanding balance.
+// It returns nil and the reason when the bill cannot be paid by PromptPay.
+func promptPayQRFor(d *invoiceDocument) (qr *PromptPayQR, reason string, err error) {
+	b := &d.Bill
+	switch {
+	case !promptPay.Configured():
+		return nil, "PromptPay payments are not configured", nil
+	case !isBillPayable(b.Status) || b.InvoiceNumber == nil:
+		return nil, "This bill is " + b.Status + " and cannot be paid", nil
+	case b.Currency != DefaultCurrency:
+		return nil, "PromptPay only accepts payments in THB", nil
+	case d.Balance().Sign() <= 0:
+		return nil, "This bill has no outstanding balance", nil
+	}
+	qr = &PromptPayQR{
+		BillID:        b.BillID,
+		InvoiceNumber: *b.InvoiceNumber,
+		Reference:     promptPayReference(*b.InvoiceNumber),
+		Amount:        d.Balance(),
+		Currency:      b.Currency,
+	}
+	qr.Payload, err = promptPay.PromptPayPayload(qr.Reference, qr.Amount, branding.CompanyName)
+	return qr, "", err
+}
+
+// serveBillQR returns the PromptPay QR of a bill as JSON (payload plus image) or,
+// with ?format=png, as the PNG image alone. A non-empty identifier restricts
+// access to the bill's own customer, as for the portal PDFs.
+func serveBillQR(w http.ResponseWriter, r *http.Request, identifier string) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Bill ID")
+		return
+	}
+	d, err := loadInvoiceDocument(id)
+	if err == nil && identifier != "" && !ownsDocument(d, identifier) {
+		err = sql.ErrNoRows
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, http.StatusNotFound, "Bill not found")
+		} else {
+			respondWithServerError(w, "Failed to retrieve bill", err)
+		}
+		return
+	}
+	qr, reason, err := promptPayQRFor(d)
+	if err != nil {
+		respondWithServerError(w, "Failed to build PromptPay payload", err)
+		return
+	}
+	if qr == nil {
+		respondWithError(w, http.StatusConflict, reason)
+		return
+	}
+	code, err := EncodeQR([]byte(qr.Payload), QRLevelM)
+	if err != nil {
+		respondWithServerError(w, "Failed to encode QR code", err)
+		return
+	}
+	var img bytes.Buffer
+	if err := png.Encode(&img, code.Image(8)); err != nil {
+		respondWithServerError(w, "Failed to encode QR image", err)
+		return
+	}
+	if r.URL.Query().Get("format") == "png" {
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Cache-Control", "no-store") // the amount changes as payments arrive
+		w.Write(img.Bytes())
+		return
+	}
+	qr.Image = "data:image/png;base64," + base64.StdEncoding.EncodeToString(img.Bytes())
+	respondWithJSON(w, http.StatusOK, qr)
+}
+
+func getBillingQR(w http.ResponseWriter, r *http.Request) {
+	log.Println("API: getBillingQR called")
+	serveBillQR(w, r, "")
+}
+
+func getCustomerBillQR(w http.ResponseWriter, r *http.Request) {
+	log.Println("API: getCustomerBillQR called")
+	identifier := r.URL.Query().Get("identifier")
+	if identifier == "" {
+		respondWithError(w, http.StatusBadRequest, "Identifier (email or phone number) is required")
+		return
+	}
+	serveBillQR(w, r, identifier)
+}
+
+// --- Inbound transfer reconciliation ---
+
+// reconcilePromptPayTransfer records a PromptPay transfer notification from the
+// bank and applies it to the bill whose reference it carries. A transfer already
+// seen (same transaction reference) is returned as stored, so banks may safely
+// retry notifications.
+func reconcilePromptPayTransfer(w http.ResponseWriter, r *http.Request) {
+	log.Println("API: reconcilePromptPayTransfer called")
+	var t InboundTransfer
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+	t.Source, t.ImportID = TransferSourcePromptPay, nil
+	if t.TransactionRef == "" {
+		respondWithError(w, http.StatusBadRequest, "Transaction_Ref is required")
+		return
+	}
+	if t.Amount.Sign() <= 0 {
+		respondWithError(w, http.StatusBadRequest, "Amount must be positive")
+		return
+	}
+	if t.Currency == "" {
+		t.Currency = DefaultCurrency
+	}
+	if t.TransferredAt == "" {
+		t.TransferredAt = time.Now().Format(time.RFC3339)
+	}
+	t.PaymentReference = promptPayReference(t.PaymentReference)
+
+	tx, err := auditFor(r).begin()
+	if err != nil {
+		respondWithServerError(w, "Failed to start database transaction", err)
+		return
+	}
+	defer tx.Rollback()
+
+	duplicate, err := findInboundTransfer(tx, &t)
+	if err != nil {
+		respondWithServerError(w, "Failed to look up transfer", err)
+		return
+	}
+	if duplicate {
+		respondWithJSON(w, http.StatusOK, t)
+		return
+	}
+
+	// The QR reference identifies exactly one bill, so a match is applied at
+	// once, including part payments.
+	t.MatchStatus = TransferUnmatched
+	var billID int
+	var status string
+	err = tx.QueryRow(`SELECT "bill_id", "bill_status" FROM "billing" WHERE "payment_reference" = $1 FOR UPDATE`,
+		t.PaymentReference).Scan(&billID, &status)
+	if err == nil {
+		err = applyTransfer(tx, &t, billID, status, false, currentUserID(r))
+	} else if err == sql.ErrNoRows {
+		err = nil // left unmatched for review
+	}
+	if err == nil {
+		err = insertInboundTransfer(tx, &t)
+	}
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			// A concurrent notification for the same transaction won the race.
+			respondWithError(w, http.StatusConflict, "Transfer "+t.TransactionRef+" is already being processed")
+			return
+		}
+		respondWithServerError(w, "Failed to reconcile transfer", err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondWithServerError(w, "Failed to commit transaction", err)
+		return
+	}
+	log.Printf("PromptPay transfer %s (%s) for reference %s: %s", t.TransactionRef, t.Amount.Format(t.Currency), t.PaymentReference, t.MatchStatus)
+	respondWithJSON(w, http.StatusCreated, t)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+func TestCRC16CCITT(t *testing.T) {
+	tests := []struct {
+		in   string
+		want uint16
+	}{
+		{"123456789", 0x29B1}, // The CRC-16/CCITT-FALSE check value
+		{"", 0xFFFF},
+		// Payloads produced by other PromptPay generators and accepted by banking apps.
+		{"00020101021129370016A000000677010111011300668999999995802TH53037646304", 0xFE29},
+		{"00020101021229370016A000000677010111011300660000000005802TH530376454044.226304", 0xE469},
+	}
+	for _, tt := range tests {
+		if got := crc16CCITT([]byte(tt.in)); got != tt.want {
+			t.Errorf("crc16CCITT(%q) = %04X, want %04X", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestPromptPayPayload(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    PromptPayConfig
+		reference string
+		amount    Money
+		merchant  string
+		want      string
+	}{
+		{
+			name:      "credit transfer to a mobile number",
+			config:    PromptPayConfig{AccountID: "0812345678"},
+			reference: "INV20240400001",
+			amount:    MoneyFromSatang(123450),
+			merchant:  "Provincial Electricity",
+			want: "000201" + "010212" +
+				"29370016A000000677010111" + "01130066812345678" +
+				"5303764" + "54071234.50" + "5802TH" + "5922Provincial Electricity" +
+				"62180114INV20240400001" + "6304A64D",
+		},
+		{
+			name:      "credit transfer to a national ID, non-ASCII merchant name dropped",
+			config:    PromptPayConfig{AccountID: "1234567890123"},
+			reference: "A1",
+			amount:    MoneyFromSatang(100),
+			merchant:  "การไฟฟ้า",
+			want: "000201" + "010212" +
+				"29370016A000000677010111" + "02131234567890123" +
+				"5303764" + "54041.00" + "5802TH" +
+				"62060102A1" + "63043526",
+		},
+		{
+			name:      "bill payment",
+			config:    PromptPayConfig{BillerID: "010555312345601", AccountID: "0812345678"},
+			reference: "INV20240400001",
+			amount:    MoneyFromSatang(6025),
+			want: "000201" + "010212" +
+				"30570016A000000677010112" + "0115010555312345601" + "0214INV20240400001" +
+				"5303764" + "540560.25" + "5802TH" +
+				"62180114INV20240400001" + "63040C22",
+		},
+	}
+	for _, tt := range tests {
+		got, err := tt.config.PromptPayPayload(tt.reference, tt.amount, tt.merchant)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s:\n got %s\nwant %s", tt.name, got, tt.want)
+		}
+		if err := checkEMVPayload(got); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+	}
+}
+
+func TestPromptPayPayloadRejects(t *testing.T) {
+	configured := PromptPayConfig{AccountID: "0812345678"}
+	tests := []struct {
+		name      string
+		config    PromptPayConfig
+		reference string
+		amount    Money
+	}{
+		{"not configured", PromptPayConfig{}, "INV1", MoneyFromSatang(100)},
+		{"empty reference", configured, "", MoneyFromSatang(100)},
+		{"reference too long", configured, "INV202404000010000001", MoneyFromSatang(100)},
+		{"zero amount", configured, "INV1", Money{}},
+		{"negative amount", configured, "INV1", MoneyFromSatang(-100)},
+	}
+	for _, tt := range tests {
+		if got, err := tt.config.PromptPayPayload(tt.reference, tt.amount, ""); err == nil {
+			t.Errorf("%s: got %s, want an error", tt.name, got)
+		}
+	}
+}
+
+func TestPromptPayAccountField(t *testing.T) {
+	tests := []struct {
+		id, tag, value string
+	}{
+		{"0812345678", "01", "0066812345678"},
+		{"1234567890123", "02", "1234567890123"},
+		{"123456789012345", "03", "123456789012345"},
+	}
+	for _, tt := range tests {
+		tag, value, err := promptPayAccountField(tt.id)
+		if err != nil || tag != tt.tag || value != tt.value {
+			t.Errorf("promptPayAccountField(%q) = %q, %q, %v; want %q, %q", tt.id, tag, value, err, tt.tag, tt.value)
+		}
+	}
+	for _, id := range []string{"", "812345678", "1812345678", "08123456789", "081-234-5678", "12345678901234"} {
+		if _, _, err := promptPayAccountField(id); err == nil {
+			t.Errorf("promptPayAccountField(%q) succeeded, want an error", id)
+		}
+	}
+}
+
+func TestPromptPayReference(t *testing.T) {
+	tests := map[string]string{
+		"INV-202404-00001": "INV20240400001",
+		"inv/2024/7":       "INV20247",
+		"ใบแจ้งหนี้-12":    "12",
+	}
+	for in, want := range tests {
+		if got := promptPayReference(in); got != want {
+			t.Errorf("promptPayReference(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// checkEMVPayload checks that payload is a sequence of well-formed
+// ID-length-value fields ending with a correct CRC field.
+func checkEMVPayload(payload string) error {
+	for i := 0; i < len(payload); {
+		if i+4 > len(payload) {
+			return fmt.Errorf("truncated field at offset %d", i)
+		}
+		id := payload[i : i+2]
+		n, err := strconv.Atoi(payload[i+2 : i+4])
+		if err != nil || i+4+n > len(payload) {
+			return fmt.Errorf("field %s at offset %d has a bad length", id, i)
+		}
+		if id == "63" {
+			if i+4+n != len(payload) {
+				return fmt.Errorf("CRC field is not last")
+			}
+			if want := fmt.Sprintf("%04X", crc16CCITT([]byte(payload[:i+4]))); payload[i+4:] != want {
+				return fmt.Errorf("CRC is %s, want %s", payload[i+4:], want)
+			}
+			return nil
+		}
+		i += 4 + n
+	}
+	return fmt.Errorf("no CRC field")
+}
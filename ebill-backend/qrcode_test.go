@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// The round-trip tests read symbols back with the small decoder below, which
+// follows ISO/IEC 18004 independently of the encoder: it locates the data
+// modules from its own table of alignment pattern positions, reads the format
+// and version information, removes the mask, checks every Reed–Solomon block
+// through its syndromes and parses the byte-mode segment. It handles clean,
+// undamaged symbols only.
+
+func TestEncodeQRRoundTrip(t *testing.T) {
+	payload, err := PromptPayConfig{BillerID: "010555312345601"}.PromptPayPayload("INV20240400001", MoneyFromSatang(6025), "Provincial Electricity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputs := [][]byte{
+		[]byte(payload),
+		[]byte("A"),
+		[]byte("https://example.com/bills/INV-202404-00001"),
+		[]byte("ใบแจ้งหนี้ค่าไฟฟ้า"),
+		bytes.Repeat([]byte{0x00, 0xFF, 0xEC, 0x11}, 40),
+		[]byte(strings.Repeat("0123456789", 30)), // Version 10 and up use a 16-bit count
+	}
+	for level := QRLevelL; level <= QRLevelH; level++ {
+		for _, data := range inputs {
+			q, err := EncodeQR(data, level)
+			if err != nil {
+				t.Errorf("level %d, %d bytes: %v", level, len(data), err)
+				continue
+			}
+			got, err := decodeQR(q.Modules)
+			if err != nil {
+				t.Errorf("level %d, %d bytes (version %d, mask %d): %v", level, len(data), q.Version, q.Mask, err)
+				continue
+			}
+			if got.level != level || got.version != q.Version || got.mask != q.Mask {
+				t.Errorf("level %d, %d bytes: read level %d, version %d, mask %d; encoded level %d, version %d, mask %d",
+					level, len(data), got.level, got.version, got.mask, level, q.Version, q.Mask)
+			}
+			if !bytes.Equal(got.data, data) {
+				t.Errorf("level %d: decoded %q, want %q", level, got.data, data)
+			}
+		}
+	}
+}
+
+func TestEncodeQRVersions(t *testing.T) {
+	// Byte-mode capacities from ISO/IEC 18004 Table 7.
+	tests := []struct {
+		level   QRErrorCorrection
+		version int
+		max     int
+	}{
+		{QRLevelL, 1, 17},
+		{QRLevelM, 1, 14},
+		{QRLevelQ, 1, 11},
+		{QRLevelH, 1, 7},
+		{QRLevelM, 5, 84},
+		{QRLevelM, 7, 122},
+		{QRLevelL, 10, 271},
+		{QRLevelH, 20, 382},
+		{QRLevelL, 40, 2953},
+		{QRLevelH, 40, 1273},
+	}
+	for _, tt := range tests {
+		q, err := EncodeQR(make([]byte, tt.max), tt.level)
+		if err != nil || q.Version != tt.version || q.Size != tt.version*4+17 {
+			t.Errorf("level %d, %d bytes: got %+v, %v; want version %d", tt.level, tt.max, q, err, tt.version)
+			continue
+		}
+		if got, err := decodeQR(q.Modules); err != nil || len(got.data) != tt.max {
+			t.Errorf("level %d, version %d: decode failed: %v", tt.level, tt.version, err)
+		}
+		if tt.version == 40 {
+			if _, err := EncodeQR(make([]byte, tt.max+1), tt.level); err == nil {
+				t.Errorf("level %d: %d bytes encoded, want an error", tt.level, tt.max+1)
+			}
+		} else if q, err := EncodeQR(make([]byte, tt.max+1), tt.level); err != nil || q.Version != tt.version+1 {
+			t.Errorf("level %d, %d bytes: want version %d", tt.level, tt.max+1, tt.version+1)
+		}
+	}
+}
+
+func TestQRImage(t *testing.T) {
+	q, err := EncodeQR([]byte("A"), QRLevelM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := q.Image(3)
+	if dim := (q.Size + 8) * 3; img.Bounds().Dx() != dim || img.Bounds().Dy() != dim {
+		t.Fatalf("image is %v, want %dx%d", img.Bounds(), dim, dim)
+	}
+	for y := 0; y < img.Bounds().Dy(); y++ {
+		for x := 0; x < img.Bounds().Dx(); x++ {
+			mx, my := x/3-4, y/3-4
+			dark := mx >= 0 && my >= 0 && mx < q.Size && my < q.Size && q.Modules[my][mx]
+			if got := img.GrayAt(x, y).Y == 0; got != dark {
+				t.Fatalf("pixel (%d, %d) dark = %v, want %v", x, y, got, dark)
+			}
+		}
+	}
+}
+
+// qrAlignmentPositions lists the alignment pattern centre coordinates of each
+// version, from ISO/IEC 18004 Annex E.
+var qrAlignmentPositions = [41][]int{
+	nil, nil,
+	{6, 18}, {6, 22}, {6, 26}, {6, 30}, {6, 34},
+	{6, 22, 38}, {6, 24, 42}, {6, 26, 46}, {6, 28, 50}, {6, 30, 54}, {6, 32, 58}, {6, 34, 62},
+	{6, 26, 46, 66}, {6, 26, 48, 70}, {6, 26, 50, 74}, {6, 30, 54, 78}, {6, 30, 56, 82}, {6, 30, 58, 86}, {6, 34, 62, 90},
+	{6, 28, 50, 72, 94}, {6, 26, 50, 74, 98}, {6, 30, 54, 78, 102}, {6, 28, 54, 80, 106}, {6, 32, 58, 84, 110}, {6, 30, 58, 86, 114}, {6, 34, 62, 90, 118},
+	{6, 26, 50, 74, 98, 122}, {6, 30, 54, 78, 102, 126}, {6, 26, 52, 78, 104, 130}, {6, 30, 56, 82, 108, 134}, {6, 34, 60, 86, 112, 138}, {6, 30, 58, 86, 114, 142}, {6, 34, 62, 90, 118, 146},
+	{6, 30, 54, 78, 102, 126, 150}, {6, 24, 50, 76, 102, 128, 154}, {6, 28, 54, 80, 106, 132, 158}, {6, 32, 58, 84, 110, 136, 162}, {6, 26, 54, 82, 110, 138, 166}, {6, 30, 58, 86, 114, 142, 170},
+}
+
+type decodedQR struct {
+	version int
+	level   QRErrorCorrection
+	mask    int
+	data    []byte
+}
+
+// bchCode appends the remainder of value*2^bits divided by poly, the BCH code
+// used by the format and version information.
+func bchCode(value, bits, poly int) int {
+	r := value << bits
+	for i := bits + 5 + 6; i >= bits; i-- { // Covers both 5- and 6-bit values
+		if r>>i&1 != 0 {
+			r ^= poly << (i - bits)
+		}
+	}
+	return value<<bits | r
+}
+
+func decodeQR(m [][]bool) (*decodedQR, error) {
+	size := len(m)
+	if size < 21 || size > 177 || (size-17)%4 != 0 {
+		return nil, fmt.Errorf("size %d is not a QR symbol size", size)
+	}
+	d := &decodedQR{version: (size - 17) / 4}
+	bit := func(x, y int) int {
+		if m[y][x] {
+			return 1
+		}
+		return 0
+	}
+
+	// Format information, both copies, with the fixed mask 101010000010010.
+	var first, second int
+	for i := 0; i <= 5; i++ {
+		first |= bit(8, i) << i
+	}
+	first |= bit(8, 7)<<6 | bit(8, 8)<<7 | bit(7, 8)<<8
+	for i := 9; i < 15; i++ {
+		first |= bit(14-i, 8) << i
+	}
+	for i := 0; i < 8; i++ {
+		second |= bit(size-1-i, 8) << i
+	}
+	for i := 8; i < 15; i++ {
+		second |= bit(8, size-15+i) << i
+	}
+	if first != second {
+		return nil, fmt.Errorf("format information copies differ: %015b, %015b", first, second)
+	}
+	format := -1
+	for v := 0; v < 32; v++ {
+		if bchCode(v, 10, 0x537)^0x5412 == first {
+			format = v
+		}
+	}
+	if format < 0 {
+		return nil, fmt.Errorf("format information %015b is not a valid codeword", first)
+	}
+	d.level = [...]QRErrorCorrection{QRLevelM, QRLevelL, QRLevelH, QRLevelQ}[format>>3]
+	d.mask = format & 7
+	if !m[size-8][8] {
+		return nil, fmt.Errorf("dark module is light")
+	}
+
+	// Version information, both copies, from version 7 up.
+	if d.version >= 7 {
+		want := bchCode(d.version, 12, 0x1F25)
+		for i := 0; i < 18; i++ {
+			a, b := size-11+i%3, i/3
+			if bit(a, b) != want>>i&1 || bit(b, a) != want>>i&1 {
+				return nil, fmt.Errorf("version information does not encode version %d", d.version)
+			}
+		}
+	}
+
+	// Function patterns: finders with separators and format areas, timing,
+	// alignment patterns and version areas.
+	function := make([][]bool, size)
+	for y := range function {
+		function[y] = make([]bool, size)
+	}
+	fill := func(x0, y0, w, h int) {
+		for y := y0; y < y0+h; y++ {
+			for x := x0; x < x0+w; x++ {
+				function[y][x] = true
+			}
+		}
+	}
+	fill(0, 0, 9, 9)
+	fill(size-8, 0, 8, 9)
+	fill(0, size-8, 9, 8)
+	fill(6, 0, 1, size)
+	fill(0, 6, size, 1)
+	pos := qrAlignmentPositions[d.version]
+	for i, cy := range pos {
+		for j, cx := range pos {
+			last := len(pos) - 1
+			if i == 0 && j == 0 || i == 0 && j == last || i == last && j == 0 {
+				continue
+			}
+			fill(cx-2, cy-2, 5, 5)
+		}
+	}
+	if d.version >= 7 {
+		fill(size-11, 0, 3, 6)
+		fill(0, size-11, 6, 3)
+	}
+	if !m[6][8] || m[6][9] || !m[8][6] || m[9][6] {
+		return nil, fmt.Errorf("timing patterns are wrong")
+	}
+
+	// Read the data modules two columns at a time from the right, zigzagging
+	// up and down and skipping the vertical timing pattern.
+	masked := [8]func(x, y int) bool{
+		func(x, y int) bool { return (x+y)%2 == 0 },
+		func(x, y int) bool { return y%2 == 0 },
+		func(x, y int) bool { return x%3 == 0 },
+		func(x, y int) bool { return (x+y)%3 == 0 },
+		func(x, y int) bool { return (y/2+x/3)%2 == 0 },
+		func(x, y int) bool { return x*y%2+x*y%3 == 0 },
+		func(x, y int) bool { return (x*y%2+x*y%3)%2 == 0 },
+		func(x, y int) bool { return ((x+y)%2+x*y%3)%2 == 0 },
+	}[d.mask]
+	var raw []byte
+	var cur, n int
+	upward := true
+	for right := size - 1; right > 0; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for i := 0; i < size; i++ {
+			y := i
+			if upward {
+				y = size - 1 - i
+			}
+			for x := right; x >= right-1; x-- {
+				if function[y][x] {
+					continue
+				}
+				dark := m[y][x] != masked(x, y)
+				cur <<= 1
+				if dark {
+					cur |= 1
+				}
+				if n++; n == 8 {
+					raw = append(raw, byte(cur))
+					cur, n = 0, 0
+				}
+			}
+		}
+		upward = !upward
+	}
+
+	// De-interleave the blocks and check each with its syndromes.
+	level := d.level
+	numBlocks := qrNumErrorCorrectionBlocks[level][d.version]
+	ecc := qrECCCodewordsPerBlock[level][d.version]
+	shortLen := len(raw) / numBlocks
+	numShort := numBlocks - len(raw)%numBlocks
+	blocks := make([][]byte, numBlocks)
+	k := 0
+	for i := 0; i < shortLen-ecc+1; i++ {
+		for b := range blocks {
+			if i < shortLen-ecc || b >= numShort {
+				blocks[b] = append(blocks[b], raw[k])
+				k++
+			}
+		}
+	}
+	var data []byte
+	for b := range blocks {
+		data = append(data, blocks[b]...)
+	}
+	for i := 0; i < ecc; i++ {
+		for b := range blocks {
+			blocks[b] = append(blocks[b], raw[k])
+			k++
+		}
+	}
+	if k != len(raw) {
+		return nil, fmt.Errorf("%d codewords left over", len(raw)-k)
+	}
+	for b, block := range blocks {
+		if !rsSyndromesZero(block, ecc) {
+			return nil, fmt.Errorf("block %d fails its Reed–Solomon check", b)
+		}
+	}
+
+	// A single byte-mode segment, its terminator and the pad bytes.
+	bits := func(from, count int) int {
+		v := 0
+		for i := from; i < from+count; i++ {
+			v = v<<1 | int(data[i/8]>>(7-i%8)&1)
+		}
+		return v
+	}
+	if mode := bits(0, 4); mode != 0x4 {
+		return nil, fmt.Errorf("mode %04b is not byte mode", mode)
+	}
+	countBits := 8
+	if d.version >= 10 {
+		countBits = 16
+	}
+	count := bits(4, countBits)
+	start := 4 + countBits
+	if start+count*8 > len(data)*8 {
+		return nil, fmt.Errorf("count %d exceeds the capacity", count)
+	}
+	for i := 0; i < count; i++ {
+		d.data = append(d.data, byte(bits(start+i*8, 8)))
+	}
+	if end := start + count*8; end+4 <= len(data)*8 && bits(end, 4) != 0 {
+		return nil, fmt.Errorf("missing terminator")
+	}
+	padStart := (start + count*8 + 4 + 7) / 8
+	for i := padStart; i < len(data); i++ {
+		if want := [2]byte{0xEC, 0x11}[(i-padStart)%2]; data[i] != want {
+			return nil, fmt.Errorf("pad byte %d is %02X, want %02X", i, data[i], want)
+		}
+	}
+	return d, nil
+}
+
+// rsSyndromesZero reports whether block, data followed by ecc check codewords,
+// is a codeword of the QR Reed–Solomon code: the polynomial it represents has
+// the roots α^0 … α^(ecc-1) in GF(2^8) modulo x^8+x^4+x^3+x^2+1.
+func rsSyndromesZero(block []byte, ecc int) bool {
+	var exp [255]byte
+	var log [256]int
+	x := 1
+	for i := range exp {
+		exp[i], log[x] = byte(x), i
+		if x <<= 1; x >= 0x100 {
+			x ^= 0x11D
+		}
+	}
+	mul := func(a, b byte) byte {
+		if a == 0 || b == 0 {
+			return 0
+		}
+		return exp[(log[a]+log[b])%255]
+	}
+	for i := 0; i < ecc; i++ {
+		var s byte
+		for _, c := range block {
+			s = mul(s, exp[i]) ^ c
+		}
+		if s != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// Incoming money is recorded as inbound transfers, whether it was pushed by the
+// bank (PromptPay notifications) or read from an imported bank statement. Each
+// transfer is matched to a bill; confident matches become Payment rows at once,
+// and everything else waits in the review queue for an operator.
+
+// Match outcomes of an inbound transfer, matching the CHECK constraint on inbound_transfer.Match_Status.
+const (
+	TransferMatched        = "matched"         // recorded as a payment against the bill
+	TransferUnmatched      = "unmatched"       // no bill could be found
+	TransferAmbiguous      = "ambiguous"       // several bills could be meant; see Candidate_Bill_IDs
+	TransferAmountMismatch = "amount_mismatch" // the bill was found but the amount or currency does not fit
+	TransferNotPayable     = "not_payable"     // the bill is void, written off or already paid
+	TransferIgnored        = "ignored"         // dismissed by an operator, e.g. not a customer payment
+)
+
+// transferNeedsReview reports whether a transfer in this state is waiting for an operator.
+func transferNeedsReview(status string) bool {
+	switch status {
+	case TransferUnmatched, TransferAmbiguous, TransferAmountMismatch, TransferNotPayable:
+		return true
+	}
+	return false
+}
+
+// Sources of inbound transfers and the payment method their payments are recorded with.
+const (
+	TransferSourcePromptPay     = "promptpay"
+	TransferSourceBankStatement = "bank_statement"
+)
+
+var transferPaymentMethods = map[string]string{
+	TransferSourcePromptPay:     promptPayMethod,
+	TransferSourceBankStatement: "Bank Transfer",
+}
+
+// InboundTransfer is a transfer reported by the bank, and how it was matched.
+type InboundTransfer struct {
+	TransferID       int     `json:"Transfer_ID"`
+	Source           string  `json:"Source"`
+	ImportID         *int    `json:"Import_ID,omitempty"` // the bank statement import it came from
+	TransactionRef   string  `json:"Transaction_Ref"`     // the bank's own transaction ID, unique per source
+	PaymentReference string  `json:"Payment_Reference"`
+	Description      *string `json:"Description"`
+	Amount           Money   `json:"Amount"`
+	Currency         string  `json:"Currency"`
+	TransferredAt    string  `json:"Transferred_At"`
+	PayerName        *string `json:"Payer_Name"`
+	MatchStatus      string  `json:"Match_Status"`
+	BillID           *int    `json:"Bill_ID"`
+	CandidateBillIDs []int64 `json:"Candidate_Bill_IDs,omitempty"`
+	PaymentID        *int    `json:"Payment_ID"`
+	ReceivedAt       string  `json:"Received_At"`
+	ReviewedBy       *int    `json:"Reviewed_By"`
+	ReviewedAt       *string `json:"Reviewed_At"`
+	ReviewNote       *string `json:"Review_Note"`
+}
+
+const inboundTransferColumns = `"transfer_id", "source", "import_id", "transaction_ref", "payment_reference", "description", "amount", "currency",
+	"transferred_at", "payer_name", "match_status", "bill_id", "candidate_bill_ids", "payment_id", "received_at",
+	"reviewed_by", "reviewed_at", "review_note"`
+
+func scanInboundTransfer(row rowScanner, t *InboundTransfer) error {
+	return row.Scan(&t.TransferID, &t.Source, &t.ImportID, &t.TransactionRef, &t.PaymentReference, &t.Description, &t.Amount, &t.Currency,
+		&t.TransferredAt, &t.PayerName, &t.MatchStatus, &t.BillID, pq.Array(&t.CandidateBillIDs), &t.PaymentID, &t.ReceivedAt,
+		&t.ReviewedBy, &t.ReviewedAt, &t.ReviewNote)
+}
+
+// queryInboundTransfers runs a SELECT of inboundTransferColumns and collects the rows.
+func queryInboundTransfers(query string, args ...interface{}) ([]InboundTransfer, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	transfers := []InboundTransfer{}
+	for rows.Next() {
+		var t InboundTransfer
+		if err := scanInboundTransfer(rows, &t); err != nil {
+			return nil, err
+		}
+		transfers = append(transfers, t)
+	}
+	return transfers, rows.Err()
+}
+
+// findInboundTransfer loads the transfer with t's source and transaction reference
+// into t, reporting whether it has been seen before.
+func findInboundTransfer(tx *sql.Tx, t *InboundTransfer) (bool, error) {
+	err := scanInboundTransfer(tx.QueryRow(`SELECT `+inboundTransferColumns+` FROM "inbound_transfer"
+	                                        WHERE "source" = $1 AND "transaction_ref" = $2`, t.Source, t.TransactionRef), t)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// insertInboundTransfer stores a newly received transfer.
+func insertInboundTransfer(tx *sql.Tx, t *InboundTransfer) error {
+	return tx.QueryRow(`INSERT INTO "inbound_transfer" ("source", "import_id", "transaction_ref", "payment_reference", "description",
+	                    "amount", "currency", "transferred_at", "payer_name", "match_status", "bill_id", "candidate_bill_ids", "payment_id")
+	                    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) RETURNING "transfer_id", "received_at"`,
+		t.Source, t.ImportID, t.TransactionRef, t.PaymentReference, t.Description, t.Amount, t.Currency, t.TransferredAt,
+		t.PayerName, t.MatchStatus, t.BillID, pq.Array(t.CandidateBillIDs), t.PaymentID).Scan(&t.TransferID, &t.ReceivedAt)
+}
+
+// billOutstanding is what is still owed on a bill, per the bill_balance view.
+func billOutstanding(tx *sql.Tx, billID int) (balance Money, currency string, err error) {
+	err = tx.QueryRow(`SELECT "outstanding", "currency" FROM "bill_balance" WHERE "bill_id" = $1`, billID).Scan(&balance, &currency)
+	return balance, currency, err
+}
+
+// applyTransfer tries to settle t against a bill whose row the caller has locked.
+// If the bill can take the money, a completed payment is recorded and t is
+// marked matched; otherwise t is left for review with the reason as its status.
+// With exact set, only a transfer of exactly the outstanding balance is applied;
+// otherwise part payments are accepted too.
+func applyTransfer(tx *sql.Tx, t *InboundTransfer, billID int, billStatus string, exact bool, actor *int) error {
+	t.BillID, t.CandidateBillIDs = &billID, []int64{int64(billID)}
+	if !isBillPayable(billStatus) {
+		t.MatchStatus = TransferNotPayable
+		return nil
+	}
+	balance, currency, err := billOutstanding(tx, billID)
+	if err != nil {
+		return err
+	}
+	cmp := t.Amount.Cmp(balance)
+	if currency != t.Currency || cmp > 0 || (exact && cmp != 0) {
+		t.MatchStatus = TransferAmountMismatch
+		return nil
+	}
+	// Payments taken without a signed-in operator (CLI imports) are booked to the system user.
+	processedBy := 0
+	if actor != nil {
+		processedBy = *actor
+	}
+	var paymentID int
+	err = tx.QueryRow(`INSERT INTO "payment" ("bill_id", "processed_by", "payment_date", "amount_paid", "currency", "payment_method", "payment_status")
+	                   VALUES ($1, $2, $3, $4, $5, $6, 'Completed') RETURNING "payment_id"`,
+		billID, processedBy, t.TransferredAt, t.Amount, t.Currency, transferPaymentMethods[t.Source]).Scan(&paymentID)
+	if err != nil {
+		return err
+	}
+	t.MatchStatus, t.PaymentID = TransferMatched, &paymentID
+	return nil
+}
+
+// getInboundTransfers lists received transfers, newest first, optionally filtered by ?status= and ?source=.
+func getInboundTransfers(w http.ResponseWriter, r *http.Request) {
+	log.Println("API: getInboundTransfers called")
+	query := `SELECT ` + inboundTransferColumns + ` FROM "inbound_transfer" WHERE TRUE`
+	args := []interface{}{}
+	for _, filter := range []string{"status", "source"} {
+		if v := r.URL.Query().Get(filter); v != "" {
+			args = append(args, v)
+			column := map[string]string{"status": "match_status", "source": "source"}[filter]
+			query += ` AND "` + column + `" = $` + strconv.Itoa(len(args))
+		}
+	}
+	transfers, err := queryInboundTransfers(query+` ORDER BY "received_at" DESC, "transfer_id" DESC`, args...)
+	if err != nil {
+		respondWithServerError(w, "Failed to retrieve transfers", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, transfers)
+}
+
+// getReconciliationQueue lists the transfers waiting for an operator, oldest first.
+func getReconciliationQueue(w http.ResponseWriter, r *http.Request) {
+	log.Println("API: getReconciliationQueue called")
+	transfers, err := queryInboundTransfers(`SELECT `+inboundTransferColumns+` FROM "inbound_transfer"
+	                                          WHERE "match_status" IN ($1, $2, $3, $4) ORDER BY "transferred_at" ASC, "transfer_id" ASC`,
+		TransferUnmatched, TransferAmbiguous, TransferAmountMismatch, TransferNotPayable)
+	if err != nil {
+		respondWithServerError(w, "Failed to retrieve review queue", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, transfers)
+}
+
+// TransferReviewRequest is the body of the review actions on a queued transfer.
+type TransferReviewRequest struct {
+	BillID int    `json:"Bill_ID"` // for match
+	Note   string `json:"Note"`
+}
+
+// reviewInboundTransfer returns a handler that resolves a queued transfer:
+// "match" applies it to the chosen bill as a payment, "ignore" dismisses it.
+func reviewInboundTransfer(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		id, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid Transfer ID")
+			return
+		}
+		log.Printf("API: reviewInboundTransfer called for Transfer ID: %d (%s)", id, action)
+
+		var req TransferReviewRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+			return
+		}
+		if action == "match" && req.BillID == 0 {
+			respondWithError(w, http.StatusBadRequest, "Bill_ID is required to match a transfer")
+			return
+		}
+		if action == "ignore" && req.Note == "" {
+			respondWithError(w, http.StatusBadRequest, "A note is required to ignore a transfer")
+			return
+		}
+
+		tx, err := auditFor(r).begin()
+		if err != nil {
+			respondWithServerError(w, "Failed to start database transaction", err)
+			return
+		}
+		defer tx.Rollback()
+
+		var t InboundTransfer
+		err = scanInboundTransfer(tx.QueryRow(`SELECT `+inboundTransferColumns+` FROM "inbound_transfer" WHERE "transfer_id" = $1 FOR UPDATE`, id), &t)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				respondWithError(w, http.StatusNotFound, "Transfer not found")
+			} else {
+				respondWithServerError(w, "Failed to retrieve transfer", err)
+			}
+			return
+		}
+		if !transferNeedsReview(t.MatchStatus) {
+			respondWithError(w, http.StatusConflict, "Transfer is already "+t.MatchStatus)
+			return
+		}
+
+		if action == "match" {
+			var status string
+			err = tx.QueryRow(`SELECT "bill_status" FROM "billing" WHERE "bill_id" = $1 FOR UPDATE`, req.BillID).Scan(&status)
+			if err != nil {
+				if err == sql.ErrNoRows {
+					respondWithError(w, http.StatusNotFound, "Bill not found")
+				} else {
+					respondWithServerError(w, "Failed to retrieve bill", err)
+				}
+				return
+			}
+			// The operator vouches for the bill, so part payments are fine; the
+			// amount still may not exceed what is owed.
+			if err := applyTransfer(tx, &t, req.BillID, status, false, currentUserID(r)); err != nil {
+				respondWithServerError(w, "Failed to record payment", err)
+				return
+			}
+			if t.MatchStatus != TransferMatched {
+				respondWithError(w, http.StatusConflict, "Transfer cannot be applied to this bill: "+t.MatchStatus)
+				return
+			}
+		} else {
+			t.MatchStatus = TransferIgnored
+		}
+
+		err = tx.QueryRow(`UPDATE "inbound_transfer" SET "match_status" = $1, "bill_id" = $2, "payment_id" = $3,
+		                   "reviewed_by" = $4, "reviewed_at" = $5, "review_note" = NULLIF($6, '')
+		                   WHERE "transfer_id" = $7 RETURNING "reviewed_by", "reviewed_at", "review_note"`,
+			t.MatchStatus, t.BillID, t.PaymentID, currentUserID(r), time.Now(), req.Note, id).Scan(&t.ReviewedBy, &t.ReviewedAt, &t.ReviewNote)
+		if err != nil {
+			respondWithServerError(w, "Failed to update transfer", err)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			respondWithServerError(w, "Failed to commit transaction", err)
+			return
+		}
+		respondWithJSON(w, http.StatusOK, t)
+	}
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// The reports under /api/v2/reports aggregate bills, payments and usage. Each takes
+// ?from= and ?to= (YYYY-MM-DD, inclusive) and answers with a JSON array, or
+// with a download when ?format= is csv, xlsx or ndjson. Amounts are never
+// converted between currencies, so every row with an amount is for one
+// currency.
+
+// reportBilledStatuses are the bills that count as billed: issued at some
+// point and not voided.
+const reportBilledStatuses = `('issued', 'partially_paid', 'paid', 'written_off')`
+
+// How many customers the top debtors report lists per currency.
+const (
+	defaultTopDebtors = 10
+	maxTopDebtors     = 100
+)
+
+// Aging buckets in order, each up to a number of days past the due date.
+var agingBuckets = []struct {
+	name    string
+	maxDays int // Inclusive; -1 for no upper bound
+}{
+	{"current", 0},
+	{"1-30", 30},
+	{"31-60", 60},
+	{"61-90", 90},
+	{"90+", -1},
+}
+
+// MonthlyCollection compares what was billed in a month with what was
+// collected in the same month.
+type MonthlyCollection struct {
+	Month          string   `json:"month"` // YYYY-MM
+	Currency       string   `json:"currency"`
+	BillCount      int      `json:"bill_count"`
+	Billed         Money    `json:"billed"` // Amount due of bills dated in the month
+	PaymentCount   int      `json:"payment_count"`
+	Collected      Money    `json:"collected"`       // Completed payments dated in the month
+	CollectionRate *Decimal `json:"collection_rate"` // Collected as a percentage of billed; null when nothing was billed
+}
+
+// AgingBucket is what customers owe on bills overdue by a range of days.
+type AgingBucket struct {
+	Currency    string `json:"currency"`
+	Bucket      string `json:"bucket"` // current, 1-30, 31-60, 61-90 or 90+
+	BillCount   int    `json:"bill_count"`
+	Outstanding Money  `json:"outstanding"`
+}
+
+// PaymentMethodTotal is the payments taken with one method.
+type PaymentMethodTotal struct {
+	PaymentMethod string `json:"payment_method"`
+	Currency      string `json:"currency"`
+	PaymentCount  int    `json:"payment_count"`
+	Amount        Money  `json:"amount"`
+}
+
+// Debtor is a customer with unpaid bills.
+type Debtor struct {
+	CustomerID     int    `json:"customer_id"`
+	CustomerName   string `json:"customer_name"`
+	Currency       string `json:"currency"`
+	UnpaidBills    int    `json:"unpaid_bills"`
+	Outstanding    Money  `json:"outstanding"`
+	OldestDueDate  string `json:"oldest_due_date" export:"date"`
+	MaxDaysOverdue int    `json:"max_days_overdue"`
+}
+
+// reportRange is the ?from= and ?to= of a report request.
+type reportRange struct {
+	From, To string // YYYY-MM-DD, or empty for no limit
+}
+
+func parseReportRange(q url.Values) (reportRange, error) {
+	rg := reportRange{From: q.Get("from"), To: q.Get("to")}
+	for name, v := range map[string]string{"from": rg.From, "to": rg.To} {
+		if _, err := time.Parse("2006-01-02", v); v != "" && err != nil {
+			return rg, listParamErrorf("%s must be YYYY-MM-DD", name)
+		}
+	}
+	if rg.From != "" && rg.To != "" && rg.From > rg.To {
+		return rg, listParamErrorf("from must not be after to")
+	}
+	return rg, nil
+}
+
+// conditions limits expr, a date, to the range, appending arguments to args.
+func (rg reportRange) conditions(expr string, args *[]interface{}) []string {
+	var conds []string
+	if rg.From != "" {
+		*args = append(*args, rg.From)
+		conds = append(conds, fmt.Sprintf("%s >= $%d", expr, len(*args)))
+	}
+	if rg.To != "" {
+		*args = append(*args, rg.To)
+		conds = append(conds, fmt.Sprintf("%s <= $%d", expr, len(*args)))
+	}
+	return conds
+}
+
+// currencyCondition adds the ?currency= filter on expr, if given.
+func currencyCondition(q url.Values, expr string, conds []string, args *[]interface{}) []string {
+	if c := strings.ToUpper(q.Get("currency")); c != "" {
+		*args = append(*args, c)
+		conds = append(conds, fmt.Sprintf("%s = $%d", expr, len(*args)))
+	}
+	return conds
+}
+
+// collectionReport returns billed and collected amounts per month and currency.
+func collectionReport(q url.Values) ([]MonthlyCollection, error) {
+	rg, err := parseReportRange(q)
+	if err != nil {
+		return nil, err
+	}
+	var args []interface{}
+	billConds := append([]string{`"bill_status" IN ` + reportBilledStatuses}, rg.conditions(`"billing_date"`, &args)...)
+	billConds = currencyCondition(q, `"currency"`, billConds, &args)
+	payConds := append([]string{`"payment_status" = 'Completed'`}, rg.conditions(`"payment_date"`, &args)...)
+	payConds = currencyCondition(q, `"currency"`, payConds, &args)
+	rows, err := db.Query(`
+		WITH billed AS (
+			SELECT TO_CHAR("billing_date", 'YYYY-MM') AS month, "currency", COUNT(*) AS n, COALESCE(SUM("amount_due"), 0) AS amount
+			FROM "billing"`+whereClause(billConds)+`
+			GROUP BY 1, 2),
+		collected AS (
+			SELECT TO_CHAR("payment_date", 'YYYY-MM') AS month, "currency", COUNT(*) AS n, SUM("amount_paid") AS amount
+			FROM "payment"`+whereClause(payConds)+`
+			GROUP BY 1, 2)
+		SELECT COALESCE(b.month, c.month), COALESCE(b.currency, c.currency), COALESCE(b.n, 0), COALESCE(b.amount, 0),
+		       COALESCE(c.n, 0), COALESCE(c.amount, 0)
+		FROM billed b FULL JOIN collected c ON c.month = b.month AND c.currency = b.currency
+		ORDER BY 1, 2`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	report := []MonthlyCollection{}
+	for rows.Next() {
+		var m MonthlyCollection
+		if err := rows.Scan(&m.Month, &m.Currency, &m.BillCount, &m.Billed, &m.PaymentCount, &m.Collected); err != nil {
+			return nil, err
+		}
+		if !m.Billed.IsZero() {
+			rate := m.Collected.Decimal().Mul(NewDecimal(100, 0)).Quo(m.Billed.Decimal(), 2)
+			m.CollectionRate = &rate
+		}
+		report = append(report, m)
+	}
+	return report, rows.Err()
+}
+
+// agingReport buckets what is still owed on unpaid bills by days overdue as
+// of today. The date range applies to the billing date.
+func agingReport(q url.Values) ([]AgingBucket, error) {
+	rg, err := parseReportRange(q)
+	if err != nil {
+		return nil, err
+	}
+	var args []interface{}
+	conds := rg.conditions(`u."billing_date"`, &args)
+	conds = currencyCondition(q, `bb."currency"`, conds, &args)
+	if v := q.Get("customer_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, listParamErrorf("customer_id must be an integer")
+		}
+		args = append(args, id)
+		conds = append(conds, fmt.Sprintf(`u."customer_id" = $%d`, len(args)))
+	}
+	var cases []string
+	for _, b := range agingBuckets {
+		if b.maxDays < 0 {
+			cases = append(cases, fmt.Sprintf(`ELSE '%s'`, b.name))
+		} else {
+			cases = append(cases, fmt.Sprintf(`WHEN u."days_overdue" <= %d THEN '%s'`, b.maxDays, b.name))
+		}
+	}
+	rows, err := db.Query(`
+		SELECT bb."currency", CASE `+strings.Join(cases, " ")+` END, COUNT(*), SUM(bb."outstanding")
+		FROM "unpaid_bills" u JOIN "bill_balance" bb ON bb."bill_id" = u."bill_id"`+whereClause(conds)+`
+		GROUP BY 1, 2`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	type key struct{ currency, bucket string }
+	found := map[key]AgingBucket{}
+	seen := map[string]bool{}
+	var currencies []string
+	for rows.Next() {
+		var b AgingBucket
+		if err := rows.Scan(&b.Currency, &b.Bucket, &b.BillCount, &b.Outstanding); err != nil {
+			return nil, err
+		}
+		if !seen[b.Currency] {
+			seen[b.Currency] = true
+			currencies = append(currencies, b.Currency)
+		}
+		found[key{b.Currency, b.Bucket}] = b
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// Every bucket is listed for every currency, in bucket order, even when empty.
+	sort.Strings(currencies)
+	report := []AgingBucket{}
+	for _, c := range currencies {
+		for _, b := range agingBuckets {
+			row, ok := found[key{c, b.name}]
+			if !ok {
+				row = AgingBucket{Currency: c, Bucket: b.name}
+			}
+			report = append(report, row)
+		}
+	}
+	return report, nil
+}
+
+// paymentMethodReport totals completed payments by method and currency.
+func paymentMethodReport(q url.Values) ([]PaymentMethodTotal, error) {
+	rg, err := parseReportRange(q)
+	if err != nil {
+		return nil, err
+	}
+	var args []interface{}
+	conds := append([]string{`"payment_status" = 'Completed'`}, rg.conditions(`"payment_date"`, &args)...)
+	conds = currencyCondition(q, `"currency"`, conds, &args)
+	rows, err := db.Query(`SELECT "payment_method", "currency", COUNT(*), SUM("amount_paid") FROM "payment"`+whereClause(conds)+`
+	                       GROUP BY 1, 2 ORDER BY 2, 4 DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	report := []PaymentMethodTotal{}
+	for rows.Next() {
+		var t PaymentMethodTotal
+		if err := rows.Scan(&t.PaymentMethod, &t.Currency, &t.PaymentCount, &t.Amount); err != nil {
+			return nil, err
+		}
+		report = append(report, t)
+	}
+	return report, rows.Err()
+}
+
+// topDebtorsReport lists the customers owing the most on unpaid bills, per
+// currency. The date range applies to the billing date.
+func topDebtorsReport(q url.Values) ([]Debtor, error) {
+	rg, err := parseReportRange(q)
+	if err != nil {
+		return nil, err
+	}
+	limit := defaultTopDebtors
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxTopDebtors {
+			return nil, listParamErrorf("limit must be between 1 and %d", maxTopDebtors)
+		}
+		limit = n
+	}
+	var args []interface{}
+	conds := append([]string{`bb."outstanding" > 0`}, rg.conditions(`u."billing_date"`, &args)...)
+	conds = currencyCondition(q, `bb."currency"`, conds, &args)
+	args = append(args, limit)
+	rows, err := db.Query(fmt.Sprintf(`
+		SELECT customer_id, customer_name, currency, n, outstanding, oldest_due_date, max_days_overdue FROM (
+			SELECT u."customer_id", u."customer_name", bb."currency", COUNT(*) AS n, SUM(bb."outstanding") AS outstanding,
+			       TO_CHAR(MIN(u."due_date"), 'YYYY-MM-DD') AS oldest_due_date, MAX(u."days_overdue") AS max_days_overdue,
+			       ROW_NUMBER() OVER (PARTITION BY bb."currency" ORDER BY SUM(bb."outstanding") DESC, u."customer_id") AS rank
+			FROM "unpaid_bills" u JOIN "bill_balance" bb ON bb."bill_id" = u."bill_id"%s
+			GROUP BY u."customer_id", u."customer_name", bb."currency") ranked
+		WHERE rank <= $%d
+		ORDER BY currency, rank`, whereClause(conds), len(args)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	report := []Debtor{}
+	for rows.Next() {
+		var d Debtor
+		if err := rows.Scan(&d.CustomerID, &d.CustomerName, &d.Currency, &d.UnpaidBills, &d.Outstanding, &d.OldestDueDate, &d.MaxDaysOverdue); err != nil {
+			return nil, err
+		}
+		report = append(report, d)
+	}
+	return report, rows.Err()
+}
+
+// canViewReports reports whether the caller may see figures across customers.
+func canViewReports(r *http.Request) bool {
+	return currentPermission(r) != "customer"
+}
+
+// reportHandler serves a report: JSON by default, in the v2 envelope under
+// /api/v2, or a download in ?format=.
+func reportHandler(name string, run func(url.Values) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("API: %s report called", name)
+		if !canViewReports(r) {
+			respondWithError(w, http.StatusForbidden, "Reports are not available to customer accounts")
+			return
+		}
+		q := r.URL.Query()
+		format := q.Get("format")
+		if _, ok := exportContentTypes[format]; format != "" && format != "json" && !ok {
+			respondWithError(w, http.StatusBadRequest, "format must be json, csv, xlsx or ndjson")
+			return
+		}
+		rows, err := run(q)
+		if err != nil {
+			if _, ok := err.(*listParamError); ok {
+				respondWithError(w, http.StatusBadRequest, "Invalid report parameters: "+err.Error())
+				return
+			}
+			respondWithServerError(w, "Failed to compute "+name+" report", err)
+			return
+		}
+		if format == "" || format == "json" {
+			if strings.HasPrefix(r.URL.Path, "/api/v2/") {
+				respondV2(w, http.StatusOK, rows)
+			} else {
+				respondWithJSON(w, http.StatusOK, rows)
+			}
+			return
+		}
+		if r, ok := rows.(interface{ rows() interface{} }); ok {
+			rows = r.rows() // Export the table inside a structured report
+		}
+		respondWithRows(w, name, format, rows)
+	}
+}
+
+var reportRoutes = []struct {
+	path, summary string
+	run           func(url.Values) (interface{}, error)
+	response      interface{}
+	params        []apiParam
+}{
+	{"/reports/collections", "Billed and collected amounts per month, with the collection rate",
+		func(q url.Values) (interface{}, error) { return collectionReport(q) }, []MonthlyCollection{}, []apiParam{currencyParam}},
+	{"/reports/aging", "Outstanding receivables in aging buckets by days overdue as of today",
+		func(q url.Values) (interface{}, error) { return agingReport(q) }, []AgingBucket{},
+		[]apiParam{currencyParam, queryParam("customer_id", "integer", "")}},
+	{"/reports/payment-methods", "Completed payments by method",
+		func(q url.Values) (interface{}, error) { return paymentMethodReport(q) }, []PaymentMethodTotal{}, []apiParam{currencyParam}},
+	{"/reports/top-debtors", "Customers owing the most on unpaid bills, per currency",
+		func(q url.Values) (interface{}, error) { return topDebtorsReport(q) }, []Debtor{},
+		[]apiParam{currencyParam, queryParam("limit", "integer", fmt.Sprintf("Customers per currency, at most %d (default %d)", maxTopDebtors, defaultTopDebtors))}},
+	{"/reports/consumption", "Units consumed per month and meter type",
+		func(q url.Values) (interface{}, error) { return consumptionByMeterType(q) }, []MeterTypeConsumption{},
+		[]apiParam{{Name: "meter_type", Enum: []string{"Standard", "Smart", "Digital", "Prepayment"}}}},
+	{"/reports/forecast", "Projected meter consumption or billed revenue for the coming months, with confidence intervals",
+		func(q url.Values) (interface{}, error) { return forecastReport(q) }, Forecast{}, forecastParams},
+}
+
+var currencyParam = apiParam{Name: "currency", Description: "ISO 4217 code"}
+
+// registerReports adds the report routes; they are served both under /api
+// and under /api/v2.
+func registerReports(router *mux.Router) {
+	for _, rt := range reportRoutes {
+		router.HandleFunc(rt.path, reportHandler(strings.TrimPrefix(rt.path, "/reports/"), rt.run)).Methods("GET", "OPTIONS")
+	}
+}
+
+// reportOperations documents the report routes under base, /api or /api/v2,
+// with the given tag.
+func reportOperations(base, tag string) []apiOperation {
+	var ops []apiOperation
+	for _, rt := range reportRoutes {
+		ops = append(ops, reportOperation(base+rt.path, tag, rt.summary, rt.response, rt.params))
+	}
+	return ops
+}
+
+// reportOperation documents one report served by reportHandler.
+func reportOperation(path, tag, summary string, response interface{}, extra []apiParam) apiOperation {
+	params := append(append([]apiParam{}, dateRangeParams...),
+		apiParam{Name: "format", Enum: []string{"json", ExportFormatCSV, ExportFormatXLSX, ExportFormatNDJSON}, Description: "Default json"})
+	return apiOperation{Method: "GET", Path: path, Tag: tag, Summary: summary,
+		Params: append(params, extra...), Response: response, Produces: []string{exportContentTypes[ExportFormatCSV],
+			exportContentTypes[ExportFormatXLSX], exportContentTypes[ExportFormatNDJSON]}}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Search result types.
+const (
+	SearchTypeCustomer = "customer"
+	SearchTypeMeter    = "meter"
+	SearchTypeBill     = "bill"
+)
+
+const (
+	minSearchLength    = 2
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// SearchResult is one ranked hit. ID is the Customer_ID, Meter_ID or Bill_ID depending on Type.
+type SearchResult struct {
+	Type       string  `json:"Type"`
+	ID         int     `json:"ID"`
+	CustomerID int     `json:"Customer_ID"`
+	Title      string  `json:"Title"`
+	Subtitle   string  `json:"Subtitle"`
+	Rank       float64 `json:"Rank"`
+}
+
+// SearchResponse is the body returned by /api/search.
+type SearchResponse struct {
+	Query   string         `json:"Query"`
+	Types   []string       `json:"Types"`
+	Results []SearchResult `json:"Results"`
+}
+
+// searchScope is what the caller's role allows a search to see.
+type searchScope struct {
+	contactDetails bool   // Match on and show customer email and phone number
+	customerEmail  string // When set, only records of the customer with this email
+}
+
+// searchScopeFor derives the search scope from the caller's permission.
+// Operators and administrators see everything; viewers do not see contact
+// details; customers only find their own records, excluding draft bills.
+func searchScopeFor(r *http.Request) (searchScope, bool, error) {
+	switch currentPermission(r) {
+	case "administrator", "operator":
+		return searchScope{contactDetails: true}, true, nil
+	case "viewer":
+		return searchScope{}, true, nil
+	case "customer":
+		id := currentUserID(r)
+		if id == nil {
+			return searchScope{}, false, nil
+		}
+		var email string
+		err := db.QueryRow(`SELECT "email" FROM "users" WHERE "id" = $1`, *id).Scan(&email)
+		if err == sql.ErrNoRows {
+			return searchScope{}, false, nil
+		}
+		return searchScope{contactDetails: true, customerEmail: email}, err == nil, err
+	}
+	return searchScope{}, false, nil
+}
+
+var nonDigits = regexp.MustCompile(`\D`)
+
+// searchQuery holds the query text in the forms the SQL below compares against.
+type searchQuery struct {
+	text      string // As typed, trimmed
+	like      string // ILIKE pattern matching the text anywhere
+	digits    string // Digits only, for phone numbers typed without dashes
+	reference string // Upper-case letters and digits, as in Billing.Payment_Reference
+}
+
+func newSearchQuery(q string) searchQuery {
+	sq := searchQuery{text: q, like: "%" + likeEscaper.Replace(q) + "%"}
+	if d := nonDigits.ReplaceAllString(q, ""); len(d) >= 3 {
+		sq.digits = d
+	}
+	sq.reference = nonAlphanumeric.ReplaceAllString(strings.ToUpper(q), "")
+	return sq
+}
+
+// searchCustomers matches name and address by full-text and trigram
+// similarity, and email and phone number by substring when allowed.
+func searchCustomers(sq searchQuery, scope searchScope, limit int) ([]SearchResult, error) {
+	args := []interface{}{sq.text, sq.like}
+	subtitle := `c.address`
+	ranks := []string{
+		`ts_rank(to_tsvector('simple', c.name || ' ' || c.address), plainto_tsquery('simple', $1))`,
+		`similarity(c.name, $1)`,
+		`word_similarity($1, c.address) * 0.8`,
+		`CASE WHEN c.name ILIKE $1 THEN 1.0 END`,
+	}
+	matches := []string{
+		`to_tsvector('simple', c.name || ' ' || c.address) @@ plainto_tsquery('simple', $1)`,
+		`c.name % $1`, `c.name ILIKE $2`, `$1 <% c.address`, `c.address ILIKE $2`,
+	}
+	if scope.contactDetails {
+		subtitle = `concat_ws(' | ', c.address, c.email, c.phone_number)`
+		ranks = append(ranks, `CASE WHEN c.email ILIKE $1 THEN 1.0 WHEN c.email ILIKE $2 THEN 0.6 END`)
+		matches = append(matches, `c.email ILIKE $2`)
+		if sq.digits != "" {
+			args = append(args, "%"+sq.digits+"%")
+			phone := fmt.Sprintf(`regexp_replace(c.phone_number, '\D', '', 'g') LIKE $%d`, len(args))
+			ranks = append(ranks, `CASE WHEN `+phone+` THEN 0.9 END`)
+			matches = append(matches, phone)
+		}
+	}
+	where := "(" + strings.Join(matches, " OR ") + ")"
+	if scope.customerEmail != "" {
+		args = append(args, scope.customerEmail)
+		where += fmt.Sprintf(` AND c.email = $%d`, len(args))
+	}
+	args = append(args, limit)
+	return querySearchResults(SearchTypeCustomer, fmt.Sprintf(`SELECT c.customer_id, c.customer_id, c.name, %s, GREATEST(%s) AS rank
+	    FROM customer c WHERE %s ORDER BY rank DESC, c.customer_id LIMIT $%d`, subtitle, strings.Join(ranks, ", "), where, len(args)), args...)
+}
+
+// searchMeters matches meter numbers by substring and trigram similarity.
+func searchMeters(sq searchQuery, scope searchScope, limit int) ([]SearchResult, error) {
+	args := []interface{}{sq.text, sq.like}
+	where := `(m.meter_number ILIKE $2 OR m.meter_number % $1)`
+	if scope.customerEmail != "" {
+		args = append(args, scope.customerEmail)
+		where += fmt.Sprintf(` AND c.email = $%d`, len(args))
+	}
+	args = append(args, limit)
+	return querySearchResults(SearchTypeMeter, fmt.Sprintf(`SELECT m.meter_id, m.customer_id, m.meter_number, c.name,
+	        GREATEST(similarity(m.meter_number, $1), CASE WHEN m.meter_number ILIKE $1 THEN 1.0 WHEN m.meter_number ILIKE $2 THEN 0.7 END) AS rank
+	    FROM meter m JOIN customer c ON c.customer_id = m.customer_id
+	    WHERE %s ORDER BY rank DESC, m.meter_id LIMIT $%d`, where, len(args)), args...)
+}
+
+// searchBills matches invoice numbers by substring and trigram similarity,
+// the PromptPay payment reference exactly, and "BILL-<id>" or a bare bill ID.
+func searchBills(sq searchQuery, scope searchScope, limit int) ([]SearchResult, error) {
+	billID := -1
+	if n, err := strconv.Atoi(strings.TrimPrefix(strings.ToUpper(sq.text), "BILL-")); err == nil {
+		billID = n
+	}
+	args := []interface{}{sq.text, sq.like, sq.reference, billID}
+	where := `(b.invoice_number ILIKE $2 OR b.invoice_number % $1 OR b.payment_reference = $3 OR b.bill_id = $4)`
+	if scope.customerEmail != "" {
+		args = append(args, scope.customerEmail)
+		where += fmt.Sprintf(` AND c.email = $%d AND b.bill_status <> 'draft'`, len(args))
+	}
+	args = append(args, limit)
+	return querySearchResults(SearchTypeBill, fmt.Sprintf(`SELECT b.bill_id, b.customer_id, COALESCE(b.invoice_number, 'BILL-' || b.bill_id),
+	        concat_ws(' | ', c.name, to_char(b.billing_date, 'YYYY-MM-DD'), b.bill_status, b.amount_due || ' ' || b.currency),
+	        GREATEST(similarity(COALESCE(b.invoice_number, ''), $1),
+	                 CASE WHEN b.bill_id = $4 OR b.payment_reference = $3 THEN 1.0 WHEN b.invoice_number ILIKE $2 THEN 0.7 END) AS rank
+	    FROM billing b JOIN customer c ON c.customer_id = b.customer_id
+	    WHERE %s ORDER BY rank DESC, b.bill_id DESC LIMIT $%d`, where, len(args)), args...)
+}
+
+func querySearchResults(resultType, query string, args ...interface{}) ([]SearchResult, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var results []SearchResult
+	for rows.Next() {
+		res := SearchResult{Type: resultType}
+		if err := rows.Scan(&res.ID, &res.CustomerID, &res.Title, &res.Subtitle, &res.Rank); err != nil {
+			return nil, err
+		}
+		results = append(results, res)
+	}
+	return results, rows.Err()
+}
+
+var searchers = map[string]func(searchQuery, searchScope, int) ([]SearchResult, error){
+	SearchTypeCustomer: searchCustomers,
+	SearchTypeMeter:    searchMeters,
+	SearchTypeBill:     searchBills,
+}
+
+// search handles GET /api/search?q=&type=customer,meter,bill&limit=. Results of
+// all requested types are merged and ordered by rank.
+func search(w http.ResponseWriter, r *http.Request) {
+	log.Println("API: search called")
+	scope, ok, err := searchScopeFor(r)
+	if err != nil {
+		respondWithServerError(w, "Failed to search", err)
+		return
+	}
+	if !ok {
+		respondWithError(w, http.StatusForbidden, "Search is not available for this account")
+		return
+	}
+	q := r.URL.Query()
+	text := strings.TrimSpace(q.Get("q"))
+	if len([]rune(text)) < minSearchLength {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("q must be at least %d characters", minSearchLength))
+		return
+	}
+	limit := defaultSearchLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxSearchLimit {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxSearchLimit))
+			return
+		}
+		limit = n
+	}
+	types := []string{SearchTypeCustomer, SearchTypeMeter, SearchTypeBill}
+	if v := q.Get("type"); v != "" {
+		types = strings.Split(v, ",")
+		for _, t := range types {
+			if _, ok := searchers[t]; !ok {
+				respondWithError(w, http.StatusBadRequest, "Unknown search type: "+t)
+				return
+			}
+		}
+	}
+
+	sq := newSearchQuery(text)
+	results := []SearchResult{}
+	for _, t := range types {
+		found, err := searchers[t](sq, scope, limit)
+		if err != nil {
+			respondWithServerError(w, "Failed to search "+t+"s", err)
+			return
+		}
+		results = append(results, found...)
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Rank > results[j].Rank })
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	respondWithJSON(w, http.StatusOK, SearchResponse{Query: text, Types: types, Results: results})
+}
@@ -0,0 +1,801 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// The service layer holds the business rules behind the API. The v1 handlers
+// (main.go, bill_state.go) and the v2 handlers (api_v2.go) only decode the
+// request, call a service and encode the result in their own format, so the
+// two versions cannot drift apart in behaviour. Services report problems the
+// client can act on as *APIError; any other error is a server error. Both can
+// be passed to respondWithServerError.
+
+// --- Authentication ---
+
+// authenticate checks a username and password and returns a signed token
+// for the user, valid for 24 hours.
+func authenticate(creds Credentials) (token string, user User, expires time.Time, err error) {
+	if verr := validateStruct("credentials", &creds); verr != nil {
+		return "", user, expires, verr
+	}
+	invalid := &APIError{Status: http.StatusUnauthorized, Code: ErrCodeUnauthorized, Message: "Invalid username or password"}
+	query := "SELECT id, username, email, permission, password_hash FROM users WHERE username = $1"
+	err = db.QueryRow(query, creds.Username).Scan(&user.ID, &user.Username, &user.Email, &user.Permission, &user.PasswordHash)
+	if err == sql.ErrNoRows {
+		return "", user, expires, invalid
+	} else if err != nil {
+		log.Printf("Database error during login for user '%s': %v", creds.Username, err)
+		return "", user, expires, err
+	}
+	if err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(creds.Password)); err != nil {
+		return "", user, expires, invalid
+	}
+
+	expires = time.Now().Add(24 * time.Hour)
+	claims := &CustomClaims{
+		Username:   user.Username,
+		Permission: user.Permission,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expires),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Issuer:    "ebill-app",
+			Subject:   fmt.Sprintf("%d", user.ID),
+		},
+	}
+	token, err = jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtKey)
+	if err != nil {
+		return "", user, expires, fmt.Errorf("could not generate token: %w", err)
+	}
+	return token, user, expires, nil
+}
+
+func listUsers(q url.Values) ([]User, ListMeta, error) {
+	users := []User{}
+	meta, err := userList.query(q, func(row rowScanner) error {
+		var u User
+		if err := row.Scan(&u.ID, &u.Username, &u.Email, &u.Permission); err != nil {
+			return err
+		}
+		users = append(users, u)
+		return nil
+	})
+	return users, meta, err
+}
+
+// --- Customers ---
+
+type customerService struct{}
+
+var customerSvc customerService
+
+// scanCustomer scans the columns of customerList.
+func scanCustomer(row rowScanner, c *Customer) error {
+	return row.Scan(&c.CustomerID, &c.Name, &c.Address, &c.Email, &c.PhoneNumber, &c.RegistrationDate, &c.Version)
+}
+
+var customerHistoryList = historyList("customer", "customer_id", customerList.columns)
+
+func (customerService) List(q url.Values) ([]Customer, ListMeta, error) {
+	customers := []Customer{}
+	meta, err := customerList.query(q, func(row rowScanner) error {
+		var c Customer
+		if err := scanCustomer(row, &c); err != nil {
+			return err
+		}
+		customers = append(customers, c)
+		return nil
+	})
+	return customers, meta, err
+}
+
+func (customerService) Get(id int) (Customer, error) {
+	var c Customer
+	err := scanCustomer(db.QueryRow(`SELECT `+customerList.columns+` FROM "customer" WHERE "customer_id" = $1`, id), &c)
+	if err == sql.ErrNoRows {
+		return c, notFoundError("Customer")
+	}
+	return c, err
+}
+
+// GetAsOf returns customer id as it was at asOf.
+func (customerService) GetAsOf(id int, asOf string) (Customer, error) {
+	return getAsOf("customer", "customer_id", customerList.columns, id, asOf, "Customer", scanCustomer)
+}
+
+// History lists the versions of customer id.
+func (customerService) History(id int, q url.Values) ([]RecordVersion[Customer], ListMeta, error) {
+	return listHistory(&customerHistoryList, id, q, "Customer", scanCustomer)
+}
+
+func (customerService) Create(a auditContext, c *Customer) error {
+	if verr := c.validate(); verr != nil {
+		return verr
+	}
+	sqlStatement := `INSERT INTO "customer" ("name", "address", "email", "phone_number", "registration_date") VALUES ($1, $2, $3, $4, $5)
+					RETURNING "customer_id", "version"`
+	return a.write(func(tx *sql.Tx) error {
+		return tx.QueryRow(sqlStatement, c.Name, c.Address, c.Email, c.PhoneNumber, c.RegistrationDate).Scan(&c.CustomerID, &c.Version)
+	})
+}
+
+// Update validates c and writes all of its fields to customer id, provided
+// the row is still at version (0 for any version).
+func (customerService) Update(a auditContext, id int, c *Customer, version int) error {
+	if verr := c.validate(); verr != nil {
+		return verr
+	}
+	sqlStatement := `UPDATE "customer" SET "name" = $1, "address" = $2, "email" = $3, "phone_number" = $4, "registration_date"
+					= $5 WHERE "customer_id" = $6 AND ($7 = 0 OR "version" = $7) RETURNING "version"`
+	err := a.write(func(tx *sql.Tx) error {
+		return tx.QueryRow(sqlStatement, c.Name, c.Address, c.Email, c.PhoneNumber, c.RegistrationDate, id, version).Scan(&c.Version)
+	})
+	if err == sql.ErrNoRows {
+		return notWrittenError("customer", "customer_id", id, version, "Customer")
+	} else if err != nil {
+		return err
+	}
+	c.CustomerID = id
+	return nil
+}
+
+// Patch loads customer id, lets apply change it and saves the result.
+func (s customerService) Patch(a auditContext, id, version int, apply func(*Customer) error) (Customer, error) {
+	c, err := s.Get(id)
+	if err != nil {
+		return c, err
+	}
+	if apiErr := staleVersion(version, c.Version); apiErr != nil {
+		return c, apiErr
+	}
+	if err := apply(&c); err != nil {
+		return c, err
+	}
+	err = s.Update(a, id, &c, version)
+	return c, err
+}
+
+func (customerService) Delete(a auditContext, id, version int) error {
+	var count int64
+	err := a.write(func(tx *sql.Tx) error {
+		res, err := tx.Exec(`DELETE FROM "customer" WHERE "customer_id" = $1 AND ($2 = 0 OR "version" = $2)`, id, version)
+		if err != nil {
+			return err
+		}
+		count, err = res.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return notWrittenError("customer", "customer_id", id, version, "Customer")
+	}
+	return nil
+}
+
+// --- Meters ---
+
+type meterService struct{}
+
+var meterSvc meterService
+
+// scanMeter scans the columns of meterList.
+func scanMeter(row rowScanner, m *Meter) error {
+	return row.Scan(&m.MeterID, &m.CustomerID, &m.MeterNumber, &m.InstallationDate, &m.ActiveStatus, &m.Version)
+}
+
+var meterHistoryList = historyList("meter", "meter_id", meterList.columns)
+
+func (meterService) List(q url.Values) ([]Meter, ListMeta, error) {
+	meters := []Meter{}
+	meta, err := meterList.query(q, func(row rowScanner) error {
+		var m Meter
+		if err := scanMeter(row, &m); err != nil {
+			return err
+		}
+		meters = append(meters, m)
+		return nil
+	})
+	return meters, meta, err
+}
+
+func (meterService) Get(id int) (Meter, error) {
+	var m Meter
+	err := scanMeter(db.QueryRow(`SELECT `+meterList.columns+` FROM "meter" WHERE "meter_id" = $1`, id), &m)
+	if err == sql.ErrNoRows {
+		return m, notFoundError("Meter")
+	}
+	return m, err
+}
+
+// GetAsOf returns meter id as it was at asOf.
+func (meterService) GetAsOf(id int, asOf string) (Meter, error) {
+	return getAsOf("meter", "meter_id", meterList.columns, id, asOf, "Meter", scanMeter)
+}
+
+// History lists the versions of meter id.
+func (meterService) History(id int, q url.Values) ([]RecordVersion[Meter], ListMeta, error) {
+	return listHistory(&meterHistoryList, id, q, "Meter", scanMeter)
+}
+
+func (meterService) Create(a auditContext, m *Meter) error {
+	if verr := m.validate(); verr != nil {
+		return verr
+	}
+	sqlStatement := `INSERT INTO "meter" ("customer_id", "meter_number", "installation_date", "active_status") VALUES ($1, $2, $3, $4) RETURNING "meter_id", "version"`
+	return a.write(func(tx *sql.Tx) error {
+		return tx.QueryRow(sqlStatement, m.CustomerID, m.MeterNumber, m.InstallationDate, m.ActiveStatus).Scan(&m.MeterID, &m.Version)
+	})
+}
+
+// Update validates m and writes all of its fields to meter id, provided the
+// row is still at version (0 for any version).
+func (meterService) Update(a auditContext, id int, m *Meter, version int) error {
+	if verr := m.validate(); verr != nil {
+		return verr
+	}
+	sqlStatement := `UPDATE "meter" SET "customer_id"=$1, "meter_number"=$2, "installation_date"=$3, "active_status"=$4
+	                 WHERE "meter_id"=$5 AND ($6 = 0 OR "version" = $6) RETURNING "version"`
+	err := a.write(func(tx *sql.Tx) error {
+		return tx.QueryRow(sqlStatement, m.CustomerID, m.MeterNumber, m.InstallationDate, m.ActiveStatus, id, version).Scan(&m.Version)
+	})
+	if err == sql.ErrNoRows {
+		return notWrittenError("meter", "meter_id", id, version, "Meter")
+	} else if err != nil {
+		return err
+	}
+	m.MeterID = id
+	return nil
+}
+
+// Patch loads meter id, lets apply change it and saves the result.
+func (s meterService) Patch(a auditContext, id, version int, apply func(*Meter) error) (Meter, error) {
+	m, err := s.Get(id)
+	if err != nil {
+		return m, err
+	}
+	if apiErr := staleVersion(version, m.Version); apiErr != nil {
+		return m, apiErr
+	}
+	if err := apply(&m); err != nil {
+		return m, err
+	}
+	err = s.Update(a, id, &m, version)
+	return m, err
+}
+
+func (meterService) Delete(a auditContext, id, version int) error {
+	var count int64
+	err := a.write(func(tx *sql.Tx) error {
+		res, err := tx.Exec(`DELETE FROM "meter" WHERE "meter_id" = $1 AND ($2 = 0 OR "version" = $2)`, id, version)
+		if err != nil {
+			return err
+		}
+		count, _ = res.RowsAffected()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return notWrittenError("meter", "meter_id", id, version, "Meter")
+	}
+	return nil
+}
+
+// --- Bills ---
+
+type billService struct{}
+
+var billSvc billService
+
+func (billService) List(q url.Values) ([]Billing, ListMeta, error) {
+	billings := []Billing{}
+	meta, err := billingList.query(q, func(row rowScanner) error {
+		var b Billing
+		if err := row.Scan(&b.Customer_Name, &b.BillID, &b.CustomerID, &b.MeterID, &b.BillingDate, &b.DueDate,
+			&b.ReadingPrevious, &b.ReadingCurrent, &b.RateApplied, &b.TotalUnit, &b.AmountDue, &b.Currency, &b.PaidStatus, &b.Status, &b.ReplacesBillID, &b.InvoiceNumber, &b.IssuedDate, &b.Version); err != nil {
+			return err
+		}
+		billings = append(billings, b)
+		return nil
+	})
+	return billings, meta, err
+}
+
+const billColumns = `"bill_id", "customer_id", "meter_id", "billing_date", "due_date",
+	"previous_reading", "current_reading", "rate_applied", "total_unit", "amount_due", "currency", "paid_status",
+	"bill_status", "replaces_bill_id", "invoice_number", "issued_date", "version"`
+
+func scanBill(row rowScanner, b *Billing) error {
+	return row.Scan(&b.BillID, &b.CustomerID, &b.MeterID, &b.BillingDate, &b.DueDate,
+		&b.ReadingPrevious, &b.ReadingCurrent, &b.RateApplied, &b.TotalUnit, &b.AmountDue, &b.Currency, &b.PaidStatus,
+		&b.Status, &b.ReplacesBillID, &b.InvoiceNumber, &b.IssuedDate, &b.Version)
+}
+
+// billVersionColumns are the columns of billing_history read by scanBillVersion.
+const billVersionColumns = `"line_items", ` + billColumns
+
+// scanBillVersion scans a bill from billing_history with the line items it
+// had then.
+func scanBillVersion(row rowScanner, b *Billing) error {
+	var items []byte
+	if err := scanBill(prefixScanner{row, []interface{}{&items}}, b); err != nil {
+		return err
+	}
+	return json.Unmarshal(items, &b.LineItems)
+}
+
+var billHistoryList = historyList("billing", "bill_id", billVersionColumns)
+
+// load reads the bill row without its line items.
+func (billService) load(id int) (Billing, error) {
+	var b Billing
+	err := scanBill(db.QueryRow(`SELECT `+billColumns+` FROM "billing" WHERE "bill_id" = $1`, id), &b)
+	if err == sql.ErrNoRows {
+		return b, notFoundError("Bill")
+	}
+	return b, err
+}
+
+// Get returns bill id with its line items.
+func (s billService) Get(id int) (Billing, error) {
+	b, err := s.load(id)
+	if err != nil {
+		return b, err
+	}
+	b.LineItems, err = getBillLineItems(b.BillID)
+	return b, err
+}
+
+// GetAsOf returns bill id, with its line items, as it was at asOf.
+func (billService) GetAsOf(id int, asOf string) (Billing, error) {
+	return getAsOf("billing", "bill_id", billVersionColumns, id, asOf, "Bill", scanBillVersion)
+}
+
+// History lists the versions of bill id.
+func (billService) History(id int, q url.Values) ([]RecordVersion[Billing], ListMeta, error) {
+	return listHistory(&billHistoryList, id, q, "Bill", scanBillVersion)
+}
+
+// Create stores b as a new draft and computes its line items.
+func (billService) Create(a auditContext, b *Billing) error {
+	b.normalize()
+	if verr := b.validate(); verr != nil {
+		return verr
+	}
+	// New bills always start as editable drafts; they are issued separately.
+	b.Status = BillStatusDraft
+	b.PaidStatus = false
+	b.ReplacesBillID = nil
+	b.InvoiceNumber = nil
+	b.IssuedDate = nil
+
+	tx, err := a.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Total_Unit is generated by the database, so we don't insert it directly. We can return it.
+	// Amount_Due is the sum of the line items written below.
+	sqlStatement := `INSERT INTO "billing" ("customer_id", "meter_id", "billing_date", "due_date",
+	                 "previous_reading", "current_reading", "rate_applied", "currency", "bill_status")
+	                 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING "bill_id", "total_unit", "version"`
+	err = tx.QueryRow(sqlStatement, b.CustomerID, b.MeterID, b.BillingDate, b.DueDate,
+		b.ReadingPrevious, b.ReadingCurrent, b.RateApplied, b.Currency, b.Status).Scan(&b.BillID, &b.TotalUnit, &b.Version)
+	if err != nil {
+		return err
+	}
+	if err := replaceBillLineItems(tx, b, tariff); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Update validates b and writes its editable fields to bill id, which must
+// still be a draft at version (0 for any version), recomputing the line items.
+func (billService) Update(a auditContext, id int, b *Billing, version int) error {
+	b.normalize()
+	if verr := b.validate(); verr != nil {
+		return verr
+	}
+
+	tx, err := a.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Only drafts are editable; issued bills must be voided and reissued or corrected with notes.
+	var status string
+	var current int
+	err = tx.QueryRow(`SELECT "bill_status", "version" FROM "billing" WHERE "bill_id" = $1 FOR UPDATE`, id).Scan(&status, &current)
+	if err == sql.ErrNoRows {
+		return &APIError{Status: http.StatusNotFound, Code: ErrCodeNotFound, Message: "Bill not found or no changes made"}
+	} else if err != nil {
+		return err
+	}
+	if apiErr := staleVersion(version, current); apiErr != nil {
+		return apiErr
+	}
+	if status != BillStatusDraft {
+		return &APIError{Status: http.StatusConflict, Code: ErrCodeConflict,
+			Message: "Bill is " + status + " and can no longer be edited; void and reissue it or raise a credit/debit note"}
+	}
+
+	// Total_Unit is generated, so not included in SET. We can return it.
+	// Amount_Due follows the line items, which are recomputed from the new readings.
+	// Paid_Status and Bill_Status are not client-editable.
+	sqlStatement := `UPDATE "billing" SET "customer_id"=$1, "meter_id"=$2, "billing_date"=$3, "due_date"=$4,
+	                 "previous_reading"=$5, "current_reading"=$6, "rate_applied"=$7, "currency"=$8
+									 WHERE "bill_id"=$9 RETURNING "total_unit", "paid_status", "bill_status", "replaces_bill_id", "invoice_number", "issued_date", "version"`
+	err = tx.QueryRow(sqlStatement, b.CustomerID, b.MeterID, b.BillingDate, b.DueDate,
+		b.ReadingPrevious, b.ReadingCurrent, b.RateApplied, b.Currency, id).Scan(&b.TotalUnit, &b.PaidStatus, &b.Status, &b.ReplacesBillID, &b.InvoiceNumber, &b.IssuedDate, &b.Version)
+	if err != nil {
+		return err
+	}
+	b.BillID = id
+	// A replacement keeps the tariff of the bill it corrects, as in Reissue.
+	t := tariff
+	if b.ReplacesBillID != nil {
+		if t, err = billTariff(tx, *b.ReplacesBillID); err != nil {
+			return err
+		}
+	}
+	if err := replaceBillLineItems(tx, b, t); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Patch loads draft bill id, lets apply change it and saves the result.
+func (s billService) Patch(a auditContext, id, version int, apply func(*Billing) error) (Billing, error) {
+	b, err := s.load(id)
+	if err != nil {
+		return b, err
+	}
+	if apiErr := staleVersion(version, b.Version); apiErr != nil {
+		return b, apiErr
+	}
+	if err := apply(&b); err != nil {
+		return b, err
+	}
+	err = s.Update(a, id, &b, version)
+	return b, err
+}
+
+// Delete removes a draft bill; bills that were issued must be voided instead.
+func (billService) Delete(a auditContext, id, version int) error {
+	var status string
+	var current int
+	err := db.QueryRow(`SELECT "bill_status", "version" FROM "billing" WHERE "bill_id" = $1`, id).Scan(&status, &current)
+	if err == sql.ErrNoRows {
+		return notFoundError("Bill")
+	} else if err != nil {
+		return err
+	}
+	if apiErr := staleVersion(version, current); apiErr != nil {
+		return apiErr
+	}
+	if status != BillStatusDraft {
+		return &APIError{Status: http.StatusConflict, Code: ErrCodeConflict, Message: "Bill is " + status + " and cannot be deleted; void it instead"}
+	}
+	// The status and version conditions guard against the bill being changed in the meantime.
+	var count int64
+	err = a.write(func(tx *sql.Tx) error {
+		res, err := tx.Exec(`DELETE FROM "billing" WHERE "bill_id" = $1 AND "bill_status" = $2 AND "version" = $3`, id, BillStatusDraft, current)
+		if err != nil {
+			return err
+		}
+		count, _ = res.RowsAffected()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return notWrittenError("billing", "bill_id", id, current, "Bill")
+	}
+	return nil
+}
+
+// transitionError maps the errors of transitionBill to client errors.
+func transitionError(err error) error {
+	switch {
+	case err == sql.ErrNoRows:
+		return notFoundError("Bill")
+	case errors.Is(err, errInvalidBillTransition):
+		return &APIError{Status: http.StatusConflict, Code: ErrCodeConflict, Message: err.Error()}
+	}
+	return err
+}
+
+// ChangeStatus moves bill id to state to on behalf of a's actor. Issuing
+// assigns the invoice number, which is returned; voiding and writing off need
+// a reason.
+func (billService) ChangeStatus(a auditContext, id int, to, reason string) (from, invoiceNumber string, err error) {
+	if to != BillStatusIssued && reason == "" {
+		return "", "", &APIError{Status: http.StatusBadRequest, Code: ErrCodeBadRequest, Message: "A reason is required to " + billActionName(to) + " a bill"}
+	}
+	tx, err := a.begin()
+	if err != nil {
+		return "", "", err
+	}
+	defer tx.Rollback()
+
+	// The legal invoice number is allocated only now, inside the issuing
+	// transaction, so a failed issue rolls the counter back with it.
+	if to == BillStatusIssued {
+		invoiceNumber, err = assignInvoiceNumber(tx, id, time.Now())
+		if err != nil && err != sql.ErrNoRows {
+			return "", "", err
+		}
+	}
+	from, err = transitionBill(tx, id, to, reason, a.ActorID)
+	if err != nil {
+		return from, "", transitionError(err)
+	}
+	return from, invoiceNumber, tx.Commit()
+}
+
+// Reissue voids bill id and creates a new draft copy that references it, so
+// the corrected bill can be edited and issued again. The copy keeps the rate
+// and tariff of the original, so a reissue after a tariff change does not
+// reprice the customer.
+func (billService) Reissue(a auditContext, id int, reason string) (Billing, error) {
+	var b Billing
+	if reason == "" {
+		return b, &APIError{Status: http.StatusBadRequest, Code: ErrCodeBadRequest, Message: "A reason is required to reissue a bill"}
+	}
+	tx, err := a.begin()
+	if err != nil {
+		return b, err
+	}
+	defer tx.Rollback()
+
+	if _, err = transitionBill(tx, id, BillStatusVoid, "Reissued: "+reason, a.ActorID); err != nil {
+		return b, transitionError(err)
+	}
+	err = tx.QueryRow(`INSERT INTO "billing" ("customer_id", "meter_id", "billing_date", "due_date",
+	                   "previous_reading", "current_reading", "rate_applied", "currency", "bill_status", "replaces_bill_id")
+	                   SELECT "customer_id", "meter_id", "billing_date", "due_date",
+	                   "previous_reading", "current_reading", "rate_applied", "currency", $1, "bill_id"
+	                   FROM "billing" WHERE "bill_id" = $2
+	                   RETURNING "bill_id", "customer_id", "meter_id", "billing_date", "due_date",
+	                   "previous_reading", "current_reading", "rate_applied", "total_unit", "currency", "bill_status", "replaces_bill_id", "version"`,
+		BillStatusDraft, id).Scan(&b.BillID, &b.CustomerID, &b.MeterID, &b.BillingDate, &b.DueDate,
+		&b.ReadingPrevious, &b.ReadingCurrent, &b.RateApplied, &b.TotalUnit, &b.Currency, &b.Status, &b.ReplacesBillID, &b.Version)
+	if err != nil {
+		return b, err
+	}
+	t, err := billTariff(tx, id)
+	if err != nil {
+		return b, err
+	}
+	if err := replaceBillLineItems(tx, &b, t); err != nil {
+		return b, err
+	}
+	err = tx.Commit()
+	return b, err
+}
+
+// OpenForCustomer returns the unpaid bills of the customer with this email or
+// phone number, earliest due first. An unknown identifier has no bills.
+func (billService) OpenForCustomer(identifier string) ([]Billing, error) {
+	bills := []Billing{}
+	if identifier == "" {
+		return bills, &APIError{Status: http.StatusBadRequest, Code: ErrCodeBadRequest, Message: "Identifier (email or phone number) is required"}
+	}
+	var customerID int
+	err := db.QueryRow(`SELECT "customer_id" FROM "customer" WHERE ("email" = $1 OR "phone_number" = $1)`, identifier).Scan(&customerID)
+	if err == sql.ErrNoRows {
+		log.Printf("No customer found for identifier: %s", identifier)
+		return bills, nil
+	} else if err != nil {
+		return bills, err
+	}
+
+	rows, err := db.Query(`SELECT "bill_id", "customer_id", "meter_id", "billing_date", "due_date",
+	                        "previous_reading", "current_reading", "rate_applied", "total_unit", "amount_due", "currency", "paid_status",
+	                        "bill_status", "replaces_bill_id", "invoice_number", "issued_date", "version"
+	                        FROM "billing" WHERE "customer_id" = $1 AND "bill_status" IN ($2, $3) ORDER BY "due_date" ASC`,
+		customerID, BillStatusIssued, BillStatusPartiallyPaid)
+	if err != nil {
+		return bills, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var b Billing
+		if err := rows.Scan(&b.BillID, &b.CustomerID, &b.MeterID, &b.BillingDate, &b.DueDate,
+			&b.ReadingPrevious, &b.ReadingCurrent, &b.RateApplied, &b.TotalUnit, &b.AmountDue, &b.Currency, &b.PaidStatus, &b.Status, &b.ReplacesBillID, &b.InvoiceNumber, &b.IssuedDate, &b.Version); err != nil {
+			return bills, err
+		}
+		bills = append(bills, b)
+	}
+	return bills, rows.Err()
+}
+
+// --- Payments ---
+
+type paymentService struct{}
+
+var paymentSvc paymentService
+
+// errBillAlreadyPaid is returned by PayOutstanding for a bill with nothing left to pay.
+var errBillAlreadyPaid = &APIError{Status: http.StatusConflict, Code: ErrCodeConflict, Message: "This bill has already been paid."}
+
+func (paymentService) List(q url.Values) ([]Payment, ListMeta, error) {
+	payments := []Payment{}
+	meta, err := paymentList.query(q, func(row rowScanner) error {
+		var p Payment
+		if err := row.Scan(&p.PaymentID, &p.BillID, &p.ProcessedBy, &p.PaymentDate, &p.AmountPaid, &p.Currency, &p.PaymentMethod, &p.PaymentStatus, &p.Version); err != nil {
+			return err
+		}
+		payments = append(payments, p)
+		return nil
+	})
+	return payments, meta, err
+}
+
+func (paymentService) Get(id int) (Payment, error) {
+	var p Payment
+	sqlStatement := `SELECT "payment_id", "bill_id", "processed_by", "payment_date", "amount_paid", "currency", "payment_method", "payment_status", "version"
+	                 FROM "payment" WHERE "payment_id" = $1`
+	err := db.QueryRow(sqlStatement, id).Scan(&p.PaymentID, &p.BillID, &p.ProcessedBy, &p.PaymentDate, &p.AmountPaid, &p.Currency, &p.PaymentMethod, &p.PaymentStatus, &p.Version)
+	if err == sql.ErrNoRows {
+		return p, notFoundError("Payment")
+	}
+	return p, err
+}
+
+// Create records p against a bill that can still take payments.
+func (paymentService) Create(a auditContext, p *Payment) error {
+	if p.Currency == "" {
+		p.Currency = DefaultCurrency
+	}
+	if verr := p.validate(); verr != nil {
+		return verr
+	}
+	var billStatus string
+	err := db.QueryRow(`SELECT "bill_status" FROM "billing" WHERE "bill_id" = $1`, p.BillID).Scan(&billStatus)
+	if err == sql.ErrNoRows {
+		return &APIError{Status: http.StatusBadRequest, Code: ErrCodeBadRequest, Message: "Bill not found for payment"}
+	} else if err != nil {
+		return err
+	}
+	if !isBillPayable(billStatus) {
+		return &APIError{Status: http.StatusConflict, Code: ErrCodeConflict, Message: "Bill is " + billStatus + " and cannot accept payments"}
+	}
+	sqlStatement := `INSERT INTO "payment" ("bill_id", "processed_by", "payment_date", "amount_paid", "currency", "payment_method", "payment_status")
+	                 VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING "payment_id", "version"`
+	return a.write(func(tx *sql.Tx) error {
+		return tx.QueryRow(sqlStatement, p.BillID, p.ProcessedBy, p.PaymentDate, p.AmountPaid, p.Currency, p.PaymentMethod, p.PaymentStatus).Scan(&p.PaymentID, &p.Version)
+	})
+}
+
+// Update validates p and writes all of its fields to payment id, provided
+// the row is still at version (0 for any version).
+func (paymentService) Update(a auditContext, id int, p *Payment, version int) error {
+	if p.Currency == "" {
+		p.Currency = DefaultCurrency
+	}
+	if verr := p.validate(); verr != nil {
+		return verr
+	}
+	sqlStatement := `UPDATE "payment" SET "bill_id"=$1, "processed_by"=$2, "payment_date"=$3, "amount_paid"=$4, "currency"=$5, "payment_method"=$6, "payment_status"=$7
+	                 WHERE "payment_id"=$8 AND ($9 = 0 OR "version" = $9) RETURNING "version"`
+	err := a.write(func(tx *sql.Tx) error {
+		return tx.QueryRow(sqlStatement, p.BillID, p.ProcessedBy, p.PaymentDate, p.AmountPaid, p.Currency, p.PaymentMethod, p.PaymentStatus,
+			id, version).Scan(&p.Version)
+	})
+	if err == sql.ErrNoRows {
+		return notWrittenError("payment", "payment_id", id, version, "Payment")
+	} else if err != nil {
+		return err
+	}
+	p.PaymentID = id
+	return nil
+}
+
+// Patch loads payment id, lets apply change it and saves the result.
+func (s paymentService) Patch(a auditContext, id, version int, apply func(*Payment) error) (Payment, error) {
+	p, err := s.Get(id)
+	if err != nil {
+		return p, err
+	}
+	if apiErr := staleVersion(version, p.Version); apiErr != nil {
+		return p, apiErr
+	}
+	if err := apply(&p); err != nil {
+		return p, err
+	}
+	err = s.Update(a, id, &p, version)
+	return p, err
+}
+
+func (paymentService) Delete(a auditContext, id, version int) error {
+	var count int64
+	err := a.write(func(tx *sql.Tx) error {
+		res, err := tx.Exec(`DELETE FROM "payment" WHERE "payment_id" = $1 AND ($2 = 0 OR "version" = $2)`, id, version)
+		if err != nil {
+			return err
+		}
+		count, _ = res.RowsAffected()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return notWrittenError("payment", "payment_id", id, version, "Payment")
+	}
+	return nil
+}
+
+// PayOutstanding records a completed payment of the whole outstanding balance
+// of a bill, as made by a customer through the portal, and returns its ID.
+func (paymentService) PayOutstanding(a auditContext, billID int, method string) (int, error) {
+	if method == "" {
+		return 0, &APIError{Status: http.StatusBadRequest, Code: ErrCodeBadRequest, Message: "Payment method is required"}
+	}
+	tx, err := a.begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Lock the bill so two payments cannot both settle the same balance.
+	var status string
+	err = tx.QueryRow(`SELECT "bill_status" FROM "billing" WHERE "bill_id" = $1 FOR UPDATE`, billID).Scan(&status)
+	if err == sql.ErrNoRows {
+		return 0, notFoundError("Bill")
+	} else if err != nil {
+		return 0, err
+	}
+	if status == BillStatusPaid {
+		return 0, errBillAlreadyPaid
+	}
+	if !isBillPayable(status) {
+		return 0, &APIError{Status: http.StatusConflict, Code: ErrCodeConflict, Message: "This bill is " + status + " and cannot be paid"}
+	}
+
+	// The customer owes what is left after credit and debit notes and earlier
+	// payments. Paid_Status and Bill_Status are then updated by the Payment
+	// trigger.
+	amountToPay, currency, err := billOutstanding(tx, billID)
+	if err != nil {
+		return 0, err
+	}
+	if amountToPay.Sign() <= 0 {
+		return 0, errBillAlreadyPaid
+	}
+
+	// Processed_By 0 marks a payment made by the customer rather than staff.
+	paymentDate := time.Now().Format("2006-01-02")
+	log.Printf("Attempting to insert payment with values: BillID=%d, ProcessedBy=nil, PaymentDate='%s', AmountPaid=%s, PaymentMethod='%s', PaymentStatus='Completed'",
+		billID, paymentDate, amountToPay.Format(currency), method)
+	var paymentID int
+	err = tx.QueryRow(`INSERT INTO "payment" ("bill_id", "processed_by", "payment_date", "amount_paid", "currency", "payment_method", "payment_status")
+	                   VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING "payment_id"`,
+		billID, 0, paymentDate, amountToPay, currency, method, "Completed").Scan(&paymentID)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	log.Printf("Payment successful for Bill ID: %d, new Payment ID: %d", billID, paymentID)
+	return paymentID, nil
+}
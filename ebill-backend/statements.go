@@ -0,0 +1,627 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Customer statement output formats.
+const (
+	StatementOutputJSON = "json"
+	StatementOutputCSV  = "csv"
+	StatementOutputPDF  = "pdf"
+)
+
+// StatementSummary totals a statement period by kind of movement. Bills are
+// net of voided bills, Adjustments are credit notes and Fees are debit notes.
+type StatementSummary struct {
+	Bills       Money `json:"Bills"`
+	Payments    Money `json:"Payments"`
+	Adjustments Money `json:"Adjustments"`
+	Fees        Money `json:"Fees"`
+}
+
+// CustomerStatement is a customer's account activity in one currency over a date range.
+type CustomerStatement struct {
+	Customer       Customer         `json:"Customer"`
+	PeriodStart    string           `json:"Period_Start"`
+	PeriodEnd      string           `json:"Period_End"`
+	Currency       string           `json:"Currency"`
+	OpeningBalance Money            `json:"Opening_Balance"`
+	Entries        []LedgerEntry    `json:"Entries"`
+	Summary        StatementSummary `json:"Summary"`
+	ClosingBalance Money            `json:"Closing_Balance"`
+	GeneratedAt    time.Time        `json:"Generated_At"`
+}
+
+// statementNumber identifies the statement on documents and in file names.
+func (s *CustomerStatement) statementNumber() string {
+	return fmt.Sprintf("ST-%d-%s-%s", s.Customer.CustomerID, strings.ReplaceAll(s.PeriodEnd, "-", ""), s.Currency)
+}
+
+// parseStatementPeriod reads ?month=YYYY-MM or ?from=&to= (inclusive dates).
+// Without either the previous calendar month is used.
+func parseStatementPeriod(q url.Values) (from, to time.Time, err error) {
+	if m := q.Get("month"); m != "" {
+		start, err := time.Parse("2006-01", m)
+		if err != nil {
+			return from, to, fmt.Errorf("month must be YYYY-MM")
+		}
+		from, to = monthBounds(start)
+		return from, to, nil
+	}
+	if q.Get("from") == "" && q.Get("to") == "" {
+		from, to = monthBounds(previousMonth(time.Now()))
+		return from, to, nil
+	}
+	if from, err = time.Parse("2006-01-02", q.Get("from")); err != nil {
+		return from, to, fmt.Errorf("from must be YYYY-MM-DD")
+	}
+	if to, err = time.Parse("2006-01-02", q.Get("to")); err != nil {
+		return from, to, fmt.Errorf("to must be YYYY-MM-DD")
+	}
+	if to.Before(from) {
+		return from, to, fmt.Errorf("to must not be before from")
+	}
+	return from, to, nil
+}
+
+// previousMonth returns the first day of the month before the one containing
+// t. Stepping back from the first avoids AddDate's normalisation, which turns
+// 31 October minus a month into 1 October.
+func previousMonth(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -1, 0)
+}
+
+// monthBounds returns the first and last day of the month containing t.
+func monthBounds(t time.Time) (time.Time, time.Time) {
+	first := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return first, first.AddDate(0, 1, -1)
+}
+
+// statementCurrency is the currency of the customer's most recent ledger entry.
+func statementCurrency(customerID int) (string, error) {
+	var currency string
+	err := db.QueryRow(`SELECT "currency" FROM "customer_ledger" WHERE "customer_id" = $1
+	                    ORDER BY "entry_date" DESC, "entry_order" DESC LIMIT 1`, customerID).Scan(&currency)
+	if err == sql.ErrNoRows {
+		return DefaultCurrency, nil
+	}
+	return currency, err
+}
+
+// loadCustomerStatement builds the statement of a customer from the customer_ledger view.
+func loadCustomerStatement(customerID int, currency string, from, to time.Time) (*CustomerStatement, error) {
+	s := &CustomerStatement{
+		PeriodStart: from.Format("2006-01-02"),
+		PeriodEnd:   to.Format("2006-01-02"),
+		Currency:    currency,
+		Entries:     []LedgerEntry{},
+		GeneratedAt: time.Now(),
+	}
+	c := &s.Customer
+	err := db.QueryRow(`SELECT "customer_id", "name", "address", "email", "phone_number", "registration_date"
+	                    FROM "customer" WHERE "customer_id" = $1`, customerID).Scan(&c.CustomerID, &c.Name, &c.Address, &c.Email,
+		&c.PhoneNumber, &c.RegistrationDate)
+	if err != nil {
+		return nil, err
+	}
+	err = db.QueryRow(`SELECT COALESCE(SUM("debit" - "credit"), 0) FROM "customer_ledger"
+	                   WHERE "customer_id" = $1 AND "currency" = $2 AND "entry_date" < $3`,
+		customerID, currency, s.PeriodStart).Scan(&s.OpeningBalance)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(`SELECT "entry_date", "entry_type", "reference", "bill_id", "description", "debit", "credit", "currency"
+	                       FROM "customer_ledger" WHERE "customer_id" = $1 AND "currency" = $2 AND "entry_date" BETWEEN $3 AND $4
+	                       ORDER BY "entry_date" ASC, "entry_order" ASC, "reference" ASC`, customerID, currency, s.PeriodStart, s.PeriodEnd)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	balance := s.OpeningBalance
+	for rows.Next() {
+		var e LedgerEntry
+		if err := rows.Scan(&e.EntryDate, &e.EntryType, &e.Reference, &e.BillID, &e.Description, &e.Debit, &e.Credit, &e.Currency); err != nil {
+			return nil, err
+		}
+		if len(e.EntryDate) > 10 {
+			e.EntryDate = e.EntryDate[:10]
+		}
+		balance = balance.Add(e.Debit).Sub(e.Credit)
+		e.Balance = balance
+		switch e.EntryType {
+		case "bill":
+			s.Summary.Bills = s.Summary.Bills.Add(e.Debit).Sub(e.Credit)
+		case "payment":
+			s.Summary.Payments = s.Summary.Payments.Add(e.Credit)
+		case "credit_note":
+			s.Summary.Adjustments = s.Summary.Adjustments.Add(e.Credit)
+		case "debit_note":
+			s.Summary.Fees = s.Summary.Fees.Add(e.Debit)
+		}
+		s.Entries = append(s.Entries, e)
+	}
+	s.ClosingBalance = balance
+	return s, rows.Err()
+}
+
+// WriteCSV writes the statement as CSV, bracketed by opening and closing balance rows.
+func (s *CustomerStatement) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"Date", "Type", "Reference", "Bill_ID", "Description", "Debit", "Credit", "Balance", "Currency"})
+	cw.Write([]string{s.PeriodStart, "opening_balance", "", "", "Opening balance", "", "", s.OpeningBalance.String(), s.Currency})
+	for _, e := range s.Entries {
+		billID := ""
+		if e.BillID != nil {
+			billID = strconv.Itoa(*e.BillID)
+		}
+		cw.Write([]string{e.EntryDate, e.EntryType, e.Reference, billID, e.Description,
+			e.Debit.String(), e.Credit.String(), e.Balance.String(), e.Currency})
+	}
+	cw.Write([]string{s.PeriodEnd, "closing_balance", "", "", "Closing balance", "", "", s.ClosingBalance.String(), s.Currency})
+	cw.Flush()
+	return cw.Error()
+}
+
+// renderStatementPDF lays out a statement on as many A4 pages as its entries need.
+func renderStatementPDF(s *CustomerStatement) *PDFDocument {
+	doc := NewPDFDocument("Statement " + s.statementNumber())
+	fonts := newDocumentFonts()
+	p := doc.AddPage()
+	drawDocumentHeader(p, fonts, fonts.label("STATEMENT", "ใบแจ้งยอดบัญชี"))
+
+	p.SetFillColor(brandR, brandG, brandB)
+	p.Text(40, 130, fonts.bold, 10, fonts.label("Customer", "ผู้ใช้ไฟฟ้า"))
+	p.SetFillColor(0, 0, 0)
+	p.Text(40, 148, fonts.bold, 12, s.Customer.Name)
+	y := 163.0
+	for _, line := range wrapText(fonts.regular, 10, s.Customer.Address, 250) {
+		p.Text(40, y, fonts.regular, 10, line)
+		y += 13
+	}
+	drawField(p, fonts, 40, y+6, 150, fonts.label("Customer no.", "รหัสผู้ใช้"), strconv.Itoa(s.Customer.CustomerID))
+
+	fields := [][2]string{
+		{fonts.label("Statement no.", "เลขที่"), s.statementNumber()},
+		{fonts.label("Period from", "ตั้งแต่วันที่"), displayDate(s.PeriodStart)},
+		{fonts.label("Period to", "ถึงวันที่"), displayDate(s.PeriodEnd)},
+		{fonts.label("Currency", "สกุลเงิน"), s.Currency},
+	}
+	for i, f := range fields {
+		drawField(p, fonts, 330, 130+float64(i)*15, 440, f[0], f[1])
+	}
+
+	// Summary band.
+	y = max(y+50, 225)
+	summary := [][2]string{
+		{fonts.label("Opening", "ยอดยกมา"), formatAmount(s.OpeningBalance)},
+		{fonts.label("Bills", "ค่าไฟฟ้า"), formatAmount(s.Summary.Bills)},
+		{fonts.label("Fees", "ค่าธรรมเนียม"), formatAmount(s.Summary.Fees)},
+		{fonts.label("Adjustments", "ลดหนี้"), formatAmount(s.Summary.Adjustments.Neg())},
+		{fonts.label("Payments", "ชำระแล้ว"), formatAmount(s.Summary.Payments.Neg())},
+		{fonts.label("Closing", "ยอดยกไป"), formatAmount(s.ClosingBalance)},
+	}
+	colW := (pdfPageWidth - 80) / float64(len(summary))
+	p.SetFillColor(0.95, 0.96, 0.98)
+	p.FillRect(40, y, pdfPageWidth-80, 40)
+	for i, r := range summary {
+		x := 40 + float64(i)*colW + colW/2
+		p.SetFillColor(0.35, 0.35, 0.35)
+		p.TextCenter(x, y+15, fonts.regular, 8, r[0])
+		p.SetFillColor(0, 0, 0)
+		p.TextCenter(x, y+31, fonts.bold, 11, r[1])
+	}
+
+	// Entries table, continued on new pages as needed.
+	const colRef, colDesc, colDebit, colCredit, colBalance = 110.0, 200.0, 390.0, 465.0, pdfPageWidth - 46
+	tableHeader := func(y float64) {
+		p.SetFillColor(brandR, brandG, brandB)
+		p.FillRect(40, y-13, pdfPageWidth-80, 19)
+		p.SetFillColor(1, 1, 1)
+		p.Text(46, y, fonts.bold, 9, fonts.label("Date", "วันที่"))
+		p.Text(colRef, y, fonts.bold, 9, fonts.label("Reference", "เลขที่"))
+		p.Text(colDesc, y, fonts.bold, 9, fonts.label("Description", "รายการ"))
+		p.TextRight(colDebit, y, fonts.bold, 9, fonts.label("Debit", "เดบิต"))
+		p.TextRight(colCredit, y, fonts.bold, 9, fonts.label("Credit", "เครดิต"))
+		p.TextRight(colBalance, y, fonts.bold, 9, fonts.label("Balance", "คงเหลือ"))
+		p.SetFillColor(0, 0, 0)
+		p.SetStrokeColor(0.85, 0.85, 0.85)
+	}
+	row := func(y float64, date, ref, desc, debit, credit, balance string, f PDFFont) {
+		p.Text(46, y, f, 9, date)
+		p.Text(colRef, y, f, 9, ref)
+		if lines := wrapText(f, 9, desc, colDebit-colDesc-70); len(lines) > 0 {
+			p.Text(colDesc, y, f, 9, lines[0])
+		}
+		p.TextRight(colDebit, y, f, 9, debit)
+		p.TextRight(colCredit, y, f, 9, credit)
+		p.TextRight(colBalance, y, f, 9, balance)
+		p.Line(40, y+6, pdfPageWidth-40, y+6, 0.5)
+	}
+	amount := func(m Money) string {
+		if m.IsZero() {
+			return ""
+		}
+		return formatAmount(m)
+	}
+
+	y += 70
+	tableHeader(y)
+	y += 18
+	row(y, displayDate(s.PeriodStart), "", fonts.label("Opening balance", "ยอดยกมา"), "", "", formatAmount(s.OpeningBalance), fonts.bold)
+	for _, e := range s.Entries {
+		y += 17
+		if y > pdfPageHeight-70 {
+			drawFooter(p, fonts)
+			p = doc.AddPage()
+			y = 60
+			tableHeader(y)
+			y += 18
+		}
+		row(y, displayDate(e.EntryDate), e.Reference, e.Description, amount(e.Debit), amount(e.Credit), formatAmount(e.Balance), fonts.regular)
+	}
+	y += 17
+	if y > pdfPageHeight-100 {
+		drawFooter(p, fonts)
+		p = doc.AddPage()
+		y = 60
+	}
+	p.SetFillColor(0.95, 0.96, 0.98)
+	p.FillRect(300, y-8, pdfPageWidth-340, 26)
+	p.SetFillColor(0, 0, 0)
+	p.TextRight(colCredit, y+9, fonts.bold, 12, fonts.label("Closing balance", "ยอดยกไป"))
+	p.TextRight(colBalance, y+9, fonts.bold, 12, formatAmount(s.ClosingBalance)+" "+s.Currency)
+	drawFooter(p, fonts)
+	return doc
+}
+
+// writeStatement encodes s in the given format.
+func writeStatement(w io.Writer, s *CustomerStatement, format string) error {
+	switch format {
+	case StatementOutputJSON:
+		return json.NewEncoder(w).Encode(s)
+	case StatementOutputCSV:
+		return s.WriteCSV(w)
+	case StatementOutputPDF:
+		_, err := renderStatementPDF(s).WriteTo(w)
+		return err
+	}
+	return fmt.Errorf("unknown statement format %q", format)
+}
+
+// getCustomerStatement generates a statement for ?month= or ?from=&to=, as
+// JSON (default), CSV or PDF depending on ?format=.
+func getCustomerStatement(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	customerID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Customer ID")
+		return
+	}
+	log.Printf("API: getCustomerStatement called for Customer ID: %d", customerID)
+	q := r.URL.Query()
+	from, to, err := parseStatementPeriod(q)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid statement period: "+err.Error())
+		return
+	}
+	format := strings.ToLower(q.Get("format"))
+	if format == "" {
+		format = StatementOutputJSON
+	}
+	if format != StatementOutputJSON && format != StatementOutputCSV && format != StatementOutputPDF {
+		respondWithError(w, http.StatusBadRequest, "format must be json, csv or pdf")
+		return
+	}
+	currency := strings.ToUpper(q.Get("currency"))
+	if currency == "" {
+		if currency, err = statementCurrency(customerID); err != nil {
+			respondWithServerError(w, "Failed to retrieve statement", err)
+			return
+		}
+	}
+	s, err := loadCustomerStatement(customerID, currency, from, to)
+	if err == sql.ErrNoRows {
+		respondWithError(w, http.StatusNotFound, "Customer not found")
+		return
+	} else if err != nil {
+		respondWithServerError(w, "Failed to retrieve statement", err)
+		return
+	}
+	switch format {
+	case StatementOutputJSON:
+		respondWithJSON(w, http.StatusOK, s)
+	case StatementOutputPDF:
+		writePDF(w, s.statementNumber()+".pdf", renderStatementPDF(s))
+	case StatementOutputCSV:
+		var buf bytes.Buffer
+		if err := s.WriteCSV(&buf); err != nil {
+			respondWithServerError(w, "Failed to write statement", err)
+			return
+		}
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", s.statementNumber()+".csv"))
+		w.WriteHeader(http.StatusOK)
+		w.Write(buf.Bytes())
+	}
+}
+
+// StoredStatement is the month-end snapshot of a statement kept in customer_statement.
+type StoredStatement struct {
+	StatementID    int              `json:"Statement_ID"`
+	CustomerID     int              `json:"Customer_ID"`
+	PeriodStart    string           `json:"Period_Start"`
+	PeriodEnd      string           `json:"Period_End"`
+	Currency       string           `json:"Currency"`
+	OpeningBalance Money            `json:"Opening_Balance"`
+	Summary        StatementSummary `json:"Summary"`
+	ClosingBalance Money            `json:"Closing_Balance"`
+	EntryCount     int              `json:"Entry_Count"`
+	GeneratedAt    time.Time        `json:"Generated_At"`
+}
+
+// StatementRun reports the outcome of a month-end batch.
+type StatementRun struct {
+	PeriodStart string   `json:"Period_Start"`
+	PeriodEnd   string   `json:"Period_End"`
+	Generated   int      `json:"Generated"`
+	Skipped     int      `json:"Skipped"` // No opening balance and no activity
+	Files       []string `json:"Files,omitempty"`
+}
+
+// generateMonthEndStatements builds and stores the statement of every customer
+// (one per currency) for the month containing month. Customers with nothing
+// owing and no activity in the month are skipped. The snapshots are stored in
+// one transaction, so a month either has all of its statements or none and a
+// failed run is retried in full. When outDir is non-empty each statement is
+// then also written there in the given format. Re-running a month replaces its
+// snapshots.
+func generateMonthEndStatements(month time.Time, outDir, format string) (*StatementRun, error) {
+	from, to := monthBounds(month)
+	run := &StatementRun{PeriodStart: from.Format("2006-01-02"), PeriodEnd: to.Format("2006-01-02")}
+	if outDir != "" {
+		if err := os.MkdirAll(outDir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	rows, err := db.Query(`SELECT DISTINCT "customer_id", "currency" FROM "customer_ledger"
+	                       WHERE "entry_date" <= $1 ORDER BY "customer_id", "currency"`, run.PeriodEnd)
+	if err != nil {
+		return nil, err
+	}
+	type account struct {
+		customerID int
+		currency   string
+	}
+	var accounts []account
+	for rows.Next() {
+		var a account
+		if err := rows.Scan(&a.customerID, &a.currency); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		accounts = append(accounts, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	var statements []*CustomerStatement
+	for _, a := range accounts {
+		s, err := loadCustomerStatement(a.customerID, a.currency, from, to)
+		if err != nil {
+			return nil, fmt.Errorf("customer %d: %w", a.customerID, err)
+		}
+		if s.OpeningBalance.IsZero() && len(s.Entries) == 0 {
+			run.Skipped++
+			continue
+		}
+		_, err = tx.Exec(`INSERT INTO "customer_statement" ("customer_id", "period_start", "period_end", "currency", "opening_balance",
+		                      "total_bills", "total_payments", "total_adjustments", "total_fees", "closing_balance", "entry_count")
+		                  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		                  ON CONFLICT ("customer_id", "period_start", "period_end", "currency") DO UPDATE SET
+		                      "opening_balance" = EXCLUDED."opening_balance", "total_bills" = EXCLUDED."total_bills",
+		                      "total_payments" = EXCLUDED."total_payments", "total_adjustments" = EXCLUDED."total_adjustments",
+		                      "total_fees" = EXCLUDED."total_fees", "closing_balance" = EXCLUDED."closing_balance",
+		                      "entry_count" = EXCLUDED."entry_count", "generated_at" = CURRENT_TIMESTAMP`,
+			a.customerID, s.PeriodStart, s.PeriodEnd, s.Currency, s.OpeningBalance, s.Summary.Bills, s.Summary.Payments,
+			s.Summary.Adjustments, s.Summary.Fees, s.ClosingBalance, len(s.Entries))
+		if err != nil {
+			return nil, fmt.Errorf("customer %d: %w", a.customerID, err)
+		}
+		statements = append(statements, s)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	run.Generated = len(statements)
+
+	if outDir == "" {
+		return run, nil
+	}
+	for _, s := range statements {
+		path := filepath.Join(outDir, s.statementNumber()+"."+format)
+		if err := writeStatementFile(path, s, format); err != nil {
+			return nil, err
+		}
+		run.Files = append(run.Files, path)
+	}
+	return run, nil
+}
+
+func writeStatementFile(path string, s *CustomerStatement, format string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeStatement(f, s, format); err != nil {
+		f.Close()
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return f.Close()
+}
+
+// runMonthEndStatements generates the stored statements for ?month=YYYY-MM
+// (default: the previous month).
+func runMonthEndStatements(w http.ResponseWriter, r *http.Request) {
+	log.Println("API: runMonthEndStatements called")
+	q := r.URL.Query()
+	if q.Get("from") != "" || q.Get("to") != "" {
+		respondWithError(w, http.StatusBadRequest, "Month-end statements are generated per calendar month; use ?month=YYYY-MM")
+		return
+	}
+	from, _, err := parseStatementPeriod(q)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid statement period: "+err.Error())
+		return
+	}
+	if !from.AddDate(0, 1, 0).Before(time.Now()) {
+		respondWithError(w, http.StatusConflict, "Statements can only be generated once the month has ended")
+		return
+	}
+	run, err := generateMonthEndStatements(from, "", "")
+	if err != nil {
+		respondWithServerError(w, "Failed to generate statements", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, run)
+}
+
+// getStoredStatements lists month-end statement snapshots, optionally filtered
+// by ?month=YYYY-MM and ?customer_id=.
+func getStoredStatements(w http.ResponseWriter, r *http.Request) {
+	log.Println("API: getStoredStatements called")
+	q := r.URL.Query()
+	where := []string{"TRUE"}
+	var args []interface{}
+	if m := q.Get("month"); m != "" {
+		start, err := time.Parse("2006-01", m)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "month must be YYYY-MM")
+			return
+		}
+		args = append(args, start.Format("2006-01-02"))
+		where = append(where, fmt.Sprintf(`"period_start" = $%d`, len(args)))
+	}
+	if c := q.Get("customer_id"); c != "" {
+		id, err := strconv.Atoi(c)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid Customer ID")
+			return
+		}
+		args = append(args, id)
+		where = append(where, fmt.Sprintf(`"customer_id" = $%d`, len(args)))
+	}
+	rows, err := db.Query(`SELECT "statement_id", "customer_id", "period_start", "period_end", "currency", "opening_balance", "total_bills",
+	                              "total_payments", "total_adjustments", "total_fees", "closing_balance", "entry_count", "generated_at"
+	                       FROM "customer_statement" WHERE `+strings.Join(where, " AND ")+`
+	                       ORDER BY "period_start" DESC, "customer_id" ASC, "currency" ASC`, args...)
+	if err != nil {
+		respondWithServerError(w, "Failed to retrieve statements", err)
+		return
+	}
+	defer rows.Close()
+	statements := []StoredStatement{}
+	for rows.Next() {
+		var s StoredStatement
+		if err := rows.Scan(&s.StatementID, &s.CustomerID, &s.PeriodStart, &s.PeriodEnd, &s.Currency, &s.OpeningBalance, &s.Summary.Bills,
+			&s.Summary.Payments, &s.Summary.Adjustments, &s.Summary.Fees, &s.ClosingBalance, &s.EntryCount, &s.GeneratedAt); err != nil {
+			respondWithServerError(w, "Error scanning statement data", err)
+			return
+		}
+		statements = append(statements, s)
+	}
+	if err = rows.Err(); err != nil {
+		respondWithServerError(w, "Error iterating statement rows", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, statements)
+}
+
+// StatementSchedule controls the in-process month-end batch. It is on unless
+// MONTH_END_STATEMENTS=off; STATEMENT_OUTPUT_DIR and STATEMENT_OUTPUT_FORMAT
+// additionally write each statement to disk.
+type StatementSchedule struct {
+	Enabled   bool
+	OutputDir string
+	Format    string
+}
+
+var statementSchedule = StatementSchedule{Enabled: true, Format: StatementOutputPDF}
+
+func loadStatementConfig() error {
+	switch strings.ToLower(os.Getenv("MONTH_END_STATEMENTS")) {
+	case "", "on", "true", "1":
+	case "off", "false", "0":
+		statementSchedule.Enabled = false
+	default:
+		return fmt.Errorf("MONTH_END_STATEMENTS must be on or off")
+	}
+	statementSchedule.OutputDir = os.Getenv("STATEMENT_OUTPUT_DIR")
+	if f := strings.ToLower(os.Getenv("STATEMENT_OUTPUT_FORMAT")); f != "" {
+		if f != StatementOutputJSON && f != StatementOutputCSV && f != StatementOutputPDF {
+			return fmt.Errorf("STATEMENT_OUTPUT_FORMAT must be json, csv or pdf")
+		}
+		statementSchedule.Format = f
+	}
+	return nil
+}
+
+// startMonthEndStatements checks hourly whether the previous month's
+// statements have been generated and generates them if not, so the batch runs
+// shortly after each month end without an external scheduler.
+func startMonthEndStatements() {
+	if !statementSchedule.Enabled {
+		return
+	}
+	done := ""
+	check := func() {
+		from, _ := monthBounds(previousMonth(time.Now()))
+		period := from.Format("2006-01-02")
+		if done == period {
+			return
+		}
+		var exists bool
+		err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM "customer_statement" WHERE "period_start" = $1)`, period).Scan(&exists)
+		if err != nil {
+			log.Printf("Month-end statements: %v", err)
+			return
+		}
+		if !exists {
+			run, err := generateMonthEndStatements(from, statementSchedule.OutputDir, statementSchedule.Format)
+			if err != nil {
+				log.Printf("Month-end statements for %s failed: %v", from.Format("2006-01"), err)
+				return
+			}
+			log.Printf("Month-end statements for %s: %d generated, %d skipped", from.Format("2006-01"), run.Generated, run.Skipped)
+		}
+		done = period
+	}
+	go func() {
+		check()
+		for range time.Tick(time.Hour) {
+			check()
+		}
+	}()
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Request bodies are validated from `validate` struct tags before anything
+// is written to the database, and every violation is reported at once. A tag
+// is a comma-separated list of rules:
+//
+//	required     the field must not be empty, blank or zero
+//	max=N        a string of at most N characters
+//	email        an email address, as accepted by the Customer.Email CHECK
+//	phone        a phone number in the ###-###-#### form
+//	date         a date (YYYY-MM-DD)
+//	currency     an ISO 4217 code such as THB
+//	oneof=a|b    one of the listed values
+//	gt=N, gte=N  a number greater than (or equal to) N
+//	gtefield=F   not less than the field whose JSON name is F; dates and numbers
+//
+// Rules other than required are skipped for empty strings.
+
+var (
+	emailPattern    = regexp.MustCompile(`^[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}$`)
+	phonePattern    = regexp.MustCompile(`^\d{3}-\d{3}-\d{4}$`)
+	currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+)
+
+// parseDate parses a date as sent by clients (YYYY-MM-DD) or as echoed back
+// from a DATE column (RFC 3339).
+func parseDate(s string) (time.Time, bool) {
+	for _, layout := range []string{"2006-01-02", time.RFC3339} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// isDate reports whether s is a date accepted by parseDate.
+func isDate(s string) bool {
+	_, ok := parseDate(s)
+	return ok
+}
+
+// fieldName is the JSON name of a struct field, which is what error details refer to.
+func fieldName(f reflect.StructField) string {
+	if name := strings.Split(f.Tag.Get("json"), ",")[0]; name != "" && name != "-" {
+		return name
+	}
+	return f.Name
+}
+
+// decimalValue returns the value of a Decimal, Money or integer field.
+func decimalValue(v reflect.Value) (Decimal, bool) {
+	switch x := v.Interface().(type) {
+	case Decimal:
+		return x, true
+	case Money:
+		return x.Decimal(), true
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return NewDecimal(v.Int(), 0), true
+	}
+	return Decimal{}, false
+}
+
+// isEmptyValue reports whether a field holds nothing: a blank string or a zero number.
+func isEmptyValue(v reflect.Value) bool {
+	if v.Kind() == reflect.String {
+		return strings.TrimSpace(v.String()) == ""
+	}
+	if d, ok := decimalValue(v); ok {
+		return d.IsZero()
+	}
+	return v.IsZero()
+}
+
+// compareFields orders two field values of the same kind: dates by time,
+// numbers numerically. ok is false when they cannot be compared.
+func compareFields(a, b reflect.Value) (cmp int, ok bool) {
+	if a.Kind() == reflect.String && b.Kind() == reflect.String {
+		ta, okA := parseDate(a.String())
+		tb, okB := parseDate(b.String())
+		if !okA || !okB {
+			return 0, false
+		}
+		return ta.Compare(tb), true
+	}
+	da, okA := decimalValue(a)
+	db, okB := decimalValue(b)
+	if !okA || !okB {
+		return 0, false
+	}
+	return da.Cmp(db), true
+}
+
+// fieldByJSONName finds the field of struct s whose JSON name is name.
+func fieldByJSONName(s reflect.Value, name string) reflect.Value {
+	for i := 0; i < s.NumField(); i++ {
+		if fieldName(s.Type().Field(i)) == name {
+			return s.Field(i)
+		}
+	}
+	return reflect.Value{}
+}
+
+// checkRule returns the code and message for a field value v of struct s
+// that breaks rule, or empty strings.
+func checkRule(s, v reflect.Value, name, rule, arg string) (code, message string) {
+	switch rule {
+	case "max":
+		n, _ := strconv.Atoi(arg)
+		if utf8.RuneCountInString(v.String()) > n {
+			return "too_long", fmt.Sprintf("%s must be at most %d characters", name, n)
+		}
+	case "email":
+		if !emailPattern.MatchString(v.String()) {
+			return "invalid_format", name + " must be a valid email address"
+		}
+	case "phone":
+		if !phonePattern.MatchString(v.String()) {
+			return "invalid_format", name + " must be a phone number in the form ###-###-####"
+		}
+	case "date":
+		if !isDate(v.String()) {
+			return "invalid_format", name + " must be a date (YYYY-MM-DD)"
+		}
+	case "currency":
+		if !currencyPattern.MatchString(v.String()) {
+			return "invalid_format", name + " must be a three-letter ISO 4217 currency code"
+		}
+	case "oneof":
+		allowed := strings.Split(arg, "|")
+		for _, a := range allowed {
+			if v.String() == a {
+				return "", ""
+			}
+		}
+		return "not_allowed", name + " must be one of: " + strings.Join(allowed, ", ")
+	case "gt", "gte":
+		d, ok := decimalValue(v)
+		bound, err := ParseDecimal(arg)
+		if !ok || err != nil {
+			panic("validate: " + rule + " on non-numeric field " + name)
+		}
+		if c := d.Cmp(bound); c < 0 || (c == 0 && rule == "gt") {
+			if rule == "gt" {
+				return "out_of_range", name + " must be greater than " + arg
+			}
+			return "out_of_range", name + " must be at least " + arg
+		}
+	case "gtefield":
+		other := fieldByJSONName(s, arg)
+		if !other.IsValid() {
+			panic("validate: unknown field " + arg)
+		}
+		// Values that cannot be compared are reported by their format rules.
+		if c, ok := compareFields(v, other); ok && c < 0 {
+			if v.Kind() == reflect.String {
+				return "out_of_range", name + " cannot be before " + arg
+			}
+			return "out_of_range", name + " cannot be less than " + arg
+		}
+	default:
+		panic("validate: unknown rule " + rule)
+	}
+	return "", ""
+}
+
+// validateStruct checks the validate tags of the struct that v points to and
+// returns a 422 listing every field that fails, or nil. noun names the
+// resource in the summary message.
+func validateStruct(noun string, v interface{}) *APIError {
+	s := reflect.Indirect(reflect.ValueOf(v))
+	var details []FieldError
+	for i := 0; i < s.NumField(); i++ {
+		f := s.Type().Field(i)
+		tag := f.Tag.Get("validate")
+		if tag == "" {
+			continue
+		}
+		name, value := fieldName(f), s.Field(i)
+		for _, r := range strings.Split(tag, ",") {
+			rule, arg, _ := strings.Cut(r, "=")
+			if rule == "required" {
+				if isEmptyValue(value) {
+					details = append(details, FieldError{Field: name, Code: "required", Message: name + " is required"})
+					break
+				}
+				continue
+			}
+			if isEmptyValue(value) && value.Kind() == reflect.String {
+				break
+			}
+			if code, msg := checkRule(s, value, name, rule, arg); code != "" {
+				details = append(details, FieldError{Field: name, Code: code, Message: msg})
+				break
+			}
+		}
+	}
+	if len(details) == 0 {
+		return nil
+	}
+	msgs := make([]string, len(details))
+	for i, d := range details {
+		msgs[i] = d.Message
+	}
+	return &APIError{Status: http.StatusUnprocessableEntity, Code: ErrCodeValidation,
+		Message: "Invalid " + noun + ": " + strings.Join(msgs, "; "), Details: details}
+}
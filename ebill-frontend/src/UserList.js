@@ -1,5 +1,6 @@
 import React, { useState, useEffect } from 'react';
 import { useNavigate } from 'react-router-dom';
+import { listAll, SERVER_URL } from './services/api';
 
 function UserList() {
   const [users, setUsers] = useState([]);
@@ -16,44 +17,13 @@ function UserList() {
     }
 
     // Ensure your Go backend is running and accessible at http://localhost:8080
-    fetch('http://localhost:8080/users', {
-      headers: {
-        'Authorization': `Bearer ${token}`, // Send the token
-      },
-    })
-      .then(async response => { // Make async to handle potential text() or json() parsing
-        if (!response.ok) {
-          if (response.status === 401) { // Unauthorized or Invalid token
-            localStorage.removeItem('token'); // Clear invalid token
-            localStorage.removeItem('username');
-            localStorage.removeItem('permission'); // Ensure this matches your localStorage key
-            navigate('/login'); // Redirect to login
-          }
-          // For other errors, try to parse JSON, otherwise get text
-          let errorMsg = `HTTP error! status: ${response.status}`;
-          const contentType = response.headers.get("content-type");
-          if (contentType && contentType.indexOf("application/json") !== -1) {
-            try {
-              const errorData = await response.json();
-              errorMsg = errorData.error || errorMsg;
-            } catch (e) {
-              const textError = await response.text().catch(() => null);
-              errorMsg = textError || errorMsg;
-            }
-          } else {
-            const textError = await response.text().catch(() => null);
-            errorMsg = textError || errorMsg;
-          }
-          throw new Error(errorMsg);
-        }
-        return response.json();
-      })
-      .then(data => {
-        console.log("UserList.js: Data received from backend:", data);
-        setUsers(data);
+    listAll(`${SERVER_URL}/users`)
+      .then(rows => {
+        setUsers(rows);
         setLoading(false);
       })
       .catch(error => {
+        // A 401 has already sent the user back to the login page.
         console.error("Error fetching users:", error);
         setError(error.message);
         setLoading(false);
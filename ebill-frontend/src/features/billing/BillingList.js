@@ -31,7 +31,7 @@ function BillingList() {
   const handleFormSubmit = async (billData) => {
     try {
       if (editingBill) {
-        await billingService.update(editingBill.Bill_ID, billData);
+        await billingService.update(editingBill.Bill_ID, billData, editingBill.Version);
       } else {
         await billingService.create(billData);
       }
@@ -52,10 +52,10 @@ function BillingList() {
     setIsFormOpen(true);
   };
 
-  const handleDelete = async (billId) => {
+  const handleDelete = async (billId, version) => {
     if (window.confirm('Are you sure you want to delete this bill?')) {
       try {
-        await billingService.delete(billId);
+        await billingService.delete(billId, version);
         fetchBills();
       } catch (err) {
         setError(err.message || 'Failed to delete bill.');
@@ -63,6 +63,26 @@ function BillingList() {
     }
   };
 
+  const handleIssue = async (billId) => {
+    try {
+      await billingService.issue(billId);
+      fetchBills();
+    } catch (err) {
+      setError(err.message || 'Failed to issue bill.');
+    }
+  };
+
+  const handleVoid = async (billId) => {
+    const reason = window.prompt('Reason for voiding this bill:');
+    if (!reason) return;
+    try {
+      await billingService.void(billId, reason);
+      fetchBills();
+    } catch (err) {
+      setError(err.message || 'Failed to void bill.');
+    }
+  };
+
   if (loading) return <p>Loading bills...</p>;
   if (error) return <p style={{ color: 'red' }}>Error: {error}</p>;
 
@@ -94,6 +114,7 @@ function BillingList() {
               <th>Rate</th>
               <th>Due Date</th>
               <th>Amount Due</th>
+              <th>Status</th>
               <th>Paid</th>
               <th>Actions</th>
             </tr>
@@ -108,11 +129,20 @@ function BillingList() {
                 <td>{bill.Total_Unit}</td>
                 <td>x {bill.Rate_Applied}</td>
                 <td>{bill.Due_Date ? new Date(bill.Due_Date).toLocaleDateString() : 'N/A'}</td>
-                <td>{bill.Amount_Due} THB</td>
+                <td>{bill.Amount_Due} {bill.Currency || 'THB'}</td>
+                <td>{bill.Bill_Status}</td>
                 <td>{bill.Paid_Status ? '✅' : '❌'}</td>
                 <td className="actions-cell">
-                  <button onClick={() => handleEdit(bill)} className="btn btn-warning btn-sm">Edit</button>
-                  <button onClick={() => handleDelete(bill.Bill_ID)} className="btn btn-danger btn-sm">Delete</button>
+                  {bill.Bill_Status === 'draft' && (
+                    <>
+                      <button onClick={() => handleEdit(bill)} className="btn btn-warning btn-sm">Edit</button>
+                      <button onClick={() => handleIssue(bill.Bill_ID)} className="btn btn-primary btn-sm">Issue</button>
+                      <button onClick={() => handleDelete(bill.Bill_ID, bill.Version)} className="btn btn-danger btn-sm">Delete</button>
+                    </>
+                  )}
+                  {bill.Bill_Status === 'issued' && (
+                    <button onClick={() => handleVoid(bill.Bill_ID)} className="btn btn-danger btn-sm">Void</button>
+                  )}
                 </td>
               </tr>
             ))}
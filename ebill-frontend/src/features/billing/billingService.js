@@ -1,8 +1,13 @@
-import apiRequest from '../../services/api';
+import apiRequest, { ifMatch, listAll } from '../../services/api';
 
 const billingService = {
-  getAll: async () => {
-    return apiRequest('/billing');
+  getAll: async (params = {}) => {
+    return listAll('/billing', params);
+  },
+
+  // One page as { data, meta }; params may hold filters, sort, limit, offset or cursor.
+  list: async (params = {}) => {
+    return apiRequest(`/billing?${new URLSearchParams(params)}`);
   },
 
   getById: async (id) => {
@@ -16,15 +21,27 @@ const billingService = {
     });
   },
 
-  update: async (id, billingData) => {
+  update: async (id, billingData, version) => {
     return apiRequest(`/billing/${id}`, {
       method: 'PUT',
+      headers: ifMatch(version),
       body: JSON.stringify(billingData),
     });
   },
 
-  delete: async (id) => {
-    return apiRequest(`/billing/${id}`, { method: 'DELETE' });
+  delete: async (id, version) => {
+    return apiRequest(`/billing/${id}`, { method: 'DELETE', headers: ifMatch(version) });
+  },
+
+  issue: async (id) => {
+    return apiRequest(`/billing/${id}/issue`, { method: 'POST' });
+  },
+
+  void: async (id, reason) => {
+    return apiRequest(`/billing/${id}/void`, {
+      method: 'POST',
+      body: JSON.stringify({ reason }),
+    });
   },
 };
 
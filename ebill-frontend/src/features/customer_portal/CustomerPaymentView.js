@@ -23,6 +23,7 @@ function CustomerPaymentView() {
   const [error, setError] = useState('');
   const [checkoutError, setCheckoutError] = useState('');
   const [message, setMessage] = useState(''); // For success or info messages
+  const [promptPayQR, setPromptPayQR] = useState(null); // QR of the bill being paid by PromptPay
   // const navigate = useNavigate(); // We will display messages on this page
 
   const handleIdentifierChange = (event) => {
@@ -85,6 +86,20 @@ function CustomerPaymentView() {
     }
   };
 
+  const handleShowPromptPay = async (bill) => {
+    setCheckoutError('');
+    if (promptPayQR && promptPayQR.Bill_ID === bill.Bill_ID) {
+      setPromptPayQR(null);
+      return;
+    }
+    try {
+      setPromptPayQR(await customerPortalService.getPromptPayQR(bill.Bill_ID, identifier));
+    } catch (err) {
+      setPromptPayQR(null);
+      setCheckoutError(err.message || `PromptPay is not available for Bill ID: ${bill.Bill_ID}.`);
+    }
+  };
+
   return (
     <Container component="main" maxWidth="md" sx={{ mt: 4 }}>
       <Paper elevation={3} sx={{ padding: 3 }}>
@@ -136,7 +151,7 @@ function CustomerPaymentView() {
               <React.Fragment key={bill.Bill_ID}>
                 <ListItem alignItems="flex-start">
                   <ListItemText
-                    primary={`Bill ID: ${bill.Bill_ID} - Amount Due: ${bill.Amount_Due} THB`}
+                    primary={`Bill ID: ${bill.Bill_ID} - Amount Due: ${bill.Amount_Due} ${bill.Currency || 'THB'}`}
                     secondary={
                       <>
                         <Typography component="span" variant="body2" color="text.primary">
@@ -158,7 +173,23 @@ function CustomerPaymentView() {
                   >
                     Checkout
                   </Button>
+                  <Button
+                    variant="outlined"
+                    onClick={() => handleShowPromptPay(bill)}
+                    sx={{ ml: 1 }}
+                    disabled={loading}
+                  >
+                    PromptPay
+                  </Button>
                 </ListItem>
+                {promptPayQR && promptPayQR.Bill_ID === bill.Bill_ID && (
+                  <Box sx={{ textAlign: 'center', pb: 2 }}>
+                    <img src={promptPayQR.Image} alt={`PromptPay QR for bill ${bill.Bill_ID}`} width={240} height={240} />
+                    <Typography variant="body2">
+                      Reference: {promptPayQR.Reference} - Amount: {promptPayQR.Amount} {promptPayQR.Currency}
+                    </Typography>
+                  </Box>
+                )}
                 {index < bills.length - 1 && <Divider variant="inset" component="li" />}
               </React.Fragment>
             ))}
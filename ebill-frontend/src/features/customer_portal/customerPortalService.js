@@ -14,6 +14,10 @@ const customerPortalService = {
       // No need for withoutApiPrefix here if apiRequest handles /public correctly
       // or ensure api.js is flexible. For now, assuming /public is handled.
     }, { withoutApiPrefix: true }); // Ensure this path doesn't get /api prepended
+  },
+  getPromptPayQR: async (billId, identifier) => {
+    // Returns the PromptPay payload, reference, amount and a PNG data URL for the bill.
+    return apiRequest(`/public/bills/${billId}/qr?identifier=${encodeURIComponent(identifier)}`);
   }
 };
 
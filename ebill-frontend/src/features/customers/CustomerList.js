@@ -32,7 +32,7 @@ function CustomerList() {
     try {
       if (editingCustomer) {
         // Update: Customer_ID is part of customerData from the form if editing
-        await customerService.update(editingCustomer.Customer_ID, customerData);
+        await customerService.update(editingCustomer.Customer_ID, customerData, editingCustomer.Version);
       } else {
         await customerService.create(customerData);
       }
@@ -56,10 +56,10 @@ function CustomerList() {
     setIsFormOpen(true);
   };
 
-  const handleDelete = async (customerId) => {
+  const handleDelete = async (customerId, version) => {
     if (window.confirm('Are you sure you want to delete this customer?')) {
       try {
-        await customerService.delete(customerId);
+        await customerService.delete(customerId, version);
         fetchCustomers(); // Refresh list
       } catch (err) {
         setError(err.message || 'Failed to delete customer.');
@@ -113,7 +113,7 @@ function CustomerList() {
                 <td>{customer.Registration_Date ? new Date(customer.Registration_Date).toLocaleDateString() : 'N/A'}</td>
                 <td>
                   <button onClick={() => handleEdit(customer)} className="btn btn-warning btn-sm">Edit</button>
-                  <button onClick={() => handleDelete(customer.Customer_ID)} className="btn btn-danger btn-sm">Delete</button>
+                  <button onClick={() => handleDelete(customer.Customer_ID, customer.Version)} className="btn btn-danger btn-sm">Delete</button>
                 </td>
               </tr>
             ))}
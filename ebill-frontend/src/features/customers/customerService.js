@@ -1,8 +1,13 @@
-import apiRequest from '../../services/api';
+import apiRequest, { ifMatch, listAll } from '../../services/api';
 
 const customerService = {
-  getAll: async () => {
-    return apiRequest('/customers');
+  getAll: async (params = {}) => {
+    return listAll('/customers', params);
+  },
+
+  // One page as { data, meta }; params may hold filters, sort, limit, offset or cursor.
+  list: async (params = {}) => {
+    return apiRequest(`/customers?${new URLSearchParams(params)}`);
   },
 
   getById: async (id) => {
@@ -16,15 +21,16 @@ const customerService = {
     });
   },
 
-  update: async (id, customerData) => {
+  update: async (id, customerData, version) => {
     return apiRequest(`/customers/${id}`, {
       method: 'PUT',
+      headers: ifMatch(version),
       body: JSON.stringify(customerData),
     });
   },
 
-  delete: async (id) => {
-    return apiRequest(`/customers/${id}`, { method: 'DELETE' });
+  delete: async (id, version) => {
+    return apiRequest(`/customers/${id}`, { method: 'DELETE', headers: ifMatch(version) });
   },
 };
 
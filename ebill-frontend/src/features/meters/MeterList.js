@@ -31,7 +31,7 @@ function MeterList() {
   const handleFormSubmit = async (meterData) => {
     try {
       if (editingMeter) {
-        await meterService.update(editingMeter.Meter_ID, meterData);
+        await meterService.update(editingMeter.Meter_ID, meterData, editingMeter.Version);
       } else {
         await meterService.create(meterData);
       }
@@ -52,10 +52,10 @@ function MeterList() {
     setIsFormOpen(true);
   };
 
-  const handleDelete = async (meterId) => {
+  const handleDelete = async (meterId, version) => {
     if (window.confirm('Are you sure you want to delete this meter?')) {
       try {
-        await meterService.delete(meterId);
+        await meterService.delete(meterId, version);
         fetchMeters();
       } catch (err) {
         setError(err.message || 'Failed to delete meter.');
@@ -107,7 +107,7 @@ function MeterList() {
                 <td>{meter.Active_Status ? '✅' : '❌'}</td>
                 <td>
                   <button onClick={() => handleEdit(meter)} className="btn btn-warning btn-sm">Edit</button>
-                  <button onClick={() => handleDelete(meter.Meter_ID)} className="btn btn-danger btn-sm">Delete</button>
+                  <button onClick={() => handleDelete(meter.Meter_ID, meter.Version)} className="btn btn-danger btn-sm">Delete</button>
                 </td>
               </tr>
             ))}
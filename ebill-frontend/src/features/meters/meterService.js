@@ -1,8 +1,13 @@
-import apiRequest from '../../services/api';
+import apiRequest, { ifMatch, listAll } from '../../services/api';
 
 const meterService = {
-  getAll: async () => {
-    return apiRequest('/meters');
+  getAll: async (params = {}) => {
+    return listAll('/meters', params);
+  },
+
+  // One page as { data, meta }; params may hold filters, sort, limit, offset or cursor.
+  list: async (params = {}) => {
+    return apiRequest(`/meters?${new URLSearchParams(params)}`);
   },
 
   getById: async (id) => {
@@ -16,15 +21,16 @@ const meterService = {
     });
   },
 
-  update: async (id, meterData) => {
+  update: async (id, meterData, version) => {
     return apiRequest(`/meters/${id}`, {
       method: 'PUT',
+      headers: ifMatch(version),
       body: JSON.stringify(meterData),
     });
   },
 
-  delete: async (id) => {
-    return apiRequest(`/meters/${id}`, { method: 'DELETE' });
+  delete: async (id, version) => {
+    return apiRequest(`/meters/${id}`, { method: 'DELETE', headers: ifMatch(version) });
   },
 };
 
@@ -31,7 +31,7 @@ function PaymentList() {
   const handleFormSubmit = async (paymentData) => {
     try {
       if (editingPayment) {
-        await paymentService.update(editingPayment.Payment_ID, paymentData);
+        await paymentService.update(editingPayment.Payment_ID, paymentData, editingPayment.Version);
       } else {
         await paymentService.create(paymentData);
       }
@@ -52,10 +52,10 @@ function PaymentList() {
     setIsFormOpen(true);
   };
 
-  const handleDelete = async (paymentId) => {
+  const handleDelete = async (paymentId, version) => {
     if (window.confirm('Are you sure you want to delete this payment record?')) {
       try {
-        await paymentService.delete(paymentId);
+        await paymentService.delete(paymentId, version);
         fetchPayments();
       } catch (err) {
         setError(err.message || 'Failed to delete payment.');
@@ -106,7 +106,7 @@ function PaymentList() {
                 <td>{payment.Payment_Status == 'Completed' ? '✅' : payment.Payment_Status}</td>
                 <td className="actions-cell">
                   <button onClick={() => handleEdit(payment)} className="btn btn-warning btn-sm">Edit</button>
-                  <button onClick={() => handleDelete(payment.Payment_ID)} className="btn btn-danger btn-sm">Delete</button>
+                  <button onClick={() => handleDelete(payment.Payment_ID, payment.Version)} className="btn btn-danger btn-sm">Delete</button>
                 </td>
               </tr>
             ))}
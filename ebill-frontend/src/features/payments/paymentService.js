@@ -1,8 +1,13 @@
-import apiRequest from '../../services/api';
+import apiRequest, { ifMatch, listAll } from '../../services/api';
 
 const paymentService = {
-  getAll: async () => {
-    return apiRequest('/payments');
+  getAll: async (params = {}) => {
+    return listAll('/payments', params);
+  },
+
+  // One page as { data, meta }; params may hold filters, sort, limit, offset or cursor.
+  list: async (params = {}) => {
+    return apiRequest(`/payments?${new URLSearchParams(params)}`);
   },
 
   getById: async (id) => {
@@ -16,15 +21,16 @@ const paymentService = {
     });
   },
 
-  update: async (id, paymentData) => {
+  update: async (id, paymentData, version) => {
     return apiRequest(`/payments/${id}`, {
       method: 'PUT',
+      headers: ifMatch(version),
       body: JSON.stringify(paymentData),
     });
   },
 
-  delete: async (id) => {
-    return apiRequest(`/payments/${id}`, { method: 'DELETE' });
+  delete: async (id, version) => {
+    return apiRequest(`/payments/${id}`, { method: 'DELETE', headers: ifMatch(version) });
   },
 };
 
@@ -1,4 +1,8 @@
-const BASE_URL = 'http://localhost:8080/api'; // Adjust if your Go backend API prefix is different
+export const SERVER_URL = 'http://localhost:8080';
+const BASE_URL = `${SERVER_URL}/api`; // Adjust if your Go backend API prefix is different
+
+// Endpoints are relative to BASE_URL unless they are full URLs, such as the
+// /users list that the backend serves outside /api.
 
 async function request(endpoint, options = {}) {
   const token = localStorage.getItem('token');
@@ -17,7 +21,8 @@ async function request(endpoint, options = {}) {
   };
 
   try {
-    const response = await fetch(`${BASE_URL}${endpoint}`, config);
+    const url = endpoint.startsWith('http') ? endpoint : `${BASE_URL}${endpoint}`;
+    const response = await fetch(url, config);
 
     if (response.status === 204) { // No Content, typically for DELETE
       return null;
@@ -57,7 +62,14 @@ async function request(endpoint, options = {}) {
         // Consider a more robust way to trigger navigation if not in a component
         window.location.href = '/login';
       }
-      throw new Error(data.error || `HTTP error! status: ${response.status}`);
+      // Error bodies are { error, code, details, request_id }; code and the
+      // field-level details are stable and meant for branching and forms.
+      const apiError = new Error(data.error || `HTTP error! status: ${response.status}`);
+      apiError.status = response.status;
+      apiError.code = data.code;
+      apiError.details = data.details || [];
+      apiError.requestId = data.request_id;
+      throw apiError;
     }
     return data;
   } catch (error) {
@@ -66,4 +78,25 @@ async function request(endpoint, options = {}) {
   }
 }
 
+// List endpoints respond with { data, meta }. listAll follows meta.next_cursor
+// until every page matching params has been fetched and returns the rows.
+export async function listAll(endpoint, params = {}) {
+  const rows = [];
+  let cursor;
+  do {
+    const query = new URLSearchParams({ ...params, limit: 500, ...(cursor ? { cursor } : {}) });
+    const page = await request(`${endpoint}?${query}`);
+    rows.push(...(page.data || []));
+    cursor = page.meta && page.meta.next_cursor;
+  } while (cursor);
+  return rows;
+}
+
+// Conditional requests: PUT, PATCH and DELETE of customers, meters, bills and
+// payments must carry the Version the client last saw, or the server answers
+// 412 when someone else has changed the record in the meantime.
+export function ifMatch(version) {
+  return { 'If-Match': `"${version}"` };
+}
+
 export default request;
\ No newline at end of file
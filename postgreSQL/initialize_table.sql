@@ -1,9 +1,27 @@
+DROP TABLE IF EXISTS audit_log CASCADE;
+DROP TABLE IF EXISTS customer_history CASCADE;
+DROP TABLE IF EXISTS meter_history CASCADE;
+DROP TABLE IF EXISTS billing_history CASCADE;
 DROP TABLE IF EXISTS users CASCADE;
+DROP TABLE IF EXISTS cash_shift CASCADE;
+DROP TABLE IF EXISTS import_job_row CASCADE;
+DROP TABLE IF EXISTS import_job CASCADE;
+DROP TABLE IF EXISTS bill_line_item CASCADE;
+DROP TABLE IF EXISTS bill_status_history CASCADE;
+DROP TABLE IF EXISTS billing_note_line_item CASCADE;
+DROP TABLE IF EXISTS billing_note CASCADE;
+DROP TABLE IF EXISTS document_sequence CASCADE;
+DROP TABLE IF EXISTS customer_statement CASCADE;
+DROP TABLE IF EXISTS inbound_transfer CASCADE;
+DROP TABLE IF EXISTS bank_statement_import CASCADE;
 DROP TABLE IF EXISTS Payment CASCADE;
 DROP TABLE IF EXISTS Billing CASCADE;
 DROP TABLE IF EXISTS Meter CASCADE;
 DROP TABLE IF EXISTS Customer CASCADE;
 
+-- Trigram matching for /api/search
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
 --Create users table with constraints
 CREATE TABLE users (
   id SERIAL PRIMARY KEY,
@@ -20,7 +38,8 @@ CREATE TABLE Customer (
     Address VARCHAR (255) NOT NULL,
     Email VARCHAR (100) NOT NULL UNIQUE CHECK (email ~* '^[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}$'),
     Phone_Number VARCHAR(15) NOT NULL UNIQUE CHECK (Phone_Number ~ '^\d{3}-\d{3}-\d{4}$'),
-    Registration_Date DATE NOT NULL DEFAULT CURRENT_DATE
+    Registration_Date DATE NOT NULL DEFAULT CURRENT_DATE,
+    Version INTEGER NOT NULL DEFAULT 1 -- Bumped on every update; sent as the ETag
 );
 
 --Create Meter table with constraints
@@ -31,6 +50,7 @@ CREATE TABLE Meter(
     Meter_Type VARCHAR (50) NOT NULL DEFAULT 'Standard' CHECK (Meter_Type IN ('Standard', 'Smart', 'Digital', 'Prepayment')),
     Installation_Date Date NOT NULL,
     Active_Status BOOLEAN NOT NULL DEFAULT TRUE,
+    Version INTEGER NOT NULL DEFAULT 1, -- Bumped on every update; sent as the ETag
     FOREIGN KEY (Customer_ID) REFERENCES Customer(Customer_ID) ON DELETE CASCADE
 );
 
@@ -46,10 +66,20 @@ CREATE TABLE Billing(
     Total_Unit DECIMAL(10, 2) GENERATED ALWAYS AS (Current_Reading - Previous_Reading) STORED,
     Rate_Applied DECIMAL(10, 4) NOT NULL CHECK (Rate_Applied > 0),
     Amount_Due DECIMAL(10, 2), -- Will be calculated by trigger, so NOT NULL can be enforced by trigger or kept if default is set
+    Currency CHAR(3) NOT NULL DEFAULT 'THB' CHECK (Currency ~ '^[A-Z]{3}$'), -- ISO 4217 code for Amount_Due
     Paid_Status BOOLEAN NOT NULL DEFAULT FALSE,
+    Bill_Status VARCHAR(20) NOT NULL DEFAULT 'draft' CHECK (Bill_Status IN ('draft', 'issued', 'partially_paid', 'paid', 'void', 'written_off')),
+    Replaces_Bill_ID INTEGER, -- Set on a reissued bill, pointing at the voided original
+    Invoice_Number VARCHAR(30) UNIQUE, -- Legal, gap-free invoice number; assigned on issue, not on draft creation
+    Issued_Date DATE,
+    -- The invoice number as carried in PromptPay QR codes (letters and digits only); incoming transfers are matched on it
+    Payment_Reference VARCHAR(30) GENERATED ALWAYS AS (UPPER(regexp_replace(Invoice_Number, '[^A-Za-z0-9]', '', 'g'))) STORED,
+    Version INTEGER NOT NULL DEFAULT 1, -- Bumped when a stored (not derived) column changes; sent as the ETag
     FOREIGN KEY (Customer_ID) REFERENCES Customer(Customer_ID),
     FOREIGN KEY (Meter_ID) REFERENCES Meter(Meter_ID),
-    CONSTRAINT valid_due_date CHECK (Due_Date >= Billing_Date)
+    FOREIGN KEY (Replaces_Bill_ID) REFERENCES Billing(Bill_ID),
+    CONSTRAINT valid_due_date CHECK (Due_Date >= Billing_Date),
+    CONSTRAINT issued_bill_has_invoice_number CHECK (Bill_Status IN ('draft', 'void') OR Invoice_Number IS NOT NULL)
 );
 
 CREATE TABLE Payment (
@@ -58,12 +88,247 @@ CREATE TABLE Payment (
     Processed_By INTEGER,
     Payment_Date DATE NOT NULL,
     Amount_Paid DECIMAL(10, 2) NOT NULL CHECK (Amount_Paid > 0),
-    Payment_Method VARCHAR(50) NOT NULL CHECK (Payment_Method IN ('Credit Card', 'Bank Transfer', 'Cash', 'Check', 'Online Portal')),
+    Currency CHAR(3) NOT NULL DEFAULT 'THB' CHECK (Currency ~ '^[A-Z]{3}$'), -- ISO 4217 code for Amount_Paid
+    Payment_Method VARCHAR(50) NOT NULL CHECK (Payment_Method IN ('Credit Card', 'Bank Transfer', 'Cash', 'Check', 'Online Portal', 'PromptPay')),
     Payment_Status VARCHAR(20) NOT NULL DEFAULT 'Completed' CHECK (Payment_Status IN ('Pending', 'Completed', 'Failed', 'Refunded')),
+    Version INTEGER NOT NULL DEFAULT 1, -- Bumped on every update; sent as the ETag
+    Recorded_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, -- When the payment was entered; cash shifts are reconciled by it
+    Refunded_At TIMESTAMP, -- When Payment_Status became 'Refunded'; set by trg_payment_stamp_refund
     FOREIGN KEY (Bill_ID) REFERENCES Billing(Bill_ID) ON DELETE CASCADE,
     FOREIGN KEY (Processed_By) REFERENCES users(id) ON DELETE SET NULL  -- Reference to the user who processed the payment
 );
 
+--Create bill_line_item table: the components that make up a bill's Amount_Due
+CREATE TABLE bill_line_item (
+    Line_Item_ID SERIAL PRIMARY KEY,
+    Bill_ID INTEGER NOT NULL,
+    Item_Type VARCHAR(20) NOT NULL CHECK (Item_Type IN ('energy', 'service', 'ft', 'vat')),
+    Description VARCHAR(100) NOT NULL,
+    Quantity DECIMAL(12, 4) NOT NULL, -- units for energy/ft, 1 for service, taxable base for vat
+    Unit_Price DECIMAL(10, 4) NOT NULL, -- rate per unit, flat charge, or VAT rate
+    Amount DECIMAL(10, 2) NOT NULL,
+    FOREIGN KEY (Bill_ID) REFERENCES Billing(Bill_ID) ON DELETE CASCADE
+);
+
+--Create bill_status_history table: every lifecycle transition of a bill
+CREATE TABLE bill_status_history (
+    History_ID SERIAL PRIMARY KEY,
+    Bill_ID INTEGER NOT NULL,
+    From_Status VARCHAR(20) NOT NULL,
+    To_Status VARCHAR(20) NOT NULL,
+    Reason VARCHAR(255) NOT NULL DEFAULT '',
+    Changed_By INTEGER, -- NULL when changed by a payment trigger or public portal
+    Changed_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (Bill_ID) REFERENCES Billing(Bill_ID),
+    FOREIGN KEY (Changed_By) REFERENCES users(id) ON DELETE SET NULL
+);
+
+--Create document_sequence table: gap-free counters for numbered documents (invoices, credit/debit notes)
+-- One row per series and period (e.g. 'INV', '202404'); Period is '' for series that never restart.
+-- The row is locked by the allocating transaction, so a rollback also rolls back the increment.
+CREATE TABLE document_sequence (
+    Series VARCHAR(20) NOT NULL,
+    Period VARCHAR(6) NOT NULL DEFAULT '',
+    Last_Value INTEGER NOT NULL CHECK (Last_Value >= 0),
+    PRIMARY KEY (Series, Period)
+);
+
+--Create billing_note table: credit and debit notes correcting an issued bill
+CREATE TABLE billing_note (
+    Note_ID SERIAL PRIMARY KEY,
+    Note_Number VARCHAR(30) NOT NULL UNIQUE,
+    Note_Type VARCHAR(10) NOT NULL CHECK (Note_Type IN ('credit', 'debit')),
+    Bill_ID INTEGER NOT NULL,
+    Customer_ID INTEGER NOT NULL,
+    Note_Date DATE NOT NULL DEFAULT CURRENT_DATE,
+    Reason VARCHAR(255) NOT NULL CHECK (Reason <> ''),
+    Amount DECIMAL(10, 2) NOT NULL CHECK (Amount > 0), -- Sum of the note's line items
+    Currency CHAR(3) NOT NULL DEFAULT 'THB' CHECK (Currency ~ '^[A-Z]{3}$'),
+    Created_By INTEGER,
+    Created_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (Bill_ID) REFERENCES Billing(Bill_ID),
+    FOREIGN KEY (Customer_ID) REFERENCES Customer(Customer_ID),
+    FOREIGN KEY (Created_By) REFERENCES users(id) ON DELETE SET NULL
+);
+
+--Create billing_note_line_item table
+CREATE TABLE billing_note_line_item (
+    Note_Line_ID SERIAL PRIMARY KEY,
+    Note_ID INTEGER NOT NULL,
+    Description VARCHAR(100) NOT NULL,
+    Quantity DECIMAL(12, 4) NOT NULL,
+    Unit_Price DECIMAL(10, 4) NOT NULL,
+    Amount DECIMAL(10, 2) NOT NULL,
+    FOREIGN KEY (Note_ID) REFERENCES billing_note(Note_ID) ON DELETE CASCADE
+);
+
+--Create bank_statement_import table: one row per imported bank statement file
+CREATE TABLE bank_statement_import (
+    Import_ID SERIAL PRIMARY KEY,
+    File_Name VARCHAR(255) NOT NULL,
+    Format VARCHAR(10) NOT NULL CHECK (Format IN ('csv', 'fixed')),
+    Imported_By INTEGER,
+    Imported_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    Total_Lines INTEGER NOT NULL DEFAULT 0,
+    Skipped_Lines INTEGER NOT NULL DEFAULT 0, -- Withdrawals and reversals
+    Duplicate_Lines INTEGER NOT NULL DEFAULT 0, -- Already imported from an earlier statement
+    FOREIGN KEY (Imported_By) REFERENCES users(id) ON DELETE SET NULL
+);
+
+--Create inbound_transfer table: money received (PromptPay notifications, bank statement lines), matched to bills
+CREATE TABLE inbound_transfer (
+    Transfer_ID SERIAL PRIMARY KEY,
+    Source VARCHAR(20) NOT NULL CHECK (Source IN ('promptpay', 'bank_statement')),
+    Import_ID INTEGER, -- Set for bank statement lines
+    Transaction_Ref VARCHAR(64) NOT NULL, -- The bank's transaction ID; repeated notifications are ignored
+    Payment_Reference VARCHAR(30) NOT NULL,
+    Description VARCHAR(255),
+    Amount DECIMAL(10, 2) NOT NULL CHECK (Amount > 0),
+    Currency CHAR(3) NOT NULL DEFAULT 'THB' CHECK (Currency ~ '^[A-Z]{3}$'),
+    Transferred_At TIMESTAMP NOT NULL,
+    Payer_Name VARCHAR(100),
+    Match_Status VARCHAR(20) NOT NULL CHECK (Match_Status IN ('matched', 'unmatched', 'ambiguous', 'amount_mismatch', 'not_payable', 'ignored')),
+    Bill_ID INTEGER,
+    Candidate_Bill_IDs INTEGER[], -- Bills an operator should choose from
+    Payment_ID INTEGER, -- The payment recorded for a matched transfer
+    Received_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    Reviewed_By INTEGER,
+    Reviewed_At TIMESTAMP,
+    Review_Note TEXT,
+    UNIQUE (Source, Transaction_Ref),
+    FOREIGN KEY (Import_ID) REFERENCES bank_statement_import(Import_ID) ON DELETE CASCADE,
+    FOREIGN KEY (Bill_ID) REFERENCES Billing(Bill_ID) ON DELETE SET NULL,
+    FOREIGN KEY (Payment_ID) REFERENCES Payment(Payment_ID) ON DELETE SET NULL,
+    FOREIGN KEY (Reviewed_By) REFERENCES users(id) ON DELETE SET NULL
+);
+
+--Create customer_statement table: month-end statement snapshots, one per customer, period and currency
+CREATE TABLE customer_statement (
+    Statement_ID SERIAL PRIMARY KEY,
+    Customer_ID INTEGER NOT NULL,
+    Period_Start DATE NOT NULL,
+    Period_End DATE NOT NULL CHECK (Period_End >= Period_Start),
+    Currency CHAR(3) NOT NULL DEFAULT 'THB' CHECK (Currency ~ '^[A-Z]{3}$'),
+    Opening_Balance DECIMAL(12, 2) NOT NULL,
+    Total_Bills DECIMAL(12, 2) NOT NULL DEFAULT 0, -- Net of voided bills
+    Total_Payments DECIMAL(12, 2) NOT NULL DEFAULT 0,
+    Total_Adjustments DECIMAL(12, 2) NOT NULL DEFAULT 0, -- Credit notes
+    Total_Fees DECIMAL(12, 2) NOT NULL DEFAULT 0, -- Debit notes
+    Closing_Balance DECIMAL(12, 2) NOT NULL,
+    Entry_Count INTEGER NOT NULL DEFAULT 0,
+    Generated_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (Customer_ID, Period_Start, Period_End, Currency),
+    FOREIGN KEY (Customer_ID) REFERENCES Customer(Customer_ID) ON DELETE CASCADE
+);
+
+--Create import_job table: one row per bulk import of customers, meters or meter readings
+CREATE TABLE import_job (
+    Job_ID SERIAL PRIMARY KEY,
+    Kind VARCHAR(20) NOT NULL CHECK (Kind IN ('customers', 'meters', 'readings')),
+    File_Name VARCHAR(255) NOT NULL,
+    Format VARCHAR(10) NOT NULL CHECK (Format IN ('csv', 'xlsx')),
+    File_Data BYTEA NOT NULL, -- Kept so that an interrupted job can be resumed
+    Dry_Run BOOLEAN NOT NULL DEFAULT FALSE, -- Rows are validated and their outcome recorded, but nothing is written
+    Status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (Status IN ('pending', 'running', 'completed', 'failed', 'interrupted')),
+    Total_Rows INTEGER NOT NULL DEFAULT 0,
+    Processed_Rows INTEGER NOT NULL DEFAULT 0, -- Rows with a recorded outcome; a resumed job continues after them
+    Created_Rows INTEGER NOT NULL DEFAULT 0,
+    Updated_Rows INTEGER NOT NULL DEFAULT 0,
+    Unchanged_Rows INTEGER NOT NULL DEFAULT 0,
+    Failed_Rows INTEGER NOT NULL DEFAULT 0,
+    Error TEXT, -- Why the job stopped, when it failed
+    Created_By INTEGER,
+    Created_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    Started_At TIMESTAMP,
+    Finished_At TIMESTAMP,
+    FOREIGN KEY (Created_By) REFERENCES users(id) ON DELETE SET NULL
+);
+
+--Create import_job_row table: the outcome of every row of an import
+CREATE TABLE import_job_row (
+    Job_ID INTEGER NOT NULL,
+    Row_Number INTEGER NOT NULL, -- Row (XLSX) or line (CSV) in the file; the header is row 1
+    Action VARCHAR(10) NOT NULL CHECK (Action IN ('created', 'updated', 'unchanged', 'failed')),
+    Entity_ID INTEGER, -- Customer_ID, Meter_ID or Bill_ID written; NULL for failed rows and rows a dry run would create
+    Errors JSONB, -- Field errors of a failed row
+    PRIMARY KEY (Job_ID, Row_Number),
+    FOREIGN KEY (Job_ID) REFERENCES import_job(Job_ID) ON DELETE CASCADE
+);
+
+--Create cash_shift table: a staff user's cash drawer from opening to close
+-- Expected_Cash is the opening float plus completed cash payments the operator recorded during the shift;
+-- Discrepancy is Declared_Cash minus Expected_Cash, and any non-zero discrepancy is flagged for a supervisor.
+CREATE TABLE cash_shift (
+    Shift_ID SERIAL PRIMARY KEY,
+    Operator_ID INTEGER NOT NULL,
+    Currency CHAR(3) NOT NULL DEFAULT 'THB' CHECK (Currency ~ '^[A-Z]{3}$'),
+    Opening_Float DECIMAL(10, 2) NOT NULL DEFAULT 0 CHECK (Opening_Float >= 0),
+    Status VARCHAR(10) NOT NULL DEFAULT 'open' CHECK (Status IN ('open', 'closed', 'reviewed')),
+    Opened_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    Closed_At TIMESTAMP,
+    Declared_Cash DECIMAL(10, 2) CHECK (Declared_Cash >= 0),
+    Recorded_Cash DECIMAL(10, 2),
+    Expected_Cash DECIMAL(10, 2),
+    Discrepancy DECIMAL(10, 2),
+    Flagged BOOLEAN NOT NULL DEFAULT FALSE,
+    Close_Note VARCHAR(255) NOT NULL DEFAULT '',
+    Reviewed_By INTEGER,
+    Reviewed_At TIMESTAMP,
+    Review_Note VARCHAR(255) NOT NULL DEFAULT '',
+    FOREIGN KEY (Operator_ID) REFERENCES users(id),
+    FOREIGN KEY (Reviewed_By) REFERENCES users(id) ON DELETE SET NULL,
+    CONSTRAINT closed_shift_is_counted CHECK (Status = 'open' OR (Closed_At IS NOT NULL AND Declared_Cash IS NOT NULL))
+);
+
+--Create audit_log table: every insert, update and delete of customers, meters, bills, payments and users
+-- Rows are written by the audit_row_change trigger and never changed afterwards. Actor, Client_IP and
+-- Request_ID come from the ebill.* settings the API sets in the transaction making the change; they are
+-- NULL for changes made outside a request, such as seed data or psql. Actor_Username is kept as it was
+-- at the time, so the entry still reads correctly after the user is renamed or deleted.
+CREATE TABLE audit_log (
+    Audit_ID BIGSERIAL PRIMARY KEY,
+    Changed_At TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    Actor_ID INTEGER, -- users.id; not a foreign key so that deleting a user keeps their trail
+    Actor_Username VARCHAR(50),
+    Action VARCHAR(10) NOT NULL CHECK (Action IN ('create', 'update', 'delete')),
+    Entity_Type VARCHAR(20) NOT NULL CHECK (Entity_Type IN ('customer', 'meter', 'bill', 'payment', 'user')),
+    Entity_ID INTEGER NOT NULL,
+    Before_Data JSONB, -- The row before the change; NULL for creates
+    After_Data JSONB, -- The row after the change; NULL for deletes
+    Client_IP INET,
+    Request_ID VARCHAR(64),
+    Transaction_ID BIGINT NOT NULL DEFAULT txid_current() -- Groups a transaction's changes to one row into one entry
+);
+
+--Create history tables: every version of each customer, meter and bill, for point-in-time queries
+-- A version holds the row's columns as they were from Valid_From until Valid_To; the current version
+-- has no Valid_To, and a deleted row has none. Versions are written by the record_row_history trigger,
+-- one per transaction that changes the row. Changed_By is the actor recorded in audit_log.
+CREATE TABLE customer_history (
+    LIKE Customer,
+    Valid_From TIMESTAMP NOT NULL,
+    Valid_To TIMESTAMP,
+    Changed_By INTEGER,
+    History_ID BIGSERIAL PRIMARY KEY
+);
+
+CREATE TABLE meter_history (
+    LIKE Meter,
+    Valid_From TIMESTAMP NOT NULL,
+    Valid_To TIMESTAMP,
+    Changed_By INTEGER,
+    History_ID BIGSERIAL PRIMARY KEY
+);
+
+CREATE TABLE billing_history (
+    LIKE Billing,
+    Valid_From TIMESTAMP NOT NULL,
+    Valid_To TIMESTAMP,
+    Changed_By INTEGER,
+    History_ID BIGSERIAL PRIMARY KEY,
+    Line_Items JSONB NOT NULL DEFAULT '[]' -- The bill's line items in this version, as bill_line_item rows
+);
+
 -- Create indexes for performance optimization
 CREATE INDEX idx_customer_email ON Customer(Email);
 CREATE INDEX idx_customer_name ON Customer(Name);
@@ -72,15 +337,51 @@ CREATE INDEX idx_meter_number ON Meter(Meter_Number);
 CREATE INDEX idx_billing_customer ON Billing(Customer_ID);
 CREATE INDEX idx_billing_dates ON Billing(Billing_Date, Due_Date);
 CREATE INDEX idx_billing_paid ON Billing(Paid_Status);
+CREATE INDEX idx_billing_status ON Billing(Bill_Status);
+CREATE UNIQUE INDEX idx_billing_payment_reference ON Billing(Payment_Reference);
+CREATE INDEX idx_bill_status_history_bill ON bill_status_history(Bill_ID);
+CREATE INDEX idx_billing_note_bill ON billing_note(Bill_ID);
+CREATE INDEX idx_billing_note_customer ON billing_note(Customer_ID);
+CREATE INDEX idx_customer_statement_period ON customer_statement(Period_Start);
+
+-- Search indexes: full-text over name and address, trigrams for fuzzy and substring matches
+CREATE INDEX idx_customer_search_fts ON Customer USING GIN (to_tsvector('simple', Name || ' ' || Address));
+CREATE INDEX idx_customer_name_trgm ON Customer USING GIN (Name gin_trgm_ops);
+CREATE INDEX idx_customer_address_trgm ON Customer USING GIN (Address gin_trgm_ops);
+CREATE INDEX idx_customer_email_trgm ON Customer USING GIN (Email gin_trgm_ops);
+CREATE INDEX idx_meter_number_trgm ON Meter USING GIN (Meter_Number gin_trgm_ops);
+CREATE INDEX idx_billing_invoice_number_trgm ON Billing USING GIN (Invoice_Number gin_trgm_ops);
+CREATE INDEX idx_note_line_item_note ON billing_note_line_item(Note_ID);
 CREATE INDEX idx_payment_date ON Payment(Payment_Date);
 CREATE INDEX idx_payment_bill ON Payment(Bill_ID);
+CREATE INDEX idx_line_item_bill ON bill_line_item(Bill_ID);
+CREATE INDEX idx_inbound_transfer_status ON inbound_transfer(Match_Status);
+CREATE INDEX idx_inbound_transfer_import ON inbound_transfer(Import_ID);
+CREATE INDEX idx_import_job_status ON import_job(Status);
+CREATE INDEX idx_customer_email_lower ON Customer(LOWER(Email));
+CREATE INDEX idx_payment_processed_by ON Payment(Processed_By, Recorded_At);
+CREATE INDEX idx_payment_refunded_at ON Payment(Refunded_At) WHERE Refunded_At IS NOT NULL;
+CREATE UNIQUE INDEX idx_cash_shift_one_open ON cash_shift(Operator_ID) WHERE Status = 'open';
+CREATE INDEX idx_audit_log_entity ON audit_log(Entity_Type, Entity_ID);
+CREATE INDEX idx_audit_log_actor ON audit_log(Actor_ID);
+CREATE INDEX idx_audit_log_changed_at ON audit_log(Changed_At);
+CREATE INDEX idx_audit_log_transaction ON audit_log(Transaction_ID, Entity_Type, Entity_ID);
+CREATE INDEX idx_customer_history_valid ON customer_history(Customer_ID, Valid_From);
+CREATE INDEX idx_meter_history_valid ON meter_history(Meter_ID, Valid_From);
+CREATE INDEX idx_billing_history_valid ON billing_history(Bill_ID, Valid_From);
 
 -- Create trigger function to automatically calculate Amount_Due in Billing table
+-- Amount_Due is the sum of the bill's line items; bills without line items
+-- (e.g. the seed data below) fall back to the energy charge alone.
 CREATE OR REPLACE FUNCTION calculate_billing_amount_due()
 RETURNS TRIGGER AS $$
+DECLARE
+    line_total DECIMAL(10, 2);
 BEGIN
 	NEW.Total_Unit = NEW.Current_Reading - NEW.Previous_Reading;
-    NEW.Amount_Due = NEW.Total_Unit * NEW.Rate_Applied;
+    SELECT SUM(Amount) INTO line_total FROM bill_line_item WHERE Bill_ID = NEW.Bill_ID;
+    -- Round half-up to satang explicitly, matching Money rounding in the Go layer
+    NEW.Amount_Due = COALESCE(line_total, ROUND(NEW.Total_Unit * NEW.Rate_Applied, 2));
     RETURN NEW;
 END;
 $$ LANGUAGE plpgsql;
@@ -91,34 +392,228 @@ BEFORE INSERT OR UPDATE ON Billing
 FOR EACH ROW
 EXECUTE FUNCTION calculate_billing_amount_due();
 
--- Create trigger function to update Paid_Status in Billing table when a payment is made
-CREATE OR REPLACE FUNCTION update_billing_paid_status()
+-- Create trigger function that makes bills immutable once they leave draft
+-- Only Paid_Status, Bill_Status and the derived Amount_Due may change on an issued bill, an assigned
+-- Invoice_Number never changes,
+-- and only drafts may be deleted. Corrections go through void/reissue or credit/debit notes.
+CREATE OR REPLACE FUNCTION protect_issued_billing()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        IF OLD.Bill_Status <> 'draft' THEN
+            RAISE EXCEPTION 'bill % is % and cannot be deleted', OLD.Bill_ID, OLD.Bill_Status
+                USING ERRCODE = 'check_violation';
+        END IF;
+        RETURN OLD;
+    END IF;
+    IF OLD.Invoice_Number IS NOT NULL AND NEW.Invoice_Number IS DISTINCT FROM OLD.Invoice_Number THEN
+        RAISE EXCEPTION 'invoice number % of bill % cannot be changed', OLD.Invoice_Number, OLD.Bill_ID
+            USING ERRCODE = 'check_violation';
+    END IF;
+    IF OLD.Bill_Status <> 'draft' AND (
+        NEW.Customer_ID, NEW.Meter_ID, NEW.Billing_Date, NEW.Due_Date,
+        NEW.Previous_Reading, NEW.Current_Reading, NEW.Rate_Applied, NEW.Currency
+    ) IS DISTINCT FROM (
+        OLD.Customer_ID, OLD.Meter_ID, OLD.Billing_Date, OLD.Due_Date,
+        OLD.Previous_Reading, OLD.Current_Reading, OLD.Rate_Applied, OLD.Currency
+    ) THEN
+        RAISE EXCEPTION 'bill % is % and can no longer be edited', OLD.Bill_ID, OLD.Bill_Status
+            USING ERRCODE = 'check_violation';
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Create trigger to protect issued bills from edits and deletion
+CREATE TRIGGER trg_billing_protect_issued
+BEFORE UPDATE OR DELETE ON Billing
+FOR EACH ROW
+EXECUTE FUNCTION protect_issued_billing();
+
+-- Create trigger function that freezes the line items of non-draft bills
+CREATE OR REPLACE FUNCTION protect_issued_line_items()
+RETURNS TRIGGER AS $$
+DECLARE
+    status VARCHAR(20);
+BEGIN
+    SELECT Bill_Status INTO status FROM Billing WHERE Bill_ID = COALESCE(NEW.Bill_ID, OLD.Bill_ID);
+    IF status IS NOT NULL AND status <> 'draft' THEN
+        RAISE EXCEPTION 'line items of % bill % cannot be changed', status, COALESCE(NEW.Bill_ID, OLD.Bill_ID)
+            USING ERRCODE = 'check_violation';
+    END IF;
+    RETURN COALESCE(NEW, OLD);
+END;
+$$ LANGUAGE plpgsql;
+
+-- Create trigger to protect line items of issued bills
+CREATE TRIGGER trg_line_item_protect_issued
+BEFORE INSERT OR UPDATE OR DELETE ON bill_line_item
+FOR EACH ROW
+EXECUTE FUNCTION protect_issued_line_items();
+
+-- Create trigger function to keep Billing.Amount_Due equal to the sum of its line items
+CREATE OR REPLACE FUNCTION sync_billing_amount_due()
+RETURNS TRIGGER AS $$
+BEGIN
+    -- Touching the row re-runs calculate_billing_amount_due()
+    UPDATE Billing SET Amount_Due = NULL
+    WHERE Bill_ID = COALESCE(NEW.Bill_ID, OLD.Bill_ID);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Create trigger to recalculate Amount_Due whenever line items change
+CREATE TRIGGER trg_line_item_sync_amount_due
+AFTER INSERT OR UPDATE OR DELETE ON bill_line_item
+FOR EACH ROW
+EXECUTE FUNCTION sync_billing_amount_due();
+
+-- Create trigger function that bumps a row's Version on every update, for optimistic concurrency control
+CREATE OR REPLACE FUNCTION bump_row_version()
+RETURNS TRIGGER AS $$
+BEGIN
+    NEW.Version = OLD.Version + 1;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trg_customer_bump_version
+BEFORE UPDATE ON Customer
+FOR EACH ROW
+EXECUTE FUNCTION bump_row_version();
+
+CREATE TRIGGER trg_meter_bump_version
+BEFORE UPDATE ON Meter
+FOR EACH ROW
+EXECUTE FUNCTION bump_row_version();
+
+CREATE TRIGGER trg_payment_bump_version
+BEFORE UPDATE ON Payment
+FOR EACH ROW
+EXECUTE FUNCTION bump_row_version();
+
+-- Create trigger function that records when a payment was refunded, so the cash-up reports a
+-- refund on the day it was given rather than the day the payment was taken
+CREATE OR REPLACE FUNCTION stamp_payment_refund()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.Payment_Status <> 'Refunded' THEN
+        NEW.Refunded_At = NULL;
+    ELSIF TG_OP = 'INSERT' OR OLD.Payment_Status <> 'Refunded' THEN
+        NEW.Refunded_At = CURRENT_TIMESTAMP;
+    ELSE
+        NEW.Refunded_At = OLD.Refunded_At;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trg_payment_stamp_refund
+BEFORE INSERT OR UPDATE ON Payment
+FOR EACH ROW
+EXECUTE FUNCTION stamp_payment_refund();
+
+-- Create trigger function that bumps a bill's Version when one of its stored columns changes
+-- Amount_Due is left out: it is re-derived once per line item while a draft is saved,
+-- which must not make the version returned by that save stale.
+CREATE OR REPLACE FUNCTION bump_billing_version()
+RETURNS TRIGGER AS $$
+BEGIN
+    NEW.Version = OLD.Version;
+    IF (
+        NEW.Customer_ID, NEW.Meter_ID, NEW.Billing_Date, NEW.Due_Date, NEW.Previous_Reading, NEW.Current_Reading,
+        NEW.Rate_Applied, NEW.Currency, NEW.Paid_Status, NEW.Bill_Status, NEW.Replaces_Bill_ID, NEW.Invoice_Number, NEW.Issued_Date
+    ) IS DISTINCT FROM (
+        OLD.Customer_ID, OLD.Meter_ID, OLD.Billing_Date, OLD.Due_Date, OLD.Previous_Reading, OLD.Current_Reading,
+        OLD.Rate_Applied, OLD.Currency, OLD.Paid_Status, OLD.Bill_Status, OLD.Replaces_Bill_ID, OLD.Invoice_Number, OLD.Issued_Date
+    ) THEN
+        NEW.Version = OLD.Version + 1;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trg_billing_bump_version
+BEFORE UPDATE ON Billing
+FOR EACH ROW
+EXECUTE FUNCTION bump_billing_version();
+
+-- Create trigger function to reject payments against bills that are not open
+CREATE OR REPLACE FUNCTION check_billing_payable()
 RETURNS TRIGGER AS $$
+DECLARE
+    status VARCHAR(20);
+BEGIN
+    SELECT Bill_Status INTO status FROM Billing WHERE Bill_ID = NEW.Bill_ID;
+    IF status NOT IN ('issued', 'partially_paid') THEN
+        RAISE EXCEPTION 'bill % is % and cannot accept payments', NEW.Bill_ID, status
+            USING ERRCODE = 'check_violation';
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Create trigger to check bill status before a payment is recorded
+CREATE TRIGGER trg_payment_check_payable
+BEFORE INSERT ON Payment
+FOR EACH ROW
+EXECUTE FUNCTION check_billing_payable();
+
+-- Create function to recompute Paid_Status and Bill_Status of a bill from its payments and notes
+-- A bill is settled once completed payments cover Amount_Due plus debit notes minus credit notes.
+CREATE OR REPLACE FUNCTION refresh_billing_status(p_bill_id INTEGER, p_reason VARCHAR)
+RETURNS VOID AS $$
 DECLARE
     total_paid DECIMAL(10, 2);
-    bill_amount DECIMAL(10, 2);
+    net_amount DECIMAL(10, 2);
+    old_status VARCHAR(20);
+    new_status VARCHAR(20);
 BEGIN
     -- Calculate total payments for this bill
     SELECT COALESCE(SUM(Amount_Paid), 0) INTO total_paid
     FROM Payment
-    WHERE Bill_ID = NEW.Bill_ID AND Payment_Status = 'Completed';
+    WHERE Bill_ID = p_bill_id AND Payment_Status = 'Completed';
     
-    -- Get the bill amount
-    SELECT Amount_Due INTO bill_amount
+    -- Get the bill amount and current state
+    SELECT Amount_Due, Bill_Status INTO net_amount, old_status
     FROM Billing
-    WHERE Bill_ID = NEW.Bill_ID;
+    WHERE Bill_ID = p_bill_id;
+
+    -- Void and written-off bills are closed; drafts cannot be paid
+    IF old_status NOT IN ('issued', 'partially_paid', 'paid') THEN
+        RETURN;
+    END IF;
+
+    -- Apply credit and debit notes
+    SELECT net_amount + COALESCE(SUM(CASE Note_Type WHEN 'debit' THEN Amount ELSE -Amount END), 0) INTO net_amount
+    FROM billing_note
+    WHERE Bill_ID = p_bill_id;
     
     -- Update paid status if payment covers the bill
-    IF total_paid >= bill_amount THEN
-        UPDATE Billing
-        SET Paid_Status = TRUE
-        WHERE Bill_ID = NEW.Bill_ID;
+    IF total_paid >= net_amount THEN
+        new_status := 'paid';
+    ELSIF total_paid > 0 THEN
+        new_status := 'partially_paid';
     ELSE
-        UPDATE Billing
-        SET Paid_Status = FALSE
-        WHERE Bill_ID = NEW.Bill_ID;
+        new_status := 'issued';
     END IF;
-    
+
+    UPDATE Billing
+    SET Paid_Status = (new_status = 'paid'), Bill_Status = new_status
+    WHERE Bill_ID = p_bill_id;
+
+    IF new_status <> old_status THEN
+        INSERT INTO bill_status_history (Bill_ID, From_Status, To_Status, Reason)
+        VALUES (p_bill_id, old_status, new_status, p_reason);
+    END IF;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Create trigger function to update Paid_Status and Bill_Status in Billing table when a payment is made
+CREATE OR REPLACE FUNCTION update_billing_paid_status()
+RETURNS TRIGGER AS $$
+BEGIN
+    PERFORM refresh_billing_status(NEW.Bill_ID, 'Payment ' || NEW.Payment_ID || ' ' || LOWER(NEW.Payment_Status));
     RETURN NEW;
 END;
 $$ LANGUAGE plpgsql;
@@ -129,6 +624,170 @@ AFTER INSERT OR UPDATE ON Payment
 FOR EACH ROW
 EXECUTE FUNCTION update_billing_paid_status();
 
+-- Create trigger function to update bill status when a credit or debit note is raised
+CREATE OR REPLACE FUNCTION update_billing_status_from_note()
+RETURNS TRIGGER AS $$
+BEGIN
+    PERFORM refresh_billing_status(NEW.Bill_ID, INITCAP(NEW.Note_Type) || ' note ' || NEW.Note_Number);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Create trigger to re-evaluate the bill after a note is raised
+CREATE TRIGGER trg_billing_note_update_billing
+AFTER INSERT ON billing_note
+FOR EACH ROW
+EXECUTE FUNCTION update_billing_status_from_note();
+
+-- Create trigger function that records a row change in audit_log
+-- TG_ARGV[0] is the entity type and TG_ARGV[1] the row's ID column. Password hashes are never copied,
+-- and updates that leave the row as it was are not logged. A row changed several times in one
+-- transaction keeps a single entry from its first before-image to its last after-image, so saving a
+-- bill is one entry even though each of its line items re-derives Amount_Due; changes that cancel
+-- out, such as a row created and deleted again, leave none.
+CREATE OR REPLACE FUNCTION audit_row_change()
+RETURNS TRIGGER AS $$
+DECLARE
+    row_before JSONB;
+    row_after JSONB;
+    row_id INTEGER;
+    prior audit_log%ROWTYPE;
+    actor INTEGER := NULLIF(current_setting('ebill.actor_id', true), '')::INTEGER;
+BEGIN
+    IF TG_OP <> 'INSERT' THEN
+        row_before = to_jsonb(OLD) - 'password_hash';
+    END IF;
+    IF TG_OP <> 'DELETE' THEN
+        row_after = to_jsonb(NEW) - 'password_hash';
+    END IF;
+    IF row_before = row_after THEN
+        RETURN NULL;
+    END IF;
+    row_id = (COALESCE(row_after, row_before) ->> TG_ARGV[1])::INTEGER;
+
+    SELECT * INTO prior FROM audit_log
+    WHERE Transaction_ID = txid_current() AND Entity_Type = TG_ARGV[0] AND Entity_ID = row_id;
+    IF FOUND THEN
+        IF (prior.Action = 'create' AND row_after IS NULL) OR prior.Before_Data = row_after THEN
+            DELETE FROM audit_log WHERE Audit_ID = prior.Audit_ID;
+        ELSE
+            UPDATE audit_log SET
+                After_Data = row_after,
+                Action = CASE WHEN prior.Action = 'create' THEN 'create' WHEN row_after IS NULL THEN 'delete' ELSE 'update' END
+            WHERE Audit_ID = prior.Audit_ID;
+        END IF;
+        RETURN NULL;
+    END IF;
+
+    INSERT INTO audit_log (Actor_ID, Actor_Username, Action, Entity_Type, Entity_ID, Before_Data, After_Data, Client_IP, Request_ID)
+    VALUES (
+        actor,
+        COALESCE(NULLIF(current_setting('ebill.actor_username', true), ''), (SELECT username FROM users WHERE id = actor)),
+        CASE TG_OP WHEN 'INSERT' THEN 'create' WHEN 'UPDATE' THEN 'update' ELSE 'delete' END,
+        TG_ARGV[0],
+        row_id,
+        row_before,
+        row_after,
+        NULLIF(current_setting('ebill.client_ip', true), '')::INET,
+        NULLIF(current_setting('ebill.request_id', true), '')
+    );
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Create triggers that audit the changes of each entity
+CREATE TRIGGER trg_customer_audit
+AFTER INSERT OR UPDATE OR DELETE ON Customer
+FOR EACH ROW
+EXECUTE FUNCTION audit_row_change('customer', 'customer_id');
+
+CREATE TRIGGER trg_meter_audit
+AFTER INSERT OR UPDATE OR DELETE ON Meter
+FOR EACH ROW
+EXECUTE FUNCTION audit_row_change('meter', 'meter_id');
+
+CREATE TRIGGER trg_billing_audit
+AFTER INSERT OR UPDATE OR DELETE ON Billing
+FOR EACH ROW
+EXECUTE FUNCTION audit_row_change('bill', 'bill_id');
+
+CREATE TRIGGER trg_payment_audit
+AFTER INSERT OR UPDATE OR DELETE ON Payment
+FOR EACH ROW
+EXECUTE FUNCTION audit_row_change('payment', 'payment_id');
+
+CREATE TRIGGER trg_users_audit
+AFTER INSERT OR UPDATE OR DELETE ON users
+FOR EACH ROW
+EXECUTE FUNCTION audit_row_change('user', 'id');
+
+-- Create trigger function that keeps the history tables
+-- The version a change replaces is closed at the transaction's start time and the new row becomes the
+-- current version; a row changed again in the same transaction keeps a single version. TG_ARGV[0] is
+-- the row's ID column. A bill's version also keeps its line items: saving them re-derives the bill's
+-- Amount_Due, which updates the bill after the last of them is written.
+CREATE OR REPLACE FUNCTION record_row_history()
+RETURNS TRIGGER AS $$
+DECLARE
+    history_table TEXT := TG_TABLE_NAME || '_history';
+    row_id INTEGER;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        row_id = (to_jsonb(OLD) ->> TG_ARGV[0])::INTEGER;
+    ELSE
+        row_id = (to_jsonb(NEW) ->> TG_ARGV[0])::INTEGER;
+    END IF;
+    EXECUTE format('DELETE FROM %I WHERE %I = $1 AND Valid_To IS NULL AND Valid_From = LOCALTIMESTAMP', history_table, TG_ARGV[0])
+        USING row_id;
+    EXECUTE format('UPDATE %I SET Valid_To = LOCALTIMESTAMP WHERE %I = $1 AND Valid_To IS NULL', history_table, TG_ARGV[0])
+        USING row_id;
+    IF TG_OP <> 'DELETE' THEN
+        EXECUTE format('INSERT INTO %I SELECT ($1).*, LOCALTIMESTAMP, NULL, NULLIF(current_setting(''ebill.actor_id'', true), '''')::INTEGER',
+            history_table) USING NEW;
+        IF TG_TABLE_NAME = 'billing' THEN
+            UPDATE billing_history SET Line_Items = (
+                SELECT COALESCE(jsonb_agg(to_jsonb(li) ORDER BY li.Line_Item_ID), '[]') FROM bill_line_item li WHERE li.Bill_ID = row_id
+            ) WHERE Bill_ID = row_id AND Valid_To IS NULL;
+        END IF;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Create triggers that record the versions of customers, meters and bills
+CREATE TRIGGER trg_customer_history
+AFTER INSERT OR UPDATE OR DELETE ON Customer
+FOR EACH ROW
+EXECUTE FUNCTION record_row_history('customer_id');
+
+CREATE TRIGGER trg_meter_history
+AFTER INSERT OR UPDATE OR DELETE ON Meter
+FOR EACH ROW
+EXECUTE FUNCTION record_row_history('meter_id');
+
+CREATE TRIGGER trg_billing_history
+AFTER INSERT OR UPDATE OR DELETE ON Billing
+FOR EACH ROW
+EXECUTE FUNCTION record_row_history('bill_id');
+
+-- Create trigger function that keeps audit_log append-only
+-- Only the transaction that wrote an entry may still change it, while merging the row's later changes
+-- into it; committed entries are final.
+CREATE OR REPLACE FUNCTION protect_audit_log()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF OLD.Transaction_ID = txid_current() THEN
+        RETURN COALESCE(NEW, OLD);
+    END IF;
+    RAISE EXCEPTION 'audit_log entries cannot be changed or deleted';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trg_audit_log_append_only
+BEFORE UPDATE OR DELETE ON audit_log
+FOR EACH ROW
+EXECUTE FUNCTION protect_audit_log();
+
 -- Create a view to see unpaid bills
 CREATE OR REPLACE VIEW unpaid_bills AS
 SELECT 
@@ -147,11 +806,29 @@ FROM
 JOIN 
     Customer c ON b.Customer_ID = c.Customer_ID
 WHERE 
-    b.Paid_Status = FALSE
+    b.Bill_Status IN ('issued', 'partially_paid')
 ORDER BY 
     Days_Overdue DESC, b.Due_Date ASC;
 
+-- Create a view of what is still owed on each bill:
+-- the amount due plus debit notes, minus credit notes and completed payments
+CREATE OR REPLACE VIEW bill_balance AS
+SELECT
+    b.Bill_ID,
+    b.Customer_ID,
+    b.Bill_Status,
+    b.Currency,
+    b.Amount_Due
+    + COALESCE((SELECT SUM(CASE n.Note_Type WHEN 'debit' THEN n.Amount ELSE -n.Amount END)
+                FROM billing_note n WHERE n.Bill_ID = b.Bill_ID), 0)
+    - COALESCE((SELECT SUM(p.Amount_Paid) FROM Payment p
+                WHERE p.Bill_ID = b.Bill_ID AND p.Payment_Status = 'Completed'), 0) AS Outstanding
+FROM
+    Billing b;
+
 -- Create a view for customer consumption history
+-- Void bills are left out so that a reissued bill's usage is counted once.
+-- Period_Days is the length of the billing period: since the meter's previous bill, or since installation.
 CREATE OR REPLACE VIEW customer_consumption AS
 SELECT 
     c.Customer_ID,
@@ -161,16 +838,67 @@ SELECT
     b.Billing_Date,
     b.Total_Unit,
     b.Amount_Due,
-    b.Paid_Status
+    b.Paid_Status,
+    b.Bill_ID,
+    m.Meter_Type,
+    b.Billing_Date - COALESCE(LAG(b.Billing_Date) OVER (PARTITION BY b.Meter_ID ORDER BY b.Billing_Date, b.Bill_ID),
+                              m.Installation_Date) AS Period_Days
 FROM 
     Customer c
 JOIN 
     Meter m ON c.Customer_ID = m.Customer_ID
 JOIN 
     Billing b ON m.Meter_ID = b.Meter_ID
+WHERE
+    b.Bill_Status <> 'void'
 ORDER BY 
     c.Customer_ID, b.Billing_Date DESC;
 
+-- Create a view of every financial movement per customer
+-- Debits increase what the customer owes (bills, debit notes); credits reduce it (payments, credit notes).
+-- Entry_Order keeps same-day entries in a stable, sensible order.
+CREATE OR REPLACE VIEW customer_ledger AS
+SELECT b.Customer_ID, b.Billing_Date AS Entry_Date, 1 AS Entry_Order, 'bill' AS Entry_Type,
+       'BILL-' || b.Bill_ID AS Reference, b.Bill_ID, 'Electricity bill' AS Description,
+       b.Amount_Due AS Debit, 0::DECIMAL(10, 2) AS Credit, b.Currency
+FROM Billing b
+WHERE b.Bill_Status IN ('issued', 'partially_paid', 'paid', 'written_off')
+UNION ALL
+SELECT b.Customer_ID, b.Billing_Date, 1, 'bill', 'BILL-' || b.Bill_ID, b.Bill_ID, 'Electricity bill (voided)',
+       b.Amount_Due, b.Amount_Due, b.Currency
+FROM Billing b
+WHERE b.Bill_Status = 'void' AND EXISTS (
+    SELECT 1 FROM bill_status_history h WHERE h.Bill_ID = b.Bill_ID AND h.From_Status <> 'draft'
+)
+UNION ALL
+SELECT n.Customer_ID, n.Note_Date, 2, n.Note_Type || '_note', n.Note_Number, n.Bill_ID, n.Reason,
+       CASE WHEN n.Note_Type = 'debit' THEN n.Amount ELSE 0 END,
+       CASE WHEN n.Note_Type = 'credit' THEN n.Amount ELSE 0 END,
+       n.Currency
+FROM billing_note n
+UNION ALL
+SELECT b.Customer_ID, p.Payment_Date, 3, 'payment', 'PAY-' || p.Payment_ID, p.Bill_ID, 'Payment (' || p.Payment_Method || ')',
+       0, p.Amount_Paid, p.Currency
+FROM Payment p
+JOIN Billing b ON b.Bill_ID = p.Bill_ID
+WHERE p.Payment_Status = 'Completed';
+
+-- Create a view for monthly VAT reporting, built from the vat line items
+CREATE OR REPLACE VIEW monthly_tax_summary AS
+SELECT 
+    TO_CHAR(b.Billing_Date, 'YYYY-MM') AS Month,
+    b.Currency,
+    COUNT(DISTINCT b.Bill_ID) AS Bill_Count,
+    COALESCE(SUM(li.Quantity) FILTER (WHERE li.Item_Type = 'vat'), 0)::DECIMAL(12, 2) AS Taxable_Base,
+    COALESCE(SUM(li.Amount) FILTER (WHERE li.Item_Type = 'vat'), 0) AS VAT_Amount,
+    COALESCE(SUM(li.Amount), 0) AS Total_Billed
+FROM 
+    Billing b
+JOIN 
+    bill_line_item li ON li.Bill_ID = b.Bill_ID
+GROUP BY 
+    TO_CHAR(b.Billing_Date, 'YYYY-MM'), b.Currency;
+
 -- Sample user (replace with your actual hashed password generation)
 -- Password for 'admin' is 'root'
 -- Bcrypt hash for 'root' (cost 10): $2a$10$nAIL5przC.RemyJ2CDmWKetjj1LnM64dwgtxj6SJ/kHlncKpihk6K
@@ -188,11 +916,13 @@ INSERT INTO Meter (Meter_ID, Customer_ID, Meter_Number, Meter_Type, Installation
 (102, 2, 'MTR67890', 'Standard', '2023-03-22', TRUE),
 (103, 3, 'MTR11121', 'Standard', '2023-05-30', FALSE);
 
-INSERT INTO Billing (Bill_ID, Customer_ID, Meter_ID, Billing_Date, Due_Date, Previous_Reading, Current_Reading, Rate_Applied, Paid_Status) VALUES
-(1001, 1, 101, '2024-04-01', '2024-04-15', 5000.00, 5120.50, 0.50, FALSE),
-(1002, 2, 102, '2024-04-01', '2024-04-15', 3000.00, 3150.00, 0.50, FALSE),
-(1003, 3, 103, '2024-04-01', '2024-04-15', 1200.00, 1320.50, 0.50, FALSE),
-(1004, 2, 102, '2024-04-01', '2024-04-15', 2000.00, 4150.00, 0.50, FALSE);
+INSERT INTO Billing (Bill_ID, Customer_ID, Meter_ID, Billing_Date, Due_Date, Previous_Reading, Current_Reading, Rate_Applied, Paid_Status, Bill_Status, Invoice_Number, Issued_Date) VALUES
+(1001, 1, 101, '2024-04-01', '2024-04-15', 5000.00, 5120.50, 0.50, FALSE, 'issued', 'INV-202404-00001', '2024-04-01'),
+(1002, 2, 102, '2024-04-01', '2024-04-15', 3000.00, 3150.00, 0.50, FALSE, 'issued', 'INV-202404-00002', '2024-04-01'),
+(1003, 3, 103, '2024-04-01', '2024-04-15', 1200.00, 1320.50, 0.50, FALSE, 'issued', 'INV-202404-00003', '2024-04-01'),
+(1004, 2, 102, '2024-04-01', '2024-04-15', 2000.00, 4150.00, 0.50, FALSE, 'issued', 'INV-202404-00004', '2024-04-01');
+
+INSERT INTO document_sequence (Series, Period, Last_Value) VALUES ('INV', '202404', 4);
 
 --INSERT INTO Payment (Payment_ID, Bill_ID, Processed_By, Payment_Date, Amount_Paid, Payment_Method, Payment_Status) VALUES
 --(5001, 1002, 1, '2024-04-10', 75.00, 'Credit Card', 'Pending'),